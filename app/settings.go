@@ -9,6 +9,53 @@ import (
 	"strings"
 )
 
+const NODE_TYPE_BitcoinCore = "Bitcoin Core"
+const NODE_TYPE_BitcoinCoreRest = "Bitcoin Core REST"
+const NODE_TYPE_Esplora = "Esplora"
+const NODE_TYPE_BlockFiles = "Block Files"
+const NODE_TYPE_Multiple = "Multiple Nodes"
+const NODE_TYPE_Replay = "Replay"
+
+// node fixtures are recorded from the node or replayed instead of connecting to a node
+const NODE_FIXTURES_MODE_Record = "record"
+const NODE_FIXTURES_MODE_Replay = "replay"
+
+// how read requests are spread over multiple nodes
+const NODE_ROUTING_Failover = "failover"          // the first available node in the list
+const NODE_ROUTING_RoundRobin = "round-robin"     // each available node in turn
+const NODE_ROUTING_LeastLatency = "least-latency" // the available node with the lowest average latency
+
+// NodeEndpoint is one of the nodes in the nodes setting
+// the location is a url, or a directory for block files
+type NodeEndpoint struct {
+	nodeType string
+	location string
+}
+
+func (ne NodeEndpoint) GetNodeType() string {
+	return ne.nodeType
+}
+
+func (ne NodeEndpoint) GetLocation() string {
+	return ne.location
+}
+
+// the location without any credentials in it
+func (ne NodeEndpoint) GetDisplayLocation() string {
+	scheme, rest, hasScheme := strings.Cut(ne.location, "://")
+	if !hasScheme {
+		return ne.location
+	}
+	host, path, _ := strings.Cut(rest, "/")
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	if len(path) > 0 {
+		return scheme + "://" + host + "/" + path
+	}
+	return scheme + "://" + host
+}
+
 type settingsManager struct {
 	alreadyParsed  bool
 	versionTag     string
@@ -16,10 +63,39 @@ type settingsManager struct {
 
 	configFile string
 
-	bitcoinCoreAddr     string
-	bitcoinCorePort     uint16
-	bitcoinCoreUsername string
-	bitcoinCorePassword string
+	nodeType string
+
+	nodeEndpoints           []NodeEndpoint
+	nodeRouting             string
+	nodeHealthCheckInterval uint16 // seconds
+	nodeMaxTipLag           uint32 // blocks
+
+	bitcoinCoreAddr       string
+	bitcoinCorePort       uint16
+	bitcoinCoreUsername   string
+	bitcoinCorePassword   string
+	bitcoinCoreCookieFile string
+	bitcoinCoreTls        bool
+	bitcoinCorePath       string
+
+	nodeCaFile   string
+	nodeTimeout  uint16 // seconds
+	rpcBatchSize uint16
+
+	nodeMaxConcurrentRequests uint16
+	nodeMaxRequestsPerSecond  float64 // 0 for no limit
+
+	nodeFixturesDir  string
+	nodeFixturesMode string // empty if fixtures are not used
+
+	esploraUrl string
+
+	blocksDir string
+
+	zmqRawBlock  string
+	zmqHashBlock string
+	zmqRawTx     string
+	zmqSequence  string
 
 	nodeVersionStr string
 
@@ -27,8 +103,23 @@ type settingsManager struct {
 	addr    string
 	port    uint16
 
-	noWeb   bool
-	caching bool
+	noWeb          bool
+	caching        bool
+	cacheMaxMemory uint64 // megabytes
+	prefetching    bool
+
+	diskCacheFile    string
+	diskCacheMaxSize uint64 // megabytes
+
+	spendIndexFile        string
+	spendIndexStartHeight uint32
+
+	addressIndexFile        string
+	addressIndexStartHeight uint32
+	addressIndexEndHeight   uint32
+
+	txBlockIndexFile        string
+	txBlockIndexStartHeight uint32
 
 	// testMode string
 	// testVerifiedDir string
@@ -43,6 +134,11 @@ func (s *settingsManager) ExitOnError() {
 		panic("Web parameters are not valid.")
 	}
 
+	// fixtures can not be recorded or replayed without a directory
+	if len(s.nodeFixturesMode) > 0 && len(s.nodeFixturesDir) == 0 {
+		panic("node-fixtures-mode requires node-fixtures-dir.")
+	}
+
 	/*
 	   // make sure the user has the correct test file permissions
 
@@ -60,16 +156,127 @@ func (s *settingsManager) GetConfigFileLocation() string {
 	return s.configFile
 }
 
+// returns the node type of a node-type setting value, or an empty string if it is not a node type
+func getNodeTypeFromName(name string) string {
+	switch strings.ToLower(name) {
+	case "bitcoin-core":
+		return NODE_TYPE_BitcoinCore
+	case "bitcoin-core-rest":
+		return NODE_TYPE_BitcoinCoreRest
+	case "esplora":
+		return NODE_TYPE_Esplora
+	case "block-files":
+		return NODE_TYPE_BlockFiles
+	}
+	return ""
+}
+
+// if the node type is not set, it is determined by which node settings are present
+// replaying fixtures and then the nodes setting take precedence over every other node setting
 func (s *settingsManager) GetNodeType() string {
-	if len(s.bitcoinCoreAddr) > 0 && s.bitcoinCorePort != 0 && len(s.bitcoinCoreUsername) > 0 && len(s.bitcoinCorePassword) > 0 {
-		return "Bitcoin Core"
+	if s.nodeFixturesMode == NODE_FIXTURES_MODE_Replay {
+		return NODE_TYPE_Replay
+	}
+
+	if len(s.nodeEndpoints) > 0 {
+		return NODE_TYPE_Multiple
+	}
+
+	if len(s.nodeType) > 0 {
+		return getNodeTypeFromName(s.nodeType)
+	}
+
+	hasCredentials := len(s.bitcoinCoreUsername) > 0 && len(s.bitcoinCorePassword) > 0 || len(s.bitcoinCoreCookieFile) > 0
+	if len(s.bitcoinCoreAddr) > 0 && s.bitcoinCorePort != 0 && hasCredentials {
+		return NODE_TYPE_BitcoinCore
+	}
+	if len(s.esploraUrl) > 0 {
+		return NODE_TYPE_Esplora
+	}
+	if len(s.blocksDir) > 0 {
+		return NODE_TYPE_BlockFiles
 	}
 
 	return ""
 }
 
+// the nodes from the nodes setting, in the order they are listed
+func (s *settingsManager) GetNodeEndpoints() []NodeEndpoint {
+	return s.nodeEndpoints
+}
+
+func (s *settingsManager) GetNodeRouting() string {
+	return s.nodeRouting
+}
+
+func (s *settingsManager) GetNodeHealthCheckIntervalSeconds() uint16 {
+	return s.nodeHealthCheckInterval
+}
+
+// the number of blocks a node's tip can be behind the highest tip before requests are no longer sent to it
+func (s *settingsManager) GetNodeMaxTipLag() uint32 {
+	return s.nodeMaxTipLag
+}
+
+// returns the location of the node being used, for display purposes
 func (s *settingsManager) GetNodeFullUrl() string {
-	return s.bitcoinCoreAddr + ":" + strconv.FormatUint(uint64(s.bitcoinCorePort), 10)
+	switch s.GetNodeType() {
+	case NODE_TYPE_Replay:
+		return s.nodeFixturesDir
+	case NODE_TYPE_Multiple:
+		locations := make([]string, len(s.nodeEndpoints))
+		for e, endpoint := range s.nodeEndpoints {
+			locations[e] = endpoint.GetDisplayLocation()
+		}
+		return strings.Join(locations, ", ")
+	case NODE_TYPE_Esplora:
+		return s.esploraUrl
+	case NODE_TYPE_BlockFiles:
+		return s.blocksDir
+	}
+	return s.GetBitcoinCoreUrl()
+}
+
+// includes the scheme and the path, but not a trailing slash
+func (s *settingsManager) GetBitcoinCoreUrl() string {
+	scheme := "http://"
+	if s.bitcoinCoreTls {
+		scheme = "https://"
+	}
+	return scheme + s.bitcoinCoreAddr + ":" + strconv.FormatUint(uint64(s.bitcoinCorePort), 10) + s.bitcoinCorePath
+}
+
+func (s *settingsManager) GetNodeFixturesDir() string {
+	return s.nodeFixturesDir
+}
+
+// returns an empty string if fixtures are neither recorded nor replayed
+func (s *settingsManager) GetNodeFixturesMode() string {
+	return s.nodeFixturesMode
+}
+
+func (s *settingsManager) GetEsploraUrl() string {
+	return s.esploraUrl
+}
+
+func (s *settingsManager) GetBlocksDir() string {
+	return s.blocksDir
+}
+
+func (s *settingsManager) GetZmqRawBlockEndpoint() string {
+	return s.zmqRawBlock
+}
+
+func (s *settingsManager) GetZmqHashBlockEndpoint() string {
+	return s.zmqHashBlock
+}
+
+func (s *settingsManager) GetZmqRawTxEndpoint() string {
+	return s.zmqRawTx
+}
+
+func (s *settingsManager) GetZmqSequenceEndpoint() string {
+	return s.zmqSequence
 }
 
 func (s *settingsManager) GetNodeUsername() string {
@@ -80,6 +287,41 @@ func (s *settingsManager) GetNodePassword() string {
 	return s.bitcoinCorePassword
 }
 
+func (s *settingsManager) GetBitcoinCorePath() string {
+	return s.bitcoinCorePath
+}
+
+// when set, the credentials are read from this file instead of the username and password settings
+func (s *settingsManager) GetNodeCookieFile() string {
+	return s.bitcoinCoreCookieFile
+}
+
+// a PEM file with additional certificate authorities to trust for https node connections
+func (s *settingsManager) GetNodeCaFile() string {
+	return s.nodeCaFile
+}
+
+func (s *settingsManager) GetNodeTimeoutSeconds() uint16 {
+	return s.nodeTimeout
+}
+
+// the maximum number of requests sent to the node at the same time
+func (s *settingsManager) GetNodeMaxConcurrentRequests() uint16 {
+	return s.nodeMaxConcurrentRequests
+}
+
+func (s *settingsManager) GetNodeMaxRequestsPerSecond() float64 {
+	return s.nodeMaxRequestsPerSecond
+}
+
+// the maximum number of transactions requested from the node at once
+func (s *settingsManager) GetRpcBatchSize() uint16 {
+	if s.rpcBatchSize == 0 {
+		return 1
+	}
+	return s.rpcBatchSize
+}
+
 func (s *settingsManager) GetBaseUrl(alwaysIncludePort bool) string {
 	if s.port != 80 || alwaysIncludePort {
 		//return fmt.Sprintf("%s:%d", s.addr, s.port)
@@ -129,6 +371,61 @@ func (s *settingsManager) IsCachingOn() bool {
 	return s.caching
 }
 
+// whether the previous outputs of requested blocks are requested in the background
+func (s *settingsManager) IsPrefetchingOn() bool {
+	return s.prefetching
+}
+
+// an empty string if there is no spend index
+func (s *settingsManager) GetSpendIndexFile() string {
+	return s.spendIndexFile
+}
+
+// the first block read into the spend index
+func (s *settingsManager) GetSpendIndexStartHeight() uint32 {
+	return s.spendIndexStartHeight
+}
+
+// an empty string if there is no address index
+func (s *settingsManager) GetAddressIndexFile() string {
+	return s.addressIndexFile
+}
+
+// the first block read into the address index
+func (s *settingsManager) GetAddressIndexStartHeight() uint32 {
+	return s.addressIndexStartHeight
+}
+
+// the last block read into the address index, 0 if the index follows the tip
+func (s *settingsManager) GetAddressIndexEndHeight() uint32 {
+	return s.addressIndexEndHeight
+}
+
+// an empty string if there is no transaction block index
+func (s *settingsManager) GetTxBlockIndexFile() string {
+	return s.txBlockIndexFile
+}
+
+// the first block read into the transaction block index
+func (s *settingsManager) GetTxBlockIndexStartHeight() uint32 {
+	return s.txBlockIndexStartHeight
+}
+
+// the memory budget of the cache in bytes
+func (s *settingsManager) GetCacheMaxMemory() uint64 {
+	return s.cacheMaxMemory * 1024 * 1024
+}
+
+// an empty string if there is no disk cache
+func (s *settingsManager) GetDiskCacheFile() string {
+	return s.diskCacheFile
+}
+
+// the maximum size of the disk cache in bytes
+func (s *settingsManager) GetDiskCacheMaxSize() uint64 {
+	return s.diskCacheMaxSize * 1024 * 1024
+}
+
 func getBoolValue(setting string) bool {
 	lower := strings.ToLower(setting)
 	intVal, err := strconv.Atoi(setting)
@@ -143,6 +440,8 @@ func (s *settingsManager) setSettings(settings map[string]string) {
 		switch k {
 		case "config-file":
 			s.configFile = v
+		case "node-type":
+			s.nodeType = strings.ToLower(v)
 
 		// bitcoin core settings
 		case "bitcoin-core-addr":
@@ -157,6 +456,102 @@ func (s *settingsManager) setSettings(settings map[string]string) {
 			s.bitcoinCoreUsername = v
 		case "bitcoin-core-password":
 			s.bitcoinCorePassword = v
+		case "bitcoin-core-cookie-file":
+			s.bitcoinCoreCookieFile = v
+		case "bitcoin-core-tls":
+			s.bitcoinCoreTls = getBoolValue(v)
+		case "bitcoin-core-path":
+			// stored with a leading slash and without a trailing slash
+			s.bitcoinCorePath = strings.TrimSuffix(v, "/")
+			if len(s.bitcoinCorePath) > 0 && s.bitcoinCorePath[0] != '/' {
+				s.bitcoinCorePath = "/" + s.bitcoinCorePath
+			}
+
+			// a list of nodes in the form type:location, separated by commas
+		case "nodes":
+			s.nodeEndpoints = nil
+			for _, entry := range strings.Split(v, ",") {
+				entry = strings.TrimSpace(entry)
+				if len(entry) == 0 {
+					continue
+				}
+				typeName, location, found := strings.Cut(entry, ":")
+				nodeType := getNodeTypeFromName(typeName)
+				if !found || len(nodeType) == 0 || len(location) == 0 {
+					panic(entry + " is not a node. Nodes are listed as type:location, for example esplora:http://127.0.0.1:3000.")
+				}
+				s.nodeEndpoints = append(s.nodeEndpoints, NodeEndpoint{nodeType: nodeType, location: location})
+			}
+		case "node-routing":
+			s.nodeRouting = strings.ToLower(v)
+			if s.nodeRouting != NODE_ROUTING_Failover && s.nodeRouting != NODE_ROUTING_RoundRobin && s.nodeRouting != NODE_ROUTING_LeastLatency {
+				panic(v + " is not a node routing. Use failover, round-robin or least-latency.")
+			}
+		case "node-health-check-interval":
+			interval, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.nodeHealthCheckInterval = uint16(interval)
+		case "node-max-tip-lag":
+			maxLag, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.nodeMaxTipLag = uint32(maxLag)
+
+			// settings for all node connections
+		case "node-ca-file":
+			s.nodeCaFile = v
+		case "node-timeout":
+			timeout, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.nodeTimeout = uint16(timeout)
+		case "node-max-concurrent-requests":
+			maxRequests, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.nodeMaxConcurrentRequests = uint16(maxRequests)
+		case "node-max-requests-per-second":
+			maxRequestsPerSecond, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.nodeMaxRequestsPerSecond = maxRequestsPerSecond
+		case "node-fixtures-dir":
+			s.nodeFixturesDir = v
+		case "node-fixtures-mode":
+			s.nodeFixturesMode = strings.ToLower(v)
+			if s.nodeFixturesMode != NODE_FIXTURES_MODE_Record && s.nodeFixturesMode != NODE_FIXTURES_MODE_Replay {
+				panic(v + " is not a node fixtures mode. Use record or replay.")
+			}
+		case "rpc-batch-size":
+			batchSize, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.rpcBatchSize = uint16(batchSize)
+
+			// esplora settings
+		case "esplora-url":
+			s.esploraUrl = v
+
+			// block file settings
+		case "blocks-dir":
+			s.blocksDir = v
+
+			// zmq settings
+		case "zmq-rawblock":
+			s.zmqRawBlock = v
+		case "zmq-hashblock":
+			s.zmqHashBlock = v
+		case "zmq-rawtx":
+			s.zmqRawTx = v
+		case "zmq-sequence":
+			s.zmqSequence = v
 
 			// scantool settings
 		case "base-url":
@@ -171,6 +566,52 @@ func (s *settingsManager) setSettings(settings map[string]string) {
 			s.port = uint16(port)
 		case "caching":
 			s.caching = getBoolValue(v)
+		case "prefetch-previous-outputs":
+			s.prefetching = getBoolValue(v)
+		case "cache-max-memory":
+			maxMemory, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.cacheMaxMemory = uint64(maxMemory)
+		case "spend-index-file":
+			s.spendIndexFile = v
+		case "spend-index-start-height":
+			startHeight, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.spendIndexStartHeight = uint32(startHeight)
+		case "address-index-file":
+			s.addressIndexFile = v
+		case "address-index-start-height":
+			startHeight, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.addressIndexStartHeight = uint32(startHeight)
+		case "address-index-end-height":
+			endHeight, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.addressIndexEndHeight = uint32(endHeight)
+		case "tx-block-index-file":
+			s.txBlockIndexFile = v
+		case "tx-block-index-start-height":
+			startHeight, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.txBlockIndexStartHeight = uint32(startHeight)
+		case "disk-cache-file":
+			s.diskCacheFile = v
+		case "disk-cache-max-size":
+			maxSize, err := strconv.Atoi(v)
+			if err != nil {
+				panic(err.Error())
+			}
+			s.diskCacheMaxSize = uint64(maxSize)
 		case "no-web":
 			s.noWeb = getBoolValue(v)
 
@@ -196,11 +637,23 @@ func getDefaultSettings() settingsManager {
 		bitcoinCorePort: 8332,
 		//								bitcoinCoreUsername: "",
 		//								bitcoinCorePassword: "",
+		nodeTimeout:  30,
+		rpcBatchSize: 100,
+
+		nodeMaxConcurrentRequests: 8,
+
+		nodeRouting:             NODE_ROUTING_Failover,
+		nodeHealthCheckInterval: 10,
+		nodeMaxTipLag:           1,
 
 		addr: "127.0.0.1",
 		port: 8080,
 		//								noWeb: false,
 		//								caching: false,
+		cacheMaxMemory: 256,
+		prefetching:    true,
+
+		diskCacheMaxSize: 1024,
 
 		//								testMode: "",
 		//								testVerifiedDir: "",
@@ -334,8 +787,8 @@ func checkFile(fileName string, requiredPermissions byte) bool {
 	// make sure the user has the correct permissions
 	hasPermission := true
 	if requiredPermissions != 0 {
-		hasPermission = fileInfo.Mode().Perm()&fs.FileMode(requiredPermissions<<8) != 0 ||
-			fileInfo.Mode().Perm()&fs.FileMode(requiredPermissions<<4) != 0 ||
+		hasPermission = fileInfo.Mode().Perm()&(fs.FileMode(requiredPermissions)<<6) != 0 ||
+			fileInfo.Mode().Perm()&(fs.FileMode(requiredPermissions)<<3) != 0 ||
 			fileInfo.Mode().Perm()&fs.FileMode(requiredPermissions) != 0
 	}
 	if !hasPermission {
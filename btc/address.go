@@ -0,0 +1,313 @@
+package btc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// network names, as reported by Bitcoin Core
+const NETWORK_Mainnet = "main"
+const NETWORK_Testnet = "test"
+const NETWORK_Signet = "signet"
+const NETWORK_Regtest = "regtest"
+
+// returns the address of an output script, the same way Bitcoin Core does
+// returns an empty string if the output script has no address
+func GetAddress(outputScript []byte, network string) string {
+
+	scriptLen := len(outputScript)
+
+	// p2pkh
+	if scriptLen == 25 && outputScript[0] == 0x76 && outputScript[1] == 0xa9 && outputScript[2] == 0x14 && outputScript[23] == 0x88 && outputScript[24] == 0xac {
+		return encodeBase58Check(getP2pkhVersion(network), outputScript[3:23])
+	}
+
+	// p2sh
+	if scriptLen == 23 && outputScript[0] == 0xa9 && outputScript[1] == 0x14 && outputScript[22] == 0x87 {
+		return encodeBase58Check(getP2shVersion(network), outputScript[2:22])
+	}
+
+	// witness programs
+	script := NewScript(outputScript)
+	version, program, isWitnessProgram := script.getWitnessProgram()
+	if isWitnessProgram {
+		if version == 0 && len(program) != 20 && len(program) != 32 {
+			return ""
+		}
+		return encodeSegwitAddress(getBech32Hrp(network), version, program)
+	}
+
+	return ""
+}
+
+// returns the output script of an address on any network
+// this is the reverse of GetAddress
+func GetOutputScript(address string) ([]byte, error) {
+
+	// witness programs
+	lowerAddress := strings.ToLower(address)
+	for _, hrp := range []string{"bc", "tb", "bcrt"} {
+		if !strings.HasPrefix(lowerAddress, hrp+"1") || len(lowerAddress) == len(hrp)+1 {
+			continue
+		}
+
+		version, program, err := decodeSegwitAddress(hrp, address)
+		if err != nil {
+			continue
+		}
+
+		outputScript := []byte{0x00}
+		if version > 0 {
+			outputScript[0] = 0x50 + version
+		}
+		outputScript = append(outputScript, byte(len(program)))
+		return append(outputScript, program...), nil
+	}
+
+	// p2pkh and p2sh
+	version, payload, err := decodeBase58Check(address)
+	if err == nil && len(payload) == 20 {
+		switch version {
+		case getP2pkhVersion(NETWORK_Mainnet), getP2pkhVersion(NETWORK_Testnet):
+			return append(append([]byte{0x76, 0xa9, 0x14}, payload...), 0x88, 0xac), nil
+		case getP2shVersion(NETWORK_Mainnet), getP2shVersion(NETWORK_Testnet):
+			return append(append([]byte{0xa9, 0x14}, payload...), 0x87), nil
+		}
+	}
+
+	return nil, errors.New(address + " is not an address.")
+}
+
+func getP2pkhVersion(network string) byte {
+	if network == NETWORK_Mainnet {
+		return 0x00
+	}
+	return 0x6f
+}
+
+func getP2shVersion(network string) byte {
+	if network == NETWORK_Mainnet {
+		return 0x05
+	}
+	return 0xc4
+}
+
+func getBech32Hrp(network string) string {
+	switch network {
+	case NETWORK_Mainnet:
+		return "bc"
+	case NETWORK_Regtest:
+		return "bcrt"
+	}
+	return "tb"
+}
+
+// base58
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func encodeBase58Check(version byte, payload []byte) string {
+
+	data := append([]byte{version}, payload...)
+	firstHash := sha256.Sum256(data)
+	secondHash := sha256.Sum256(firstHash[:])
+	data = append(data, secondHash[0:4]...)
+
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	encoded := make([]byte, 0, len(data)*138/100+1)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+
+	// leading zero bytes are encoded as leading ones
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	// reverse it
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return string(encoded)
+}
+
+func decodeBase58Check(encoded string) (byte, []byte, error) {
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(encoded) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return 0, nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	// leading ones are decoded as leading zero bytes
+	data := n.Bytes()
+	for _, c := range []byte(encoded) {
+		if c != base58Alphabet[0] {
+			break
+		}
+		data = append([]byte{0}, data...)
+	}
+
+	if len(data) < 5 {
+		return 0, nil, errors.New("base58 data too short")
+	}
+
+	payload := data[:len(data)-4]
+	firstHash := sha256.Sum256(payload)
+	secondHash := sha256.Sum256(firstHash[:])
+	if !bytes.Equal(secondHash[0:4], data[len(data)-4:]) {
+		return 0, nil, errors.New("invalid base58 checksum")
+	}
+
+	return payload[0], payload[1:], nil
+}
+
+// bech32 and bech32m, https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const bech32Constant = uint32(1)
+const bech32mConstant = uint32(0x2bc830a3)
+
+func encodeSegwitAddress(hrp string, version byte, program []byte) string {
+
+	// witness version 0 uses bech32, all other versions use bech32m
+	checksumConstant := bech32Constant
+	if version > 0 {
+		checksumConstant = bech32mConstant
+	}
+
+	data := append([]byte{version}, convertBits(program, 8, 5)...)
+
+	values := append(expandBech32Hrp(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ checksumConstant
+	for i := 0; i < 6; i++ {
+		data = append(data, byte((polymod>>uint(5*(5-i)))&31))
+	}
+
+	var address strings.Builder
+	address.WriteString(hrp)
+	address.WriteByte('1')
+	for _, d := range data {
+		address.WriteByte(bech32Alphabet[d])
+	}
+
+	return address.String()
+}
+
+// returns the witness version and witness program of a segwit address with the given human readable part
+func decodeSegwitAddress(hrp string, address string) (byte, []byte, error) {
+
+	// mixed case is not allowed
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return 0, nil, errors.New("mixed case bech32 address")
+	}
+	address = strings.ToLower(address)
+
+	separator := strings.LastIndexByte(address, '1')
+	if separator != len(hrp) || address[:separator] != hrp || len(address)-separator-1 < 7 || len(address) > 90 {
+		return 0, nil, errors.New("malformed bech32 address")
+	}
+
+	data := make([]byte, 0, len(address)-separator-1)
+	for _, c := range []byte(address[separator+1:]) {
+		value := strings.IndexByte(bech32Alphabet, c)
+		if value < 0 {
+			return 0, nil, errors.New("invalid bech32 character")
+		}
+		data = append(data, byte(value))
+	}
+
+	// witness version 0 uses bech32, all other versions use bech32m
+	version := data[0]
+	checksumConstant := bech32Constant
+	if version > 0 {
+		checksumConstant = bech32mConstant
+	}
+	if version > 16 || bech32Polymod(append(expandBech32Hrp(hrp), data...)) != checksumConstant {
+		return 0, nil, errors.New("invalid bech32 checksum")
+	}
+
+	// the padding bits of the program must be zeros
+	programBits := data[1 : len(data)-6]
+	program := convertBits(programBits, 5, 8)
+	if len(programBits)*5%8 > 0 {
+		if program[len(program)-1] != 0 || len(programBits)*5%8 >= 5 {
+			return 0, nil, errors.New("invalid bech32 padding")
+		}
+		program = program[:len(program)-1]
+	}
+
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, errors.New("invalid witness program length")
+	}
+
+	return version, program, nil
+}
+
+func expandBech32Hrp(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	return expanded
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	checksum := uint32(1)
+	for _, v := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+// regroups the bits of data from fromBits-bit groups to toBits-bit groups, padding the last group with zeros
+func convertBits(data []byte, fromBits uint, toBits uint) []byte {
+	accumulator := uint32(0)
+	bitCount := uint(0)
+	maxValue := uint32(1<<toBits) - 1
+
+	converted := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, d := range data {
+		accumulator = accumulator<<fromBits | uint32(d)
+		bitCount += fromBits
+		for bitCount >= toBits {
+			bitCount -= toBits
+			converted = append(converted, byte((accumulator>>bitCount)&maxValue))
+		}
+	}
+	if bitCount > 0 {
+		converted = append(converted, byte((accumulator<<(toBits-bitCount))&maxValue))
+	}
+
+	return converted
+}
@@ -8,6 +8,8 @@ type Block struct {
 	version      int32
 	timestamp    int64
 	txIds        []string
+
+	orphaned bool
 }
 
 func NewBlock(hash string, previous string, next string, height uint32, version int32, timestamp int64, txIds []string) Block {
@@ -45,3 +47,12 @@ func (b *Block) GetTxIds() []string {
 func (b *Block) GetTimestamp() int64 {
 	return b.timestamp
 }
+
+// orphaned blocks are not in the active chain, usually because they were replaced by a reorg
+func (b *Block) IsOrphaned() bool {
+	return b.orphaned
+}
+
+func (b *Block) SetOrphaned(orphaned bool) {
+	b.orphaned = orphaned
+}
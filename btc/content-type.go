@@ -0,0 +1,202 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MIME_TYPE_PNG = "image/png"
+const MIME_TYPE_JPEG = "image/jpeg"
+const MIME_TYPE_GIF = "image/gif"
+const MIME_TYPE_WEBP = "image/webp"
+const MIME_TYPE_AVIF = "image/avif"
+const MIME_TYPE_SVG = "image/svg+xml"
+const MIME_TYPE_PDF = "application/pdf"
+const MIME_TYPE_GZIP = "application/gzip"
+const MIME_TYPE_ZIP = "application/zip"
+const MIME_TYPE_XML = "application/xml"
+const MIME_TYPE_JSON = "application/json"
+const MIME_TYPE_HTML = "text/html"
+const MIME_TYPE_TEXT = "text/plain;charset=utf-8"
+
+// the largest stack item allowed by consensus, data larger than this must be split across multiple pushes
+const MAX_STACK_ITEM_SIZE = 520
+
+// the minimum length of a field before it will be considered text
+const MIN_TEXT_LENGTH = 4
+
+// returns the mime type of the data and the confidence of the detection as a percentage
+// returns an empty mime type if nothing was detected
+func DetectContentType(data []byte) (string, uint8) {
+
+	dataLen := len(data)
+	if dataLen == 0 {
+		return "", 0
+	}
+
+	// binary formats, identified by their magic bytes
+	switch {
+	case bytes.HasPrefix(data, []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a}):
+		return MIME_TYPE_PNG, 99
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return MIME_TYPE_GIF, 99
+	case dataLen >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return MIME_TYPE_WEBP, 99
+	case dataLen >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) && (bytes.Equal(data[8:12], []byte("avif")) || bytes.Equal(data[8:12], []byte("avis"))):
+		return MIME_TYPE_AVIF, 99
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return MIME_TYPE_PDF, 99
+	case bytes.HasPrefix(data, []byte{'P', 'K', 0x03, 0x04}) || bytes.HasPrefix(data, []byte{'P', 'K', 0x05, 0x06}):
+		return MIME_TYPE_ZIP, 95
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return MIME_TYPE_JPEG, 95
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b, 0x08}):
+		return MIME_TYPE_GZIP, 90
+	}
+
+	// everything else must be text
+	if dataLen < MIN_TEXT_LENGTH || !utf8.Valid(data) {
+		return "", 0
+	}
+
+	text := bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf})
+	text = bytes.TrimSpace(text)
+	lowerText := bytes.ToLower(text)
+
+	// markup
+	if bytes.HasPrefix(lowerText, []byte("<!doctype html")) || bytes.HasPrefix(lowerText, []byte("<html")) {
+		return MIME_TYPE_HTML, 95
+	}
+	if bytes.HasPrefix(lowerText, []byte("<svg")) || (bytes.HasPrefix(lowerText, []byte("<?xml")) && bytes.Contains(lowerText, []byte("<svg"))) {
+		return MIME_TYPE_SVG, 95
+	}
+	if bytes.HasPrefix(lowerText, []byte("<?xml")) {
+		return MIME_TYPE_XML, 90
+	}
+
+	// json, which must be complete to be identified
+	if (bytes.HasPrefix(text, []byte("{")) || bytes.HasPrefix(text, []byte("["))) && json.Valid(text) {
+		return MIME_TYPE_JSON, 95
+	}
+
+	// plain text, the confidence depends on the portion of characters that are printable
+	runeCount := 0
+	printableCount := 0
+	for _, r := range string(data) {
+		runeCount++
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			printableCount++
+		}
+	}
+	printablePercent := (printableCount * 100) / runeCount
+	if printablePercent < 90 {
+		return "", 0
+	}
+
+	// very short strings are often just random bytes that happen to be printable
+	confidence := printablePercent - 10
+	if runeCount < 16 {
+		confidence -= 20
+	}
+
+	return MIME_TYPE_TEXT, uint8(confidence)
+}
+
+// returns a label for a data field that includes the detected content type
+// partNumber and partCount are only included for payloads that span multiple fields
+func getContentTypeLabel(prefix string, fieldLen int, partNumber int, partCount int, mimeType string, confidence uint8) string {
+
+	s := ""
+	if fieldLen != 1 {
+		s = "s"
+	}
+	label := prefix + " (" + strconv.Itoa(fieldLen) + " Byte" + s
+	if partCount > 1 {
+		label += fmt.Sprintf(", Part %d of %d", partNumber, partCount)
+	}
+	if len(mimeType) > 0 {
+		label += fmt.Sprintf(", %s, %d%% Confidence", mimeType, confidence)
+	}
+
+	return label + ")"
+}
+
+// detects content that has been split across multiple consecutive data pushes and labels each part
+// a split payload is either a run of maximum-size pushes followed by a shorter push or the data pushes that follow OP_RETURN
+func (s *Script) setEmbeddedContentTypes() {
+
+	fieldCount := len(s.fields)
+	if s.IsNullDataOutput() && fieldCount > 2 {
+		s.setPayloadContentType(1, fieldCount)
+		return
+	}
+
+	for runBegin := 0; runBegin < fieldCount; runBegin++ {
+		if s.fields[runBegin].IsOpcode() || len(s.fields[runBegin].AsBytes()) != MAX_STACK_ITEM_SIZE {
+			continue
+		}
+
+		// the run includes every maximum-size push and the push that follows, if it is data
+		runEnd := runBegin
+		for runEnd < fieldCount && !s.fields[runEnd].IsOpcode() && len(s.fields[runEnd].AsBytes()) == MAX_STACK_ITEM_SIZE {
+			runEnd++
+		}
+		if runEnd < fieldCount && !s.fields[runEnd].IsOpcode() && len(s.fields[runEnd].AsBytes()) > 0 {
+			runEnd++
+		}
+
+		if runEnd-runBegin > 1 {
+			s.setPayloadContentType(runBegin, runEnd)
+		}
+		runBegin = runEnd
+	}
+}
+
+func (s *Script) setPayloadContentType(begin int, end int) {
+
+	payload := make([]byte, 0)
+	for f := begin; f < end; f++ {
+		if s.fields[f].IsOpcode() {
+			return
+		}
+		payload = append(payload, s.fields[f].AsBytes()...)
+	}
+
+	mimeType, confidence := DetectContentType(payload)
+	if len(mimeType) == 0 {
+		return
+	}
+
+	for f := begin; f < end; f++ {
+		s.SetFieldType(f, getContentTypeLabel("Data", len(s.fields[f].AsBytes()), f-begin+1, end-begin, mimeType, confidence))
+	}
+}
+
+// bare multisig outputs are sometimes used to store data in fake public keys
+// the data is the public keys without their prefix bytes
+func (s *Script) setFakePublicKeyContentTypes() {
+
+	fieldCount := len(s.fields)
+	if fieldCount < 4 {
+		return
+	}
+
+	keyCount := fieldCount - 3
+	payload := make([]byte, 0)
+	for f := 1; f <= keyCount; f++ {
+		payload = append(payload, s.fields[f].AsBytes()[1:]...)
+	}
+
+	mimeType, confidence := DetectContentType(payload)
+	if len(mimeType) == 0 {
+		return
+	}
+
+	for f := 1; f <= keyCount; f++ {
+		s.SetFieldType(f, getContentTypeLabel("Public Key", len(s.fields[f].AsBytes()), f, keyCount, mimeType, confidence))
+	}
+}
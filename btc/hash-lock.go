@@ -0,0 +1,124 @@
+package btc
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+const HASH_TYPE_RIPEMD160 = "RIPEMD160"
+const HASH_TYPE_SHA1 = "SHA1"
+const HASH_TYPE_SHA256 = "SHA256"
+const HASH_TYPE_HASH160 = "HASH160"
+const HASH_TYPE_HASH256 = "HASH256"
+
+// a hash lock is a script fragment of the form <hash opcode> <hash> OP_EQUAL or OP_EQUALVERIFY
+// which can only be satisfied by revealing the preimage of the hash
+type HashLock struct {
+	hashType       string
+	hash           []byte
+	hashFieldIndex int
+}
+
+func (hl *HashLock) GetHashType() string {
+	return hl.hashType
+}
+
+func (hl *HashLock) GetHash() []byte {
+	return hl.hash
+}
+
+func (hl *HashLock) GetHashFieldIndex() int {
+	return hl.hashFieldIndex
+}
+
+func (hl *HashLock) IsPreimage(candidate []byte) bool {
+	return bytes.Equal(ComputeHash(hl.hashType, candidate), hl.hash)
+}
+
+// returns nil if the hash type is unknown
+func ComputeHash(hashType string, data []byte) []byte {
+
+	switch hashType {
+	case HASH_TYPE_RIPEMD160:
+		h := ripemd160.New()
+		h.Write(data)
+		return h.Sum(nil)
+	case HASH_TYPE_SHA1:
+		h := sha1.Sum(data)
+		return h[:]
+	case HASH_TYPE_SHA256:
+		h := sha256.Sum256(data)
+		return h[:]
+	case HASH_TYPE_HASH160:
+		s := sha256.Sum256(data)
+		return ComputeHash(HASH_TYPE_RIPEMD160, s[:])
+	case HASH_TYPE_HASH256:
+		s := sha256.Sum256(data)
+		h := sha256.Sum256(s[:])
+		return h[:]
+	}
+
+	return nil
+}
+
+// returns the hash type and the length of the hash for a hash opcode
+func getHashOpcodeType(opcodeName string) (string, int) {
+	switch opcodeName {
+	case "OP_RIPEMD160":
+		return HASH_TYPE_RIPEMD160, 20
+	case "OP_SHA1":
+		return HASH_TYPE_SHA1, 20
+	case "OP_SHA256":
+		return HASH_TYPE_SHA256, 32
+	case "OP_HASH160":
+		return HASH_TYPE_HASH160, 20
+	case "OP_HASH256":
+		return HASH_TYPE_HASH256, 32
+	}
+	return "", 0
+}
+
+func (s *Script) GetHashLocks() []HashLock {
+
+	hashLocks := make([]HashLock, 0)
+
+	fieldCount := len(s.fields)
+	for f := 0; f+2 < fieldCount; f++ {
+		if !s.fields[f].IsOpcode() || s.fields[f+1].IsOpcode() || !s.fields[f+2].IsOpcode() {
+			continue
+		}
+
+		hashType, hashLen := getHashOpcodeType(s.fields[f].AsHex())
+		if len(hashType) == 0 || len(s.fields[f+1].AsBytes()) != hashLen {
+			continue
+		}
+
+		equalOpcode := s.fields[f+2].AsHex()
+		if equalOpcode != "OP_EQUAL" && equalOpcode != "OP_EQUALVERIFY" {
+			continue
+		}
+
+		// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY is a public key hash check, not a hash lock
+		if f > 0 && s.fields[f-1].AsHex() == "OP_DUP" {
+			continue
+		}
+
+		hashLocks = append(hashLocks, HashLock{hashType: hashType, hash: s.fields[f+1].AsBytes(), hashFieldIndex: f + 1})
+	}
+
+	return hashLocks
+}
+
+// labels the hash fields of every hash lock in the script
+func (s *Script) setHashLockFieldTypes() {
+	for _, hashLock := range s.GetHashLocks() {
+		s.SetFieldType(hashLock.hashFieldIndex, "Hash Lock ("+hashLock.hashType+")")
+	}
+}
+
+func getPreimageLabel(hashType string) string {
+	return "Preimage (verified " + hashType + ")"
+}
@@ -0,0 +1,112 @@
+package btc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// the hashes of "abc"
+var hashLockTestHashes = map[string]string{
+	HASH_TYPE_SHA256:    "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	HASH_TYPE_RIPEMD160: "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc",
+	HASH_TYPE_HASH160:   "bb1be98c142444d7a56aa3981c3942a978e4dc33",
+}
+
+var hashLockTestOpcodes = map[string]byte{
+	HASH_TYPE_SHA256:    0xa8,
+	HASH_TYPE_RIPEMD160: 0xa6,
+	HASH_TYPE_HASH160:   0xa9,
+}
+
+// <hash opcode> <hash> OP_EQUALVERIFY OP_1, which is not mistaken for a P2SH output script
+func getHashLockTestScript(t *testing.T, hashType string) []byte {
+	hash, err := hex.DecodeString(hashLockTestHashes[hashType])
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := []byte{hashLockTestOpcodes[hashType], byte(len(hash))}
+	script = append(script, hash...)
+	return append(script, 0x88, 0x51)
+}
+
+func TestComputeHash(t *testing.T) {
+	for hashType, expected := range hashLockTestHashes {
+		hash := hex.EncodeToString(ComputeHash(hashType, []byte("abc")))
+		if hash != expected {
+			t.Errorf("%s of abc is %s, expected %s", hashType, hash, expected)
+		}
+	}
+}
+
+// the preimage pushed by the input script is verified against the hash lock of the previous output script
+func TestHashLockOutputScript(t *testing.T) {
+
+	for hashType := range hashLockTestHashes {
+		outputScript := NewScript(getHashLockTestScript(t, hashType))
+		hashLocks := outputScript.GetHashLocks()
+		if len(hashLocks) != 1 || hashLocks[0].GetHashType() != hashType || hashLocks[0].GetHashFieldIndex() != 1 {
+			t.Fatalf("%s: found hash locks %+v", hashType, hashLocks)
+		}
+		outputFields := outputScript.GetFields()
+		if outputFields[1].AsType() != "Hash Lock ("+hashType+")" {
+			t.Errorf("%s: the hash field is labelled %s", hashType, outputFields[1].AsType())
+		}
+
+		for preimage, expectedCount := range map[string]uint16{"abc": 1, "abd": 0} {
+			inputScript := NewScript([]byte{0x03, preimage[0], preimage[1], preimage[2]})
+			input := NewInput(false, "", 0, inputScript, Segwit{}, 0xffffffff, Output{})
+			input.SetPreviousOutput(NewOutput(1000, outputScript, ""))
+
+			if input.GetRevealedPreimageCount() != expectedCount {
+				t.Errorf("%s: preimage %s counted %d times, expected %d", hashType, preimage, input.GetRevealedPreimageCount(), expectedCount)
+			}
+
+			resultScript := input.GetInputScript()
+			inputFields := resultScript.GetFields()
+			isLabelled := inputFields[0].AsType() == getPreimageLabel(hashType)
+			if isLabelled != (expectedCount == 1) {
+				t.Errorf("%s: preimage %s is labelled %s", hashType, preimage, inputFields[0].AsType())
+			}
+		}
+	}
+}
+
+// the preimage in the witness is verified against the hash lock of the witness script
+func TestHashLockWitnessScript(t *testing.T) {
+
+	for hashType := range hashLockTestHashes {
+		witnessScript := getHashLockTestScript(t, hashType)
+		scriptHash := sha256.Sum256(witnessScript)
+		outputScript := NewScript(append([]byte{0x00, 0x20}, scriptHash[:]...))
+
+		for preimage, expectedCount := range map[string]uint16{"abc": 1, "abd": 0} {
+			input := NewInput(false, "", 0, NewScript(nil), NewSegwit([][]byte{[]byte(preimage), witnessScript}), 0xffffffff, Output{})
+			input.SetPreviousOutput(NewOutput(1000, outputScript, ""))
+
+			if input.GetSpendType() != OUTPUT_TYPE_P2WSH {
+				t.Fatalf("%s: spend type is %s, expected %s", hashType, input.GetSpendType(), OUTPUT_TYPE_P2WSH)
+			}
+			if input.GetRevealedPreimageCount() != expectedCount {
+				t.Errorf("%s: preimage %s counted %d times, expected %d", hashType, preimage, input.GetRevealedPreimageCount(), expectedCount)
+			}
+
+			segwit := input.GetSegwit()
+			segwitFields := segwit.GetFields()
+			isLabelled := segwitFields[0].AsType() == getPreimageLabel(hashType)
+			if isLabelled != (expectedCount == 1) {
+				t.Errorf("%s: preimage %s is labelled %s", hashType, preimage, segwitFields[0].AsType())
+			}
+		}
+	}
+}
+
+// a public key hash check is not a hash lock
+func TestHashLockP2pkh(t *testing.T) {
+	hash, _ := hex.DecodeString(hashLockTestHashes[HASH_TYPE_HASH160])
+	script := append(append([]byte{0x76, 0xa9, 0x14}, hash...), 0x88, 0xac)
+	p2pkhScript := NewScript(script)
+	if len(p2pkhScript.GetHashLocks()) != 0 {
+		t.Error("a P2PKH output script has a hash lock")
+	}
+}
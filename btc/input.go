@@ -27,6 +27,11 @@ type Input struct {
 
 	previousOutput Output
 	spendType      string
+
+	nonStandardType   string
+	nonStandardReason string
+
+	revealedPreimageCount uint16
 }
 
 func NewInput(coinbase bool, previousOutputTxId string, previousOutputIndex uint16, inputScript Script, segwit Segwit, sequence uint32, previousOutput Output) Input {
@@ -42,14 +47,28 @@ func NewInput(coinbase bool, previousOutputTxId string, previousOutputIndex uint
 	return i
 }
 
+// returns a copy that can be re-evaluated without changing the original
+// the fields of the scripts and the segwit fields are copied, since re-evaluating an input changes their types
+func (i *Input) Copy() Input {
+	inputCopy := *i
+	inputCopy.inputScript = i.inputScript.copy()
+	inputCopy.redeemScript = i.redeemScript.copy()
+	inputCopy.segwit = i.segwit.copy()
+	return inputCopy
+}
+
 func (i *Input) SetPreviousOutput(previousOutput Output) {
 
 	// reset everything
 	i.spendType = ""
+	i.nonStandardType = ""
+	i.nonStandardReason = ""
+	i.revealedPreimageCount = 0
 	i.segwit.DeleteWitnessScript()
 	i.segwit.DeleteTapScript()
 	for f, _ := range i.segwit.fields {
 		i.segwit.fields[f].SetType("")
+		i.segwit.fields[f].SetBinding("")
 	}
 
 	// re-evaluate the input based on the new previous output
@@ -124,15 +143,82 @@ func (i *Input) SetPreviousOutput(previousOutput Output) {
 				i.segwit.SetTapScript(i.segwit.parseTapScript())
 			}
 
+		case OUTPUT_TYPE_P2A:
+
+			// anchors are spent with an empty input script and an empty witness
+			if i.inputScript.IsEmpty() && i.segwit.IsEmpty() {
+				i.spendType = OUTPUT_TYPE_P2A
+			}
+
+		default:
+
+			// other registered witness program types only require an empty input script
+			if IsWitnessProgramOutputType(previousOutputType) && i.inputScript.IsEmpty() {
+				i.spendType = previousOutputType
+			}
 		}
 	}
 
+	// explain why the input is non-standard
+	if i.spendType == SPEND_TYPE_NonStandard {
+		i.nonStandardType, i.nonStandardReason = i.classifyNonStandard()
+	}
+
 	// set the segwit field types
 	for f, field := range i.segwit.fields {
 		if len(field.AsType()) == 0 {
 			i.segwit.fields[f].SetType(GetStackItemType(field.AsBytes(), i.spendType == SPEND_TYPE_P2TR_Key || i.spendType == SPEND_TYPE_P2TR_Script))
 		}
 	}
+
+	i.segwit.setStackBindings()
+	i.verifyPreimages()
+}
+
+// labels every input script field and segwit field that is the preimage of a hash lock
+// in one of the scripts being satisfied by this input
+func (i *Input) verifyPreimages() {
+
+	hashLocks := make([]HashLock, 0)
+	if i.previousOutput.GetOutputType() == OUTPUT_TYPE_NonStandard {
+		previousOutputScript := i.previousOutput.GetOutputScript()
+		hashLocks = append(hashLocks, previousOutputScript.GetHashLocks()...)
+	}
+	if !i.redeemScript.IsNil() {
+		hashLocks = append(hashLocks, i.redeemScript.GetHashLocks()...)
+	}
+	if !i.segwit.witnessScript.IsNil() {
+		hashLocks = append(hashLocks, i.segwit.witnessScript.GetHashLocks()...)
+	}
+	if !i.segwit.tapScript.IsNil() {
+		hashLocks = append(hashLocks, i.segwit.tapScript.GetHashLocks()...)
+	}
+	if len(hashLocks) == 0 {
+		return
+	}
+
+	for f, field := range i.inputScript.fields {
+		if field.IsOpcode() {
+			continue
+		}
+		for _, hashLock := range hashLocks {
+			if hashLock.IsPreimage(field.AsBytes()) {
+				i.inputScript.SetFieldType(f, getPreimageLabel(hashLock.hashType))
+				i.revealedPreimageCount++
+				break
+			}
+		}
+	}
+
+	for f, field := range i.segwit.fields {
+		for _, hashLock := range hashLocks {
+			if hashLock.IsPreimage(field.AsBytes()) {
+				i.segwit.fields[f].SetType(getPreimageLabel(hashLock.hashType))
+				i.revealedPreimageCount++
+				break
+			}
+		}
+	}
 }
 
 func (i *Input) SetRedeemScript(redeemScript Script) {
@@ -179,6 +265,18 @@ func (i *Input) GetSpendType() string {
 	return i.spendType
 }
 
+func (i *Input) GetNonStandardType() string {
+	return i.nonStandardType
+}
+
+func (i *Input) GetNonStandardReason() string {
+	return i.nonStandardReason
+}
+
+func (i *Input) GetRevealedPreimageCount() uint16 {
+	return i.revealedPreimageCount
+}
+
 func (i *Input) GetSequence() uint32 {
 	return i.sequence
 }
@@ -0,0 +1,611 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// the address index maps the hash of each output script to the outputs that funded it and the inputs that spent them
+// it is built by the block scanner from the start height to the end height, or to the tip if there is no end height
+// an input is only indexed if the output it spends was funded in an indexed block
+// outputs and spends in unconfirmed transactions are not indexed
+
+// increment whenever the stored data changes, an index built by another version is rebuilt
+const ADDRESS_INDEX_VERSION = 1
+
+// buckets
+const ADDRESS_INDEX_BUCKET_Meta = "meta"
+const ADDRESS_INDEX_BUCKET_Outputs = "outputs" // outpoint -> script hash, value and block height of every indexed output
+const ADDRESS_INDEX_BUCKET_Scripts = "scripts" // script hash -> script length, script and address
+const ADDRESS_INDEX_BUCKET_History = "history" // script hash, block height, tx id, direction and index -> value, and for spends the outpoint and spend type
+const ADDRESS_INDEX_BUCKET_Blocks = "blocks"   // height -> hash of every indexed block
+
+// meta keys
+const ADDRESS_INDEX_KEY_Version = "version" // the version followed by the start and end heights
+
+// history directions
+const ADDRESS_HISTORY_Funding = byte(0)
+const ADDRESS_HISTORY_Spending = byte(1)
+
+// key lengths
+const ADDRESS_INDEX_SCRIPT_HASH_LEN = 32
+const ADDRESS_INDEX_HISTORY_KEY_LEN = ADDRESS_INDEX_SCRIPT_HASH_LEN + 4 + 32 + 1 + 4
+
+// AddressHistoryEntry is an output that funded a script or an input that spent one of those outputs
+type AddressHistoryEntry struct {
+	txId        string
+	blockHeight uint32
+	isSpend     bool
+	index       uint32 // the output index for funding entries and the input index for spends
+	value       uint64
+
+	// only for spends
+	previousOutputTxId  string
+	previousOutputIndex uint32
+	spendType           string
+}
+
+func (e *AddressHistoryEntry) GetTxId() string {
+	return e.txId
+}
+
+func (e *AddressHistoryEntry) GetBlockHeight() uint32 {
+	return e.blockHeight
+}
+
+func (e *AddressHistoryEntry) IsSpend() bool {
+	return e.isSpend
+}
+
+// the output index for outputs and the input index for spends
+func (e *AddressHistoryEntry) GetIndex() uint32 {
+	return e.index
+}
+
+func (e *AddressHistoryEntry) GetValue() uint64 {
+	return e.value
+}
+
+func (e *AddressHistoryEntry) GetPreviousOutputTxId() string {
+	return e.previousOutputTxId
+}
+
+func (e *AddressHistoryEntry) GetPreviousOutputIndex() uint32 {
+	return e.previousOutputIndex
+}
+
+func (e *AddressHistoryEntry) GetSpendType() string {
+	return e.spendType
+}
+
+// AddressUtxo is an output to a script that has not been spent in an indexed block
+type AddressUtxo struct {
+	txId        string
+	outputIndex uint32
+	value       uint64
+	blockHeight uint32
+}
+
+func (u *AddressUtxo) GetTxId() string {
+	return u.txId
+}
+
+func (u *AddressUtxo) GetOutputIndex() uint32 {
+	return u.outputIndex
+}
+
+func (u *AddressUtxo) GetValue() uint64 {
+	return u.value
+}
+
+func (u *AddressUtxo) GetBlockHeight() uint32 {
+	return u.blockHeight
+}
+
+// AddressSummary is the totals of the history of a script
+type AddressSummary struct {
+	scriptHash   string
+	outputScript btc.Script
+	outputType   string
+	address      string
+
+	fundedCount uint32
+	fundedValue uint64
+	spentCount  uint32
+	spentValue  uint64
+	spendTypes  map[string]uint32
+
+	startHeight   uint32
+	indexedHeight int64 // -1 if no block has been indexed
+}
+
+func (s *AddressSummary) IsNil() bool {
+	return len(s.scriptHash) == 0
+}
+
+func (s *AddressSummary) GetScriptHash() string {
+	return s.scriptHash
+}
+
+// the output script is nil if no output to the script has been indexed
+func (s *AddressSummary) GetOutputScript() btc.Script {
+	return s.outputScript
+}
+
+func (s *AddressSummary) GetOutputType() string {
+	return s.outputType
+}
+
+func (s *AddressSummary) GetAddress() string {
+	return s.address
+}
+
+func (s *AddressSummary) GetFundedCount() uint32 {
+	return s.fundedCount
+}
+
+func (s *AddressSummary) GetFundedValue() uint64 {
+	return s.fundedValue
+}
+
+func (s *AddressSummary) GetSpentCount() uint32 {
+	return s.spentCount
+}
+
+func (s *AddressSummary) GetSpentValue() uint64 {
+	return s.spentValue
+}
+
+func (s *AddressSummary) GetBalance() uint64 {
+	return s.fundedValue - s.spentValue
+}
+
+// the number of spends of each spend type
+func (s *AddressSummary) GetSpendTypes() map[string]uint32 {
+	return s.spendTypes
+}
+
+func (s *AddressSummary) GetStartHeight() uint32 {
+	return s.startHeight
+}
+
+// the height of the last indexed block, or -1 if no block has been indexed
+func (s *AddressSummary) GetIndexedHeight() int64 {
+	return s.indexedHeight
+}
+
+type addressIndex struct {
+	db          *bolt.DB
+	startHeight uint32
+	endHeight   uint32 // 0 if the index follows the tip
+}
+
+func openAddressIndex(fileName string, startHeight uint32, endHeight uint32) (*addressIndex, error) {
+
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.New("ADDRESS INDEX ERROR: " + fileName + ": " + err.Error())
+	}
+
+	ai := addressIndex{db: db, startHeight: startHeight, endHeight: endHeight}
+	err = ai.checkVersion()
+	if err != nil {
+		db.Close()
+		return nil, errors.New("ADDRESS INDEX ERROR: " + err.Error())
+	}
+
+	return &ai, nil
+}
+
+// the index is rebuilt if it was built by a different version or for a different block range
+func (ai *addressIndex) checkVersion() error {
+
+	meta := make([]byte, 12)
+	binary.BigEndian.PutUint32(meta[:4], ADDRESS_INDEX_VERSION)
+	binary.BigEndian.PutUint32(meta[4:8], ai.startHeight)
+	binary.BigEndian.PutUint32(meta[8:], ai.endHeight)
+
+	return ai.db.Update(func(tx *bolt.Tx) error {
+		metaBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Meta))
+		if metaBucket != nil && bytes.Equal(metaBucket.Get([]byte(ADDRESS_INDEX_KEY_Version)), meta) {
+			return nil
+		}
+
+		if metaBucket != nil {
+			fmt.Println("The address index was built by a different version of the scantool or for a different block range and will be rebuilt.")
+		}
+
+		for _, bucket := range []string{ADDRESS_INDEX_BUCKET_Meta, ADDRESS_INDEX_BUCKET_Outputs, ADDRESS_INDEX_BUCKET_Scripts, ADDRESS_INDEX_BUCKET_History, ADDRESS_INDEX_BUCKET_Blocks} {
+			err := tx.DeleteBucket([]byte(bucket))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Meta)).Put([]byte(ADDRESS_INDEX_KEY_Version), meta)
+	})
+}
+
+// the script hash is the sha256 hash of the output script
+// scripts are looked up either by address or by script hash
+func getScriptHashKey(addressOrScriptHash string) ([]byte, error) {
+
+	if len(addressOrScriptHash) == 2*ADDRESS_INDEX_SCRIPT_HASH_LEN {
+		scriptHash, err := hex.DecodeString(addressOrScriptHash)
+		if err == nil {
+			return scriptHash, nil
+		}
+	}
+
+	outputScript, err := btc.GetOutputScript(addressOrScriptHash)
+	if err != nil {
+		return nil, newNotFoundError(addressOrScriptHash + " is not an address or a script hash.")
+	}
+	scriptHash := sha256.Sum256(outputScript)
+	return scriptHash[:], nil
+}
+
+func getHistoryKey(scriptHash []byte, blockHeight uint32, txId []byte, direction byte, index uint32) []byte {
+	key := make([]byte, 0, ADDRESS_INDEX_HISTORY_KEY_LEN)
+	key = append(key, scriptHash...)
+	key = binary.BigEndian.AppendUint32(key, blockHeight)
+	key = append(key, txId...)
+	key = append(key, direction)
+	return binary.BigEndian.AppendUint32(key, index)
+}
+
+// calls read for every history entry of a script, in the order of the blocks they are in
+func (ai *addressIndex) forEachHistoryEntry(scriptHash []byte, read func(entry AddressHistoryEntry) error) error {
+
+	err := ai.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_History)).Cursor()
+		for key, value := cursor.Seek(scriptHash); key != nil && bytes.HasPrefix(key, scriptHash); key, value = cursor.Next() {
+			if len(key) != ADDRESS_INDEX_HISTORY_KEY_LEN || len(value) < 8 {
+				continue
+			}
+
+			entry := AddressHistoryEntry{
+				blockHeight: binary.BigEndian.Uint32(key[32:36]),
+				txId:        hex.EncodeToString(key[36:68]),
+				isSpend:     key[68] == ADDRESS_HISTORY_Spending,
+				index:       binary.BigEndian.Uint32(key[69:]),
+				value:       binary.BigEndian.Uint64(value[:8])}
+			if entry.isSpend && len(value) >= 44 {
+				entry.previousOutputTxId = hex.EncodeToString(value[8:40])
+				entry.previousOutputIndex = binary.BigEndian.Uint32(value[40:44])
+				entry.spendType = string(value[44:])
+			}
+
+			err := read(entry)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return newUnavailableError("ADDRESS INDEX ERROR:", err)
+	}
+	return nil
+}
+
+// returns at most limit entries of the history of a script, starting at offset
+func (ai *addressIndex) getHistory(addressOrScriptHash string, offset int, limit int) ([]AddressHistoryEntry, error) {
+
+	scriptHash, err := getScriptHashKey(addressOrScriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]AddressHistoryEntry, 0)
+	position := 0
+	err = ai.forEachHistoryEntry(scriptHash, func(entry AddressHistoryEntry) error {
+		if position >= offset && len(history) < limit {
+			history = append(history, entry)
+		}
+		position++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// returns the outputs to a script that have not been spent in an indexed block, in the order of the blocks they are in
+func (ai *addressIndex) getUtxos(addressOrScriptHash string) ([]AddressUtxo, error) {
+
+	scriptHash, err := getScriptHashKey(addressOrScriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := make([]AddressUtxo, 0)
+	spent := make(map[string]bool)
+	err = ai.forEachHistoryEntry(scriptHash, func(entry AddressHistoryEntry) error {
+		if entry.isSpend {
+			spent[fmt.Sprintf("%s:%d", entry.previousOutputTxId, entry.previousOutputIndex)] = true
+		} else {
+			utxos = append(utxos, AddressUtxo{txId: entry.txId, outputIndex: entry.index, value: entry.value, blockHeight: entry.blockHeight})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unspent := make([]AddressUtxo, 0, len(utxos))
+	for _, utxo := range utxos {
+		if !spent[fmt.Sprintf("%s:%d", utxo.txId, utxo.outputIndex)] {
+			unspent = append(unspent, utxo)
+		}
+	}
+	return unspent, nil
+}
+
+// returns the totals of the history of a script
+func (ai *addressIndex) getSummary(addressOrScriptHash string) (AddressSummary, error) {
+
+	scriptHash, err := getScriptHashKey(addressOrScriptHash)
+	if err != nil {
+		return AddressSummary{}, err
+	}
+
+	summary := AddressSummary{scriptHash: hex.EncodeToString(scriptHash), spendTypes: make(map[string]uint32), startHeight: ai.startHeight}
+	summary.indexedHeight, _ = ai.getLastBlock()
+
+	err = ai.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Scripts)).Get(scriptHash)
+		if len(value) >= 2 {
+			scriptLen := int(binary.BigEndian.Uint16(value[:2]))
+			if len(value) >= 2+scriptLen {
+				output := btc.NewOutput(0, btc.NewScript(value[2:2+scriptLen]), string(value[2+scriptLen:]))
+				summary.outputScript = output.GetOutputScript()
+				summary.outputType = output.GetOutputType()
+				summary.address = output.GetAddress()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return AddressSummary{}, newUnavailableError("ADDRESS INDEX ERROR:", err)
+	}
+
+	err = ai.forEachHistoryEntry(scriptHash, func(entry AddressHistoryEntry) error {
+		if entry.isSpend {
+			summary.spentCount++
+			summary.spentValue += entry.value
+			summary.spendTypes[entry.spendType]++
+		} else {
+			summary.fundedCount++
+			summary.fundedValue += entry.value
+		}
+		return nil
+	})
+	if err != nil {
+		return AddressSummary{}, err
+	}
+
+	return summary, nil
+}
+
+func (ai *addressIndex) getName() string {
+	return "Address index"
+}
+
+func (ai *addressIndex) getStartHeight() uint32 {
+	return ai.startHeight
+}
+
+func (ai *addressIndex) getEndHeight() uint32 {
+	return ai.endHeight
+}
+
+func (ai *addressIndex) getLastBlock() (int64, string) {
+
+	height := int64(-1)
+	blockHash := ""
+	ai.db.View(func(tx *bolt.Tx) error {
+		key, value := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Blocks)).Cursor().Last()
+		if len(key) == 4 {
+			height = int64(binary.BigEndian.Uint32(key))
+			blockHash = string(value)
+		}
+		return nil
+	})
+	return height, blockHash
+}
+
+// the transactions of a raw block, in block order
+func decodeBlockTxs(rawBlock *nodeBlock) ([]btc.Tx, error) {
+
+	if !rawBlock.hasTxData() {
+		return nil, newDecodeError("Block has no transaction data.", nil)
+	}
+
+	rawTxs := rawBlock.getTxs()
+	txs := make([]btc.Tx, len(rawTxs))
+	for t := range rawTxs {
+		var err error
+		txs[t], err = decodeTx(&rawTxs[t])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return txs, nil
+}
+
+// outputs are indexed before the inputs of later transactions, which might spend them
+func (ai *addressIndex) connectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	txs, err := decodeBlockTxs(rawBlock)
+	if err != nil {
+		return err
+	}
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return ai.db.Update(func(tx *bolt.Tx) error {
+		outputBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Outputs))
+		scriptBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Scripts))
+		historyBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_History))
+
+		for _, blockTx := range txs {
+			txIdBytes, err := hex.DecodeString(blockTx.GetTxId())
+			if err != nil || len(txIdBytes) != 32 {
+				return newDecodeError(blockTx.GetTxId()+" is not a transaction id.", nil)
+			}
+
+			// spends of indexed outputs
+			for i, input := range blockTx.GetInputs() {
+				if input.IsCoinbase() {
+					continue
+				}
+
+				outpointKey, err := getOutpointKey(input.GetPreviousOutputTxId(), uint32(input.GetPreviousOutputIndex()))
+				if err != nil {
+					return newDecodeError("Malformed input in transaction "+blockTx.GetTxId()+".", err)
+				}
+				outputValue := outputBucket.Get(outpointKey)
+				if len(outputValue) != 44 {
+					continue
+				}
+				scriptHash := outputValue[:32]
+
+				// the spend type is determined from the script of the output being spent
+				spendType := ""
+				scriptValue := scriptBucket.Get(scriptHash)
+				if len(scriptValue) >= 2 {
+					scriptLen := int(binary.BigEndian.Uint16(scriptValue[:2]))
+					if len(scriptValue) >= 2+scriptLen {
+						input.SetPreviousOutput(btc.NewOutput(binary.BigEndian.Uint64(outputValue[32:40]), btc.NewScript(scriptValue[2:2+scriptLen]), ""))
+						spendType = input.GetSpendType()
+					}
+				}
+
+				historyValue := append(append([]byte{}, outputValue[32:40]...), outpointKey...)
+				historyValue = append(historyValue, []byte(spendType)...)
+				err = historyBucket.Put(getHistoryKey(scriptHash, blockHeight, txIdBytes, ADDRESS_HISTORY_Spending, uint32(i)), historyValue)
+				if err != nil {
+					return err
+				}
+			}
+
+			// outputs, except for unspendable data outputs
+			for o, output := range blockTx.GetOutputs() {
+				outputScript := output.GetOutputScript()
+				if output.GetOutputType() == btc.OUTPUT_TYPE_OP_RETURN {
+					continue
+				}
+
+				scriptBytes := outputScript.AsBytes()
+				scriptHash := sha256.Sum256(scriptBytes)
+				if scriptBucket.Get(scriptHash[:]) == nil && len(scriptBytes) <= 0xffff {
+					scriptValue := binary.BigEndian.AppendUint16(nil, uint16(len(scriptBytes)))
+					scriptValue = append(scriptValue, scriptBytes...)
+					scriptValue = append(scriptValue, []byte(output.GetAddress())...)
+					err = scriptBucket.Put(scriptHash[:], scriptValue)
+					if err != nil {
+						return err
+					}
+				}
+
+				outputValue := binary.BigEndian.AppendUint64(append([]byte{}, scriptHash[:]...), output.GetValue())
+				outputValue = binary.BigEndian.AppendUint32(outputValue, blockHeight)
+				err = outputBucket.Put(binary.BigEndian.AppendUint32(append([]byte{}, txIdBytes...), uint32(o)), outputValue)
+				if err != nil {
+					return err
+				}
+
+				err = historyBucket.Put(getHistoryKey(scriptHash[:], blockHeight, txIdBytes, ADDRESS_HISTORY_Funding, uint32(o)), binary.BigEndian.AppendUint64(nil, output.GetValue()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Blocks)).Put(heightKey, []byte(blockHash))
+	})
+}
+
+// the entries of a block replaced by a reorg are removed
+// spends are removed before outputs, because outputs that were spent in the same block are needed to find the spends
+func (ai *addressIndex) disconnectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	var txs []btc.Tx
+	if rawBlock != nil {
+		var err error
+		txs, err = decodeBlockTxs(rawBlock)
+		if err != nil {
+			return err
+		}
+	}
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return ai.db.Update(func(tx *bolt.Tx) error {
+		outputBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Outputs))
+		historyBucket := tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_History))
+
+		txIds := make([][]byte, len(txs))
+		for t := range txs {
+			txIdBytes, err := hex.DecodeString(txs[t].GetTxId())
+			if err != nil || len(txIdBytes) != 32 {
+				return newDecodeError(txs[t].GetTxId()+" is not a transaction id.", nil)
+			}
+			txIds[t] = txIdBytes
+
+			for i, input := range txs[t].GetInputs() {
+				if input.IsCoinbase() {
+					continue
+				}
+				outpointKey, err := getOutpointKey(input.GetPreviousOutputTxId(), uint32(input.GetPreviousOutputIndex()))
+				if err != nil {
+					return newDecodeError("Malformed input in transaction "+txs[t].GetTxId()+".", err)
+				}
+				outputValue := outputBucket.Get(outpointKey)
+				if len(outputValue) != 44 {
+					continue
+				}
+				err = historyBucket.Delete(getHistoryKey(outputValue[:32], blockHeight, txIdBytes, ADDRESS_HISTORY_Spending, uint32(i)))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for t := range txs {
+			for o := range txs[t].GetOutputs() {
+				outpointKey := binary.BigEndian.AppendUint32(append([]byte{}, txIds[t]...), uint32(o))
+				outputValue := outputBucket.Get(outpointKey)
+				if len(outputValue) != 44 || binary.BigEndian.Uint32(outputValue[40:]) != blockHeight {
+					continue
+				}
+				err := historyBucket.Delete(getHistoryKey(outputValue[:32], blockHeight, txIds[t], ADDRESS_HISTORY_Funding, uint32(o)))
+				if err != nil {
+					return err
+				}
+				err = outputBucket.Delete(outpointKey)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Bucket([]byte(ADDRESS_INDEX_BUCKET_Blocks)).Delete(heightKey)
+	})
+}
@@ -0,0 +1,145 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+func getIndexTestScriptHash(t *testing.T, scriptHex string) string {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptHash := sha256.Sum256(script)
+	return hex.EncodeToString(scriptHash[:])
+}
+
+func newFundingTestEntry(height uint32, tx int, outputIndex uint32, value uint64) AddressHistoryEntry {
+	return AddressHistoryEntry{txId: getIndexTestTxId(tx), blockHeight: height, index: outputIndex, value: value}
+}
+
+func newSpendingTestEntry(height uint32, tx int, inputIndex uint32, value uint64, previousTx int, previousOutputIndex uint32) AddressHistoryEntry {
+	return AddressHistoryEntry{txId: getIndexTestTxId(tx), blockHeight: height, isSpend: true, index: inputIndex, value: value,
+		previousOutputTxId: getIndexTestTxId(previousTx), previousOutputIndex: previousOutputIndex, spendType: btc.OUTPUT_TYPE_P2PKH}
+}
+
+// the history is read a page at a time, and every page together is the whole history
+func checkAddressHistory(t *testing.T, ai *addressIndex, scriptHash string, expected []AddressHistoryEntry) {
+
+	history, err := ai.getHistory(scriptHash, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Fatalf("the history is %+v, expected %+v", history, expected)
+	}
+
+	for _, page := range []struct{ offset, limit int }{{0, 4}, {4, 4}, {1, 2}, {len(expected) - 1, 4}, {len(expected), 4}} {
+		history, err := ai.getHistory(scriptHash, page.offset, page.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		end := page.offset + page.limit
+		if end > len(expected) {
+			end = len(expected)
+		}
+		if !reflect.DeepEqual(history, expected[page.offset:end]) {
+			t.Errorf("the history at offset %d with limit %d is %+v, expected %+v", page.offset, page.limit, history, expected[page.offset:end])
+		}
+	}
+}
+
+func checkAddressUtxos(t *testing.T, ai *addressIndex, scriptHash string, expected []AddressUtxo) {
+	utxos, err := ai.getUtxos(scriptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(utxos, expected) {
+		t.Errorf("the utxos are %+v, expected %+v", utxos, expected)
+	}
+}
+
+func TestAddressIndex(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "addresses.db")
+	ai, err := openAddressIndex(fileName, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptHashA := getIndexTestScriptHash(t, indexTestScriptA)
+	scriptHashB := getIndexTestScriptHash(t, indexTestScriptB)
+
+	btcNode := newIndexTestNode()
+	scanIndexTestChain(t, ai, btcNode, 2, getZmqTestBlockHash(2))
+
+	// in each block, the outputs of a transaction come before its spends
+	checkAddressHistory(t, ai, scriptHashA, []AddressHistoryEntry{
+		newFundingTestEntry(0, 0, 0, 5000),
+		newFundingTestEntry(0, 0, 1, 3000),
+		newFundingTestEntry(1, 2, 0, 1000),
+		newSpendingTestEntry(1, 2, 0, 5000, 0, 0),
+		newSpendingTestEntry(2, 4, 0, 3000, 0, 1),
+		newSpendingTestEntry(2, 4, 1, 1000, 2, 0)})
+	checkAddressUtxos(t, ai, scriptHashA, []AddressUtxo{})
+	checkAddressUtxos(t, ai, scriptHashB, []AddressUtxo{
+		{txId: getIndexTestTxId(1), outputIndex: 0, value: 5000, blockHeight: 1},
+		{txId: getIndexTestTxId(2), outputIndex: 1, value: 3900, blockHeight: 1},
+		{txId: getIndexTestTxId(3), outputIndex: 0, value: 5000, blockHeight: 2},
+		{txId: getIndexTestTxId(4), outputIndex: 0, value: 3900, blockHeight: 2}})
+
+	// the outputs and spends of block 2 are replaced by those of the stale branch
+	btcNode.reorg()
+	scanIndexTestChain(t, ai, btcNode, 3, getStaleTestBlockHash(3))
+	expectedHistoryA := []AddressHistoryEntry{
+		newFundingTestEntry(0, 0, 0, 5000),
+		newFundingTestEntry(0, 0, 1, 3000),
+		newFundingTestEntry(1, 2, 0, 1000),
+		newSpendingTestEntry(1, 2, 0, 5000, 0, 0),
+		newFundingTestEntry(2, 5, 0, 5000),
+		newFundingTestEntry(2, 6, 0, 2900),
+		newSpendingTestEntry(2, 6, 0, 3000, 0, 1)}
+	checkAddressHistory(t, ai, scriptHashA, expectedHistoryA)
+	expectedUtxosA := []AddressUtxo{
+		{txId: getIndexTestTxId(2), outputIndex: 0, value: 1000, blockHeight: 1},
+		{txId: getIndexTestTxId(5), outputIndex: 0, value: 5000, blockHeight: 2},
+		{txId: getIndexTestTxId(6), outputIndex: 0, value: 2900, blockHeight: 2}}
+	checkAddressUtxos(t, ai, scriptHashA, expectedUtxosA)
+	checkAddressUtxos(t, ai, scriptHashB, []AddressUtxo{
+		{txId: getIndexTestTxId(1), outputIndex: 0, value: 5000, blockHeight: 1},
+		{txId: getIndexTestTxId(2), outputIndex: 1, value: 3900, blockHeight: 1},
+		{txId: getIndexTestTxId(7), outputIndex: 0, value: 5000, blockHeight: 3}})
+
+	summary, err := ai.getSummary(scriptHashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.GetFundedCount() != 5 || summary.GetFundedValue() != 16900 || summary.GetSpentCount() != 2 || summary.GetBalance() != 8900 ||
+		summary.GetSpendTypes()[btc.OUTPUT_TYPE_P2PKH] != 2 || summary.GetOutputType() != btc.OUTPUT_TYPE_P2PKH || summary.GetIndexedHeight() != 3 {
+		t.Errorf("the summary of script A is %+v", summary)
+	}
+
+	// the index is kept when it is opened again
+	ai.db.Close()
+	ai, err = openAddressIndex(fileName, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAddressHistory(t, ai, scriptHashA, expectedHistoryA)
+	checkAddressUtxos(t, ai, scriptHashA, expectedUtxosA)
+	ai.db.Close()
+
+	// an index with an end height is rebuilt up to the end height
+	ai, err = openAddressIndex(fileName, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ai.db.Close() })
+	scanIndexTestChain(t, ai, btcNode, 1, getZmqTestBlockHash(1))
+	checkAddressHistory(t, ai, scriptHashA, expectedHistoryA[:4])
+}
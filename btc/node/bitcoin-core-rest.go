@@ -0,0 +1,218 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// uses Bitcoin Core's unauthenticated REST interface, which must be enabled with rest=1
+// blocks are requested in binary format and deserialized natively, which is much faster than JSON for large blocks
+
+type BitcoinCoreRest struct {
+	version string
+	network string
+	url     string
+}
+
+// the url includes the scheme and the port
+func NewBitcoinCoreRest(url string) (*BitcoinCoreRest, error) {
+
+	bcr := BitcoinCoreRest{url: strings.TrimSuffix(url, "/")}
+	bcr.version = bcr.getVersionStr()
+	if len(bcr.version) == 0 {
+		return nil, errors.New("Failed to connect to Bitcoin Core REST interface.")
+	}
+	return &bcr, nil
+}
+
+func (bcr *BitcoinCoreRest) getNodeType() string {
+	return app.NODE_TYPE_BitcoinCoreRest
+}
+
+func (bcr *BitcoinCoreRest) GetVersionString() string {
+	return bcr.getNodeType() + " " + bcr.version
+}
+
+// the REST interface does not report the node version, so the network is used instead
+func (bcr *BitcoinCoreRest) getVersionStr() string {
+	chainInfo, err := bcr.getChainInfo(context.Background())
+	if err != nil {
+		fmt.Println(err.Error())
+		return ""
+	}
+	if len(chainInfo.Chain) == 0 {
+		return ""
+	}
+
+	bcr.network = chainInfo.Chain
+	return "(" + bcr.network + ")"
+}
+
+// API functions
+
+// the fields of a block header that are not part of a serialized block
+type restBlockHeader struct {
+	Height        uint32 `json:"height"`
+	Confirmations int64  `json:"confirmations"`
+	NextBlockHash string `json:"nextblockhash"`
+}
+
+type restChainInfo struct {
+	Chain         string `json:"chain"`
+	BestBlockHash string `json:"bestblockhash"`
+}
+
+func (bcr *BitcoinCoreRest) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+
+	// without transaction data, the JSON response is small and already has the structure of getblock with verbosity 1
+	if !withTxData {
+		var rawBlock nodeBlock
+		err := bcr.getJson(ctx, "/rest/block/notxdetails/"+blockHash+".json", &rawBlock)
+		if err != nil {
+			return nil, err
+		}
+		return &rawBlock, nil
+	}
+
+	// the height and the next block hash are not part of a serialized block, so they are read from the header
+	var headers []restBlockHeader
+	err := bcr.getJson(ctx, "/rest/headers/"+blockHash+".json?count=1", &headers)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, newNotFoundError("BITCOIN CORE REST ERROR: Block " + blockHash + " not found.")
+	}
+
+	serializedBlock, err := bcr.get(ctx, "/rest/block/"+blockHash+".bin")
+	if err != nil {
+		return nil, err
+	}
+
+	rawBlock, err := deserializeBlock(serializedBlock, bcr.network, withTxData)
+	if err != nil {
+		return nil, newDecodeError("BITCOIN CORE REST ERROR: Block "+blockHash+" could not be deserialized.", err)
+	}
+	rawBlock.Height = headers[0].Height
+	rawBlock.Confirmations = headers[0].Confirmations
+	rawBlock.NextBlockHash = headers[0].NextBlockHash
+
+	return rawBlock, nil
+}
+
+func (bcr *BitcoinCoreRest) getBestBlockHash(ctx context.Context) (string, error) {
+	chainInfo, err := bcr.getChainInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(chainInfo.BestBlockHash) == 0 {
+		return "", newDecodeError("BITCOIN CORE REST ERROR: No best block hash in chain info.", nil)
+	}
+	return chainInfo.BestBlockHash, nil
+}
+
+// the REST interface only reports the tip of the active chain
+func (bcr *BitcoinCoreRest) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	return nil, newUnavailableError("Chain tips are not available from the Bitcoin Core REST interface.", nil)
+}
+
+func (bcr *BitcoinCoreRest) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	blockHashBytes, err := bcr.get(ctx, fmt.Sprintf("/rest/blockhashbyheight/%d.bin", blockHeight))
+	if err != nil {
+		return "", err
+	}
+	if len(blockHashBytes) != 32 {
+		return "", newDecodeError(fmt.Sprintf("BITCOIN CORE REST ERROR: Block hash has %d bytes.", len(blockHashBytes)), nil)
+	}
+	return reverseHex(blockHashBytes), nil
+}
+
+// transactions are requested as JSON because the binary format does not include the block hash and block time
+func (bcr *BitcoinCoreRest) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	var rawTx nodeTx
+	err := bcr.getJson(ctx, "/rest/tx/"+txId+".json", &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rawTx, nil
+}
+
+// the REST interface has no request for a transaction in a block, so the transaction is taken from the block
+func (bcr *BitcoinCoreRest) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	rawBlock, err := bcr.getBlock(ctx, blockHash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTx := rawBlock.findTx(txId)
+	if rawTx == nil {
+		return nil, newNotFoundError("Transaction " + txId + " not found in block " + blockHash + ".")
+	}
+	return rawTx, nil
+}
+
+func (bcr *BitcoinCoreRest) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	var txIds []string
+	err := bcr.getJson(ctx, "/rest/mempool/contents.json?verbose=false", &txIds)
+	if err != nil {
+		return nil, err
+	}
+	return txIds, nil
+}
+
+// the REST interface can only return the entries for the entire mempool, which is too large to request for a single transaction
+func (bcr *BitcoinCoreRest) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	return nil, newUnavailableError("Mempool entries are not available from the Bitcoin Core REST interface.", nil)
+}
+
+func (bcr *BitcoinCoreRest) getChainInfo(ctx context.Context) (restChainInfo, error) {
+	var chainInfo restChainInfo
+	err := bcr.getJson(ctx, "/rest/chaininfo.json", &chainInfo)
+	return chainInfo, err
+}
+
+func (bcr *BitcoinCoreRest) getJson(ctx context.Context, path string, response interface{}) error {
+	responseBody, err := bcr.get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(responseBody, response)
+	if err != nil {
+		return newDecodeError("JSON ERROR:", err)
+	}
+	return nil
+}
+
+func (bcr *BitcoinCoreRest) get(ctx context.Context, path string) ([]byte, error) {
+
+	requestUrl := bcr.url + path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, errors.New("BITCOIN CORE REST ERROR: " + err.Error())
+	}
+	response, err := getHttpClient().Do(request)
+	if err != nil {
+		return nil, newUnavailableError("BITCOIN CORE REST ERROR:", err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, newUnavailableError("BITCOIN CORE REST ERROR:", err)
+	}
+
+	// errors are returned as plain text
+	if response.StatusCode != http.StatusOK {
+		return nil, getHttpStatusError("BITCOIN CORE REST", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return responseBody, nil
+}
@@ -0,0 +1,180 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// serves mainnet block 277647 the way Bitcoin Core's REST interface does, with the block as the tip of the chain
+// the serialized block is served as it is, unless it is replaced with other bytes
+func newBitcoinCoreRestTestServer(t *testing.T, serializedBlock []byte, statusCode *int) *BitcoinCoreRest {
+
+	expected := readTestBlockFromJson(t)
+	blockJson, err := json.Marshal(expected.withoutTxData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	txJson, err := json.Marshal(expected.findTx(expected.getTxIds()[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockHashBytes := mustDecodeHex(t, reverseHex(mustDecodeHex(t, testBlockHash)))
+
+	responses := map[string][]byte{
+		"/rest/chaininfo.json":                                         []byte(`{"chain":"main","blocks":277647,"bestblockhash":"` + testBlockHash + `"}`),
+		"/rest/headers/" + testBlockHash + ".json":                     []byte(fmt.Sprintf(`[{"hash":"%s","confirmations":1,"height":%d}]`, testBlockHash, testBlockHeight)),
+		"/rest/block/" + testBlockHash + ".bin":                        serializedBlock,
+		"/rest/block/notxdetails/" + testBlockHash + ".json":           blockJson,
+		fmt.Sprintf("/rest/blockhashbyheight/%d.bin", testBlockHeight): blockHashBytes,
+		"/rest/tx/" + expected.getTxIds()[1] + ".json":                 txJson,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode != nil && *statusCode != 0 {
+			w.WriteHeader(*statusCode)
+			w.Write([]byte(http.StatusText(*statusCode)))
+			return
+		}
+
+		response, found := responses[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(r.URL.Path + " not found\r\n"))
+			return
+		}
+		w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+
+	bcr, err := NewBitcoinCoreRest(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bcr
+}
+
+func mustDecodeHex(t *testing.T, hexString string) []byte {
+	b, err := hex.DecodeString(hexString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBitcoinCoreRestGetBlock(t *testing.T) {
+
+	bcr := newBitcoinCoreRestTestServer(t, readTestBlock(t), nil)
+	expected := readTestBlockFromJson(t)
+
+	if bcr.getVersionStr() != "(main)" {
+		t.Errorf("version is %s, expected (main)", bcr.getVersionStr())
+	}
+
+	rawBlock, err := bcr.getBlock(context.Background(), testBlockHash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the height and confirmations come from the header, everything else from the serialized block
+	if rawBlock.Hash != testBlockHash || rawBlock.Height != testBlockHeight || rawBlock.Confirmations != 1 || len(rawBlock.NextBlockHash) != 0 {
+		t.Errorf("unexpected block %s %d %d %s", rawBlock.Hash, rawBlock.Height, rawBlock.Confirmations, rawBlock.NextBlockHash)
+	}
+	if rawBlock.PreviousBlockHash != expected.PreviousBlockHash || rawBlock.Time != expected.Time {
+		t.Errorf("unexpected header %s %d", rawBlock.PreviousBlockHash, rawBlock.Time)
+	}
+	if !reflect.DeepEqual(rawBlock.getTxs(), expected.getTxs()) {
+		t.Error("the txs of the block do not match the JSON form")
+	}
+	if rawBlock.validate() != nil {
+		t.Error(rawBlock.validate())
+	}
+
+	// without tx data, the JSON form is requested instead
+	rawBlock, err = bcr.getBlock(context.Background(), testBlockHash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawBlock.hasTxData() || !reflect.DeepEqual(rawBlock.getTxIds(), expected.getTxIds()) {
+		t.Error("the txids of the block do not match the JSON form")
+	}
+}
+
+func TestBitcoinCoreRestGetTx(t *testing.T) {
+
+	bcr := newBitcoinCoreRestTestServer(t, readTestBlock(t), nil)
+	expected := readTestBlockFromJson(t)
+	txId := expected.getTxIds()[1]
+
+	rawTx, err := bcr.getTx(context.Background(), txId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rawTx, expected.findTx(txId)) {
+		t.Errorf("unexpected tx %+v", rawTx)
+	}
+
+	// a transaction in a block is taken from the serialized block, with the block hash and time
+	lastTxId := expected.getTxIds()[len(expected.getTxIds())-1]
+	rawTx, err = bcr.getTxInBlock(context.Background(), lastTxId, testBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rawTx, expected.findTx(lastTxId)) {
+		t.Errorf("unexpected tx %+v", rawTx)
+	}
+
+	_, err = bcr.getTxInBlock(context.Background(), ZERO_HASH, testBlockHash)
+	if !IsNotFound(err) {
+		t.Errorf("a tx that is not in the block returned %v, expected a not found error", err)
+	}
+}
+
+func TestBitcoinCoreRestBlockHashes(t *testing.T) {
+
+	bcr := newBitcoinCoreRestTestServer(t, readTestBlock(t), nil)
+
+	blockHash, err := bcr.getBestBlockHash(context.Background())
+	if err != nil || blockHash != testBlockHash {
+		t.Errorf("best block hash is %s %v, expected %s", blockHash, err, testBlockHash)
+	}
+
+	// the binary block hash is in internal byte order
+	blockHash, err = bcr.getBlockHash(context.Background(), testBlockHeight)
+	if err != nil || blockHash != testBlockHash {
+		t.Errorf("block hash is %s %v, expected %s", blockHash, err, testBlockHash)
+	}
+
+	_, err = bcr.getBlockHash(context.Background(), testBlockHeight+1)
+	if !IsNotFound(err) {
+		t.Errorf("a height above the tip returned %v, expected a not found error", err)
+	}
+}
+
+func TestBitcoinCoreRestErrors(t *testing.T) {
+
+	serializedBlock := readTestBlock(t)
+	statusCode := 0
+	bcr := newBitcoinCoreRestTestServer(t, serializedBlock[:len(serializedBlock)-1], &statusCode)
+
+	_, err := bcr.getBlock(context.Background(), testBlockHash, true)
+	if GetErrorType(err) != ERROR_TYPE_Decode {
+		t.Errorf("a truncated block returned %v, expected a decode error", err)
+	}
+
+	_, err = bcr.getBlock(context.Background(), ZERO_HASH, true)
+	if !IsNotFound(err) {
+		t.Errorf("an unknown block returned %v, expected a not found error", err)
+	}
+
+	statusCode = http.StatusServiceUnavailable
+	_, err = bcr.getBlock(context.Background(), testBlockHash, true)
+	if GetErrorType(err) != ERROR_TYPE_Unavailable {
+		t.Errorf("503 returned %v, expected an unavailable error", err)
+	}
+}
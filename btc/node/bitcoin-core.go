@@ -2,6 +2,7 @@ package node
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,13 +13,30 @@ import (
 	"github.com/btc-script-explorer/scantool/app"
 )
 
+// Bitcoin Core error codes
+const RPC_ERROR_InvalidAddressOrKey = -5
+const RPC_ERROR_InvalidParameter = -8
+const RPC_ERROR_InWarmup = -28
+
+// Bitcoin Core's getrawtransaction does not return the genesis transaction
+const GENESIS_TX_ID = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
 type BitcoinCore struct {
-	version string
+	version     string
+	rpcUrl      string
+	credentials *nodeCredentials
 }
 
-func NewBitcoinCore() (*BitcoinCore, error) {
+// the url includes the scheme and the port, and it can include a path such as /wallet/<name>
+func NewBitcoinCore(url string, credentials *nodeCredentials) (*BitcoinCore, error) {
+
+	// the RPC URL is the root path unless a path is set
+	rpcUrl := strings.TrimSuffix(url, "/")
+	if _, path, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(rpcUrl, "http://"), "https://"), "/"); len(path) == 0 {
+		rpcUrl += "/"
+	}
 
-	bc := BitcoinCore{}
+	bc := BitcoinCore{rpcUrl: rpcUrl, credentials: credentials}
 	bc.version = bc.getVersionStr()
 	if len(bc.version) == 0 {
 		return nil, errors.New("Failed to connect to Bitcoin Node.")
@@ -27,7 +45,7 @@ func NewBitcoinCore() (*BitcoinCore, error) {
 }
 
 func (bc *BitcoinCore) getNodeType() string {
-	return "Bitcoin Core"
+	return app.NODE_TYPE_BitcoinCore
 }
 
 func (bc *BitcoinCore) GetVersionString() string {
@@ -35,16 +53,17 @@ func (bc *BitcoinCore) GetVersionString() string {
 }
 
 func (bc *BitcoinCore) getVersionStr() string {
-	networkInfo := bc.getNetworkInfo()
-	if networkInfo == nil {
+	networkInfo, err := bc.getNetworkInfo(context.Background())
+	if err != nil {
+		fmt.Println(err.Error())
 		return ""
 	}
-	if networkInfo["subversion"] == nil {
+	if len(networkInfo.Subversion) == 0 {
 		return ""
 	}
 
 	// the version must be extracted from the subversion field
-	versionStr := networkInfo["subversion"].(string)
+	versionStr := networkInfo.Subversion
 	if strings.Contains(versionStr, ":") {
 		parts := strings.Split(versionStr, ":")
 		versionStr = parts[1]
@@ -59,154 +78,292 @@ func (bc *BitcoinCore) getVersionStr() string {
 
 // API functions
 
-func (bc *BitcoinCore) getBlock(blockHash string, withTxData bool) (map[string]interface{}, error) {
+func (bc *BitcoinCore) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
 
-	verbosityLevel := 1
-	if withTxData {
-		verbosityLevel = 2
+	if !withTxData {
+		var rawBlock nodeBlock
+		err := bc.getResult(ctx, "getblock", []interface{}{blockHash, 1}, &rawBlock)
+		if err != nil {
+			return nil, err
+		}
+		return &rawBlock, nil
 	}
-	fmt.Println("block hash:", blockHash)
-	jsonResult := bc.getJson("getblock", []interface{}{blockHash, verbosityLevel})
 
-	var rawResponse map[string]interface{}
-	err := json.Unmarshal(jsonResult, &rawResponse)
+	// the transactions are decoded directly into a list of transactions, which is much faster for large blocks than deciding what the list holds first
+	var response struct {
+		nodeBlock
+		Tx []nodeTx `json:"tx"`
+	}
+	err := bc.getResult(ctx, "getblock", []interface{}{blockHash, 2}, &response)
 	if err != nil {
-		return nil, errors.New("JSON ERROR: " + err.Error())
+		return nil, err
 	}
 
-	if rawResponse["error"] != nil {
-		return nil, errors.New("BITCOIN CORE ERROR: " + rawResponse["error"].(map[string]interface{})["message"].(string))
+	rawBlock := response.nodeBlock
+	rawBlock.Tx.txs = response.Tx
+	return &rawBlock, nil
+}
+
+func (bc *BitcoinCore) getBestBlockHash(ctx context.Context) (string, error) {
+	var blockHash string
+	err := bc.getResult(ctx, "getbestblockhash", []interface{}{}, &blockHash)
+	return blockHash, err
+}
+
+func (bc *BitcoinCore) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	var blockHash string
+	err := bc.getResult(ctx, "getblockhash", []interface{}{blockHeight}, &blockHash)
+	return blockHash, err
+}
+
+func (bc *BitcoinCore) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	var chainTips []nodeChainTip
+	err := bc.getResult(ctx, "getchaintips", []interface{}{}, &chainTips)
+	if err != nil {
+		return nil, err
 	}
-	if rawResponse["result"] == nil {
-		return nil, errors.New("BITCOIN CORE ERROR: No response from node.")
+	return chainTips, nil
+}
+
+func (bc *BitcoinCore) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+
+	if txId != GENESIS_TX_ID {
+		var rawTx nodeTx
+		err := bc.getResult(ctx, "getrawtransaction", []interface{}{txId, true}, &rawTx)
+		if err != nil {
+			return nil, err
+		}
+		return &rawTx, nil
 	}
 
-	return rawResponse["result"].(map[string]interface{}), nil
+	// the genesis transaction is a special case
+	// Bitcoin Core won't return it with this API so we handle that case here
+	return &nodeTx{TxId: GENESIS_TX_ID,
+		Version:  1,
+		LockTime: 0,
+		Vin: []nodeInput{{
+			Coinbase: "04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73",
+			Sequence: 4294967295}},
+		Vout: []nodeOutput{{
+			Value:        50 * SATOSHIS_PER_BTC,
+			N:            0,
+			ScriptPubKey: nodeScript{Hex: "4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac"}}},
+		BlockHash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		BlockTime: 1231006505}, nil
 }
 
-func (bc *BitcoinCore) getBestBlockHash() string {
-	jsonResult := bc.getJson("getbestblockhash", []interface{}{})
-	if len(jsonResult) == 0 {
-		return ""
+// a transaction can be requested from a known block without -txindex, as long as the block has not been pruned
+func (bc *BitcoinCore) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+
+	if txId == GENESIS_TX_ID {
+		return bc.getTx(ctx, txId)
 	}
 
-	var rawResponse map[string]interface{}
-	err := json.Unmarshal(jsonResult, &rawResponse)
+	var rawTx nodeTx
+	err := bc.getResult(ctx, "getrawtransaction", []interface{}{txId, true, blockHash}, &rawTx)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, err
 	}
+	return &rawTx, nil
+}
 
-	// check for error from node in json
-	if rawResponse["error"] != nil {
-		fmt.Println(rawResponse["error"].(map[string]interface{})["message"])
-		return ""
+// the transactions are requested with a single JSON-RPC batch request
+// transactions that are not found are not included in the results, any other error fails the whole request
+func (bc *BitcoinCore) getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error) {
+
+	rawTxs := make(map[string]*nodeTx, len(txIds))
+
+	paramsList := make([][]interface{}, 0, len(txIds))
+	batchTxIds := make([]string, 0, len(txIds))
+	for _, txId := range txIds {
+		if txId == GENESIS_TX_ID {
+			rawTx, err := bc.getTx(ctx, txId)
+			if err == nil {
+				rawTxs[txId] = rawTx
+			}
+			continue
+		}
+		paramsList = append(paramsList, []interface{}{txId, true})
+		batchTxIds = append(batchTxIds, txId)
 	}
 
-	return rawResponse["result"].(string)
-}
+	results, err := bc.getBatchResults(ctx, "getrawtransaction", paramsList)
+	if err != nil {
+		return nil, err
+	}
+
+	for t, result := range results {
+		if result == nil {
+			continue
+		}
+
+		var rawTx nodeTx
+		err = json.Unmarshal(result, &rawTx)
+		if err != nil {
+			return nil, newDecodeError("JSON ERROR:", err)
+		}
+		rawTxs[batchTxIds[t]] = &rawTx
+	}
 
-func (bc *BitcoinCore) getBlockHash(blockHeight uint32) string {
-	jsonResult := bc.getJson("getblockhash", []interface{}{blockHeight})
+	return rawTxs, nil
+}
 
-	var rawResponse map[string]interface{}
-	err := json.Unmarshal(jsonResult, &rawResponse)
+func (bc *BitcoinCore) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	var txIds []string
+	err := bc.getResult(ctx, "getrawmempool", []interface{}{false}, &txIds)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, err
 	}
+	return txIds, nil
+}
 
-	// check for error from node in json
-	if rawResponse["error"] != nil {
-		fmt.Println(rawResponse["error"].(map[string]interface{})["message"])
-		return ""
+func (bc *BitcoinCore) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	var rawEntry nodeMempoolEntry
+	err := bc.getResult(ctx, "getmempoolentry", []interface{}{txId}, &rawEntry)
+	if err != nil {
+		return nil, err
 	}
+	return &rawEntry, nil
+}
 
-	return rawResponse["result"].(string)
+// a JSON-RPC response, which has either a result or an error
+// the result is decoded directly into the structure expected by the caller
+type rpcResponse struct {
+	Result interface{} `json:"result"`
+	Error  *rpcError   `json:"error"`
 }
 
-func (bc *BitcoinCore) getTx(txId string) (map[string]interface{}, error) {
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
 
-	jsonResult := []byte(nil)
+// decodes the result field of the response into result, or returns the error reported by the node
+func (bc *BitcoinCore) getResult(ctx context.Context, function string, params []interface{}, result interface{}) error {
+	jsonResult, err := bc.getJson(ctx, function, params)
+	if err != nil {
+		return err
+	}
 
-	if txId != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" {
-		jsonResult = bc.getJson("getrawtransaction", []interface{}{txId, true})
-	} else {
-		// the genesis transaction is a special case
-		// Bitcoin Core won't return it with this API so we handle that case here
-		// if other raw transaction JSON fields are used in the future, they might need to be added here
-		rawJson := `{
-						"result": {
-							"txid": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
-							"version": 1,
-							"size": 204,
-							"vsize": 204,
-							"weight": 816,
-							"locktime": 0,
-							"vin": [
-								{
-									"coinbase": "04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73",
-									"sequence": 4294967295
-								}
-							],
-							"vout": [
-								{
-									"value": 50.00000000,
-									"n": 0,
-									"scriptPubKey": {
-										"hex": "4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac",
-										"type": "pubkey"
-									}
-								}
-							],
-							"hex": "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000",
-							"blockhash":"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
-							"blocktime": 1231006505
-						},
-						"error": null
-					}`
-		jsonResult = make([]byte, len(rawJson))
-		jsonResult = []byte(rawJson)
+	response := rpcResponse{Result: result}
+	err = json.Unmarshal(jsonResult, &response)
+	if err != nil {
+		return newDecodeError("JSON ERROR:", err)
 	}
 
-	if len(jsonResult) == 0 {
-		return nil, errors.New("No result from node.")
+	if response.Error != nil {
+		return getRpcError(response.Error)
 	}
 
-	var rawResponse map[string]interface{}
-	jsonError := json.Unmarshal(jsonResult, &rawResponse)
-	if jsonError != nil {
-		return nil, jsonError
+	// a null result replaces the result with nil
+	if response.Result == nil {
+		return newDecodeError("BITCOIN CORE ERROR: No result in response from node.", nil)
 	}
 
-	// check for error from node in json
-	if rawResponse["error"] != nil {
-		return nil, errors.New("NODE ERROR: " + rawResponse["error"].(map[string]interface{})["message"].(string))
+	return nil
+}
+
+// converts an error reported by the node to the matching error type
+func getRpcError(rawError *rpcError) error {
+	message := "BITCOIN CORE ERROR: " + rawError.Message
+
+	switch rawError.Code {
+	case RPC_ERROR_InvalidAddressOrKey, RPC_ERROR_InvalidParameter:
+		return newNotFoundError(message)
+	case RPC_ERROR_InWarmup:
+		return newUnavailableError(message, nil)
 	}
+	return errors.New(message)
+}
 
-	return rawResponse["result"].(map[string]interface{}), nil
+type rpcNetworkInfo struct {
+	Subversion string `json:"subversion"`
 }
 
-func (bc *BitcoinCore) getNetworkInfo() map[string]interface{} {
-	jsonResult := bc.getJson("getnetworkinfo", []interface{}{})
-	if len(jsonResult) == 0 {
-		return map[string]interface{}{}
+func (bc *BitcoinCore) getNetworkInfo(ctx context.Context) (rpcNetworkInfo, error) {
+	var networkInfo rpcNetworkInfo
+	err := bc.getResult(ctx, "getnetworkinfo", []interface{}{}, &networkInfo)
+	return networkInfo, err
+}
+
+// calls the same function once for each set of parameters in a single request
+// the results are in the same order as the parameters, with nil for each call that did not find what it was looking for
+// any other error reported for a call fails the whole batch, so that it is not mistaken for something that does not exist
+func (bc *BitcoinCore) getBatchResults(ctx context.Context, function string, paramsList [][]interface{}) ([]json.RawMessage, error) {
+
+	results := make([]json.RawMessage, len(paramsList))
+	if len(paramsList) == 0 {
+		return results, nil
 	}
 
-	var rawResponse map[string]interface{}
-	err := json.Unmarshal(jsonResult, &rawResponse)
+	type jsonBatchRequestObject struct {
+		Jsonrpc string        `json:"jsonrpc"`
+		Id      int           `json:"id"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+	}
+
+	// create the JSON request, the id of each call is its index
+	requestObjects := make([]jsonBatchRequestObject, len(paramsList))
+	for p, params := range paramsList {
+		requestObjects[p] = jsonBatchRequestObject{Jsonrpc: "2.0", Id: p, Method: function, Params: params}
+	}
+	requestJsonBytes, err := json.Marshal(requestObjects)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, errors.New("JSON ERROR: " + err.Error())
+	}
+
+	jsonResult, err := bc.send(ctx, requestJsonBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	// check for error from node in json
-	if rawResponse["error"] != nil {
-		fmt.Println(rawResponse["error"].(map[string]interface{})["message"])
-		return map[string]interface{}{}
+	// the responses are not necessarily in the same order as the calls
+	// the results are decoded by the caller, since each result is decoded separately
+	var responses []struct {
+		Id     *int            `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	err = json.Unmarshal(jsonResult, &responses)
+	if err != nil {
+		// an error that applies to the whole batch is returned as a single response
+		var response rpcResponse
+		if json.Unmarshal(jsonResult, &response) == nil && response.Error != nil {
+			return nil, getRpcError(response.Error)
+		}
+		return nil, newDecodeError("JSON ERROR:", err)
 	}
 
-	return rawResponse["result"].(map[string]interface{})
+	answered := make([]bool, len(results))
+	for _, response := range responses {
+		if response.Id == nil || *response.Id < 0 || *response.Id >= len(results) {
+			return nil, newDecodeError("BITCOIN CORE ERROR: Response with an unknown id in batch response from node.", nil)
+		}
+		answered[*response.Id] = true
+
+		if response.Error != nil {
+			if response.Error.Code == RPC_ERROR_InvalidAddressOrKey {
+				continue
+			}
+			return nil, getRpcError(response.Error)
+		}
+		if len(response.Result) == 0 || string(response.Result) == "null" {
+			return nil, newDecodeError("BITCOIN CORE ERROR: No result in batch response from node.", nil)
+		}
+		results[*response.Id] = response.Result
+	}
+
+	for _, isAnswered := range answered {
+		if !isAnswered {
+			return nil, newDecodeError("BITCOIN CORE ERROR: Missing response in batch response from node.", nil)
+		}
+	}
+
+	return results, nil
 }
 
-func (bc *BitcoinCore) getJson(function string, params []interface{}) []byte {
+func (bc *BitcoinCore) getJson(ctx context.Context, function string, params []interface{}) ([]byte, error) {
 
 	type jsonRequestObject struct {
 		Jsonrpc string        `json:"jsonrpc"`
@@ -218,37 +375,65 @@ func (bc *BitcoinCore) getJson(function string, params []interface{}) []byte {
 	requestObject := jsonRequestObject{Jsonrpc: "2.0", Method: function, Params: params}
 	requestJsonBytes, err := json.Marshal(requestObject)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, errors.New("JSON ERROR: " + err.Error())
 	}
 
-	// create the HTTP request
-	requestUrl := "http://" + app.Settings.GetNodeFullUrl() + "/"
-	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewBuffer(requestJsonBytes))
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	return bc.send(ctx, requestJsonBytes)
+}
 
-	req.SetBasicAuth(app.Settings.GetNodeUsername(), app.Settings.GetNodePassword())
+// sends a JSON-RPC request and returns the JSON response
+func (bc *BitcoinCore) send(ctx context.Context, requestJsonBytes []byte) ([]byte, error) {
+
+	response, err := bc.post(ctx, requestJsonBytes)
+
+	// the node might have been restarted with a new cookie, so the cookie file is read again before trying once more
+	if err == nil && response.StatusCode == http.StatusUnauthorized && bc.credentials.reload() {
+		response.Body.Close()
+		response, err = bc.post(ctx, requestJsonBytes)
+	}
 
-	// get the HTTP response
-	client := &http.Client{}
-	response, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, err
 	}
-	if response == nil {
-		fmt.Println("Node returned empty response.")
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		return []byte{}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		return nil, newUnauthorizedError("Bitcoin Core rejected the credentials.", nil)
 	}
 
 	// return the JSON response
 	json, err := io.ReadAll(response.Body)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, newUnavailableError("BITCOIN CORE ERROR:", err)
+	}
+
+	// errors reported by the node are in the JSON response, so only a response without JSON is an http error
+	if response.StatusCode != http.StatusOK && len(bytes.TrimSpace(json)) == 0 {
+		return nil, getHttpStatusError("BITCOIN CORE", response.StatusCode, http.StatusText(response.StatusCode))
 	}
 
-	return json
+	return json, nil
+}
+
+func (bc *BitcoinCore) post(ctx context.Context, requestJsonBytes []byte) (*http.Response, error) {
+
+	// create the HTTP request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bc.rpcUrl, bytes.NewBuffer(requestJsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	username, password, err := bc.credentials.get()
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, password)
+
+	// get the HTTP response
+	response, err := getHttpClient().Do(req)
+	if err != nil {
+		return nil, newUnavailableError("BITCOIN CORE ERROR:", err)
+	}
+	return response, nil
 }
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// answers getnetworkinfo and batches of getrawtransaction with the transactions of mainnet block 277647
+// the responses to a batch are returned in reverse order, and the calls for txids in rpcErrors fail with the given error
+func newBitcoinCoreTestServer(t *testing.T, rpcErrors map[string]rpcError) *BitcoinCore {
+
+	rawBlock := readTestBlockFromJson(t)
+	rawTxs := make(map[string]*nodeTx)
+	for _, txId := range rawBlock.getTxIds() {
+		rawTxs[txId] = rawBlock.findTx(txId)
+	}
+
+	type testRequest struct {
+		Id     int           `json:"id"`
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}
+	type testResponse struct {
+		Id     int         `json:"id"`
+		Result interface{} `json:"result"`
+		Error  *rpcError   `json:"error"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []testRequest
+		err := json.NewDecoder(r.Body).Decode(&requests)
+		if err != nil {
+			json.NewEncoder(w).Encode(testResponse{Result: rpcNetworkInfo{Subversion: "/Satoshi:25.0.0/"}})
+			return
+		}
+
+		responses := make([]testResponse, 0, len(requests))
+		for q := len(requests) - 1; q >= 0; q-- {
+			txId, _ := requests[q].Params[0].(string)
+			response := testResponse{Id: requests[q].Id}
+			if rpcErr, found := rpcErrors[txId]; found {
+				response.Error = &rpcErr
+			} else if rawTx, found := rawTxs[txId]; found {
+				response.Result = rawTx
+			} else {
+				response.Error = &rpcError{Code: RPC_ERROR_InvalidAddressOrKey, Message: "No such mempool or blockchain transaction."}
+			}
+			responses = append(responses, response)
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	t.Cleanup(server.Close)
+
+	bc, err := NewBitcoinCore(server.URL, newNodeCredentials("user", "password", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func TestBitcoinCoreGetTxs(t *testing.T) {
+
+	bc := newBitcoinCoreTestServer(t, nil)
+	expected := readTestBlockFromJson(t)
+	txIds := expected.getTxIds()[:5]
+
+	rawTxs, err := bc.getTxs(context.Background(), append([]string{ZERO_HASH}, txIds...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction that does not exist is left out
+	if len(rawTxs) != len(txIds) {
+		t.Errorf("received %d txs, expected %d", len(rawTxs), len(txIds))
+	}
+	for _, txId := range txIds {
+		if !reflect.DeepEqual(rawTxs[txId], expected.findTx(txId)) {
+			t.Errorf("unexpected tx %s %+v", txId, rawTxs[txId])
+		}
+	}
+}
+
+// only a call that did not find its transaction is treated as not found, other errors fail the whole batch
+func TestBitcoinCoreGetTxsErrors(t *testing.T) {
+
+	expected := readTestBlockFromJson(t)
+	txIds := expected.getTxIds()[:3]
+
+	bc := newBitcoinCoreTestServer(t, map[string]rpcError{txIds[1]: {Code: RPC_ERROR_InWarmup, Message: "Loading block index..."}})
+	rawTxs, err := bc.getTxs(context.Background(), txIds)
+	if GetErrorType(err) != ERROR_TYPE_Unavailable || rawTxs != nil {
+		t.Errorf("a call during warmup returned %d txs and %v, expected an unavailable error", len(rawTxs), err)
+	}
+
+	bc = newBitcoinCoreTestServer(t, map[string]rpcError{txIds[1]: {Code: -1, Message: "Internal error"}})
+	rawTxs, err = bc.getTxs(context.Background(), txIds)
+	if err == nil || IsNotFound(err) || rawTxs != nil {
+		t.Errorf("a call that failed returned %d txs and %v, expected an error", len(rawTxs), err)
+	}
+}
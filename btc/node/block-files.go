@@ -0,0 +1,439 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/btc-script-explorer/scantool/app"
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// reads blocks directly from the blk*.dat files in a Bitcoin Core blocks directory
+// each block in a block file is preceded by the network magic bytes and the size of the block
+
+type blockFileLocation struct {
+	fileNumber   int
+	offset       int64
+	size         uint32
+	previousHash string
+	timestamp    uint32
+	bits         uint32
+	height       int32 // -1 until the block is connected to the genesis block
+	chainWork    *big.Int
+}
+
+type BlockFiles struct {
+	version   string
+	network   string
+	blocksDir string
+	xorKey    []byte
+
+	// block index
+	indexMutex     sync.RWMutex
+	blocks         map[string]*blockFileLocation
+	unconnected    map[string][]string // previous block hash -> blocks that are waiting for it
+	bestChain      []string            // height -> block hash
+	bestTip        string
+	nextFileNumber int
+	nextFileOffset int64
+}
+
+// block files have no transaction index of their own
+// transactions are found by txid through the transaction block index, which only indexes blocks of the active chain
+func NewBlockFiles(blocksDir string) (*BlockFiles, error) {
+
+	bf := BlockFiles{blocksDir: blocksDir,
+		blocks:      make(map[string]*blockFileLocation),
+		unconnected: make(map[string][]string)}
+
+	// block files written by Bitcoin Core 28 and later are obfuscated with the key in xor.dat
+	xorKey, err := os.ReadFile(filepath.Join(bf.blocksDir, "xor.dat"))
+	if err == nil {
+		if len(xorKey) != 8 {
+			return nil, errors.New(fmt.Sprintf("Invalid obfuscation key in %s.", filepath.Join(bf.blocksDir, "xor.dat")))
+		}
+		if !bytes.Equal(xorKey, make([]byte, 8)) {
+			bf.xorKey = xorKey
+		}
+	}
+
+	bf.scan()
+	bf.version = bf.getVersionStr()
+	if len(bf.version) == 0 {
+		return nil, errors.New("Failed to read block files in " + bf.blocksDir + ".")
+	}
+
+	return &bf, nil
+}
+
+func (bf *BlockFiles) getNodeType() string {
+	return app.NODE_TYPE_BlockFiles
+}
+
+func (bf *BlockFiles) GetVersionString() string {
+	return bf.getNodeType() + " " + bf.version
+}
+
+func (bf *BlockFiles) getVersionStr() string {
+	bf.indexMutex.RLock()
+	defer bf.indexMutex.RUnlock()
+
+	if len(bf.bestChain) == 0 {
+		return ""
+	}
+	return "(" + bf.network + ")"
+}
+
+// API functions
+
+func (bf *BlockFiles) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+
+	bf.indexMutex.RLock()
+	location := bf.blocks[blockHash]
+	if location == nil {
+		bf.indexMutex.RUnlock()
+		return nil, newNotFoundError("Block " + blockHash + " not found in block files.")
+	}
+
+	// the height of a block is not known until it is connected to the genesis block, so a block that is not connected is not served
+	height := location.height
+	if height < 0 {
+		bf.indexMutex.RUnlock()
+		return nil, newNotFoundError("Block " + blockHash + " is not connected to the genesis block in the block files.")
+	}
+
+	inBestChain := int(height) < len(bf.bestChain) && bf.bestChain[height] == blockHash
+	nextHash := ""
+	if inBestChain && int(height)+1 < len(bf.bestChain) {
+		nextHash = bf.bestChain[height+1]
+	}
+	bf.indexMutex.RUnlock()
+
+	serializedBlock, err := bf.readBlock(location)
+	if err != nil {
+		return nil, newUnavailableError("BLOCK FILE ERROR:", err)
+	}
+
+	rawBlock, err := deserializeBlock(serializedBlock, bf.network, withTxData)
+	if err != nil {
+		return nil, newDecodeError("BLOCK FILE ERROR: Block "+blockHash+" could not be deserialized.", err)
+	}
+	rawBlock.Height = uint32(height)
+	rawBlock.NextBlockHash = nextHash
+	if !inBestChain {
+		rawBlock.Confirmations = -1
+	}
+
+	return rawBlock, nil
+}
+
+func (bf *BlockFiles) getBestBlockHash(ctx context.Context) (string, error) {
+
+	// pick up any blocks that have been written since the last scan
+	bf.scan()
+
+	bf.indexMutex.RLock()
+	defer bf.indexMutex.RUnlock()
+
+	if len(bf.bestTip) == 0 {
+		return "", newUnavailableError("No blocks found in "+bf.blocksDir+".", nil)
+	}
+	return bf.bestTip, nil
+}
+
+// the tips are the connected blocks that no other block builds on
+// block files can not tell whether the blocks of a fork were fully validated, so forks are reported with the status valid-headers,
+// which means that their blocks are available but not known to be valid
+func (bf *BlockFiles) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+
+	bf.scan()
+
+	bf.indexMutex.RLock()
+	defer bf.indexMutex.RUnlock()
+
+	hasChild := make(map[string]bool, len(bf.blocks))
+	for _, location := range bf.blocks {
+		if location.height > 0 {
+			hasChild[location.previousHash] = true
+		}
+	}
+
+	chainTips := make([]nodeChainTip, 0)
+	for blockHash, location := range bf.blocks {
+		if location.height < 0 || hasChild[blockHash] {
+			continue
+		}
+
+		if blockHash == bf.bestTip {
+			chainTips = append(chainTips, nodeChainTip{Height: uint32(location.height), Hash: blockHash, Status: CHAIN_TIP_STATUS_Active})
+			continue
+		}
+
+		// follow the fork back to the active chain
+		branchLen := uint32(0)
+		branchHash := blockHash
+		for branchLocation := location; branchLocation != nil; branchLocation = bf.blocks[branchHash] {
+			if int(branchLocation.height) < len(bf.bestChain) && bf.bestChain[branchLocation.height] == branchHash {
+				break
+			}
+			branchLen++
+			branchHash = branchLocation.previousHash
+		}
+		chainTips = append(chainTips, nodeChainTip{Height: uint32(location.height), Hash: blockHash, BranchLen: branchLen, Status: CHAIN_TIP_STATUS_ValidHeaders})
+	}
+
+	return chainTips, nil
+}
+
+func (bf *BlockFiles) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	bf.indexMutex.RLock()
+	defer bf.indexMutex.RUnlock()
+
+	if int(blockHeight) >= len(bf.bestChain) {
+		return "", newNotFoundError(fmt.Sprintf("Block %d not found in block files.", blockHeight))
+	}
+	return bf.bestChain[blockHeight], nil
+}
+
+// the cache looks for the transaction in the transaction block index when it is not found here
+func (bf *BlockFiles) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	return nil, newNotFoundError("Transaction " + txId + " can not be found in block files without its block.")
+}
+
+func (bf *BlockFiles) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+
+	rawBlock, err := bf.getBlock(ctx, blockHash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTx := rawBlock.findTx(txId)
+	if rawTx == nil {
+		return nil, newNotFoundError("Transaction " + txId + " not found in block " + blockHash + ".")
+	}
+	return rawTx, nil
+}
+
+// block files only contain confirmed transactions
+func (bf *BlockFiles) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	return []string{}, nil
+}
+
+func (bf *BlockFiles) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	return nil, newNotFoundError("Transaction " + txId + " is not in the mempool.")
+}
+
+// block file access
+
+func (bf *BlockFiles) getBlockFileName(fileNumber int) string {
+	return filepath.Join(bf.blocksDir, fmt.Sprintf("blk%05d.dat", fileNumber))
+}
+
+// reads from a block file, removing the obfuscation
+func (bf *BlockFiles) readAt(file *os.File, offset int64, size int) ([]byte, error) {
+
+	data := make([]byte, size)
+	_, err := file.ReadAt(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if bf.xorKey != nil {
+		for i := range data {
+			data[i] ^= bf.xorKey[(offset+int64(i))%8]
+		}
+	}
+
+	return data, nil
+}
+
+func (bf *BlockFiles) readBlock(location *blockFileLocation) ([]byte, error) {
+	file, err := os.Open(bf.getBlockFileName(location.fileNumber))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return bf.readAt(file, location.offset, int(location.size))
+}
+
+// returns the network of the magic bytes that precede each block
+func getNetworkFromMagic(magic []byte) string {
+	switch binary.BigEndian.Uint32(magic) {
+	case 0xf9beb4d9:
+		return btc.NETWORK_Mainnet
+	case 0x0b110907, 0x1c163f28:
+		return btc.NETWORK_Testnet
+	case 0x0a03cf40:
+		return btc.NETWORK_Signet
+	case 0xfabfb5da:
+		return btc.NETWORK_Regtest
+	}
+	return ""
+}
+
+// indexes every block that has been written since the last scan
+// a block that has only been partially written will be indexed by a later scan
+func (bf *BlockFiles) scan() {
+
+	bf.indexMutex.Lock()
+	defer bf.indexMutex.Unlock()
+
+	previousTip := bf.bestTip
+	for {
+		file, err := os.Open(bf.getBlockFileName(bf.nextFileNumber))
+		if err != nil {
+			break
+		}
+
+		fileInfo, err := file.Stat()
+		if err != nil {
+			fmt.Println(err.Error())
+			file.Close()
+			break
+		}
+		fileSize := fileInfo.Size()
+
+		for bf.nextFileOffset+8+BLOCK_HEADER_SIZE <= fileSize {
+			recordHeader, err := bf.readAt(file, bf.nextFileOffset, 8)
+			if err != nil {
+				fmt.Println(err.Error())
+				break
+			}
+
+			// block files are pre-allocated, so zeros mean we have reached the end of the data
+			network := getNetworkFromMagic(recordHeader[0:4])
+			if len(network) == 0 {
+				break
+			}
+			if len(bf.network) == 0 {
+				bf.network = network
+			}
+
+			blockSize := binary.LittleEndian.Uint32(recordHeader[4:8])
+			blockOffset := bf.nextFileOffset + 8
+			if blockOffset+int64(blockSize) > fileSize {
+				break
+			}
+
+			header, err := bf.readAt(file, blockOffset, BLOCK_HEADER_SIZE)
+			if err != nil {
+				fmt.Println(err.Error())
+				break
+			}
+			bf.addBlock(header, bf.nextFileNumber, blockOffset, blockSize)
+
+			bf.nextFileOffset = blockOffset + int64(blockSize)
+		}
+		file.Close()
+
+		// move on to the next file only after it has been created
+		_, err = os.Stat(bf.getBlockFileName(bf.nextFileNumber + 1))
+		if err != nil {
+			break
+		}
+		bf.nextFileNumber++
+		bf.nextFileOffset = 0
+	}
+
+	if bf.bestTip != previousTip {
+		bf.updateBestChain()
+	}
+}
+
+func (bf *BlockFiles) addBlock(header []byte, fileNumber int, offset int64, size uint32) {
+
+	blockHash, previousHash := parseBlockHeader(header)
+	if bf.blocks[blockHash] != nil {
+		return
+	}
+
+	bf.blocks[blockHash] = &blockFileLocation{fileNumber: fileNumber,
+		offset:       offset,
+		size:         size,
+		previousHash: previousHash,
+		timestamp:    binary.LittleEndian.Uint32(header[68:72]),
+		bits:         binary.LittleEndian.Uint32(header[72:76]),
+		height:       -1}
+
+	// blocks are not always written in order, so a block might have to wait for its parent
+	if previousHash == ZERO_HASH {
+		bf.connectBlock(blockHash, nil)
+	} else if parent := bf.blocks[previousHash]; parent != nil && parent.height >= 0 {
+		bf.connectBlock(blockHash, parent)
+	} else {
+		bf.unconnected[previousHash] = append(bf.unconnected[previousHash], blockHash)
+	}
+}
+
+// sets the height and chain work of a block and every block waiting for it
+func (bf *BlockFiles) connectBlock(blockHash string, parent *blockFileLocation) {
+
+	block := bf.blocks[blockHash]
+	block.height = 0
+	block.chainWork = getBlockWork(block.bits)
+	if parent != nil {
+		block.height = parent.height + 1
+		block.chainWork.Add(block.chainWork, parent.chainWork)
+	}
+
+	if len(bf.bestTip) == 0 || block.chainWork.Cmp(bf.blocks[bf.bestTip].chainWork) > 0 {
+		bf.bestTip = blockHash
+	}
+
+	children := bf.unconnected[blockHash]
+	delete(bf.unconnected, blockHash)
+	for _, child := range children {
+		bf.connectBlock(child, block)
+	}
+}
+
+// the expected number of hashes required to find a block, 2^256 / (target + 1)
+func getBlockWork(bits uint32) *big.Int {
+
+	exponent := uint(bits >> 24)
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+
+	target := new(big.Int)
+	if exponent <= 3 {
+		target.Rsh(mantissa, 8*(3-exponent))
+	} else {
+		target.Lsh(mantissa, 8*(exponent-3))
+	}
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// walks back from the best tip until it reaches a block that is already in the best chain
+func (bf *BlockFiles) updateBestChain() {
+
+	tip := bf.blocks[bf.bestTip]
+	bestChain := bf.bestChain
+	if int(tip.height) < len(bestChain) {
+		bestChain = bestChain[:tip.height+1]
+	} else {
+		bestChain = append(bestChain, make([]string, int(tip.height)+1-len(bestChain))...)
+	}
+
+	blockHash := bf.bestTip
+	for height := tip.height; height >= 0; height-- {
+		if bestChain[height] == blockHash {
+			break
+		}
+		bestChain[height] = blockHash
+		blockHash = bf.blocks[blockHash].previousHash
+	}
+
+	bf.bestChain = bestChain
+}
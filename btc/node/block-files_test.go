@@ -0,0 +1,171 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type blockFilesTestBlock struct {
+	hash       string
+	coinbaseId string
+	serialized []byte
+}
+
+func getDoubleSha256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// a block with a coinbase transaction that is unique to the block
+func newBlockFilesTestBlock(previous *blockFilesTestBlock, tag byte) *blockFilesTestBlock {
+
+	coinbase := binary.LittleEndian.AppendUint32(nil, 1)
+	coinbase = append(coinbase, 0x01)
+	coinbase = append(coinbase, make([]byte, 32)...)
+	// the coinbase scripts have different lengths, so that the blocks are not aligned with the obfuscation key
+	coinbaseScript := append([]byte{0x01, tag}, make([]byte, tag%7)...)
+	coinbase = append(coinbase, 0xff, 0xff, 0xff, 0xff, byte(len(coinbaseScript)))
+	coinbase = append(coinbase, coinbaseScript...)
+	coinbase = append(coinbase, 0xff, 0xff, 0xff, 0xff)
+	coinbase = append(coinbase, 0x01)
+	coinbase = binary.LittleEndian.AppendUint64(coinbase, 5000000000)
+	coinbase = append(coinbase, 0x01, 0x51)
+	coinbase = binary.LittleEndian.AppendUint32(coinbase, 0)
+	coinbaseId := getDoubleSha256(coinbase)
+
+	previousHash := make([]byte, 32)
+	if previous != nil {
+		previousHash = getDoubleSha256(previous.serialized[:BLOCK_HEADER_SIZE])
+	}
+
+	header := binary.LittleEndian.AppendUint32(nil, 1)
+	header = append(header, previousHash...)
+	header = append(header, coinbaseId...)
+	header = binary.LittleEndian.AppendUint32(header, 1231006505+uint32(tag))
+	header = binary.LittleEndian.AppendUint32(header, 0x1d00ffff)
+	header = binary.LittleEndian.AppendUint32(header, uint32(tag))
+
+	serialized := append(append(header, 0x01), coinbase...)
+	return &blockFilesTestBlock{hash: getDisplayHash(header), coinbaseId: reverseHex(coinbaseId), serialized: serialized}
+}
+
+// writes the blocks in the order given, each preceded by the mainnet magic bytes and its size, followed by pre-allocated space
+// the whole file is obfuscated with the key at the file offset of each byte, as Bitcoin Core does
+func writeBlockFilesTestFile(t *testing.T, dir string, fileNumber int, xorKey []byte, blocks ...*blockFilesTestBlock) {
+
+	data := make([]byte, 0)
+	for _, block := range blocks {
+		data = append(data, 0xf9, 0xbe, 0xb4, 0xd9)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(block.serialized)))
+		data = append(data, block.serialized...)
+	}
+	data = append(data, make([]byte, 200)...)
+
+	if xorKey != nil {
+		for i := range data {
+			data[i] ^= xorKey[i%8]
+		}
+	}
+
+	err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("blk%05d.dat", fileNumber)), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the blocks are read in the order they were written, which is not always the order of the chain,
+// and the chain with the most work is the active chain even though its blocks were written last
+func TestBlockFiles(t *testing.T) {
+
+	genesis := newBlockFilesTestBlock(nil, 0)
+	a1 := newBlockFilesTestBlock(genesis, 1)
+	a2 := newBlockFilesTestBlock(a1, 2)
+	b1 := newBlockFilesTestBlock(genesis, 11)
+	b2 := newBlockFilesTestBlock(b1, 12)
+	b3 := newBlockFilesTestBlock(b2, 13)
+	b4 := newBlockFilesTestBlock(b3, 14)
+
+	for _, xorKey := range [][]byte{nil, make([]byte, 8), {0x3c, 0x5a, 0x01, 0xf0, 0x96, 0x7e, 0x24, 0xc3}} {
+
+		dir := t.TempDir()
+		if xorKey != nil {
+			err := os.WriteFile(filepath.Join(dir, "xor.dat"), xorKey, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// blocks a2 and b3 are written before their parents
+		writeBlockFilesTestFile(t, dir, 0, xorKey, genesis, a2, a1, b1)
+		writeBlockFilesTestFile(t, dir, 1, xorKey, b3, b2)
+
+		bf, err := NewBlockFiles(dir)
+		if err != nil {
+			t.Fatalf("xor key %x: %s", xorKey, err)
+		}
+		ctx := context.Background()
+
+		bestBlockHash, err := bf.getBestBlockHash(ctx)
+		if err != nil || bestBlockHash != b3.hash {
+			t.Fatalf("xor key %x: the best block is %s, %v, expected %s", xorKey, bestBlockHash, err, b3.hash)
+		}
+		for height, expected := range []string{genesis.hash, b1.hash, b2.hash, b3.hash} {
+			blockHash, err := bf.getBlockHash(ctx, uint32(height))
+			if err != nil || blockHash != expected {
+				t.Errorf("xor key %x: block %d is %s, %v, expected %s", xorKey, height, blockHash, err, expected)
+			}
+		}
+
+		// a block of the active chain is linked to the next block, a stale block is not
+		block, err := bf.getBlock(ctx, b2.hash, true)
+		if err != nil {
+			t.Fatalf("xor key %x: %s", xorKey, err)
+		}
+		txs := block.getTxs()
+		if block.Height != 2 || block.NextBlockHash != b3.hash || block.Confirmations < 0 || len(txs) != 1 || txs[0].TxId != b2.coinbaseId {
+			t.Errorf("xor key %x: block %s returned height %d, next block %s and %d txs", xorKey, b2.hash, block.Height, block.NextBlockHash, len(txs))
+		}
+		block, err = bf.getBlock(ctx, a2.hash, false)
+		if err != nil || block.Height != 2 || len(block.NextBlockHash) != 0 || block.Confirmations != -1 {
+			t.Errorf("xor key %x: stale block %s returned %v", xorKey, a2.hash, err)
+		}
+
+		rawTx, err := bf.getTxInBlock(ctx, a1.coinbaseId, a1.hash)
+		if err != nil || rawTx.TxId != a1.coinbaseId {
+			t.Errorf("xor key %x: tx %s in block %s returned %v", xorKey, a1.coinbaseId, a1.hash, err)
+		}
+		_, err = bf.getTx(ctx, a1.coinbaseId)
+		if !IsNotFound(err) {
+			t.Errorf("xor key %x: tx %s was found without its block", xorKey, a1.coinbaseId)
+		}
+
+		chainTips, err := bf.getChainTips(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tipsByHash := make(map[string]nodeChainTip)
+		for _, chainTip := range chainTips {
+			tipsByHash[chainTip.Hash] = chainTip
+		}
+		expectedTips := map[string]nodeChainTip{
+			b3.hash: {Height: 3, Hash: b3.hash, Status: CHAIN_TIP_STATUS_Active},
+			a2.hash: {Height: 2, Hash: a2.hash, BranchLen: 2, Status: CHAIN_TIP_STATUS_ValidHeaders}}
+		if !reflect.DeepEqual(tipsByHash, expectedTips) {
+			t.Errorf("xor key %x: the chain tips are %v, expected %v", xorKey, tipsByHash, expectedTips)
+		}
+
+		// a block written after the files were first read is found by the next scan
+		writeBlockFilesTestFile(t, dir, 1, xorKey, b3, b2, b4)
+		bestBlockHash, err = bf.getBestBlockHash(ctx)
+		if err != nil || bestBlockHash != b4.hash {
+			t.Errorf("xor key %x: the best block is %s, %v, expected new block %s", xorKey, bestBlockHash, err, b4.hash)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the block scanner keeps an index current by reading every block from the index's start height to the tip
+// a block replaced by a reorg is read again and passed to the index so that it can remove what the block added
+
+// new blocks are checked this often when there are no live notifications
+const BLOCK_SCANNER_POLL_INTERVAL = 30 * time.Second
+
+// progress is printed whenever this many blocks have been indexed
+const BLOCK_SCANNER_PROGRESS_INTERVAL = 1000
+
+type scannedIndex interface {
+	getName() string
+	getStartHeight() uint32
+	getEndHeight() uint32 // 0 if the index follows the tip
+
+	// returns the height and hash of the last indexed block, or -1 if no block has been indexed
+	getLastBlock() (int64, string)
+
+	// raw blocks include their transactions
+	connectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error
+
+	// rawBlock is nil if the block can no longer be read
+	disconnectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error
+}
+
+// indexes new blocks whenever a block is connected or the poll interval has passed
+func runBlockScanner(index scannedIndex, btcNode *limitedNode, events <-chan Event) {
+
+	for {
+		err := scanBlocks(context.Background(), index, btcNode)
+		if err != nil {
+			fmt.Println(strings.ToUpper(index.getName()) + " ERROR: " + err.Error())
+		}
+
+		// other events, such as new transactions, are ignored
+		timer := time.NewTimer(BLOCK_SCANNER_POLL_INTERVAL)
+	wait:
+		for {
+			select {
+			case event := <-events:
+				if event.GetType() == EVENT_TYPE_BlockConnected || event.GetType() == EVENT_TYPE_BlockDisconnected {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		timer.Stop()
+	}
+}
+
+// indexes every block up to the current tip or the end height of the index
+func scanBlocks(ctx context.Context, index scannedIndex, btcNode *limitedNode) error {
+
+	tipHash, err := btcNode.getBestBlockHash(ctx)
+	if err != nil {
+		return err
+	}
+	tipBlock, err := btcNode.getBlock(ctx, tipHash, false)
+	if err != nil {
+		return err
+	}
+	tipHeight := tipBlock.Height
+
+	endHeight := tipHeight
+	if index.getEndHeight() > 0 && index.getEndHeight() < endHeight {
+		endHeight = index.getEndHeight()
+	}
+
+	for {
+		lastHeight, lastHash := index.getLastBlock()
+		if lastHash == tipHash {
+			return nil
+		}
+
+		nextHeight := uint32(lastHeight + 1)
+		if lastHeight < 0 {
+			nextHeight = index.getStartHeight()
+			if nextHeight > endHeight {
+				return nil
+			}
+		}
+
+		// the last indexed block was replaced by a reorg
+		if lastHeight > int64(tipHeight) {
+			err = disconnectScannedBlock(ctx, index, btcNode, uint32(lastHeight), lastHash)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// an index that has reached its end height only has to check that its last block is still in the chain
+		if lastHeight >= int64(endHeight) {
+			chainHash, err := btcNode.getBlockHash(ctx, uint32(lastHeight))
+			if err != nil {
+				return err
+			}
+			if chainHash == lastHash {
+				return nil
+			}
+			err = disconnectScannedBlock(ctx, index, btcNode, uint32(lastHeight), lastHash)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		blockHash, err := btcNode.getBlockHash(ctx, nextHeight)
+		if err != nil {
+			return err
+		}
+		rawBlock, err := btcNode.getBlock(ctx, blockHash, true)
+		if err != nil {
+			return err
+		}
+
+		if lastHeight >= 0 && rawBlock.PreviousBlockHash != lastHash {
+			err = disconnectScannedBlock(ctx, index, btcNode, uint32(lastHeight), lastHash)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = index.connectBlock(nextHeight, blockHash, rawBlock)
+		if err != nil {
+			return err
+		}
+
+		if nextHeight%BLOCK_SCANNER_PROGRESS_INTERVAL == 0 {
+			fmt.Println(fmt.Sprintf("%s: indexed block %d of %d.", index.getName(), nextHeight, endHeight))
+		}
+	}
+}
+
+// the node keeps blocks that were replaced by a reorg, so the block is read again to find what to remove
+func disconnectScannedBlock(ctx context.Context, index scannedIndex, btcNode *limitedNode, blockHeight uint32, blockHash string) error {
+
+	rawBlock, err := btcNode.getBlock(ctx, blockHash, true)
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Println(fmt.Sprintf("%s: block %s was replaced by a reorg but could not be read, so its entries can not be removed.", index.getName(), blockHash))
+	}
+
+	return index.disconnectBlock(blockHeight, blockHash, rawBlock)
+}
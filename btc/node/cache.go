@@ -1,41 +1,76 @@
 package node
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"sync"
-	"time"
 
 	//	"runtime"
 
-	"github.com/shopspring/decimal"
-
 	"github.com/btc-script-explorer/scantool/app"
 	"github.com/btc-script-explorer/scantool/btc"
 )
 
+// errors are returned as a NodeError whenever the cause fits one of the error types
 type nodeClient interface {
 	GetVersionString() string
 
 	getNodeType() string
 	getVersionStr() string
 
-	getBlock(blockHash string, withTxData bool) (map[string]interface{}, error)
-	getTx(txId string) (map[string]interface{}, error)
-	getBlockHash(blockHeight uint32) string
-	getBestBlockHash() string
+	getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error)
+	getTx(ctx context.Context, txId string) (*nodeTx, error)
+	getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) // does not require a transaction index
+	getBlockHash(ctx context.Context, blockHeight uint32) (string, error)
+	getBestBlockHash(ctx context.Context) (string, error)
+	getChainTips(ctx context.Context) ([]nodeChainTip, error)
+
+	getMempoolTxIds(ctx context.Context) ([]string, error)
+	getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error)
+}
+
+// nodes that can request several transactions in a single request
+// transactions that are not found are not included in the results
+type txBatchClient interface {
+	getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error)
 }
 
+// in record mode, the node is wrapped by a node that records its responses to the fixtures directory
 func getNode() (nodeClient, error) {
 
-	nodeType := app.Settings.GetNodeType()
+	btcNode, err := getNodeOfType(app.Settings.GetNodeType())
+	if err != nil {
+		return nil, err
+	}
+
+	if app.Settings.GetNodeFixturesMode() == app.NODE_FIXTURES_MODE_Record {
+		return newRecordingNode(btcNode, app.Settings.GetNodeFixturesDir())
+	}
+	return btcNode, nil
+}
+
+func getNodeOfType(nodeType string) (nodeClient, error) {
 
 	switch nodeType {
-	case "Bitcoin Core":
-		bitcoinCore, err := NewBitcoinCore()
+	case app.NODE_TYPE_BitcoinCore:
+		bitcoinCore, err := NewBitcoinCore(app.Settings.GetBitcoinCoreUrl(), getNodeCredentials())
 		return bitcoinCore, err
+	case app.NODE_TYPE_BitcoinCoreRest:
+		bitcoinCoreRest, err := NewBitcoinCoreRest(app.Settings.GetBitcoinCoreUrl())
+		return bitcoinCoreRest, err
+	case app.NODE_TYPE_Esplora:
+		esplora, err := NewEsplora(app.Settings.GetEsploraUrl())
+		return esplora, err
+	case app.NODE_TYPE_BlockFiles:
+		blockFiles, err := NewBlockFiles(app.Settings.GetBlocksDir())
+		return blockFiles, err
+	case app.NODE_TYPE_Multiple:
+		return newMultiNode(app.Settings.GetNodeEndpoints()), nil
+	case app.NODE_TYPE_Replay:
+		replayNode, err := newReplayNode(app.Settings.GetNodeFixturesDir())
+		return replayNode, err
 	}
 
 	return nil, errors.New(fmt.Sprintf("Incorrect node credentials or unsupported node type %s", nodeType))
@@ -43,43 +78,20 @@ func getNode() (nodeClient, error) {
 
 ///////////////////////////////////////////////////////////////////////////////////////////////
 
-type cachedBlock struct {
-	timestampLastUsed int64
-	block             btc.Block
-}
-
-type cachedTx struct {
-	timestampLastUsed int64
-	tx                btc.Tx
-}
-
-type cacheClientChannelPack struct {
-	block chan<- btc.Block
-	tx    chan<- btc.Tx
-}
-
-type cacheThreadChannelPack struct {
-	block <-chan btc.Block
-	tx    <-chan btc.Tx
-}
-
-// caches
-var blockIndex sync.Map // hash -> height
-var blockMap map[uint32]cachedBlock
-var txMap map[string]cachedTx
-
-// mutexes
-var blockCacheMutex sync.Mutex
-var txCacheMutex sync.Mutex
-
 type btcCache struct {
-	channel cacheClientChannelPack
-	btcNode nodeClient
-	caching bool
+	btcNode  *limitedNode
+	memory   *memoryCache  // nil if caching is off
+	disk     *diskCache    // nil if there is no disk cache
+	spends   *spendIndex   // nil if there is no spend index
+	address  *addressIndex // nil if there is no address index
+	txBlocks *txBlockIndex // nil if there is no transaction block index
+	prefetch *prefetcher   // nil if previous outputs are not prefetched
+	chain    *chainTip
 }
 
 var cache *btcCache = nil
 var initCacheOnce sync.Once
+var initCacheErr error = nil
 
 func initCache() {
 
@@ -87,524 +99,718 @@ func initCache() {
 		return
 	}
 
-	cachingOn := app.Settings.IsCachingOn()
-
-	btcNode, _ := getNode()
-	cache = &btcCache{btcNode: btcNode, caching: cachingOn}
-
-	if cache.caching {
-
-		blockMap = make(map[uint32]cachedBlock)
-		txMap = make(map[string]cachedTx)
-
-		// channels
-		blockChan := make(chan btc.Block, 50)
-		txChan := make(chan btc.Tx, 50)
-		cache.channel = cacheClientChannelPack{block: blockChan, tx: txChan}
-
-		// start the cache thread
-		cacheThreadChannels := cacheThreadChannelPack{block: blockChan, tx: txChan}
-		go run(cacheThreadChannels)
-	}
-}
-
-func GetCache() btcCache {
-	initCacheOnce.Do(initCache)
-	return *cache
-}
-
-func makeBlock(rawBlock map[string]interface{}) btc.Block {
-	previousHash := ""
-	nextHash := ""
-	if rawBlock["previousblockhash"] != nil {
-		previousHash = rawBlock["previousblockhash"].(string)
+	btcNode, err := getNode()
+	if err != nil {
+		initCacheErr = newUnavailableError("Failed to connect to the node.", err)
+		return
 	}
-	if rawBlock["nextblockhash"] != nil {
-		nextHash = rawBlock["nextblockhash"].(string)
+	// each node from the nodes setting has its own limits
+	limitedBtcNode := newLimitedNode(btcNode, 0, 0)
+	if btcNode.getNodeType() != app.NODE_TYPE_Multiple {
+		limitedBtcNode = newSettingsLimitedNode(btcNode)
 	}
+	cache = &btcCache{btcNode: limitedBtcNode, chain: &chainTip{}}
 
-	rawTxs := rawBlock["tx"].([]interface{})
-	txCount := len(rawTxs)
-	txIds := make([]string, txCount)
-	for t := 0; t < txCount; t++ {
-		rawTx := rawTxs[t].(map[string]interface{})
-		txIds[t] = rawTx["txid"].(string)
+	if app.Settings.IsCachingOn() {
+		cache.memory = newMemoryCache(app.Settings.GetCacheMaxMemory())
 	}
 
-	return btc.NewBlock(rawBlock["hash"].(string), previousHash, nextHash, uint32(rawBlock["height"].(float64)), int32(rawBlock["version"].(float64)), int64(rawBlock["time"].(float64)), txIds)
-}
-
-func makeTx(rawTx map[string]interface{}) btc.Tx {
-
-	isBip141 := rawTx["hex"].(string)[8:10] == "00"
-
-	// outputs
-	vout := rawTx["vout"].([]interface{})
-	outputCount := len(vout)
-	outputs := make([]btc.Output, outputCount)
-	for o := 0; o < int(outputCount); o++ {
-		rawOutput := vout[o].(map[string]interface{})
-
-		dValue := decimal.NewFromFloat(rawOutput["value"].(float64))
-		value := uint64(dValue.Mul(decimal.NewFromInt(100000000)).IntPart())
-
-		// output script
-		outputScript := rawOutput["scriptPubKey"].(map[string]interface{})
-		outputScriptBytes, err := hex.DecodeString(outputScript["hex"].(string))
+	diskCacheFile := app.Settings.GetDiskCacheFile()
+	if len(diskCacheFile) > 0 {
+		cache.disk, err = openDiskCache(diskCacheFile, app.Settings.GetDiskCacheMaxSize())
 		if err != nil {
 			fmt.Println(err.Error())
+			fmt.Println("Continuing without the disk cache.")
 		}
+	}
 
-		script := btc.NewScript(outputScriptBytes)
-
-		// address
-		address := ""
-		if outputScript["address"] != nil {
-			address = outputScript["address"].(string)
+	spendIndexFile := app.Settings.GetSpendIndexFile()
+	if len(spendIndexFile) > 0 {
+		cache.spends, err = openSpendIndex(spendIndexFile, limitedBtcNode, app.Settings.GetSpendIndexStartHeight())
+		if err != nil {
+			fmt.Println(err.Error())
+			fmt.Println("Continuing without the spend index.")
 		}
-
-		outputs[o] = btc.NewOutput(value, script, address)
 	}
 
-	// inputs
-	vin := rawTx["vin"].([]interface{})
-	inputCount := len(vin)
-	inputs := make([]btc.Input, inputCount)
-	for i := 0; i < int(inputCount); i++ {
-		rawInput := vin[i].(map[string]interface{})
+	addressIndexFile := app.Settings.GetAddressIndexFile()
+	if len(addressIndexFile) > 0 {
+		cache.address, err = openAddressIndex(addressIndexFile, app.Settings.GetAddressIndexStartHeight(), app.Settings.GetAddressIndexEndHeight())
+		if err != nil {
+			fmt.Println(err.Error())
+			fmt.Println("Continuing without the address index.")
+		}
+	}
 
-		var inputScriptBytes []byte
-		isCoinbase := i == 0 && rawInput["coinbase"] != nil
-		previousOutputTxId := ""
-		previousOutputIndex := uint16(0)
-		if isCoinbase {
-			inputScriptBytes, _ = hex.DecodeString(rawInput["coinbase"].(string))
-		} else {
-			scriptSig := rawInput["scriptSig"].(map[string]interface{})
-			inputScriptBytes, _ = hex.DecodeString(scriptSig["hex"].(string))
-
-			previousOutputTxId = rawInput["txid"].(string)
-			previousOutputIndex = uint16(rawInput["vout"].(float64))
-		}
-
-		segwit := btc.Segwit{}
-		if isBip141 {
-			segwitFields := make([][]byte, 0)
-			if rawInput["txinwitness"] != nil {
-				rawSegwitFields := rawInput["txinwitness"].([]interface{})
-				segwitFieldCount := len(rawSegwitFields)
-				for s := 0; s < segwitFieldCount; s++ {
-					segwitField, _ := hex.DecodeString(rawSegwitFields[s].(string))
-					segwitFields = append(segwitFields, segwitField)
-				}
-			}
+	// prefetched previous outputs are only useful if there is somewhere to keep them
+	if app.Settings.IsPrefetchingOn() && (cache.memory != nil || cache.disk != nil) {
+		cache.prefetch = newPrefetcher(cache)
+	}
 
-			segwit = btc.NewSegwit(segwitFields)
+	// block files find transactions by txid through the transaction block index, which is kept in its file
+	txBlockIndexFile := app.Settings.GetTxBlockIndexFile()
+	if len(txBlockIndexFile) == 0 && app.Settings.GetNodeType() == app.NODE_TYPE_BlockFiles {
+		fmt.Println("tx-block-index-file is not set, so transactions in block files can only be found together with their block hash.")
+	}
+	if len(txBlockIndexFile) > 0 {
+		cache.txBlocks, err = openTxBlockIndex(txBlockIndexFile, app.Settings.GetTxBlockIndexStartHeight())
+		if err != nil {
+			fmt.Println(err.Error())
+			fmt.Println("Continuing without the transaction block index.")
 		}
+	}
 
-		previousOutput := btc.Output{}
-		if rawInput["previous_output"] != nil {
-			rawPreviousOutput := rawInput["previous_output"].(map[string]interface{})
-			outputScriptBytes, _ := hex.DecodeString(rawPreviousOutput["output_script"].(string))
-			previousOutput = btc.NewOutput(rawPreviousOutput["value"].(uint64), btc.NewScript(outputScriptBytes), rawPreviousOutput["address"].(string))
+	// live notifications, which the cache uses to remove blocks that have changed and the indexes use to add new blocks
+	// only block events are subscribed to, so that they are not crowded out by transactions
+	topicEndpoints := getZmqTopicEndpoints()
+	var spendIndexEvents, addressIndexEvents, txBlockIndexEvents <-chan Event
+	if len(topicEndpoints) > 0 {
+		if cache.memory != nil || cache.disk != nil {
+			_, events := GetEventBus().Subscribe(100, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+			go cache.invalidateOnEvents(events)
 		}
+		if cache.spends != nil {
+			_, spendIndexEvents = GetEventBus().Subscribe(100, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+		}
+		if cache.address != nil {
+			_, addressIndexEvents = GetEventBus().Subscribe(100, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+		}
+		if cache.txBlocks != nil {
+			_, txBlockIndexEvents = GetEventBus().Subscribe(100, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+		}
+		startZmqSubscriber(limitedBtcNode, topicEndpoints)
+	}
 
-		inputs[i] = btc.NewInput(isCoinbase,
-			previousOutputTxId,
-			previousOutputIndex,
-			btc.NewScript(inputScriptBytes),
-			segwit,
-			uint32(rawInput["sequence"].(float64)),
-			previousOutput)
+	if cache.spends != nil {
+		go runBlockScanner(cache.spends, limitedBtcNode, spendIndexEvents)
 	}
+	if cache.address != nil {
+		go runBlockScanner(cache.address, limitedBtcNode, addressIndexEvents)
+	}
+	if cache.txBlocks != nil {
+		go runBlockScanner(cache.txBlocks, limitedBtcNode, txBlockIndexEvents)
+	}
+}
 
-	return btc.NewTx(rawTx["txid"].(string),
-		uint32(rawTx["version"].(float64)),
-		inputs,
-		outputs,
-		uint32(rawTx["locktime"].(float64)),
-		inputs[0].IsCoinbase(),
-		isBip141,
-		rawTx["blockhash"].(string),
-		int64(rawTx["blocktime"].(float64)))
+// returns an unavailable error if the node could not be connected to
+func GetCache() (btcCache, error) {
+	initCacheOnce.Do(initCache)
+	if initCacheErr != nil {
+		return btcCache{}, initCacheErr
+	}
+	return *cache, nil
 }
 
 // this is a pass-through function
 // the current block hash is never cached
-func (c *btcCache) getCurrentBlockHash() <-chan string {
-
-	responseChannel := make(chan string, 1)
-
-	go func(responseChannel chan<- string) {
-		responseChannel <- c.btcNode.getBestBlockHash()
-	}(responseChannel)
-
-	return responseChannel
+func (c *btcCache) getCurrentBlockHash(ctx context.Context) (string, error) {
+	return c.btcNode.getBestBlockHash(ctx)
 }
 
 // this is a pass-through function
 // the current block height is never cached
-func (c *btcCache) getCurrentBlockHeight() <-chan int32 {
-
-	responseChannel := make(chan int32, 1)
-
-	go func(responseChannel chan<- int32) {
-		currentBlockHeight := int32(-1)
-
-		blockHash := c.btcNode.getBestBlockHash()
-		if len(blockHash) > 0 {
-			response, err := c.btcNode.getBlock(blockHash, false)
-
-			if err != nil {
-				fmt.Println("NODE ERROR: " + err.Error())
-			}
-
-			if response != nil {
-				currentBlockHeight = int32(response["height"].(float64))
-			}
-		}
+func (c *btcCache) getCurrentBlockHeight(ctx context.Context) (int32, error) {
 
-		responseChannel <- currentBlockHeight
-	}(responseChannel)
+	blockHash, err := c.btcNode.getBestBlockHash(ctx)
+	if err != nil {
+		return -1, err
+	}
 
-	return responseChannel
+	response, err := c.btcNode.getBlock(ctx, blockHash, false)
+	if err != nil {
+		return -1, err
+	}
+	return int32(response.Height), nil
 }
 
-func (c *btcCache) getBlock(blockKey string) btc.Block {
+func (c *btcCache) getBlock(ctx context.Context, blockKey string) (btc.Block, error) {
 
 	block := btc.Block{}
 
 	blockHash := ""
 	blockHeight := uint32(0xffffffff)
 
-	// is it already cached?
+	// cached blocks that have been replaced by a reorg are found by checking the tip of the chain
+	if c.memory != nil || c.disk != nil {
+		c.checkChainTip(ctx)
+	}
+
 	if c.isBlockHash(blockKey) {
 		blockHash = blockKey
-
-		indexedBlockHeight, exists := blockIndex.Load(blockHash)
-		if exists {
-			blockHeight = indexedBlockHeight.(uint32)
-		}
 	} else {
 		blockHeight = c.toBlockHeight(blockKey)
+		if c.memory != nil {
+			blockHash = c.memory.getBlockHash(blockHeight)
+		}
 	}
 
-	if c.caching {
-		blockCacheMutex.Lock()
-		block = blockMap[blockHeight].block
-		blockCacheMutex.Unlock()
-
-		if !block.IsNil() {
-			return block
+	// is it already cached?
+	// orphaned blocks are always requested again, since a later reorg might have returned them to the active chain
+	if c.memory != nil && len(blockHash) > 0 {
+		cachedBlock := c.memory.getBlock(blockHash)
+		if !cachedBlock.IsNil() && !cachedBlock.IsOrphaned() {
+			return cachedBlock, nil
 		}
 	}
 
 	// it wasn't there
-	// get the block from the node, return it to the appropriate channel and cache it
-	r := make(chan btc.Block)
-	go func(r chan<- btc.Block) {
+	// get the block from the node and cache it
 
-		// make sure we have the block hash
-		if len(blockHash) == 0 {
-			if blockHeight >= 0 {
-				blockHash = c.btcNode.getBlockHash(uint32(blockHeight))
-			}
-			if len(blockHash) == 0 {
-				r <- block
-				return
-			}
+	// make sure we have the block hash
+	if len(blockHash) == 0 {
+		if blockHeight == 0xffffffff {
+			return block, newNotFoundError(blockKey + " is not a block hash or block height.")
 		}
 
-		// try to get it from the node
-		rawBlock, err := c.btcNode.getBlock(blockHash, true)
+		if c.disk != nil {
+			blockHash = c.disk.getBlockHash(blockHeight)
+		}
 
-		succeeded := err == nil
-		if !succeeded {
-			fmt.Println(fmt.Sprintf("NODE ERROR: %s", err.Error()))
-			r <- block
-			return
+		if len(blockHash) == 0 {
+			var err error
+			blockHash, err = c.btcNode.getBlockHash(ctx, blockHeight)
+			if err != nil {
+				return block, err
+			}
 		}
+	}
 
-		blockExists := rawBlock != nil
-		if blockExists {
-			block = makeBlock(rawBlock)
+	// the transactions of a block from the disk cache are read from the disk cache when they are requested
+	// the tip of the chain is not read from the disk cache because its next block hash is missing
+	if c.disk != nil {
+		rawBlock := c.disk.getBlock(blockHash)
+		if rawBlock != nil && len(rawBlock.NextBlockHash) > 0 {
+			block, err := decodeBlock(rawBlock)
+			if err == nil {
+				if c.memory != nil {
+					c.memory.putBlock(block)
+				}
+				return block, nil
+			}
 		}
+	}
 
-		// return it to the caller
-		r <- block
+	// try to get it from the node
+	rawBlock, err := c.btcNode.getBlock(ctx, blockHash, true)
+	if err != nil {
+		return block, err
+	}
+
+	block, err = decodeBlock(rawBlock)
+	if err != nil {
+		return block, err
+	}
 
-		// cache it
-		if blockExists {
-			if c.caching {
-				c.channel.block <- block
+	// orphaned blocks are not stored on disk, and their transactions are not cached because their block data is not current
+	if c.disk != nil && !block.IsOrphaned() {
+		c.disk.putBlock(rawBlock)
+	}
 
-				// cache the transactions
-				txs := rawBlock["tx"].([]interface{})
-				for _, rawTx := range txs {
-					txObj := rawTx.(map[string]interface{})
-					if txObj["txid"] == nil {
-						continue
-					}
+	// cache it
+	if c.memory != nil {
+		c.memory.putBlock(block)
+	}
+	if c.memory != nil && !block.IsOrphaned() {
 
-					txObj["blockhash"] = block.GetHash()
-					txObj["blocktime"] = float64(block.GetTimestamp())
+		// cache the transactions
+		// the raw block might be shared with other requests for the same block, so it is not modified
+		for _, rawTx := range rawBlock.getTxs() {
+			rawTx.BlockHash = block.GetHash()
+			rawTx.BlockTime = block.GetTimestamp()
 
-					c.channel.tx <- makeTx(txObj)
-				}
+			tx, err := decodeTx(&rawTx)
+			if err != nil {
+				return block, err
 			}
+			c.memory.putTx(tx)
 		}
-	}(r)
+	}
 
-	return <-r
+	return block, nil
 }
 
-func (c *btcCache) threadTxFromNode(txId string, withPreviousOutputs bool, r chan<- btc.Tx) {
+// returns a nil tx if the tx is not cached
+func (c *btcCache) getCachedTx(txId string) btc.Tx {
 
-	rawTx, err := c.btcNode.getTx(txId)
+	if c.memory == nil {
+		return btc.Tx{}
+	}
+	return c.memory.getTx(txId)
+}
 
-	succeeded := err == nil
-	txExists := rawTx != nil
+func includesPreviousOutputs(tx btc.Tx) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
 
-	// Suppressing this error message because the web interface can not tell the difference
-	// between a transaction hash and a block hash, so it attempts both and one of them
-	// could result in the node reporting an error. Specifically, if the user enters a block
-	// hash, it will always attempt to load it as a transaction first. Any time a block
-	// hash is entered, an error message would be printed to stdout, which is not necessary.
-	//	if !succeeded { fmt.Printf ("NODE ERROR: %s\n", err.Error ()) }
+	firstInput := tx.GetInput(0)
+	firstPrevOut := firstInput.GetPreviousOutput()
+	return len(firstPrevOut.GetOutputType()) != 0
+}
 
-	tx := btc.Tx{}
+// blockHash is the block the transaction is in if it is known, otherwise it is empty
+func (c *btcCache) getTx(ctx context.Context, txId string, blockHash string, withPreviousOutputs bool) (btc.Tx, error) {
 
-	if succeeded && txExists {
+	// is it already cached?
+	tx := c.getCachedTx(txId)
+	if tx.IsNil() {
 
-		if withPreviousOutputs {
+		// it wasn't there, get it from the disk cache or the node
+		var rawTx *nodeTx
+		if c.disk != nil {
+			rawTx = c.disk.getTx(txId)
+		}
 
-			// get every previous output
-			vin := rawTx["vin"].([]interface{})
-			inputCount := len(vin)
-			for i := 0; i < int(inputCount); i++ {
-				rawInput := vin[i].(map[string]interface{})
-				if rawInput["coinbase"] != nil {
-					continue
-				}
+		fromNode := rawTx == nil
+		if fromNode {
+			var err error
+			rawTx, err = c.getTxFromNode(ctx, txId, blockHash)
+			if err != nil {
+				return tx, err
+			}
+		}
+
+		// unconfirmed transactions are not cached because their block data will change
+		var err error
+		tx, err = decodeTx(rawTx)
+		if err != nil {
+			return tx, err
+		}
+		if tx.IsConfirmed() {
+			if c.memory != nil {
+				c.memory.putTx(tx)
+			}
+			if fromNode && c.disk != nil {
+				c.disk.putTxs([]*nodeTx{rawTx})
+			}
+		}
+	}
 
-				previousOutputTxId := rawInput["txid"].(string)
-				previousOutputIndex := uint16(rawInput["vout"].(float64))
+	// get the previous outputs and re-evaluate the inputs
+	if withPreviousOutputs && !includesPreviousOutputs(tx) {
+		txs := []btc.Tx{tx}
+		err := c.setPreviousOutputs(ctx, txs)
+		if err != nil {
+			return tx, err
+		}
+		tx = txs[0]
+	}
+
+	return tx, nil
+}
+
+// a node without a transaction index can only find a transaction in the mempool or in a known block
+// so a transaction with a known block is requested from that block first,
+// and a transaction that the node can not find is requested from its block in the transaction block index
+func (c *btcCache) getTxFromNode(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+
+	if isHash(blockHash) {
+		rawTx, err := c.btcNode.getTxInBlock(ctx, txId, blockHash)
+		if err == nil || !IsNotFound(err) {
+			return rawTx, err
+		}
+	}
+
+	rawTx, err := c.btcNode.getTx(ctx, txId)
+	if err == nil || !IsNotFound(err) || c.txBlocks == nil {
+		return rawTx, err
+	}
 
-				prevTx := c.getTx(previousOutputTxId, false)
-				previousOutput := prevTx.GetOutput(previousOutputIndex)
-				previousOutputScript := previousOutput.GetOutputScript()
+	indexedBlockHash := c.txBlocks.getBlockHash(txId)
+	if len(indexedBlockHash) == 0 || indexedBlockHash == blockHash {
+		return nil, err
+	}
+	return c.btcNode.getTxInBlock(ctx, txId, indexedBlockHash)
+}
 
-				rawPreviousOutput := make(map[string]interface{})
-				rawPreviousOutput["value"] = previousOutput.GetValue()
-				rawPreviousOutput["address"] = previousOutput.GetAddress()
-				rawPreviousOutput["output_script"] = previousOutputScript.AsHex()
-				rawPreviousOutput["output_type"] = previousOutput.GetOutputType()
-				rawInput["previous_output"] = rawPreviousOutput
-				vin[i] = rawInput
+// requests transactions that the node could not find from the blocks they are in, which are either in the transaction block index
+// or given as hints, by transaction id
+// a hint is only a guess, so the transaction ids of a hinted block are checked before the transaction is requested from it
+// transactions that are not found are not included in the results
+func (c *btcCache) getTxsFromBlocks(ctx context.Context, txIds []string, blockHashHints map[string]string) (map[string]*nodeTx, error) {
+
+	txBlockHashes := make(map[string]string, len(txIds))
+	hintedBlockTxIds := make(map[string]map[string]bool)
+	for _, txId := range txIds {
+		if c.txBlocks != nil {
+			blockHash := c.txBlocks.getBlockHash(txId)
+			if len(blockHash) > 0 {
+				txBlockHashes[txId] = blockHash
+				continue
 			}
-			//fmt.Println (vin)
 		}
 
-		// create the tx and cache it
-		tx = makeTx(rawTx)
+		blockHash := blockHashHints[txId]
+		if !isHash(blockHash) {
+			continue
+		}
+
+		blockTxIds, found := hintedBlockTxIds[blockHash]
+		if !found {
+			rawBlock, err := c.btcNode.getBlock(ctx, blockHash, false)
+			if err != nil && !IsNotFound(err) {
+				return nil, err
+			}
 
-		if c.caching {
-			c.channel.tx <- tx
+			blockTxIds = make(map[string]bool)
+			if err == nil {
+				for _, blockTxId := range rawBlock.getTxIds() {
+					blockTxIds[blockTxId] = true
+				}
+			}
+			hintedBlockTxIds[blockHash] = blockTxIds
 		}
+		if blockTxIds[txId] {
+			txBlockHashes[txId] = blockHash
+		}
+	}
+
+	rawTxs := make(map[string]*nodeTx, len(txBlockHashes))
+	var firstErr error
+	var rawTxsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for txId, blockHash := range txBlockHashes {
+		wg.Add(1)
+		go func(txId string, blockHash string) {
+			defer wg.Done()
+
+			rawTx, err := c.btcNode.getTxInBlock(ctx, txId, blockHash)
+
+			rawTxsMutex.Lock()
+			defer rawTxsMutex.Unlock()
+			if err == nil {
+				rawTxs[txId] = rawTx
+			} else if !IsNotFound(err) && firstErr == nil {
+				firstErr = err
+			}
+		}(txId, blockHash)
 	}
+	wg.Wait()
 
-	// return it to the caller
-	r <- tx
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return rawTxs, nil
 }
 
-func (c *btcCache) getTx(txId string, withPreviousOutputs bool) btc.Tx {
+// returns the transactions that were found, in the same order as the transaction ids
+func (c *btcCache) getTxList(ctx context.Context, txIds []string, withPreviousOutputs bool) ([]btc.Tx, error) {
 
-	// is it already cached?
-	t := cachedTx{}
-	found := false
-	if c.caching {
+	foundTxs, err := c.getTxs(ctx, txIds, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]btc.Tx, 0, len(txIds))
+	txsWithoutPreviousOutputs := make([]btc.Tx, 0)
+	positions := make([]int, 0)
+	for _, txId := range txIds {
+		tx, found := foundTxs[txId]
+		if !found {
+			continue
+		}
 
-		txCacheMutex.Lock()
-		t = txMap[txId]
-		txCacheMutex.Unlock()
+		if withPreviousOutputs && !includesPreviousOutputs(tx) {
+			txsWithoutPreviousOutputs = append(txsWithoutPreviousOutputs, tx)
+			positions = append(positions, len(txs))
+		}
+		txs = append(txs, tx)
+	}
 
-		found = !t.tx.IsNil()
+	// the previous outputs of all of the transactions are requested together
+	err = c.setPreviousOutputs(ctx, txsWithoutPreviousOutputs)
+	if err != nil {
+		return nil, err
+	}
+	for t, tx := range txsWithoutPreviousOutputs {
+		txs[positions[t]] = tx
 	}
 
-	if found {
+	return txs, nil
+}
+
+// returns the transactions that were found, without requesting their previous outputs
+// transactions that are not cached are requested from the node in batches
+// transactions that the node can not find are requested from their blocks, which are either indexed or hinted by blockHashHints
+func (c *btcCache) getTxs(ctx context.Context, txIds []string, blockHashHints map[string]string) (map[string]btc.Tx, error) {
+
+	txs := make(map[string]btc.Tx, len(txIds))
+	missingTxIdSet := make(map[string]bool)
+	missingTxIds := make([]string, 0)
+	for _, txId := range txIds {
+		if _, found := txs[txId]; found || missingTxIdSet[txId] {
+			continue
+		}
 
-		txCacheObj := t
-		tx := txCacheObj.tx
+		tx := c.getCachedTx(txId)
+		if tx.IsNil() {
+			missingTxIdSet[txId] = true
+			missingTxIds = append(missingTxIds, txId)
+		} else {
+			txs[txId] = tx
+		}
+	}
 
-		if !tx.IsNil() {
+	// transactions that are not in memory might be in the disk cache
+	if c.disk != nil && len(missingTxIds) > 0 {
+		rawTxs := c.disk.getTxs(missingTxIds)
+		stillMissingTxIds := make([]string, 0, len(missingTxIds)-len(rawTxs))
+		for _, txId := range missingTxIds {
+			rawTx, found := rawTxs[txId]
+			if !found {
+				stillMissingTxIds = append(stillMissingTxIds, txId)
+				continue
+			}
 
-			// update the cache
-			c.channel.tx <- tx
+			tx, err := decodeTx(rawTx)
+			if err != nil {
+				stillMissingTxIds = append(stillMissingTxIds, txId)
+				continue
+			}
+			txs[txId] = tx
 
-			includesPreviousOutputs := tx.IsCoinbase()
-			if !tx.IsCoinbase() {
-				firstInput := tx.GetInput(0)
-				firstPrevOut := firstInput.GetPreviousOutput()
-				includesPreviousOutputs = len(firstPrevOut.GetOutputType()) != 0
+			if c.memory != nil {
+				c.memory.putTx(tx)
 			}
+		}
+		missingTxIds = stillMissingTxIds
+	}
+
+	batchSize := int(app.Settings.GetRpcBatchSize())
+	for start := 0; start < len(missingTxIds); start += batchSize {
+		end := start + batchSize
+		if end > len(missingTxIds) {
+			end = len(missingTxIds)
+		}
 
-			if withPreviousOutputs && !includesPreviousOutputs {
+		rawTxs, err := c.btcNode.getTxs(ctx, missingTxIds[start:end])
+		if err != nil {
+			return nil, err
+		}
 
-				// get the previous outputs, re-evaluate the inputs and re-cache the transaction
-				for i, input := range tx.GetInputs() {
-					previousOutput := c.getOutput(input.GetPreviousOutputTxId(), input.GetPreviousOutputIndex())
-					tx.SetPreviousOutput(uint16(i), previousOutput)
+		if len(rawTxs) < end-start && (c.txBlocks != nil || len(blockHashHints) > 0) {
+			notFoundTxIds := make([]string, 0, end-start-len(rawTxs))
+			for _, txId := range missingTxIds[start:end] {
+				if _, found := rawTxs[txId]; !found {
+					notFoundTxIds = append(notFoundTxIds, txId)
 				}
 			}
 
-			return tx
+			blockRawTxs, err := c.getTxsFromBlocks(ctx, notFoundTxIds, blockHashHints)
+			if err != nil {
+				return nil, err
+			}
+			for txId, rawTx := range blockRawTxs {
+				rawTxs[txId] = rawTx
+			}
 		}
-	}
 
-	// it wasn't there, get it from the node
-	r := make(chan btc.Tx, 1)
-	go c.threadTxFromNode(txId, withPreviousOutputs, r)
-	return <-r
-}
+		confirmedRawTxs := make([]*nodeTx, 0, len(rawTxs))
+		for txId, rawTx := range rawTxs {
+			tx, err := decodeTx(rawTx)
+			if err != nil {
+				return nil, err
+			}
+			txs[txId] = tx
 
-func (c *btcCache) getOutput(txId string, outputIndex uint16) btc.Output {
+			if tx.IsConfirmed() {
+				if c.memory != nil {
+					c.memory.putTx(tx)
+				}
+				confirmedRawTxs = append(confirmedRawTxs, rawTx)
+			}
+		}
 
-	// is it already cached?
-	tx := c.getTx(txId, false)
-	if tx.IsNil() || outputIndex >= tx.GetOutputCount() {
-		return btc.Output{}
+		if c.disk != nil {
+			c.disk.putTxs(confirmedRawTxs)
+		}
 	}
 
-	return tx.GetOutput(outputIndex)
+	return txs, nil
 }
 
-func (c *btcCache) GetNodeVersionStr() string {
-	return c.btcNode.GetVersionString()
+// returns the previous transaction ids of the inputs of the transactions, with the blocks they might be in
+// transactions are often spent in the block they are confirmed in,
+// so the block of the spending transaction is a hint for a previous transaction that the node can not find
+func getPreviousTxIds(txs []btc.Tx) ([]string, map[string]string) {
+	previousTxIds := make([]string, 0)
+	blockHashHints := make(map[string]string)
+	for _, tx := range txs {
+		for _, input := range tx.GetInputs() {
+			if !input.IsCoinbase() {
+				previousTxIds = append(previousTxIds, input.GetPreviousOutputTxId())
+				if tx.IsConfirmed() {
+					blockHashHints[input.GetPreviousOutputTxId()] = tx.GetBlockHash()
+				}
+			}
+		}
+	}
+	return previousTxIds, blockHashHints
 }
 
-func (c *btcCache) isBlockHash(blockKey string) bool {
-	return len(blockKey) == 64
-}
+// sets the previous output of every input and re-evaluates the inputs
+// the previous transactions of all of the inputs are requested together
+// inputs whose previous transaction is not found are given a nil previous output
+// the inputs of cached transactions are shared with other requests, so each transaction in txs is replaced with a copy first
+func (c *btcCache) setPreviousOutputs(ctx context.Context, txs []btc.Tx) error {
 
-func (c *btcCache) toBlockHeight(blockKey string) uint32 {
-	height, err := strconv.Atoi(blockKey)
-	if err != nil {
-		return 0xffffffff
+	for t := range txs {
+		txs[t] = txs[t].Copy()
 	}
-	return uint32(height)
-}
 
-func run(channel cacheThreadChannelPack) {
+	// previous outputs from the disk cache are used without requesting the previous transactions
+	if c.disk != nil && len(txs) > 0 {
+		txIds := make([]string, len(txs))
+		for t, tx := range txs {
+			txIds[t] = tx.GetTxId()
+		}
+		storedPreviousOutputs := c.disk.getPreviousOutputs(txIds)
+
+		remainingTxs := make([]btc.Tx, 0, len(txs))
+		for _, tx := range txs {
+			previousOutputs, found := storedPreviousOutputs[tx.GetTxId()]
+			if !found || len(previousOutputs) != int(tx.GetInputCount()) {
+				remainingTxs = append(remainingTxs, tx)
+				continue
+			}
 
-	for true {
+			for i, input := range tx.GetInputs() {
+				if !input.IsCoinbase() {
+					tx.SetPreviousOutput(uint16(i), previousOutputs[i])
+				}
+			}
+		}
+		txs = remainingTxs
+	}
 
-		select {
+	previousTxIds, blockHashHints := getPreviousTxIds(txs)
+	if len(previousTxIds) == 0 {
+		return nil
+	}
 
-		case block := <-channel.block:
+	previousTxs, err := c.getTxs(ctx, previousTxIds, blockHashHints)
+	if err != nil {
+		return err
+	}
 
-			if block.IsNil() {
-				break
+	// only transactions whose previous outputs were all found are stored in the disk cache
+	resolvedTxs := make([]btc.Tx, 0, len(txs))
+	for _, tx := range txs {
+		resolved := true
+		for i, input := range tx.GetInputs() {
+			if input.IsCoinbase() {
+				continue
 			}
 
-			go func() {
-				blockHeight := block.GetHeight()
-				now := time.Now().Unix()
-
-				// does it already exist?
-				blockCacheMutex.Lock()
-				b := blockMap[blockHeight]
-				blockCacheMutex.Unlock()
+			previousOutput := btc.Output{}
+			previousTx, found := previousTxs[input.GetPreviousOutputTxId()]
+			if found && input.GetPreviousOutputIndex() < previousTx.GetOutputCount() {
+				previousOutput = previousTx.GetOutput(input.GetPreviousOutputIndex())
+			} else {
+				resolved = false
+			}
+			tx.SetPreviousOutput(uint16(i), previousOutput)
+		}
 
-				found := !b.block.IsNil()
-				if found {
+		if resolved {
+			resolvedTxs = append(resolvedTxs, tx)
+		}
+	}
 
-					if b.timestampLastUsed == now {
-						return
-					}
+	if c.disk != nil && len(resolvedTxs) > 0 {
+		c.disk.putPreviousOutputs(resolvedTxs)
+	}
 
-					// update the last used timestamp
-					b.timestampLastUsed = now
+	return nil
+}
 
-				} else {
+// these are pass-through functions
+// the mempool changes constantly, so it is never cached
+func (c *btcCache) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	return c.btcNode.getMempoolTxIds(ctx)
+}
 
-					// index and cache the block
-					b = cachedBlock{timestampLastUsed: now, block: block}
-					blockIndex.Store(block.GetHash(), blockHeight)
-					//fmt.Println (fmt.Sprintf ("CACHING: block %d", blockHeight))
+func (c *btcCache) getMempoolEntry(ctx context.Context, txId string) (MempoolEntry, error) {
+	rawEntry, err := c.btcNode.getMempoolEntry(ctx, txId)
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	return makeMempoolEntry(txId, rawEntry), nil
+}
 
-				}
+func (c *btcCache) getOutput(ctx context.Context, txId string, blockHash string, outputIndex uint16) (btc.Output, error) {
 
-				blockCacheMutex.Lock()
-				blockMap[blockHeight] = b
-				blockCacheMutex.Unlock()
+	// is it already cached?
+	tx, err := c.getTx(ctx, txId, blockHash, false)
+	if err != nil {
+		return btc.Output{}, err
+	}
+	if outputIndex >= tx.GetOutputCount() {
+		return btc.Output{}, newNotFoundError(fmt.Sprintf("Transaction %s does not have an output %d.", txId, outputIndex))
+	}
 
-			}()
+	return tx.GetOutput(outputIndex), nil
+}
 
-		case tx := <-channel.tx:
+// orphans cached blocks that no longer match the chain
+func (c *btcCache) invalidateOnEvents(events <-chan Event) {
 
-			if tx.IsNil() {
-				break
-			}
+	for event := range events {
+		switch event.GetType() {
 
-			go func() {
-				txId := tx.GetTxId()
-				now := time.Now().Unix()
+		case EVENT_TYPE_BlockConnected:
+			c.updateChainTip(context.Background(), event.GetBlockHash())
 
-				// does it already exist?
-				txCacheMutex.Lock()
-				t := txMap[txId]
-				txCacheMutex.Unlock()
+		case EVENT_TYPE_BlockDisconnected:
+			c.orphanBlock(event.GetBlockHash())
+		}
+	}
+}
 
-				found := !t.tx.IsNil()
-				if found {
+// returns the spends of the outputs of a transaction by output index, which are only known if there is a spend index
+func (c *btcCache) getOutputSpends(txId string) (map[uint16]OutputSpend, error) {
+	if c.spends == nil {
+		return nil, newUnavailableError("The spend index is not enabled.", nil)
+	}
+	return c.spends.getOutputSpends(txId)
+}
 
-					if t.timestampLastUsed == now {
-						return
-					}
+// only nodes from the nodes setting have a status
+func (c *btcCache) getNodeStatuses() []NodeStatus {
+	if nodes, isMultiNode := c.btcNode.btcNode.(*multiNode); isMultiNode {
+		return nodes.getStatuses()
+	}
+	return []NodeStatus{}
+}
 
-					// update the last used timestamp
-					t.timestampLastUsed = now
+// the address index returns an unavailable error if it is not enabled
+func (c *btcCache) getAddressIndex() (*addressIndex, error) {
+	if c.address == nil {
+		return nil, newUnavailableError("The address index is not enabled.", nil)
+	}
+	return c.address, nil
+}
 
-				} else {
+// returns the statistics of the memory cache, which are all zero if caching is off
+func (c *btcCache) getStats() CacheStats {
+	if c.memory == nil {
+		return CacheStats{}
+	}
+	return c.memory.getStats()
+}
 
-					// cache the tx
-					t = cachedTx{timestampLastUsed: now, tx: tx}
-					//fmt.Println (fmt.Sprintf ("CACHING: tx %s, created at %ld, queue size = %d", txId, now, len (channel.tx)))
-				}
+func (c *btcCache) GetNodeVersionStr() string {
+	return c.btcNode.GetVersionString()
+}
 
-				txCacheMutex.Lock()
-				txMap[txId] = t
-				txCacheMutex.Unlock()
-
-				//var ms runtime.MemStats
-				//runtime.ReadMemStats (&ms)
-				//if txCount == 0 { txCount = 1 }
-				//fmt.Println (fmt.Sprintf ("***** %d threads, %d bytes allocated, %d transactions (%d bytes/tx) *****", runtime.NumGoroutine (), ms.HeapAlloc, txCount, ms.HeapAlloc / uint64 (txCount)))
-
-			}()
-		}
-
-		// The number of transactions in the cache and the length of time they are allowed to remain in the cache
-		// varies from system to system. Caching is off by default. If it were to be turned on by default,
-		// a more system-independent cache control mechanism would need to be put in place.
-		// This is a very basic way to prevent the cache from becoming too large.
-		bbb := time.Now().Unix()
-		txCacheMutex.Lock()
-		txCount := len(txMap)
-		//fmt.Printf ("txCount = %d\n", txCount)
-		if txCount >= 50000 {
-			for id, data := range txMap {
-				if bbb-data.timestampLastUsed >= 1200 {
-					//fmt.Printf ("id = %s, data = %d\n", id, data.timestampLastUsed)
-					delete(txMap, id)
-				}
-			}
-		}
-		txCacheMutex.Unlock()
-		//eee := time.Now ().Unix () - bbb
-		//if eee > 0 && txCount > 10000 {
-		//	fmt.Printf ("%d took %d\n", txCount, eee)
-		//}
+func (c *btcCache) isBlockHash(blockKey string) bool {
+	return len(blockKey) == 64
+}
 
+func (c *btcCache) toBlockHeight(blockKey string) uint32 {
+	height, err := strconv.Atoi(blockKey)
+	if err != nil {
+		return 0xffffffff
 	}
+	return uint32(height)
 }
@@ -0,0 +1,151 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// the node responses for mainnet block 170 and its transactions were recorded from Bitcoin Core
+// block 170 has the first transaction from one person to another, which spends the coinbase output of block 9
+const testFixturesDir = "testdata/mainnet-fixtures"
+const testFixturesBlockHash = "00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee"
+const testFixturesBlockHeight = 170
+const testFixturesCoinbaseTxId = "b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082"
+const testFixturesTxId = "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16"
+const testFixturesPreviousTxId = "0437cd7f8525ceed2324359c2d0ba26006d92d856a9c20fa0241106ee5a597c9"
+
+// the settings are read from the command line, so they are parsed before the test flags are
+func TestMain(m *testing.M) {
+	args := os.Args
+	os.Args = args[:1]
+	app.ParseSettings("test")
+	os.Args = args
+
+	os.Exit(m.Run())
+}
+
+func newReplayTestCache(t *testing.T, dir string) *btcCache {
+	replay, err := newReplayNode(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &btcCache{btcNode: newLimitedNode(replay, 0, 0), chain: &chainTip{}}
+}
+
+// the input of the test transaction spends the 50 BTC coinbase output of block 9
+func checkTestFixturesTx(t *testing.T, c *btcCache, blockHash string) {
+
+	tx, err := c.getTx(context.Background(), testFixturesTxId, blockHash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.GetTxId() != testFixturesTxId || tx.GetBlockHash() != testFixturesBlockHash || tx.GetOutputCount() != 2 {
+		t.Errorf("tx %s returned %s in block %s", testFixturesTxId, tx.GetTxId(), tx.GetBlockHash())
+	}
+
+	input := tx.GetInput(0)
+	previousOutput := input.GetPreviousOutput()
+	if input.GetPreviousOutputTxId() != testFixturesPreviousTxId || previousOutput.GetValue() != 5000000000 || input.GetSpendType() != "P2PK" {
+		t.Errorf("the input of tx %s spends %d from %s as %s", testFixturesTxId, previousOutput.GetValue(), input.GetPreviousOutputTxId(), input.GetSpendType())
+	}
+}
+
+func TestCacheReplay(t *testing.T) {
+
+	c := newReplayTestCache(t, testFixturesDir)
+
+	// the test transaction is found by its txid, and in its block
+	checkTestFixturesTx(t, c, "")
+	checkTestFixturesTx(t, c, testFixturesBlockHash)
+
+	output, err := c.getOutput(context.Background(), testFixturesTxId, testFixturesBlockHash, 1)
+	if err != nil || output.GetValue() != 4000000000 {
+		t.Errorf("output 1 of tx %s is %d, %v, expected 4000000000", testFixturesTxId, output.GetValue(), err)
+	}
+	_, err = c.getOutput(context.Background(), testFixturesTxId, "", 2)
+	if !IsNotFound(err) {
+		t.Errorf("output 2 of tx %s returned %v, expected a not found error", testFixturesTxId, err)
+	}
+
+	_, err = c.getTx(context.Background(), "0000000000000000000000000000000000000000000000000000000000000001", "", false)
+	if !IsNotFound(err) {
+		t.Errorf("a missing tx returned %v, expected a not found error", err)
+	}
+}
+
+// the block and its transactions are cached in memory, and can be found by the height of the block
+func TestCacheReplayMemory(t *testing.T) {
+
+	c := newReplayTestCache(t, testFixturesDir)
+	c.memory = newMemoryCache(1 << 20)
+
+	for _, blockKey := range []string{testFixturesBlockHash, "170"} {
+		block, err := c.getBlock(context.Background(), blockKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txIds := block.GetTxIds()
+		if block.GetHash() != testFixturesBlockHash || block.GetHeight() != testFixturesBlockHeight || len(txIds) != 2 || txIds[0] != testFixturesCoinbaseTxId || txIds[1] != testFixturesTxId {
+			t.Errorf("block %s returned %s %d with txs %v", blockKey, block.GetHash(), block.GetHeight(), txIds)
+		}
+	}
+
+	tx := c.getCachedTx(testFixturesTxId)
+	if tx.IsNil() || tx.GetBlockHash() != testFixturesBlockHash {
+		t.Fatal("the transactions of the block were not cached")
+	}
+
+	// the previous transaction is cached when the previous outputs are set, and the cached transaction is not changed
+	checkTestFixturesTx(t, c, "")
+	previousTx := c.getCachedTx(testFixturesPreviousTxId)
+	if previousTx.IsNil() {
+		t.Error("the previous transaction was not cached")
+	}
+	cachedInput := tx.GetInput(0)
+	cachedPreviousOutput := cachedInput.GetPreviousOutput()
+	if cachedPreviousOutput.GetValue() != 0 {
+		t.Error("the previous output was set in the cached transaction")
+	}
+}
+
+// the transaction and its previous outputs are read from the disk cache, without the node
+func TestCacheReplayDisk(t *testing.T) {
+
+	dc, err := openDiskCache(filepath.Join(t.TempDir(), "disk-cache.db"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dc.db.Close() })
+
+	c := newReplayTestCache(t, testFixturesDir)
+	c.disk = dc
+	checkTestFixturesTx(t, c, testFixturesBlockHash)
+
+	// the disk cache is written in the background
+	for wait := 0; dc.getTx(testFixturesTxId) == nil || len(dc.getPreviousOutputs([]string{testFixturesTxId})) == 0; wait++ {
+		if wait == 100 {
+			t.Fatal("the transaction was not written to the disk cache")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a node with no recorded responses
+	emptyDir := t.TempDir()
+	nodeJson, err := os.ReadFile(filepath.Join(testFixturesDir, FIXTURES_NODE_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(emptyDir, FIXTURES_NODE_FILE), nodeJson, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = newReplayTestCache(t, emptyDir)
+	c.disk = dc
+	checkTestFixturesTx(t, c, "")
+}
@@ -0,0 +1,233 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tracks the tip of the active chain so that cached blocks replaced by a reorg can be found
+// whenever a new tip is seen, the new chain is followed back through the previous block hashes until it meets the
+// previous tip or a cached block at the same height with the same hash, and every cached block above that point
+// that is not in the new chain is orphaned
+// the first tip has no previous tip, so the cached blocks near it are compared to the blocks of the active chain instead,
+// since blocks cached on disk might have been replaced by a reorg while the tool was not running
+
+// the tip is checked at most this often when blocks are served from the cache
+const CHAIN_TIP_CHECK_INTERVAL = 10 * time.Second
+
+// reorgs deeper than this are not followed back to the fork point
+const CHAIN_MAX_REORG_DEPTH = 100
+
+type chainTip struct {
+	mutex       sync.Mutex
+	hash        string
+	height      uint32
+	lastChecked time.Time
+}
+
+// checks the tip if it has not been checked recently
+// the check time is set before the node is asked for the tip, so that concurrent requests do not all ask for it
+func (c *btcCache) checkChainTip(ctx context.Context) {
+
+	c.chain.mutex.Lock()
+	checkNow := time.Since(c.chain.lastChecked) >= CHAIN_TIP_CHECK_INTERVAL
+	if checkNow {
+		c.chain.lastChecked = time.Now()
+	}
+	c.chain.mutex.Unlock()
+	if !checkNow {
+		return
+	}
+
+	tipHash, err := c.btcNode.getBestBlockHash(ctx)
+	if err != nil {
+		LogError(err)
+		return
+	}
+	c.updateChainTip(ctx, tipHash)
+}
+
+// returns the hashes of the blocks cached at this height in memory and on disk, which are different only after a reorg
+func (c *btcCache) getCachedBlockHashes(blockHeight uint32) []string {
+	blockHashes := make([]string, 0, 2)
+	if c.memory != nil {
+		blockHash := c.memory.getBlockHash(blockHeight)
+		if len(blockHash) > 0 {
+			blockHashes = append(blockHashes, blockHash)
+		}
+	}
+	if c.disk != nil {
+		blockHash := c.disk.getBlockHash(blockHeight)
+		if len(blockHash) > 0 && (len(blockHashes) == 0 || blockHashes[0] != blockHash) {
+			blockHashes = append(blockHashes, blockHash)
+		}
+	}
+	return blockHashes
+}
+
+func (c *btcCache) updateChainTip(ctx context.Context, tipHash string) {
+
+	// only one update at a time, so that each new tip is compared to the one before it
+	c.chain.mutex.Lock()
+	defer c.chain.mutex.Unlock()
+
+	c.chain.lastChecked = time.Now()
+	if tipHash == c.chain.hash {
+		return
+	}
+	if len(c.chain.hash) == 0 {
+		c.checkCachedBlocks(ctx, tipHash)
+		return
+	}
+
+	previousTipHash := c.chain.hash
+	previousTipHeight := c.chain.height
+
+	// follow the new chain back to the fork point
+	chainHashes := make(map[uint32]string)
+	blockHash := tipHash
+	tipHeight := uint32(0)
+	forkHeight := int64(-1)
+	for depth := 0; depth <= CHAIN_MAX_REORG_DEPTH; depth++ {
+
+		rawBlock, err := c.btcNode.getBlock(ctx, blockHash, false)
+		if err != nil {
+			LogError(err)
+			return
+		}
+		blockHeight := rawBlock.Height
+		if depth == 0 {
+			tipHeight = blockHeight
+		}
+		chainHashes[blockHeight] = blockHash
+
+		if blockHeight <= previousTipHeight {
+			previousChainHashes := c.getCachedBlockHashes(blockHeight)
+			if blockHeight == previousTipHeight {
+				previousChainHashes = []string{previousTipHash}
+			}
+			if len(previousChainHashes) > 0 && previousChainHashes[0] == blockHash {
+				forkHeight = int64(blockHeight)
+				break
+			}
+		}
+
+		if len(rawBlock.PreviousBlockHash) == 0 {
+			forkHeight = int64(blockHeight)
+			break
+		}
+		blockHash = rawBlock.PreviousBlockHash
+	}
+
+	// without the fork point, only the heights that were followed are checked
+	lowestHeight := int64(tipHeight) - int64(len(chainHashes)) + 1
+	if forkHeight < 0 {
+		fmt.Println(fmt.Sprintf("The chain was not followed back to the previous tip, cached blocks more than %d blocks below the tip were not checked for a reorg.", CHAIN_MAX_REORG_DEPTH))
+	} else {
+		lowestHeight = forkHeight + 1
+	}
+
+	c.chain.hash = tipHash
+	c.chain.height = tipHeight
+
+	// cached blocks at the heights the new chain replaced are orphaned, as are cached blocks above the new tip
+	topHeight := previousTipHeight
+	if tipHeight > topHeight {
+		topHeight = tipHeight
+	}
+	for height := lowestHeight; height <= int64(topHeight); height++ {
+		for _, cachedBlockHash := range c.getCachedBlockHashes(uint32(height)) {
+			if cachedBlockHash != chainHashes[uint32(height)] {
+				c.orphanBlock(cachedBlockHash)
+			}
+		}
+	}
+
+	// the block at the fork point now has a different next block, so it is requested again the next time it is needed
+	if forkHeight >= 0 {
+		c.refreshNextBlockHash(uint32(forkHeight), chainHashes[uint32(forkHeight)+1])
+	}
+}
+
+// sets the first tip after orphaning the cached blocks within CHAIN_MAX_REORG_DEPTH of it that are not in the active chain
+// the tip is not set if the check fails, so that it is checked again with the next tip
+func (c *btcCache) checkCachedBlocks(ctx context.Context, tipHash string) {
+
+	rawBlock, err := c.btcNode.getBlock(ctx, tipHash, false)
+	if err != nil {
+		LogError(err)
+		return
+	}
+	tipHeight := rawBlock.Height
+
+	lowestHeight := uint32(0)
+	if tipHeight > CHAIN_MAX_REORG_DEPTH {
+		lowestHeight = tipHeight - CHAIN_MAX_REORG_DEPTH
+	}
+	for height := lowestHeight; height <= tipHeight; height++ {
+		cachedBlockHashes := c.getCachedBlockHashes(height)
+		if len(cachedBlockHashes) == 0 {
+			continue
+		}
+
+		chainHash, err := c.btcNode.getBlockHash(ctx, height)
+		if err != nil {
+			LogError(err)
+			return
+		}
+		for _, cachedBlockHash := range cachedBlockHashes {
+			if cachedBlockHash != chainHash {
+				c.orphanBlock(cachedBlockHash)
+				if height > 0 {
+					c.refreshNextBlockHash(height-1, chainHash)
+				}
+			}
+		}
+	}
+
+	// cached blocks above the tip are no longer in the active chain, and the cached tip can not have a next block
+	for height := tipHeight + 1; ; height++ {
+		cachedBlockHashes := c.getCachedBlockHashes(height)
+		if len(cachedBlockHashes) == 0 {
+			break
+		}
+		for _, cachedBlockHash := range cachedBlockHashes {
+			c.orphanBlock(cachedBlockHash)
+		}
+	}
+	c.refreshNextBlockHash(tipHeight, "")
+
+	c.chain.hash = tipHash
+	c.chain.height = tipHeight
+}
+
+// the orphaned block stays in the memory cache, where it can still be found by its hash,
+// but its transactions are removed because their block data will change
+func (c *btcCache) orphanBlock(blockHash string) {
+	if c.memory != nil {
+		c.memory.orphanBlock(blockHash)
+	}
+	if c.disk != nil {
+		c.disk.removeBlock(blockHash)
+	}
+}
+
+func (c *btcCache) refreshNextBlockHash(blockHeight uint32, nextBlockHash string) {
+	if c.memory != nil {
+		block := c.memory.getBlockAtHeight(blockHeight)
+		if !block.IsNil() && block.GetNextHash() != nextBlockHash {
+			c.memory.removeBlock(block.GetHash(), false)
+		}
+	}
+	if c.disk != nil {
+		blockHash := c.disk.getBlockHash(blockHeight)
+		if len(blockHash) > 0 {
+			rawBlock := c.disk.getBlock(blockHash)
+			if rawBlock != nil && rawBlock.NextBlockHash != nextBlockHash {
+				c.disk.removeBlock(blockHash)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a block that was replaced by a reorg, at the same height as a block of the zmq test chain
+func getStaleTestBlockHash(height uint32) string {
+	return fmt.Sprintf("%064x", 0x2000+height)
+}
+
+func putTestBlock(c *btcCache, height uint32, hash string, nextHash string) {
+	c.memory.putBlock(makeBlock(&nodeBlock{Hash: hash, Height: height, PreviousBlockHash: getZmqTestBlockHash(height - 1), NextBlockHash: nextHash}))
+}
+
+// blocks cached before the first tip is seen are checked against the active chain
+func TestFirstChainTip(t *testing.T) {
+
+	btcNode := &zmqTestNode{tipHeight: 110}
+	c := &btcCache{btcNode: newLimitedNode(btcNode, 1, 0), memory: newMemoryCache(1 << 20), chain: &chainTip{}}
+
+	putTestBlock(c, 5, getZmqTestBlockHash(5), getZmqTestBlockHash(6))
+	putTestBlock(c, 105, getZmqTestBlockHash(105), getZmqTestBlockHash(106))
+	putTestBlock(c, 107, getZmqTestBlockHash(107), getStaleTestBlockHash(108))
+	putTestBlock(c, 108, getStaleTestBlockHash(108), "")
+	putTestBlock(c, 110, getZmqTestBlockHash(110), getStaleTestBlockHash(111))
+	putTestBlock(c, 111, getStaleTestBlockHash(111), "")
+
+	c.updateChainTip(context.Background(), getZmqTestBlockHash(110))
+
+	if c.chain.hash != getZmqTestBlockHash(110) || c.chain.height != 110 {
+		t.Errorf("the tip is %s %d, expected %s 110", c.chain.hash, c.chain.height, getZmqTestBlockHash(110))
+	}
+
+	// blocks in the active chain are kept, unless their next block was replaced
+	expected := map[uint32]string{5: getZmqTestBlockHash(5), 105: getZmqTestBlockHash(105), 107: "", 108: "", 110: "", 111: ""}
+	for height, blockHash := range expected {
+		if c.memory.getBlockHash(height) != blockHash {
+			t.Errorf("the cached block at height %d is %s, expected %s", height, c.memory.getBlockHash(height), blockHash)
+		}
+	}
+
+	// a stale block can still be found by its hash, but it is orphaned
+	staleBlock := c.memory.getBlock(getStaleTestBlockHash(108))
+	if staleBlock.IsNil() || !staleBlock.IsOrphaned() {
+		t.Error("the stale block was not orphaned")
+	}
+}
+
+// the zmq test chain up to the active tip, and a competing branch of stale blocks from the fork height
+type reorgTestNode struct {
+	nodeClient // only the tip and block requests are implemented
+
+	mutex          sync.Mutex
+	tipHash        string
+	forkHeight     uint32
+	bestBlockCalls int
+}
+
+func (rtn *reorgTestNode) setTip(tipHash string) {
+	rtn.mutex.Lock()
+	defer rtn.mutex.Unlock()
+	rtn.tipHash = tipHash
+}
+
+// the tip takes a while to return, so that concurrent checks overlap
+func (rtn *reorgTestNode) getBestBlockHash(ctx context.Context) (string, error) {
+	rtn.mutex.Lock()
+	defer rtn.mutex.Unlock()
+	rtn.bestBlockCalls++
+	time.Sleep(10 * time.Millisecond)
+	return rtn.tipHash, nil
+}
+
+func (rtn *reorgTestNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	var id uint32
+	_, err := fmt.Sscanf(blockHash, "%x", &id)
+	if err != nil || id < 0x1000 {
+		return nil, newNotFoundError("Block " + blockHash + " not found.")
+	}
+
+	height := id & 0xfff
+	previousHash := getZmqTestBlockHash(height - 1)
+	if id >= 0x2000 && height-1 > rtn.forkHeight {
+		previousHash = getStaleTestBlockHash(height - 1)
+	}
+	return &nodeBlock{Hash: blockHash, Height: height, PreviousBlockHash: previousHash}, nil
+}
+
+// when the tip moves to a competing branch, the cached blocks the branch replaced are orphaned
+func TestChainTipReorg(t *testing.T) {
+
+	btcNode := &reorgTestNode{tipHash: getZmqTestBlockHash(105), forkHeight: 102}
+	c := &btcCache{btcNode: newLimitedNode(btcNode, 0, 0), memory: newMemoryCache(1 << 20),
+		chain: &chainTip{hash: getZmqTestBlockHash(105), height: 105}}
+
+	for height := uint32(101); height <= 105; height++ {
+		nextHash := getZmqTestBlockHash(height + 1)
+		if height == 105 {
+			nextHash = ""
+		}
+		putTestBlock(c, height, getZmqTestBlockHash(height), nextHash)
+	}
+
+	// the branch replaces blocks 103 to 105 and is one block longer
+	btcNode.setTip(getStaleTestBlockHash(106))
+	var wg sync.WaitGroup
+	for r := 0; r < 10; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.checkChainTip(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if btcNode.bestBlockCalls != 1 {
+		t.Errorf("concurrent checks asked for the tip %d times, expected 1", btcNode.bestBlockCalls)
+	}
+	if c.chain.hash != getStaleTestBlockHash(106) || c.chain.height != 106 {
+		t.Errorf("the tip is %s %d, expected %s 106", c.chain.hash, c.chain.height, getStaleTestBlockHash(106))
+	}
+
+	// block 101 is kept, block 102 is removed because its next block changed
+	expected := map[uint32]string{101: getZmqTestBlockHash(101), 102: "", 103: "", 104: "", 105: ""}
+	for height, blockHash := range expected {
+		if c.memory.getBlockHash(height) != blockHash {
+			t.Errorf("the cached block at height %d is %s, expected %s", height, c.memory.getBlockHash(height), blockHash)
+		}
+	}
+	for height := uint32(103); height <= 105; height++ {
+		replacedBlock := c.memory.getBlock(getZmqTestBlockHash(height))
+		if replacedBlock.IsNil() || !replacedBlock.IsOrphaned() {
+			t.Errorf("block %d of the previous chain was not orphaned", height)
+		}
+	}
+
+	// the tip is not asked for again until the check interval has passed
+	c.checkChainTip(context.Background())
+	if btcNode.bestBlockCalls != 1 {
+		t.Errorf("the tip was asked for %d times within the check interval", btcNode.bestBlockCalls)
+	}
+}
@@ -0,0 +1,534 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// the disk cache keeps transactions, the transaction lists of blocks and the previous outputs of transactions between runs
+// everything is stored in a single bbolt database file
+// transactions and blocks are stored in the same structures the node returns, so they are parsed again when they are read
+
+// increment whenever the stored data or the way it is parsed changes, everything stored by another version is discarded
+const DISK_CACHE_VERSION = 1
+
+// buckets
+const DISK_CACHE_BUCKET_Meta = "meta"
+const DISK_CACHE_BUCKET_Txs = "txs"
+const DISK_CACHE_BUCKET_Blocks = "blocks"
+const DISK_CACHE_BUCKET_Heights = "heights"
+const DISK_CACHE_BUCKET_PreviousOutputs = "prevouts"
+const DISK_CACHE_BUCKET_Order = "order" // the order entries were stored in, which is the order they are removed in
+
+// meta keys
+const DISK_CACHE_KEY_Version = "version"
+const DISK_CACHE_KEY_Size = "size"
+
+// when the cache is full, the oldest entries are removed until it is this fraction of the maximum size
+const DISK_CACHE_EVICT_TARGET_RATIO = 0.9
+
+// the file is compacted when it is opened if it is at least this large and more than half of it is unused
+const DISK_CACHE_COMPACT_MIN_FILE_SIZE = 16 * 1024 * 1024
+
+const DISK_CACHE_WRITE_QUEUE_SIZE = 100
+
+type diskCacheEntry struct {
+	bucket string
+	key    []byte
+	value  []byte
+}
+
+// the previous output of an input, inputs without a previous output (coinbase inputs) have an empty script
+type diskCacheOutput struct {
+	Value   uint64 `json:"value"`
+	Script  string `json:"script"`
+	Address string `json:"address,omitempty"`
+}
+
+type diskCache struct {
+	db       *bolt.DB
+	fileName string
+	maxSize  uint64
+
+	// entries are written by a single thread, several sets of entries in each database transaction
+	writes chan []diskCacheEntry
+}
+
+func openDiskCache(fileName string, maxSize uint64) (*diskCache, error) {
+
+	dc := diskCache{fileName: fileName, maxSize: maxSize, writes: make(chan []diskCacheEntry, DISK_CACHE_WRITE_QUEUE_SIZE)}
+
+	err := dc.open()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dc.checkVersion()
+	if err != nil {
+		dc.db.Close()
+		return nil, err
+	}
+
+	err = dc.compact()
+	if err != nil {
+		fmt.Println("DISK CACHE ERROR: compaction failed: " + err.Error())
+	}
+
+	go dc.run()
+
+	return &dc, nil
+}
+
+func (dc *diskCache) open() error {
+	db, err := bolt.Open(dc.fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return errors.New("DISK CACHE ERROR: " + dc.fileName + ": " + err.Error())
+	}
+	dc.db = db
+	return nil
+}
+
+// discards everything if the cache was written by a different version, or creates the buckets for a new cache
+func (dc *diskCache) checkVersion() error {
+
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, DISK_CACHE_VERSION)
+
+	return dc.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(DISK_CACHE_BUCKET_Meta))
+		if meta != nil && bytes.Equal(meta.Get([]byte(DISK_CACHE_KEY_Version)), version) {
+			return nil
+		}
+
+		if meta != nil {
+			fmt.Println("The disk cache was written by a different version of the scantool and has been cleared.")
+		}
+
+		for _, bucket := range []string{DISK_CACHE_BUCKET_Meta, DISK_CACHE_BUCKET_Txs, DISK_CACHE_BUCKET_Blocks, DISK_CACHE_BUCKET_Heights, DISK_CACHE_BUCKET_PreviousOutputs, DISK_CACHE_BUCKET_Order} {
+			err := tx.DeleteBucket([]byte(bucket))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(DISK_CACHE_BUCKET_Meta)).Put([]byte(DISK_CACHE_KEY_Version), version)
+	})
+}
+
+// the database file never shrinks when entries are removed, so it is copied to a new file when much of it is unused
+func (dc *diskCache) compact() error {
+
+	fileInfo, err := os.Stat(dc.fileName)
+	if err != nil {
+		return err
+	}
+
+	usedSize := dc.getSize()
+	if fileInfo.Size() < DISK_CACHE_COMPACT_MIN_FILE_SIZE || uint64(fileInfo.Size()) < usedSize*2 {
+		return nil
+	}
+
+	compactFileName := dc.fileName + ".compact"
+	os.Remove(compactFileName)
+	compactDb, err := bolt.Open(compactFileName, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = bolt.Compact(compactDb, dc.db, 64*1024*1024)
+	compactDb.Close()
+	if err != nil {
+		os.Remove(compactFileName)
+		return err
+	}
+
+	dc.db.Close()
+	err = os.Rename(compactFileName, dc.fileName)
+	if err != nil {
+		os.Remove(compactFileName)
+	}
+
+	openErr := dc.open()
+	if openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// the size of the stored entries, which is less than the size of the file
+func (dc *diskCache) getSize() uint64 {
+	size := uint64(0)
+	dc.db.View(func(tx *bolt.Tx) error {
+		size = getDiskCacheSize(tx)
+		return nil
+	})
+	return size
+}
+
+func getDiskCacheSize(tx *bolt.Tx) uint64 {
+	sizeBytes := tx.Bucket([]byte(DISK_CACHE_BUCKET_Meta)).Get([]byte(DISK_CACHE_KEY_Size))
+	if len(sizeBytes) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(sizeBytes)
+}
+
+func setDiskCacheSize(tx *bolt.Tx, size uint64) error {
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, size)
+	return tx.Bucket([]byte(DISK_CACHE_BUCKET_Meta)).Put([]byte(DISK_CACHE_KEY_Size), sizeBytes)
+}
+
+// the tracked size is an estimate, so it is never allowed to wrap around
+func subtractDiskCacheSize(size uint64, removedSize uint64) uint64 {
+	if removedSize > size {
+		return 0
+	}
+	return size - removedSize
+}
+
+func getHeightKey(blockHeight uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, blockHeight)
+	return key
+}
+
+// reading
+
+// returns nil if the transaction is not stored
+func (dc *diskCache) getTx(txId string) *nodeTx {
+	return dc.getTxs([]string{txId})[txId]
+}
+
+// returns the transactions that are stored
+func (dc *diskCache) getTxs(txIds []string) map[string]*nodeTx {
+
+	rawTxs := make(map[string]*nodeTx)
+	dc.db.View(func(tx *bolt.Tx) error {
+		txBucket := tx.Bucket([]byte(DISK_CACHE_BUCKET_Txs))
+		for _, txId := range txIds {
+			storedTx := txBucket.Get([]byte(txId))
+			if storedTx == nil {
+				continue
+			}
+
+			var rawTx nodeTx
+			if json.Unmarshal(storedTx, &rawTx) == nil {
+				rawTxs[txId] = &rawTx
+			}
+		}
+		return nil
+	})
+
+	return rawTxs
+}
+
+// returns the block in the structure returned by the node without transaction data, or nil if the block is not stored
+func (dc *diskCache) getBlock(blockHash string) *nodeBlock {
+
+	var rawBlock *nodeBlock
+	dc.db.View(func(tx *bolt.Tx) error {
+		storedBlock := tx.Bucket([]byte(DISK_CACHE_BUCKET_Blocks)).Get([]byte(blockHash))
+		if storedBlock != nil {
+			var block nodeBlock
+			if json.Unmarshal(storedBlock, &block) == nil {
+				rawBlock = &block
+			}
+		}
+		return nil
+	})
+
+	return rawBlock
+}
+
+// returns an empty string if no block at this height is stored
+func (dc *diskCache) getBlockHash(blockHeight uint32) string {
+
+	blockHash := ""
+	dc.db.View(func(tx *bolt.Tx) error {
+		blockHash = string(tx.Bucket([]byte(DISK_CACHE_BUCKET_Heights)).Get(getHeightKey(blockHeight)))
+		return nil
+	})
+
+	return blockHash
+}
+
+// returns the previous outputs of every input of the transactions that are stored
+func (dc *diskCache) getPreviousOutputs(txIds []string) map[string][]btc.Output {
+
+	previousOutputs := make(map[string][]btc.Output)
+	dc.db.View(func(tx *bolt.Tx) error {
+		previousOutputBucket := tx.Bucket([]byte(DISK_CACHE_BUCKET_PreviousOutputs))
+		for _, txId := range txIds {
+			storedOutputs := previousOutputBucket.Get([]byte(txId))
+			if storedOutputs == nil {
+				continue
+			}
+
+			var outputs []diskCacheOutput
+			if json.Unmarshal(storedOutputs, &outputs) != nil {
+				continue
+			}
+
+			previousOutputs[txId] = make([]btc.Output, len(outputs))
+			for o, output := range outputs {
+				if len(output.Script) == 0 {
+					continue
+				}
+				scriptBytes, _ := hex.DecodeString(output.Script)
+				previousOutputs[txId][o] = btc.NewOutput(output.Value, btc.NewScript(scriptBytes), output.Address)
+			}
+		}
+		return nil
+	})
+
+	return previousOutputs
+}
+
+// writing
+// entries are written in the background, and are dropped if the write queue is full
+
+// the block is stored without transaction data, and the transactions are stored separately
+// the raw block might be shared with other requests for the same block, so it is not modified
+func (dc *diskCache) putBlock(rawBlock *nodeBlock) {
+
+	if len(rawBlock.Hash) == 0 || !rawBlock.hasTxData() {
+		return
+	}
+
+	rawTxs := rawBlock.getTxs()
+	entries := make([]diskCacheEntry, 0, len(rawTxs)+2)
+
+	for _, rawTx := range rawTxs {
+		rawTx.BlockHash = rawBlock.Hash
+		rawTx.BlockTime = rawBlock.Time
+
+		txJson, err := json.Marshal(&rawTx)
+		if err != nil {
+			return
+		}
+		entries = append(entries, diskCacheEntry{bucket: DISK_CACHE_BUCKET_Txs, key: []byte(rawTx.TxId), value: txJson})
+	}
+
+	blockJson, err := json.Marshal(rawBlock.withoutTxData())
+	if err != nil {
+		return
+	}
+	entries = append(entries, diskCacheEntry{bucket: DISK_CACHE_BUCKET_Blocks, key: []byte(rawBlock.Hash), value: blockJson})
+	entries = append(entries, diskCacheEntry{bucket: DISK_CACHE_BUCKET_Heights, key: getHeightKey(rawBlock.Height), value: []byte(rawBlock.Hash)})
+
+	dc.queue(entries)
+}
+
+// only confirmed transactions are stored, because the block data of unconfirmed transactions will change
+func (dc *diskCache) putTxs(rawTxs []*nodeTx) {
+
+	entries := make([]diskCacheEntry, 0, len(rawTxs))
+	for _, rawTx := range rawTxs {
+		if len(rawTx.TxId) == 0 || len(rawTx.BlockHash) == 0 {
+			continue
+		}
+
+		txJson, err := json.Marshal(rawTx)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, diskCacheEntry{bucket: DISK_CACHE_BUCKET_Txs, key: []byte(rawTx.TxId), value: txJson})
+	}
+
+	if len(entries) > 0 {
+		dc.queue(entries)
+	}
+}
+
+// previous outputs never change, so they are stored for unconfirmed transactions too
+func (dc *diskCache) putPreviousOutputs(txs []btc.Tx) {
+
+	entries := make([]diskCacheEntry, 0, len(txs))
+	for _, tx := range txs {
+		outputs := make([]diskCacheOutput, tx.GetInputCount())
+		for i, input := range tx.GetInputs() {
+			if input.IsCoinbase() {
+				continue
+			}
+
+			previousOutput := input.GetPreviousOutput()
+			outputScript := previousOutput.GetOutputScript()
+			outputs[i] = diskCacheOutput{Value: previousOutput.GetValue(), Script: outputScript.AsHex(), Address: previousOutput.GetAddress()}
+		}
+
+		outputsJson, err := json.Marshal(outputs)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, diskCacheEntry{bucket: DISK_CACHE_BUCKET_PreviousOutputs, key: []byte(tx.GetTxId()), value: outputsJson})
+	}
+
+	if len(entries) > 0 {
+		dc.queue(entries)
+	}
+}
+
+func (dc *diskCache) queue(entries []diskCacheEntry) {
+	select {
+	case dc.writes <- entries:
+	default:
+	}
+}
+
+// removes a block and its transactions, which is done immediately rather than in the background
+func (dc *diskCache) removeBlock(blockHash string) {
+
+	err := dc.db.Update(func(tx *bolt.Tx) error {
+		size := getDiskCacheSize(tx)
+		size = subtractDiskCacheSize(size, removeDiskCacheEntry(tx, DISK_CACHE_BUCKET_Blocks, []byte(blockHash)))
+		return setDiskCacheSize(tx, size)
+	})
+	if err != nil {
+		fmt.Println("DISK CACHE ERROR: " + err.Error())
+	}
+}
+
+func (dc *diskCache) run() {
+
+	for entries := range dc.writes {
+
+		// everything else that is waiting is written in the same transaction
+		entrySets := [][]diskCacheEntry{entries}
+	collect:
+		for len(entrySets) < DISK_CACHE_WRITE_QUEUE_SIZE {
+			select {
+			case moreEntries := <-dc.writes:
+				entrySets = append(entrySets, moreEntries)
+			default:
+				break collect
+			}
+		}
+
+		err := dc.db.Update(func(tx *bolt.Tx) error {
+			size := getDiskCacheSize(tx)
+			orderBucket := tx.Bucket([]byte(DISK_CACHE_BUCKET_Order))
+
+			for _, entrySet := range entrySets {
+				for _, entry := range entrySet {
+					bucket := tx.Bucket([]byte(entry.bucket))
+					previousValue := bucket.Get(entry.key)
+
+					err := bucket.Put(entry.key, entry.value)
+					if err != nil {
+						return err
+					}
+
+					// heights are removed with their blocks, so they are not in the order
+					if entry.bucket == DISK_CACHE_BUCKET_Heights {
+						continue
+					}
+
+					if previousValue != nil {
+						size = subtractDiskCacheSize(size, uint64(len(previousValue)))
+						size += uint64(len(entry.value))
+						continue
+					}
+
+					sequence, err := orderBucket.NextSequence()
+					if err != nil {
+						return err
+					}
+					orderKey := make([]byte, 8)
+					binary.BigEndian.PutUint64(orderKey, sequence)
+					orderValue := append([]byte(entry.bucket+"/"), entry.key...)
+
+					err = orderBucket.Put(orderKey, orderValue)
+					if err != nil {
+						return err
+					}
+					size += uint64(len(entry.key)+len(entry.value)) + uint64(len(orderKey)+len(orderValue))
+				}
+			}
+
+			if size > dc.maxSize {
+				size = evictDiskCacheEntries(tx, size, uint64(float64(dc.maxSize)*DISK_CACHE_EVICT_TARGET_RATIO))
+			}
+
+			return setDiskCacheSize(tx, size)
+		})
+
+		if err != nil {
+			fmt.Println("DISK CACHE ERROR: " + err.Error())
+		}
+	}
+}
+
+// removes the oldest entries until the size is at most the target size, and returns the new size
+func evictDiskCacheEntries(tx *bolt.Tx, size uint64, targetSize uint64) uint64 {
+
+	orderBucket := tx.Bucket([]byte(DISK_CACHE_BUCKET_Order))
+	for size > targetSize {
+		// a new cursor is used each time because deleting moves the cursor
+		orderKey, orderValue := orderBucket.Cursor().First()
+		if orderKey == nil {
+			return 0
+		}
+		orderKey = append([]byte{}, orderKey...)
+		orderValue = append([]byte{}, orderValue...)
+
+		// the entry might already have been removed with its block
+		bucketName, key, found := bytes.Cut(orderValue, []byte("/"))
+		if found {
+			size = subtractDiskCacheSize(size, removeDiskCacheEntry(tx, string(bucketName), key))
+		}
+
+		orderBucket.Delete(orderKey)
+		size = subtractDiskCacheSize(size, uint64(len(orderKey)+len(orderValue)))
+	}
+
+	return size
+}
+
+// returns the size of the removed entry
+// removing a block also removes its height and its transactions
+func removeDiskCacheEntry(tx *bolt.Tx, bucketName string, key []byte) uint64 {
+
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return 0
+	}
+	value := bucket.Get(key)
+	if value == nil {
+		return 0
+	}
+
+	removedSize := uint64(len(key) + len(value))
+
+	if bucketName == DISK_CACHE_BUCKET_Blocks {
+		var storedBlock nodeBlock
+		if json.Unmarshal(value, &storedBlock) == nil {
+			heightBucket := tx.Bucket([]byte(DISK_CACHE_BUCKET_Heights))
+			heightKey := getHeightKey(storedBlock.Height)
+			if bytes.Equal(heightBucket.Get(heightKey), key) {
+				heightBucket.Delete(heightKey)
+			}
+
+			for _, txId := range storedBlock.getTxIds() {
+				removedSize += removeDiskCacheEntry(tx, DISK_CACHE_BUCKET_Txs, []byte(txId))
+			}
+		}
+	}
+
+	bucket.Delete(key)
+	return removedSize
+}
@@ -0,0 +1,88 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// error types
+const ERROR_TYPE_NotFound = "not_found"
+const ERROR_TYPE_Unavailable = "unavailable"
+const ERROR_TYPE_Unauthorized = "unauthorized"
+const ERROR_TYPE_Decode = "decode"
+
+// NodeError is returned by the node proxy so that callers can tell why a request failed
+// errors of any other type are errors reported by the node that do not fit one of the error types
+type NodeError struct {
+	errorType string
+	message   string
+	cause     error
+}
+
+func newNodeError(errorType string, message string, cause error) *NodeError {
+	return &NodeError{errorType: errorType, message: message, cause: cause}
+}
+
+func newNotFoundError(message string) *NodeError {
+	return newNodeError(ERROR_TYPE_NotFound, message, nil)
+}
+
+func newUnavailableError(message string, cause error) *NodeError {
+	return newNodeError(ERROR_TYPE_Unavailable, message, cause)
+}
+
+func newUnauthorizedError(message string, cause error) *NodeError {
+	return newNodeError(ERROR_TYPE_Unauthorized, message, cause)
+}
+
+func newDecodeError(message string, cause error) *NodeError {
+	return newNodeError(ERROR_TYPE_Decode, message, cause)
+}
+
+func (ne *NodeError) Error() string {
+	if ne.cause == nil {
+		return ne.message
+	}
+	return ne.message + " " + ne.cause.Error()
+}
+
+func (ne *NodeError) Unwrap() error {
+	return ne.cause
+}
+
+func (ne *NodeError) GetType() string {
+	return ne.errorType
+}
+
+func (ne *NodeError) GetMessage() string {
+	return ne.message
+}
+
+// returns an empty string if the error is not a NodeError
+func GetErrorType(err error) string {
+	var nodeError *NodeError
+	if errors.As(err, &nodeError) {
+		return nodeError.errorType
+	}
+	return ""
+}
+
+func IsNotFound(err error) bool {
+	return GetErrorType(err) == ERROR_TYPE_NotFound
+}
+
+// prints node errors in the same format wherever they are handled
+// not found errors are expected, since the web interface tries every search as both a transaction and a block,
+// and errors caused by a client disconnecting are not errors at all, so neither is printed
+func LogError(err error) {
+	if err == nil || IsNotFound(err) || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	errorType := GetErrorType(err)
+	if len(errorType) == 0 {
+		errorType = "error"
+	}
+	fmt.Println(fmt.Sprintf("NODE ERROR (%s): %s", errorType, err.Error()))
+}
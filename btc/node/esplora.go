@@ -0,0 +1,299 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// Esplora and Electrs do not report a version, so the version string only indicates which API is being used
+const ESPLORA_VERSION_STR = "API"
+
+// the number of transactions returned by each request for the transactions in a block
+const ESPLORA_TX_PAGE_SIZE = 25
+
+type Esplora struct {
+	version string
+	url     string
+}
+
+func NewEsplora(url string) (*Esplora, error) {
+
+	e := Esplora{url: strings.TrimSuffix(url, "/")}
+	e.version = e.getVersionStr()
+	if len(e.version) == 0 {
+		return nil, errors.New("Failed to connect to Esplora server.")
+	}
+	return &e, nil
+}
+
+func (e *Esplora) getNodeType() string {
+	return app.NODE_TYPE_Esplora
+}
+
+func (e *Esplora) GetVersionString() string {
+	return e.getNodeType() + " " + e.version
+}
+
+func (e *Esplora) getVersionStr() string {
+	_, err := e.getBestBlockHash(context.Background())
+	if err != nil {
+		fmt.Println(err.Error())
+		return ""
+	}
+	return ESPLORA_VERSION_STR
+}
+
+// the structures of Esplora's responses, where values are in satoshis
+
+type esploraBlock struct {
+	Id                string `json:"id"`
+	Height            uint32 `json:"height"`
+	Version           int32  `json:"version"`
+	Timestamp         int64  `json:"timestamp"`
+	TxCount           int    `json:"tx_count"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+type esploraBlockStatus struct {
+	InBestChain bool   `json:"in_best_chain"`
+	NextBest    string `json:"next_best"`
+}
+
+type esploraTx struct {
+	TxId     string           `json:"txid"`
+	Version  int64            `json:"version"`
+	LockTime uint32           `json:"locktime"`
+	Vin      []esploraInput   `json:"vin"`
+	Vout     []esploraOutput  `json:"vout"`
+	Weight   uint32           `json:"weight"`
+	Fee      uint64           `json:"fee"`
+	Status   *esploraTxStatus `json:"status"`
+}
+
+type esploraTxStatus struct {
+	Confirmed bool   `json:"confirmed"`
+	BlockHash string `json:"block_hash"`
+	BlockTime int64  `json:"block_time"`
+}
+
+type esploraInput struct {
+	TxId       string   `json:"txid"`
+	Vout       uint32   `json:"vout"`
+	ScriptSig  string   `json:"scriptsig"`
+	Witness    []string `json:"witness"`
+	IsCoinbase bool     `json:"is_coinbase"`
+	Sequence   uint32   `json:"sequence"`
+}
+
+type esploraOutput struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
+	Value               uint64 `json:"value"`
+}
+
+// API functions
+// the responses are converted to the same structures returned by Bitcoin Core's getblock and getrawtransaction
+
+func (e *Esplora) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+
+	var block esploraBlock
+	err := e.getJson(ctx, "/block/"+blockHash, &block)
+	if err != nil {
+		return nil, err
+	}
+
+	var blockStatus esploraBlockStatus
+	err = e.getJson(ctx, "/block/"+blockHash+"/status", &blockStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	rawBlock := nodeBlock{Hash: block.Id,
+		Height:            block.Height,
+		Version:           block.Version,
+		Time:              block.Timestamp,
+		PreviousBlockHash: block.PreviousBlockHash,
+		NextBlockHash:     blockStatus.NextBest}
+	if !blockStatus.InBestChain {
+		rawBlock.Confirmations = -1
+	}
+
+	if withTxData {
+
+		// the transactions are returned one page at a time
+		rawBlock.Tx.txs = make([]nodeTx, 0, block.TxCount)
+		for startIndex := 0; startIndex < block.TxCount; startIndex += ESPLORA_TX_PAGE_SIZE {
+			var txs []esploraTx
+			err = e.getJson(ctx, fmt.Sprintf("/block/%s/txs/%d", blockHash, startIndex), &txs)
+			if err != nil {
+				return nil, err
+			}
+
+			for t := range txs {
+				rawBlock.Tx.txs = append(rawBlock.Tx.txs, makeRawTxFromEsplora(&txs[t]))
+			}
+		}
+	} else {
+
+		err = e.getJson(ctx, "/block/"+blockHash+"/txids", &rawBlock.Tx.txIds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &rawBlock, nil
+}
+
+func (e *Esplora) getBestBlockHash(ctx context.Context) (string, error) {
+	return e.getText(ctx, "/blocks/tip/hash")
+}
+
+// Esplora only serves the active chain
+func (e *Esplora) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	return nil, newUnavailableError("Chain tips are not available from Esplora.", nil)
+}
+
+func (e *Esplora) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	return e.getText(ctx, fmt.Sprintf("/block-height/%d", blockHeight))
+}
+
+func (e *Esplora) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+
+	var tx esploraTx
+	err := e.getJson(ctx, "/tx/"+txId, &tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTx := makeRawTxFromEsplora(&tx)
+	return &rawTx, nil
+}
+
+// Esplora indexes every transaction, so the block is not needed
+func (e *Esplora) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	return e.getTx(ctx, txId)
+}
+
+func (e *Esplora) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	var txIds []string
+	err := e.getJson(ctx, "/mempool/txids", &txIds)
+	if err != nil {
+		return nil, err
+	}
+	return txIds, nil
+}
+
+// Esplora only reports the fee and size of a mempool transaction
+func (e *Esplora) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+
+	var tx esploraTx
+	err := e.getJson(ctx, "/tx/"+txId, &tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Status == nil || tx.Weight == 0 {
+		return nil, newDecodeError("ESPLORA ERROR: Transaction "+txId+" has no status or weight.", nil)
+	}
+	if tx.Status.Confirmed {
+		return nil, newNotFoundError("Transaction " + txId + " is not in the mempool.")
+	}
+
+	return &nodeMempoolEntry{Weight: tx.Weight,
+		Vsize: (tx.Weight + 3) / 4,
+		Fees:  &nodeMempoolFees{Base: btcAmount(tx.Fee)}}, nil
+}
+
+// converts an Esplora transaction to the structure returned by Bitcoin Core's getrawtransaction
+func makeRawTxFromEsplora(tx *esploraTx) nodeTx {
+
+	rawTx := nodeTx{TxId: tx.TxId, Version: tx.Version, LockTime: tx.LockTime}
+
+	// unconfirmed transactions have no block
+	if tx.Status != nil {
+		rawTx.BlockHash = tx.Status.BlockHash
+		rawTx.BlockTime = tx.Status.BlockTime
+	}
+
+	// inputs
+	rawTx.Vin = make([]nodeInput, len(tx.Vin))
+	for i := range tx.Vin {
+		input := &tx.Vin[i]
+
+		rawInput := &rawTx.Vin[i]
+		rawInput.Sequence = input.Sequence
+		if input.IsCoinbase {
+			rawInput.Coinbase = input.ScriptSig
+		} else {
+			rawInput.TxId = input.TxId
+			rawInput.Vout = input.Vout
+			rawInput.ScriptSig = &nodeScript{Hex: input.ScriptSig}
+		}
+		rawInput.Witness = input.Witness
+	}
+
+	// outputs
+	rawTx.Vout = make([]nodeOutput, len(tx.Vout))
+	for o := range tx.Vout {
+		output := &tx.Vout[o]
+		rawTx.Vout[o] = nodeOutput{Value: btcAmount(output.Value),
+			N:            uint32(o),
+			ScriptPubKey: nodeScript{Hex: output.ScriptPubKey, Address: output.ScriptPubKeyAddress}}
+	}
+
+	return rawTx
+}
+
+func (e *Esplora) getText(ctx context.Context, path string) (string, error) {
+	responseBody, err := e.get(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(responseBody)), nil
+}
+
+func (e *Esplora) getJson(ctx context.Context, path string, response interface{}) error {
+	responseBody, err := e.get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(responseBody, response)
+	if err != nil {
+		return newDecodeError("JSON ERROR:", err)
+	}
+	return nil
+}
+
+func (e *Esplora) get(ctx context.Context, path string) ([]byte, error) {
+
+	requestUrl := e.url + path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, errors.New("ESPLORA ERROR: " + err.Error())
+	}
+	response, err := getHttpClient().Do(request)
+	if err != nil {
+		return nil, newUnavailableError("ESPLORA ERROR:", err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, newUnavailableError("ESPLORA ERROR:", err)
+	}
+
+	// errors are returned as plain text
+	if response.StatusCode != http.StatusOK {
+		return nil, getHttpStatusError("ESPLORA", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return responseBody, nil
+}
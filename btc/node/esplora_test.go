@@ -0,0 +1,228 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the responses of an Esplora server for mainnet block 170, which contains the first transaction that is not a coinbase
+
+const esploraTestBlockHash = "00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee"
+const esploraTestCoinbaseTxId = "b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082"
+const esploraTestTxId = "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16"
+
+const esploraTestCoinbaseTx = `{"txid":"b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082","version":1,"locktime":0,` +
+	`"vin":[{"txid":"0000000000000000000000000000000000000000000000000000000000000000","vout":4294967295,"scriptsig":"04ffff001d0102","is_coinbase":true,"sequence":4294967295}],` +
+	`"vout":[{"scriptpubkey":"4104d46c4968bde02899d2aa0963367c7a6ce34eec332b32e42e5f3407e052d64ac625da6f0718e7b302140434bd725706957c092db53805b821a85b23a7ac61725bac","scriptpubkey_type":"p2pk","value":5000000000}],` +
+	`"size":134,"weight":536,"fee":0,` +
+	`"status":{"confirmed":true,"block_height":170,"block_hash":"00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee","block_time":1231731025}}`
+
+const esploraTestTx = `{"txid":"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16","version":1,"locktime":0,` +
+	`"vin":[{"txid":"0437cd7f8525ceed2324359c2d0ba26006d92d856a9c20fa0241106ee5a597c9","vout":0,"scriptsig":"47304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901","is_coinbase":false,"sequence":4294967295}],` +
+	`"vout":[{"scriptpubkey":"4104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac","scriptpubkey_type":"p2pk","value":1000000000},` +
+	`{"scriptpubkey":"410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac","scriptpubkey_type":"p2pk","value":4000000000}],` +
+	`"size":275,"weight":1100,"fee":0,` +
+	`"status":{"confirmed":true,"block_height":170,"block_hash":"00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee","block_time":1231731025}}`
+
+func getEsploraTestResponses() map[string]string {
+	return map[string]string{
+		"/blocks/tip/hash":  esploraTestBlockHash + "\n",
+		"/block-height/170": esploraTestBlockHash,
+		"/block/" + esploraTestBlockHash: `{"id":"00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee","height":170,"version":1,"timestamp":1231731025,"tx_count":2,` +
+			`"size":490,"weight":1960,"merkle_root":"7dac2c5666815c17a3b36427de37bb9d2e2c5ccec3f8633eb91a4205cb4c10ff",` +
+			`"previousblockhash":"000000002a22cfee1f2c846adbd12b3e183d4f97683f85dad08a79780a84bd55","nonce":1889418792,"bits":486604799}`,
+		"/block/" + esploraTestBlockHash + "/status": `{"in_best_chain":true,"height":170,"next_best":"00000000c9ec538cab7f38ef9c67a95742f56ab07b0a37c5be6b02808dbfb4e0"}`,
+		"/block/" + esploraTestBlockHash + "/txids":  `["` + esploraTestCoinbaseTxId + `","` + esploraTestTxId + `"]`,
+		"/block/" + esploraTestBlockHash + "/txs/0":  "[" + esploraTestCoinbaseTx + "," + esploraTestTx + "]",
+		"/tx/" + esploraTestCoinbaseTxId:             esploraTestCoinbaseTx,
+		"/tx/" + esploraTestTxId:                     esploraTestTx,
+	}
+}
+
+// serves the responses like Esplora does, with errors as plain text
+// every request fails with the status code if it is not zero
+func newEsploraTestServer(t *testing.T, responses map[string]string, statusCode *int) *Esplora {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode != nil && *statusCode != 0 {
+			w.WriteHeader(*statusCode)
+			w.Write([]byte(http.StatusText(*statusCode)))
+			return
+		}
+
+		response, found := responses[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Block not found"))
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	e, err := NewEsplora(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEsploraBestBlockHash(t *testing.T) {
+
+	e := newEsploraTestServer(t, getEsploraTestResponses(), nil)
+
+	blockHash, err := e.getBestBlockHash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockHash != esploraTestBlockHash {
+		t.Errorf("best block hash is %s, expected %s", blockHash, esploraTestBlockHash)
+	}
+
+	blockHash, err = e.getBlockHash(context.Background(), 170)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockHash != esploraTestBlockHash {
+		t.Errorf("block hash at height 170 is %s, expected %s", blockHash, esploraTestBlockHash)
+	}
+}
+
+func TestEsploraGetBlock(t *testing.T) {
+
+	e := newEsploraTestServer(t, getEsploraTestResponses(), nil)
+
+	for _, withTxData := range []bool{false, true} {
+		rawBlock, err := e.getBlock(context.Background(), esploraTestBlockHash, withTxData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rawBlock.Hash != esploraTestBlockHash || rawBlock.Height != 170 || rawBlock.Version != 1 || rawBlock.Time != 1231731025 {
+			t.Errorf("unexpected block header %s %d %d %d", rawBlock.Hash, rawBlock.Height, rawBlock.Version, rawBlock.Time)
+		}
+		if rawBlock.PreviousBlockHash != "000000002a22cfee1f2c846adbd12b3e183d4f97683f85dad08a79780a84bd55" {
+			t.Errorf("unexpected previous block hash %s", rawBlock.PreviousBlockHash)
+		}
+		if rawBlock.NextBlockHash != "00000000c9ec538cab7f38ef9c67a95742f56ab07b0a37c5be6b02808dbfb4e0" {
+			t.Errorf("unexpected next block hash %s", rawBlock.NextBlockHash)
+		}
+		if rawBlock.Confirmations != 0 {
+			t.Errorf("a block in the best chain has %d confirmations, expected 0", rawBlock.Confirmations)
+		}
+
+		txIds := rawBlock.getTxIds()
+		if len(txIds) != 2 || txIds[0] != esploraTestCoinbaseTxId || txIds[1] != esploraTestTxId {
+			t.Errorf("unexpected txids %v", txIds)
+		}
+		if withTxData != (len(rawBlock.getTxs()) == 2) {
+			t.Errorf("block requested with tx data %t has %d txs", withTxData, len(rawBlock.getTxs()))
+		}
+	}
+}
+
+func TestEsploraStaleBlock(t *testing.T) {
+
+	responses := getEsploraTestResponses()
+	responses["/block/"+esploraTestBlockHash+"/status"] = `{"in_best_chain":false}`
+	e := newEsploraTestServer(t, responses, nil)
+
+	rawBlock, err := e.getBlock(context.Background(), esploraTestBlockHash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawBlock.Confirmations != -1 || len(rawBlock.NextBlockHash) != 0 {
+		t.Errorf("stale block has %d confirmations and next block %s", rawBlock.Confirmations, rawBlock.NextBlockHash)
+	}
+}
+
+func TestEsploraGetTx(t *testing.T) {
+
+	e := newEsploraTestServer(t, getEsploraTestResponses(), nil)
+
+	rawTx, err := e.getTx(context.Background(), esploraTestTxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rawTx.TxId != esploraTestTxId || rawTx.Version != 1 || rawTx.LockTime != 0 {
+		t.Errorf("unexpected tx %s %d %d", rawTx.TxId, rawTx.Version, rawTx.LockTime)
+	}
+	if rawTx.BlockHash != esploraTestBlockHash || rawTx.BlockTime != 1231731025 {
+		t.Errorf("unexpected block %s %d", rawTx.BlockHash, rawTx.BlockTime)
+	}
+
+	if len(rawTx.Vin) != 1 {
+		t.Fatalf("tx has %d inputs, expected 1", len(rawTx.Vin))
+	}
+	input := rawTx.Vin[0]
+	if input.TxId != "0437cd7f8525ceed2324359c2d0ba26006d92d856a9c20fa0241106ee5a597c9" || input.Vout != 0 || input.Sequence != 0xffffffff {
+		t.Errorf("unexpected input %s:%d %d", input.TxId, input.Vout, input.Sequence)
+	}
+	if input.ScriptSig == nil || len(input.ScriptSig.Hex) != 144 || len(input.Coinbase) != 0 {
+		t.Errorf("unexpected input script %v coinbase %s", input.ScriptSig, input.Coinbase)
+	}
+
+	// the values are in satoshis, and must not be mistaken for BTC
+	expectedValues := []btcAmount{1000000000, 4000000000}
+	if len(rawTx.Vout) != len(expectedValues) {
+		t.Fatalf("tx has %d outputs, expected %d", len(rawTx.Vout), len(expectedValues))
+	}
+	for o, output := range rawTx.Vout {
+		if output.Value != expectedValues[o] || output.N != uint32(o) {
+			t.Errorf("output %d has value %d and index %d, expected %d", o, output.Value, output.N, expectedValues[o])
+		}
+	}
+
+	tx, err := makeTx(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.GetOutputs()[1].GetValue() != 4000000000 {
+		t.Errorf("decoded output value is %d, expected 4000000000", tx.GetOutputs()[1].GetValue())
+	}
+}
+
+func TestEsploraCoinbaseTx(t *testing.T) {
+
+	e := newEsploraTestServer(t, getEsploraTestResponses(), nil)
+
+	rawTx, err := e.getTxInBlock(context.Background(), esploraTestCoinbaseTxId, esploraTestBlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rawTx.Vin) != 1 || rawTx.Vin[0].Coinbase != "04ffff001d0102" || rawTx.Vin[0].ScriptSig != nil {
+		t.Errorf("unexpected coinbase input %v", rawTx.Vin)
+	}
+	if len(rawTx.Vout) != 1 || rawTx.Vout[0].Value != 5000000000 {
+		t.Errorf("unexpected coinbase outputs %v", rawTx.Vout)
+	}
+}
+
+func TestEsploraErrors(t *testing.T) {
+
+	statusCode := 0
+	e := newEsploraTestServer(t, getEsploraTestResponses(), &statusCode)
+
+	_, err := e.getTx(context.Background(), "0000000000000000000000000000000000000000000000000000000000000001")
+	if !IsNotFound(err) {
+		t.Errorf("404 returned %v, expected a not found error", err)
+	}
+	_, err = e.getBlock(context.Background(), "0000000000000000000000000000000000000000000000000000000000000001", true)
+	if !IsNotFound(err) {
+		t.Errorf("404 returned %v, expected a not found error", err)
+	}
+
+	for _, statusCode = range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		_, err = e.getTx(context.Background(), esploraTestTxId)
+		if GetErrorType(err) != ERROR_TYPE_Unavailable {
+			t.Errorf("%d returned %v, expected an unavailable error", statusCode, err)
+		}
+		_, err = e.getBestBlockHash(context.Background())
+		if GetErrorType(err) != ERROR_TYPE_Unavailable {
+			t.Errorf("%d returned %v, expected an unavailable error", statusCode, err)
+		}
+	}
+}
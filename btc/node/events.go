@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+)
+
+// event types
+const EVENT_TYPE_BlockConnected = "block_connected"
+const EVENT_TYPE_BlockDisconnected = "block_disconnected"
+const EVENT_TYPE_Tx = "tx"
+const EVENT_TYPE_MempoolAdd = "mempool_add"
+const EVENT_TYPE_MempoolRemove = "mempool_remove"
+
+type Event struct {
+	eventType       string
+	blockHash       string
+	blockHeight     int32 // -1 if unknown
+	txId            string
+	rawData         []byte // the serialized block or transaction, if it was received
+	mempoolSequence uint64
+	backfilled      bool // true if the event was missed and recovered from the node later
+}
+
+func (e *Event) GetType() string {
+	return e.eventType
+}
+
+func (e *Event) GetBlockHash() string {
+	return e.blockHash
+}
+
+func (e *Event) GetBlockHeight() int32 {
+	return e.blockHeight
+}
+
+func (e *Event) GetTxId() string {
+	return e.txId
+}
+
+func (e *Event) GetRawData() []byte {
+	return e.rawData
+}
+
+func (e *Event) GetMempoolSequence() uint64 {
+	return e.mempoolSequence
+}
+
+func (e *Event) IsBackfilled() bool {
+	return e.backfilled
+}
+
+// the event bus delivers every event to every subscriber that accepts its type
+// subscribers that do not keep up will miss events rather than block the publisher,
+// so a subscriber that must not miss block events should only accept block events, which are rare
+
+type eventSubscriber struct {
+	events     chan Event
+	eventTypes map[string]bool // every type is accepted if empty
+}
+
+type EventBus struct {
+	mutex            sync.Mutex
+	subscribers      map[uint64]eventSubscriber
+	nextSubscriberId uint64
+}
+
+var eventBus *EventBus = nil
+var initEventBusOnce sync.Once
+
+func GetEventBus() *EventBus {
+	initEventBusOnce.Do(func() {
+		eventBus = &EventBus{subscribers: make(map[uint64]eventSubscriber)}
+	})
+	return eventBus
+}
+
+// returns the subscriber id, which is required to unsubscribe, and the channel events will be sent to
+// only events of the given types are sent, or events of every type if no types are given
+func (eb *EventBus) Subscribe(bufferSize int, eventTypes ...string) (uint64, <-chan Event) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	subscriberId := eb.nextSubscriberId
+	eb.nextSubscriberId++
+
+	subscriber := eventSubscriber{events: make(chan Event, bufferSize), eventTypes: make(map[string]bool)}
+	for _, eventType := range eventTypes {
+		subscriber.eventTypes[eventType] = true
+	}
+	eb.subscribers[subscriberId] = subscriber
+	return subscriberId, subscriber.events
+}
+
+func (eb *EventBus) Unsubscribe(subscriberId uint64) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	subscriber, found := eb.subscribers[subscriberId]
+	if found {
+		delete(eb.subscribers, subscriberId)
+		close(subscriber.events)
+	}
+}
+
+func (eb *EventBus) publish(event Event) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	for subscriberId, subscriber := range eb.subscribers {
+		if len(subscriber.eventTypes) > 0 && !subscriber.eventTypes[event.eventType] {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			fmt.Println(fmt.Sprintf("Event subscriber %d is full, %s event dropped.", subscriberId, event.eventType))
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// records the responses of a node to fixture files, and replays them later without a node
+// every request is stored in its own file, so a fixture directory can be recorded by browsing the pages a test needs and then checked in
+// responses that can change over time, like the best block hash and the mempool, are overwritten each time they are recorded
+// not found errors and errors reported by the node are recorded too, but errors caused by a node that could not be reached are not
+
+// the file that identifies the node the fixtures were recorded from
+const FIXTURES_NODE_FILE = "node.json"
+
+type fixtureNode struct {
+	NodeType      string `json:"node_type"`
+	VersionStr    string `json:"version_str"`
+	VersionString string `json:"version_string"`
+}
+
+type fixtureError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type fixture struct {
+	Request string          `json:"request"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *fixtureError   `json:"error,omitempty"`
+}
+
+// returns the path of the fixture for a request, relative to the fixtures directory
+// keys are block hashes, txids and heights, anything else is hashed so that it can not be used as a path
+func getFixturePath(kind string, key string) string {
+
+	if len(key) == 0 {
+		return kind + ".json"
+	}
+
+	safe := len(key) <= 64
+	for _, c := range key {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			safe = false
+			break
+		}
+	}
+	if !safe {
+		hash := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(hash[:])
+	}
+
+	return filepath.Join(kind, key+".json")
+}
+
+func getBlockFixtureKind(withTxData bool) string {
+	if withTxData {
+		return "block-txs"
+	}
+	return "block"
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////
+
+type recordingNode struct {
+	btcNode nodeClient
+	dir     string
+}
+
+// nodes that can request several transactions at once
+type recordingBatchNode struct {
+	*recordingNode
+}
+
+// the returned node can request several transactions at once if the recorded node can
+func newRecordingNode(btcNode nodeClient, dir string) (nodeClient, error) {
+
+	rn := &recordingNode{btcNode: btcNode, dir: dir}
+
+	nodeJson, err := json.MarshalIndent(fixtureNode{NodeType: btcNode.getNodeType(),
+		VersionStr:    btcNode.getVersionStr(),
+		VersionString: btcNode.GetVersionString()}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	err = rn.writeFile(FIXTURES_NODE_FILE, nodeJson)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, canBatch := btcNode.(txBatchClient); canBatch {
+		return &recordingBatchNode{recordingNode: rn}, nil
+	}
+	return rn, nil
+}
+
+func (rn *recordingNode) GetVersionString() string {
+	return rn.btcNode.GetVersionString()
+}
+
+func (rn *recordingNode) getNodeType() string {
+	return rn.btcNode.getNodeType()
+}
+
+func (rn *recordingNode) getVersionStr() string {
+	return rn.btcNode.getVersionStr()
+}
+
+// writes the file to a temporary file first, so that a fixture is never left half written
+func (rn *recordingNode) writeFile(path string, data []byte) error {
+
+	fileName := filepath.Join(rn.dir, path)
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(fileName), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	err = os.Rename(tempFile.Name(), fileName)
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
+
+// failing to record a fixture does not fail the request
+func (rn *recordingNode) record(path string, request string, result interface{}, requestErr error) {
+
+	f := fixture{Request: request}
+	if requestErr != nil {
+		errorType := GetErrorType(requestErr)
+		if errors.Is(requestErr, context.Canceled) || errors.Is(requestErr, context.DeadlineExceeded) ||
+			errorType == ERROR_TYPE_Unavailable || errorType == ERROR_TYPE_Unauthorized || errorType == ERROR_TYPE_Decode {
+			return
+		}
+
+		f.Error = &fixtureError{Type: errorType, Message: requestErr.Error()}
+	} else {
+		resultJson, err := json.Marshal(result)
+		if err != nil {
+			fmt.Println("FIXTURE ERROR: Failed to encode the response to " + request + ". " + err.Error())
+			return
+		}
+		f.Result = resultJson
+	}
+
+	fixtureJson, err := json.Marshal(f)
+	if err == nil {
+		err = rn.writeFile(path, fixtureJson)
+	}
+	if err != nil {
+		fmt.Println("FIXTURE ERROR: Failed to record the response to " + request + ". " + err.Error())
+	}
+}
+
+func (rn *recordingNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	rawBlock, err := rn.btcNode.getBlock(ctx, blockHash, withTxData)
+	rn.record(getFixturePath(getBlockFixtureKind(withTxData), blockHash), fmt.Sprintf("getblock %s %t", blockHash, withTxData), rawBlock, err)
+	return rawBlock, err
+}
+
+func (rn *recordingNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	rawTx, err := rn.btcNode.getTx(ctx, txId)
+	rn.record(getFixturePath("tx", txId), "gettx "+txId, rawTx, err)
+	return rawTx, err
+}
+
+func (rn *recordingNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	rawTx, err := rn.btcNode.getTxInBlock(ctx, txId, blockHash)
+	rn.record(getFixturePath("block-tx", blockHash+txId), "gettxinblock "+txId+" "+blockHash, rawTx, err)
+	return rawTx, err
+}
+
+func (rn *recordingNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	blockHash, err := rn.btcNode.getBlockHash(ctx, blockHeight)
+	height := strconv.FormatUint(uint64(blockHeight), 10)
+	rn.record(getFixturePath("block-hash", height), "getblockhash "+height, blockHash, err)
+	return blockHash, err
+}
+
+func (rn *recordingNode) getBestBlockHash(ctx context.Context) (string, error) {
+	blockHash, err := rn.btcNode.getBestBlockHash(ctx)
+	rn.record(getFixturePath("best-block-hash", ""), "getbestblockhash", blockHash, err)
+	return blockHash, err
+}
+
+func (rn *recordingNode) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	chainTips, err := rn.btcNode.getChainTips(ctx)
+	rn.record(getFixturePath("chain-tips", ""), "getchaintips", chainTips, err)
+	return chainTips, err
+}
+
+func (rn *recordingNode) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	txIds, err := rn.btcNode.getMempoolTxIds(ctx)
+	rn.record(getFixturePath("mempool-tx-ids", ""), "getmempooltxids", txIds, err)
+	return txIds, err
+}
+
+func (rn *recordingNode) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	entry, err := rn.btcNode.getMempoolEntry(ctx, txId)
+	rn.record(getFixturePath("mempool-entry", txId), "getmempoolentry "+txId, entry, err)
+	return entry, err
+}
+
+// each transaction is recorded in its own fixture, so that it can be replayed by either getTx or getTxs
+func (rbn *recordingBatchNode) getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error) {
+	rawTxs, err := rbn.btcNode.(txBatchClient).getTxs(ctx, txIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, txId := range txIds {
+		rawTx, found := rawTxs[txId]
+		if found {
+			rbn.record(getFixturePath("tx", txId), "gettx "+txId, rawTx, nil)
+		} else {
+			rbn.record(getFixturePath("tx", txId), "gettx "+txId, nil, newNotFoundError("Transaction "+txId+" not found."))
+		}
+	}
+	return rawTxs, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////
+
+// serves the responses recorded by a recording node
+// a request that was not recorded fails as if the node were unavailable
+type replayNode struct {
+	dir  string
+	node fixtureNode
+}
+
+func newReplayNode(dir string) (*replayNode, error) {
+
+	nodeJson, err := os.ReadFile(filepath.Join(dir, FIXTURES_NODE_FILE))
+	if err != nil {
+		return nil, errors.New("Failed to read the node fixtures. " + err.Error())
+	}
+
+	rn := replayNode{dir: dir}
+	err = json.Unmarshal(nodeJson, &rn.node)
+	if err != nil {
+		return nil, errors.New("Failed to read the node fixtures. " + err.Error())
+	}
+	return &rn, nil
+}
+
+func (rn *replayNode) GetVersionString() string {
+	return rn.node.VersionString + " (" + app.NODE_TYPE_Replay + ")"
+}
+
+func (rn *replayNode) getNodeType() string {
+	return rn.node.NodeType
+}
+
+func (rn *replayNode) getVersionStr() string {
+	return rn.node.VersionStr
+}
+
+func (rn *replayNode) replay(path string, request string, result interface{}) error {
+
+	fixtureJson, err := os.ReadFile(filepath.Join(rn.dir, path))
+	if errors.Is(err, os.ErrNotExist) {
+		return newUnavailableError("REPLAY ERROR: No response was recorded for "+request+".", nil)
+	}
+	if err != nil {
+		return newUnavailableError("REPLAY ERROR:", err)
+	}
+
+	var f fixture
+	err = json.Unmarshal(fixtureJson, &f)
+	if err != nil {
+		return newDecodeError("REPLAY ERROR: Failed to read the response to "+request+".", err)
+	}
+
+	if f.Error != nil {
+		if len(f.Error.Type) == 0 {
+			return errors.New(f.Error.Message)
+		}
+		return newNodeError(f.Error.Type, f.Error.Message, nil)
+	}
+
+	err = json.Unmarshal(f.Result, result)
+	if err != nil {
+		return newDecodeError("REPLAY ERROR: Failed to read the response to "+request+".", err)
+	}
+	return nil
+}
+
+func (rn *replayNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	var rawBlock nodeBlock
+	err := rn.replay(getFixturePath(getBlockFixtureKind(withTxData), blockHash), fmt.Sprintf("getblock %s %t", blockHash, withTxData), &rawBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &rawBlock, nil
+}
+
+func (rn *replayNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	var rawTx nodeTx
+	err := rn.replay(getFixturePath("tx", txId), "gettx "+txId, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rawTx, nil
+}
+
+func (rn *replayNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	var rawTx nodeTx
+	err := rn.replay(getFixturePath("block-tx", blockHash+txId), "gettxinblock "+txId+" "+blockHash, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rawTx, nil
+}
+
+func (rn *replayNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	var blockHash string
+	height := strconv.FormatUint(uint64(blockHeight), 10)
+	err := rn.replay(getFixturePath("block-hash", height), "getblockhash "+height, &blockHash)
+	return blockHash, err
+}
+
+func (rn *replayNode) getBestBlockHash(ctx context.Context) (string, error) {
+	var blockHash string
+	err := rn.replay(getFixturePath("best-block-hash", ""), "getbestblockhash", &blockHash)
+	return blockHash, err
+}
+
+func (rn *replayNode) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	var chainTips []nodeChainTip
+	err := rn.replay(getFixturePath("chain-tips", ""), "getchaintips", &chainTips)
+	if err != nil {
+		return nil, err
+	}
+	return chainTips, nil
+}
+
+func (rn *replayNode) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	var txIds []string
+	err := rn.replay(getFixturePath("mempool-tx-ids", ""), "getmempooltxids", &txIds)
+	if err != nil {
+		return nil, err
+	}
+	return txIds, nil
+}
+
+func (rn *replayNode) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	var entry nodeMempoolEntry
+	err := rn.replay(getFixturePath("mempool-entry", txId), "getmempoolentry "+txId, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
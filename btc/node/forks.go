@@ -0,0 +1,314 @@
+package node
+
+import (
+	"context"
+	"sort"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// chain tips, the branches that lead to them, and comparisons of competing blocks
+// a branch is the part of a chain that is not in the active chain, so it is empty for the tip of the active chain
+// none of this is cached, since stale blocks are rarely requested and the tips change with every block
+// the blocks being compared are decoded without caching their transactions, and the previous outputs of their inputs are found
+// without the memory and disk caches, so that transactions from stale blocks are never served as the transactions of the active chain
+
+// the statuses of chain tips, as reported by Bitcoin Core
+const CHAIN_TIP_STATUS_Active = "active"              // the tip of the active chain
+const CHAIN_TIP_STATUS_ValidFork = "valid-fork"       // fully validated, but not part of the active chain
+const CHAIN_TIP_STATUS_ValidHeaders = "valid-headers" // every block is available, but the branch was never fully validated
+const CHAIN_TIP_STATUS_HeadersOnly = "headers-only"   // not every block is available, the branch was never fully validated
+const CHAIN_TIP_STATUS_Invalid = "invalid"            // the branch contains at least one invalid block
+
+// the blocks of a longer branch closest to the tip
+const CHAIN_BRANCH_MAX_BLOCKS = 20
+
+// the transactions of a block that are not in the other block, whose types are compared
+const BLOCK_COMPARISON_MAX_TXS = 1000
+
+type ChainTip struct {
+	height       uint32
+	hash         string
+	branchLength uint32
+	status       string
+}
+
+func (ct *ChainTip) GetHeight() uint32 {
+	return ct.height
+}
+
+func (ct *ChainTip) GetHash() string {
+	return ct.hash
+}
+
+// the number of blocks between the tip and the active chain
+func (ct *ChainTip) GetBranchLength() uint32 {
+	return ct.branchLength
+}
+
+func (ct *ChainTip) GetStatus() string {
+	return ct.status
+}
+
+// the height of the last block the branch has in common with the active chain
+func (ct *ChainTip) GetForkHeight() uint32 {
+	return ct.height - ct.branchLength
+}
+
+// a block of a branch, with the block of the active chain at the same height
+// the active block hash is empty if the branch is longer than the active chain
+type BranchBlock struct {
+	block           btc.Block
+	activeBlockHash string
+}
+
+func (bb *BranchBlock) GetBlock() btc.Block {
+	return bb.block
+}
+
+func (bb *BranchBlock) GetActiveBlockHash() string {
+	return bb.activeBlockHash
+}
+
+// the blocks are in order from the tip down
+// the branch is incomplete if the node does not have the data of every block, which is always true of headers-only tips,
+// or if it is longer than CHAIN_BRANCH_MAX_BLOCKS
+type ChainBranch struct {
+	tip      ChainTip
+	blocks   []BranchBlock
+	complete bool
+}
+
+func (cb *ChainBranch) GetTip() ChainTip {
+	return cb.tip
+}
+
+func (cb *ChainBranch) GetBlocks() []BranchBlock {
+	return cb.blocks
+}
+
+func (cb *ChainBranch) IsComplete() bool {
+	return cb.complete
+}
+
+// one of the blocks of a comparison
+// only the transactions that are not in the other block are included, with their previous outputs
+type ComparedBlock struct {
+	block         btc.Block
+	uniqueTxCount uint32
+	uniqueTxs     []btc.Tx // at most BLOCK_COMPARISON_MAX_TXS
+	spendTypes    map[string]uint32
+	outputTypes   map[string]uint32
+}
+
+func (cb *ComparedBlock) GetBlock() btc.Block {
+	return cb.block
+}
+
+// the number of transactions that are not in the other block, including those that were not compared
+func (cb *ComparedBlock) GetUniqueTxCount() uint32 {
+	return cb.uniqueTxCount
+}
+
+func (cb *ComparedBlock) GetUniqueTxs() []btc.Tx {
+	return cb.uniqueTxs
+}
+
+// the spend types of the inputs of the unique transactions
+func (cb *ComparedBlock) GetSpendTypes() map[string]uint32 {
+	return cb.spendTypes
+}
+
+// the output types of the outputs of the unique transactions
+func (cb *ComparedBlock) GetOutputTypes() map[string]uint32 {
+	return cb.outputTypes
+}
+
+// the transactions that are in both blocks are the same in each block, so only the others are compared
+type BlockComparison struct {
+	blocks        []ComparedBlock
+	sharedTxCount uint32
+}
+
+func (bc *BlockComparison) GetBlocks() []ComparedBlock {
+	return bc.blocks
+}
+
+func (bc *BlockComparison) GetSharedTxCount() uint32 {
+	return bc.sharedTxCount
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////
+
+// the tip of the active chain is first, followed by the other tips from the highest to the lowest
+func (c *btcCache) getChainTips(ctx context.Context) ([]ChainTip, error) {
+
+	rawChainTips, err := c.btcNode.getChainTips(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chainTips := make([]ChainTip, 0, len(rawChainTips))
+	for _, rawChainTip := range rawChainTips {
+		if !isHash(rawChainTip.Hash) || rawChainTip.BranchLen > rawChainTip.Height {
+			return nil, newDecodeError("Malformed chain tip from node: "+rawChainTip.Hash+".", nil)
+		}
+		chainTips = append(chainTips, ChainTip{height: rawChainTip.Height,
+			hash:         rawChainTip.Hash,
+			branchLength: rawChainTip.BranchLen,
+			status:       rawChainTip.Status})
+	}
+
+	sort.SliceStable(chainTips, func(a, b int) bool {
+		aActive := chainTips[a].status == CHAIN_TIP_STATUS_Active
+		bActive := chainTips[b].status == CHAIN_TIP_STATUS_Active
+		if aActive != bActive {
+			return aActive
+		}
+		return chainTips[a].height > chainTips[b].height
+	})
+
+	return chainTips, nil
+}
+
+// returns a not found error if the block is not a chain tip
+func (c *btcCache) getChainBranch(ctx context.Context, tipHash string) (ChainBranch, error) {
+
+	chainTips, err := c.getChainTips(ctx)
+	if err != nil {
+		return ChainBranch{}, err
+	}
+
+	branch := ChainBranch{}
+	for _, chainTip := range chainTips {
+		if chainTip.hash == tipHash {
+			branch.tip = chainTip
+			break
+		}
+	}
+	if len(branch.tip.hash) == 0 {
+		return ChainBranch{}, newNotFoundError(tipHash + " is not a chain tip.")
+	}
+
+	branch.blocks = make([]BranchBlock, 0)
+	branch.complete = branch.tip.status != CHAIN_TIP_STATUS_HeadersOnly
+	if !branch.complete {
+		return branch, nil
+	}
+
+	blockHash := tipHash
+	for b := uint32(0); b < branch.tip.branchLength; b++ {
+		if b == CHAIN_BRANCH_MAX_BLOCKS {
+			branch.complete = false
+			break
+		}
+
+		// a block that the node does not have ends the branch, but a node that can not be reached fails the request
+		rawBlock, err := c.btcNode.getBlock(ctx, blockHash, false)
+		if err != nil {
+			errorType := GetErrorType(err)
+			if ctx.Err() != nil || errorType == ERROR_TYPE_Unavailable || errorType == ERROR_TYPE_Unauthorized {
+				return ChainBranch{}, err
+			}
+			branch.complete = false
+			break
+		}
+
+		block, err := decodeBlock(rawBlock)
+		if err != nil {
+			return ChainBranch{}, err
+		}
+
+		activeBlockHash, err := c.btcNode.getBlockHash(ctx, block.GetHeight())
+		if err != nil && !IsNotFound(err) {
+			return ChainBranch{}, err
+		}
+
+		branch.blocks = append(branch.blocks, BranchBlock{block: block, activeBlockHash: activeBlockHash})
+		blockHash = block.GetPreviousHash()
+	}
+
+	return branch, nil
+}
+
+// competing blocks are usually at the same height, but any two blocks can be compared
+func (c *btcCache) compareBlocks(ctx context.Context, blockHashes [2]string) (BlockComparison, error) {
+
+	var rawBlocks [2]*nodeBlock
+	for b, blockHash := range blockHashes {
+		rawBlock, err := c.btcNode.getBlock(ctx, blockHash, true)
+		if err != nil {
+			return BlockComparison{}, err
+		}
+		err = rawBlock.validate()
+		if err != nil {
+			return BlockComparison{}, err
+		}
+		rawBlocks[b] = rawBlock
+	}
+
+	var blockTxIds [2]map[string]bool
+	for b, rawBlock := range rawBlocks {
+		blockTxIds[b] = make(map[string]bool)
+		for _, txId := range rawBlock.getTxIds() {
+			blockTxIds[b][txId] = true
+		}
+	}
+
+	comparison := BlockComparison{blocks: make([]ComparedBlock, 2)}
+	for txId := range blockTxIds[0] {
+		if blockTxIds[1][txId] {
+			comparison.sharedTxCount++
+		}
+	}
+
+	for b, rawBlock := range rawBlocks {
+		compared := ComparedBlock{block: makeBlock(rawBlock),
+			uniqueTxs:   make([]btc.Tx, 0),
+			spendTypes:  make(map[string]uint32),
+			outputTypes: make(map[string]uint32)}
+
+		// the raw block might be shared with other requests for the same block, so it is not modified
+		for _, rawTx := range rawBlock.getTxs() {
+			if blockTxIds[1-b][rawTx.TxId] {
+				continue
+			}
+
+			compared.uniqueTxCount++
+			if len(compared.uniqueTxs) == BLOCK_COMPARISON_MAX_TXS {
+				continue
+			}
+
+			rawTx.BlockHash = rawBlock.Hash
+			rawTx.BlockTime = rawBlock.Time
+			tx, err := makeTx(&rawTx)
+			if err != nil {
+				return BlockComparison{}, err
+			}
+			compared.uniqueTxs = append(compared.uniqueTxs, tx)
+		}
+
+		// transactions in a stale block often spend outputs from the same block, which is passed as a hint
+		// the previous transactions found in a stale block would be cached as confirmed, so they are found without caching them
+		uncached := *c
+		uncached.memory = nil
+		uncached.disk = nil
+		err := uncached.setPreviousOutputs(ctx, compared.uniqueTxs)
+		if err != nil {
+			return BlockComparison{}, err
+		}
+
+		for _, tx := range compared.uniqueTxs {
+			for _, input := range tx.GetInputs() {
+				compared.spendTypes[input.GetSpendType()]++
+			}
+			for _, output := range tx.GetOutputs() {
+				compared.outputTypes[output.GetOutputType()]++
+			}
+		}
+
+		comparison.blocks[b] = compared
+	}
+
+	return comparison, nil
+}
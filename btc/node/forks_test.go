@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"testing"
+)
+
+// a node without a transaction index, which only has the blocks it is given
+type forksTestNode struct {
+	nodeClient // only the block requests are implemented
+
+	blocks map[string]*nodeBlock
+}
+
+func (ftn *forksTestNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	rawBlock, found := ftn.blocks[blockHash]
+	if !found {
+		return nil, newNotFoundError("Block " + blockHash + " not found.")
+	}
+	if !withTxData {
+		return rawBlock.withoutTxData(), nil
+	}
+	return rawBlock, nil
+}
+
+func (ftn *forksTestNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	return nil, newNotFoundError("Transaction " + txId + " not found.")
+}
+
+func (ftn *forksTestNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	rawBlock, found := ftn.blocks[blockHash]
+	if found {
+		if rawTx := rawBlock.findTx(txId); rawTx != nil {
+			return rawTx, nil
+		}
+	}
+	return nil, newNotFoundError("Transaction " + txId + " not found.")
+}
+
+// the previous transactions of a stale block are not cached, even if they are only found in the stale block
+func TestCompareBlocksCaching(t *testing.T) {
+
+	replay, err := newReplayNode(testFixturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeBlock, err := replay.getBlock(context.Background(), testFixturesBlockHash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousTx, err := replay.getTx(context.Background(), testFixturesPreviousTxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale block has the transaction of block 170 and the transaction it spends, which the active block does not have
+	activeTxs := activeBlock.getTxs()
+	previousTx.BlockHash = ""
+	previousTx.BlockTime = 0
+	staleBlock := *activeBlock
+	staleBlock.Hash = getStaleTestBlockHash(testFixturesBlockHeight)
+	staleBlock.Tx = nodeBlockTxs{txs: []nodeTx{activeTxs[0], *previousTx, activeTxs[1]}}
+	activeBlock.Tx = nodeBlockTxs{txs: activeTxs[:1]}
+
+	btcNode := &forksTestNode{blocks: map[string]*nodeBlock{activeBlock.Hash: activeBlock, staleBlock.Hash: &staleBlock}}
+	c := &btcCache{btcNode: newLimitedNode(btcNode, 0, 0), memory: newMemoryCache(1 << 20), chain: &chainTip{}}
+
+	comparison, err := c.compareBlocks(context.Background(), [2]string{activeBlock.Hash, staleBlock.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comparedBlocks := comparison.GetBlocks()
+	uniqueTxs := comparedBlocks[1].GetUniqueTxs()
+	if comparison.GetSharedTxCount() != 1 || comparedBlocks[0].GetUniqueTxCount() != 0 || len(uniqueTxs) != 2 {
+		t.Fatalf("the blocks share %d txs and have %d and %d unique txs, expected 1, 0 and 2", comparison.GetSharedTxCount(), comparedBlocks[0].GetUniqueTxCount(), len(uniqueTxs))
+	}
+
+	// the previous output is found in the stale block
+	input := uniqueTxs[1].GetInput(0)
+	previousOutput := input.GetPreviousOutput()
+	if previousOutput.GetValue() != 5000000000 || comparedBlocks[1].GetSpendTypes()["P2PK"] != 1 {
+		t.Errorf("the input of tx %s spends %d as %v", uniqueTxs[1].GetTxId(), previousOutput.GetValue(), comparedBlocks[1].GetSpendTypes())
+	}
+
+	cachedTx := c.getCachedTx(testFixturesPreviousTxId)
+	if !cachedTx.IsNil() {
+		t.Errorf("tx %s was cached in stale block %s", testFixturesPreviousTxId, cachedTx.GetBlockHash())
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// the http client shared by every node connection
+// it applies the request timeout and trusts any additional certificate authorities from the settings
+
+var nodeHttpClient *http.Client = nil
+var initHttpClientOnce sync.Once
+
+func getHttpClient() *http.Client {
+	initHttpClientOnce.Do(func() {
+		nodeHttpClient = newHttpClient(time.Duration(app.Settings.GetNodeTimeoutSeconds())*time.Second, app.Settings.GetNodeCaFile())
+	})
+	return nodeHttpClient
+}
+
+// the certificate authorities in caFile are trusted in addition to the system certificate authorities
+func newHttpClient(timeout time.Duration, caFile string) *http.Client {
+
+	httpClient := &http.Client{Timeout: timeout}
+	if len(caFile) == 0 {
+		return httpClient
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	caPem, err := os.ReadFile(caFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		return httpClient
+	}
+	if !rootCAs.AppendCertsFromPEM(caPem) {
+		fmt.Println("No certificates found in " + caFile + ".")
+		return httpClient
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	httpClient.Transport = transport
+	return httpClient
+}
+
+// converts an unsuccessful http response to the matching error type
+func getHttpStatusError(nodeName string, statusCode int, responseText string) error {
+	message := fmt.Sprintf("%s ERROR: %s (%d)", nodeName, responseText, statusCode)
+
+	switch {
+	case statusCode == http.StatusNotFound:
+		return newNotFoundError(message)
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return newUnauthorizedError(message, nil)
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return newUnavailableError(message, nil)
+	}
+	return errors.New(message)
+}
+
+// Bitcoin Core credentials, either from the settings or from a cookie file
+// Bitcoin Core writes a new cookie file with a new password every time it starts
+
+type nodeCredentials struct {
+	mutex          sync.Mutex
+	username       string
+	password       string
+	cookieFile     string
+	cookieModified time.Time
+}
+
+var credentials *nodeCredentials = nil
+var initCredentialsOnce sync.Once
+
+// the credentials from the settings
+func getNodeCredentials() *nodeCredentials {
+	initCredentialsOnce.Do(func() {
+		credentials = newNodeCredentials(app.Settings.GetNodeUsername(), app.Settings.GetNodePassword(), app.Settings.GetNodeCookieFile())
+	})
+	return credentials
+}
+
+// the cookie file is used instead of the username and password if it is set
+func newNodeCredentials(username string, password string, cookieFile string) *nodeCredentials {
+	return &nodeCredentials{username: username, password: password, cookieFile: cookieFile}
+}
+
+// the cookie file is re-read whenever it has been modified
+func (nc *nodeCredentials) get() (string, string, error) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if len(nc.cookieFile) == 0 {
+		return nc.username, nc.password, nil
+	}
+
+	fileInfo, err := os.Stat(nc.cookieFile)
+	if err != nil {
+		return "", "", newUnauthorizedError("COOKIE FILE ERROR:", err)
+	}
+	if len(nc.username) == 0 || !fileInfo.ModTime().Equal(nc.cookieModified) {
+		err = nc.readCookieFile()
+		if err != nil {
+			return "", "", err
+		}
+		nc.cookieModified = fileInfo.ModTime()
+	}
+
+	return nc.username, nc.password, nil
+}
+
+// forces the cookie file to be read again, returns false if there is no cookie file
+func (nc *nodeCredentials) reload() bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if len(nc.cookieFile) == 0 {
+		return false
+	}
+
+	err := nc.readCookieFile()
+	if err != nil {
+		fmt.Println(err.Error())
+		return false
+	}
+	return true
+}
+
+// the cookie file contains a single line in the form username:password
+func (nc *nodeCredentials) readCookieFile() error {
+	cookie, err := os.ReadFile(nc.cookieFile)
+	if err != nil {
+		return newUnauthorizedError("COOKIE FILE ERROR:", err)
+	}
+
+	username, password, found := strings.Cut(strings.TrimSpace(string(cookie)), ":")
+	if !found {
+		return newUnauthorizedError("COOKIE FILE ERROR: "+nc.cookieFile+" is not formatted correctly.", nil)
+	}
+
+	nc.username = username
+	nc.password = password
+	return nil
+}
@@ -0,0 +1,145 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a Bitcoin Core node behind https, which answers at one RPC path and only accepts the password of its current cookie
+type cookieTestServer struct {
+	mutex             sync.Mutex
+	password          string
+	unauthorizedCount int
+}
+
+func (cts *cookieTestServer) setPassword(password string) {
+	cts.mutex.Lock()
+	defer cts.mutex.Unlock()
+	cts.password = password
+}
+
+func (cts *cookieTestServer) getUnauthorizedCount() int {
+	cts.mutex.Lock()
+	defer cts.mutex.Unlock()
+	return cts.unauthorizedCount
+}
+
+func (cts *cookieTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/wallet/test" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	cts.mutex.Lock()
+	username, password, _ := r.BasicAuth()
+	authorized := username == "__cookie__" && password == cts.password
+	if !authorized {
+		cts.unauthorizedCount++
+	}
+	cts.mutex.Unlock()
+	if !authorized {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Method string `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	response := map[string]interface{}{"error": nil, "id": nil}
+	switch request.Method {
+	case "getnetworkinfo":
+		response["result"] = rpcNetworkInfo{Subversion: "/Satoshi:25.0.0/"}
+	case "getbestblockhash":
+		response["result"] = testFixturesBlockHash
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writes the cookie without changing the modification time of the cookie file, so that only an unauthorized response causes it to be read again
+func writeTestCookieFile(t *testing.T, cookieFile string, password string, modified time.Time) {
+	err := os.WriteFile(cookieFile, []byte("__cookie__:"+password), 0600)
+	if err == nil {
+		err = os.Chtimes(cookieFile, modified, modified)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBitcoinCoreCookieFile(t *testing.T) {
+
+	testServer := cookieTestServer{password: "first"}
+	server := httptest.NewTLSServer(&testServer)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	cookieFile := filepath.Join(dir, ".cookie")
+	modified := time.Now().Add(-time.Hour)
+	writeTestCookieFile(t, cookieFile, "first", modified)
+
+	// the certificate of the node is not trusted without the certificate authority file
+	getHttpClient()
+	defaultClient := nodeHttpClient
+	t.Cleanup(func() { nodeHttpClient = defaultClient })
+	_, err := NewBitcoinCore(server.URL+"/wallet/test", newNodeCredentials("", "", cookieFile))
+	if err == nil {
+		t.Fatal("connected to a node with an untrusted certificate")
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeHttpClient = newHttpClient(5*time.Second, caFile)
+
+	// requests are sent to the RPC path
+	_, err = NewBitcoinCore(server.URL, newNodeCredentials("", "", cookieFile))
+	if err == nil {
+		t.Error("connected to the node without the RPC path")
+	}
+	bc, err := NewBitcoinCore(server.URL+"/wallet/test/", newNodeCredentials("", "", cookieFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.GetVersionString() != "Bitcoin Core 25.0.0" || testServer.getUnauthorizedCount() != 0 {
+		t.Errorf("connected to %s after %d unauthorized requests", bc.GetVersionString(), testServer.getUnauthorizedCount())
+	}
+
+	// the node restarts with a new cookie, which is read after the node rejects the old one
+	testServer.setPassword("second")
+	writeTestCookieFile(t, cookieFile, "second", modified)
+	bestBlockHash, err := bc.getBestBlockHash(context.Background())
+	if err != nil || bestBlockHash != testFixturesBlockHash {
+		t.Fatalf("the best block hash returned %s, %v after the cookie changed", bestBlockHash, err)
+	}
+	if testServer.getUnauthorizedCount() != 1 {
+		t.Errorf("the node rejected %d requests, expected 1", testServer.getUnauthorizedCount())
+	}
+
+	// a cookie that has been modified is read before the request is sent
+	testServer.setPassword("third")
+	writeTestCookieFile(t, cookieFile, "third", modified.Add(time.Minute))
+	_, err = bc.getBestBlockHash(context.Background())
+	if err != nil || testServer.getUnauthorizedCount() != 1 {
+		t.Errorf("the best block hash returned %v after %d unauthorized requests", err, testServer.getUnauthorizedCount())
+	}
+
+	// the node rejects the cookie even after it is read again
+	testServer.setPassword("fourth")
+	_, err = bc.getBestBlockHash(context.Background())
+	if GetErrorType(err) != ERROR_TYPE_Unauthorized {
+		t.Errorf("a rejected cookie returned %v, expected an unauthorized error", err)
+	}
+}
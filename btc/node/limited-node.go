@@ -0,0 +1,330 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// limits the requests sent to a node
+// at most maxConcurrent requests are sent at the same time, and requests are delayed to stay under the rate limit
+// concurrent requests for the same block or transaction share a single request to the node
+// each node from the nodes setting is limited separately, so the node that wraps all of them only shares requests
+
+type limitedNode struct {
+	btcNode nodeClient
+	slots   chan struct{} // nil if there is no concurrency limit
+	limiter *rate.Limiter // nil if there is no rate limit
+	flights flightGroup
+}
+
+// the number of transactions requested at the same time by a node without a concurrency limit
+// it is the default of node-max-concurrent-requests
+const UNLIMITED_TX_WORKER_COUNT = 8
+
+// a node is not limited if maxConcurrent is 0 and requestsPerSecond is 0
+func newLimitedNode(btcNode nodeClient, maxConcurrent int, requestsPerSecond float64) *limitedNode {
+
+	ln := limitedNode{btcNode: btcNode}
+	if maxConcurrent > 0 {
+		ln.slots = make(chan struct{}, maxConcurrent)
+	}
+	if requestsPerSecond > 0 {
+		burst := int(requestsPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		ln.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+	}
+	return &ln
+}
+
+// limits the node with the node-max-concurrent-requests and node-max-requests-per-second settings
+// at least one request at a time is allowed
+func newSettingsLimitedNode(btcNode nodeClient) *limitedNode {
+	maxConcurrent := int(app.Settings.GetNodeMaxConcurrentRequests())
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return newLimitedNode(btcNode, maxConcurrent, app.Settings.GetNodeMaxRequestsPerSecond())
+}
+
+// waits for a free slot and then for the rate limit
+func (ln *limitedNode) acquire(ctx context.Context) error {
+	if ln.slots != nil {
+		select {
+		case ln.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if ln.limiter != nil {
+		err := ln.limiter.Wait(ctx)
+		if err != nil {
+			ln.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (ln *limitedNode) release() {
+	if ln.slots != nil {
+		<-ln.slots
+	}
+}
+
+func (ln *limitedNode) GetVersionString() string {
+	return ln.btcNode.GetVersionString()
+}
+
+func (ln *limitedNode) getNodeType() string {
+	return ln.btcNode.getNodeType()
+}
+
+func (ln *limitedNode) getVersionStr() string {
+	return ln.btcNode.getVersionStr()
+}
+
+func (ln *limitedNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+
+	key := "block:" + blockHash
+	if withTxData {
+		key += ":txs"
+	}
+
+	result, err := ln.flights.do(ctx, key, func(flightCtx context.Context) (interface{}, error) {
+		err := ln.acquire(flightCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer ln.release()
+		return ln.btcNode.getBlock(flightCtx, blockHash, withTxData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*nodeBlock), nil
+}
+
+func (ln *limitedNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+
+	result, err := ln.flights.do(ctx, "tx:"+txId, func(flightCtx context.Context) (interface{}, error) {
+		err := ln.acquire(flightCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer ln.release()
+		return ln.btcNode.getTx(flightCtx, txId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*nodeTx), nil
+}
+
+func (ln *limitedNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+
+	result, err := ln.flights.do(ctx, "tx:"+txId+":"+blockHash, func(flightCtx context.Context) (interface{}, error) {
+		err := ln.acquire(flightCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer ln.release()
+		return ln.btcNode.getTxInBlock(flightCtx, txId, blockHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*nodeTx), nil
+}
+
+// nodes that can request several transactions at once use a single request
+// other nodes get one request per transaction, which are sent by one worker for each slot
+// transactions that are not found are not included in the results, any other error fails the entire request
+func (ln *limitedNode) getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error) {
+
+	batchNode, canBatch := ln.btcNode.(txBatchClient)
+	if canBatch {
+		err := ln.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer ln.release()
+		return batchNode.getTxs(ctx, txIds)
+	}
+
+	workerCount := UNLIMITED_TX_WORKER_COUNT
+	if ln.slots != nil {
+		workerCount = cap(ln.slots)
+	}
+	if workerCount > len(txIds) {
+		workerCount = len(txIds)
+	}
+
+	rawTxs := make(map[string]*nodeTx, len(txIds))
+	var firstErr error
+	var rawTxsMutex sync.Mutex
+	var wg sync.WaitGroup
+	txIdQueue := make(chan string)
+	for w := 0; w < workerCount; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for txId := range txIdQueue {
+				rawTx, err := ln.getTx(ctx, txId)
+
+				rawTxsMutex.Lock()
+				if err == nil && rawTx != nil {
+					rawTxs[txId] = rawTx
+				} else if err != nil && !IsNotFound(err) && firstErr == nil {
+					firstErr = err
+				}
+				rawTxsMutex.Unlock()
+			}
+		}()
+	}
+
+	// the remaining transactions are not requested once the request has been cancelled
+	for _, txId := range txIds {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case txIdQueue <- txId:
+		case <-ctx.Done():
+		}
+	}
+	close(txIdQueue)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return rawTxs, nil
+}
+
+func (ln *limitedNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	err := ln.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer ln.release()
+	return ln.btcNode.getBlockHash(ctx, blockHeight)
+}
+
+func (ln *limitedNode) getBestBlockHash(ctx context.Context) (string, error) {
+	err := ln.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer ln.release()
+	return ln.btcNode.getBestBlockHash(ctx)
+}
+
+func (ln *limitedNode) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	err := ln.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.release()
+	return ln.btcNode.getChainTips(ctx)
+}
+
+func (ln *limitedNode) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	err := ln.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.release()
+	return ln.btcNode.getMempoolTxIds(ctx)
+}
+
+func (ln *limitedNode) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	err := ln.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.release()
+	return ln.btcNode.getMempoolEntry(ctx, txId)
+}
+
+// shares one call among every caller that requests the same key while the call is in progress
+// the call is only cancelled when every caller waiting for it has been cancelled
+
+type flight struct {
+	done    chan struct{}
+	result  interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+func (fg *flightGroup) do(ctx context.Context, key string, call func(context.Context) (interface{}, error)) (interface{}, error) {
+
+	fg.mutex.Lock()
+	if fg.flights == nil {
+		fg.flights = make(map[string]*flight)
+	}
+
+	f, inFlight := fg.flights[key]
+	if !inFlight {
+		flightCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		fg.flights[key] = f
+
+		go func() {
+			defer func() {
+				// the call runs in its own goroutine, so a panic in a node client would stop the program rather than fail the request
+				if r := recover(); r != nil {
+					f.result, f.err = nil, newDecodeError(fmt.Sprintf("Node request failed: %v", r), nil)
+				}
+				cancel()
+
+				fg.mutex.Lock()
+				if fg.flights[key] == f {
+					delete(fg.flights, key)
+				}
+				fg.mutex.Unlock()
+
+				close(f.done)
+			}()
+
+			f.result, f.err = call(flightCtx)
+		}()
+	}
+	f.waiters++
+	fg.mutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+
+	case <-ctx.Done():
+		fg.mutex.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// later callers will start a new call rather than join the cancelled one
+			f.cancel()
+			if fg.flights[key] == f {
+				delete(fg.flights, key)
+			}
+		}
+		fg.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,229 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a node whose transaction requests wait until they are released or cancelled
+type limitedTestNode struct {
+	nodeClient // only the requests used by the tests are implemented
+
+	mutex     sync.Mutex
+	calls     int
+	active    int
+	maxActive int
+	flights   *flightGroup // the flights of the limited node, counted while a request is active
+	maxFlight int
+	release   chan struct{}
+	cancelled chan string
+}
+
+func newLimitedTestNode() *limitedTestNode {
+	return &limitedTestNode{release: make(chan struct{}), cancelled: make(chan string, 100)}
+}
+
+func (ltn *limitedTestNode) getCalls() int {
+	ltn.mutex.Lock()
+	defer ltn.mutex.Unlock()
+	return ltn.calls
+}
+
+func (ltn *limitedTestNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+
+	ltn.mutex.Lock()
+	ltn.calls++
+	ltn.active++
+	if ltn.active > ltn.maxActive {
+		ltn.maxActive = ltn.active
+	}
+	if ltn.flights != nil {
+		ltn.flights.mutex.Lock()
+		if len(ltn.flights.flights) > ltn.maxFlight {
+			ltn.maxFlight = len(ltn.flights.flights)
+		}
+		ltn.flights.mutex.Unlock()
+	}
+	ltn.mutex.Unlock()
+
+	defer func() {
+		ltn.mutex.Lock()
+		ltn.active--
+		ltn.mutex.Unlock()
+	}()
+
+	select {
+	case <-ltn.release:
+		return &nodeTx{TxId: txId}, nil
+	case <-ctx.Done():
+		ltn.cancelled <- txId
+		return nil, ctx.Err()
+	}
+}
+
+func (ltn *limitedTestNode) getBestBlockHash(ctx context.Context) (string, error) {
+	return testFixturesBlockHash, nil
+}
+
+// waits until the flight for the key has the number of waiters
+func waitForTestFlight(t *testing.T, fg *flightGroup, key string, waiters int) {
+	for wait := 0; ; wait++ {
+		fg.mutex.Lock()
+		f := fg.flights[key]
+		found := f != nil && f.waiters == waiters
+		fg.mutex.Unlock()
+		if found {
+			return
+		}
+		if wait == 100 {
+			t.Fatalf("the flight for %s does not have %d waiters", key, waiters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// concurrent callers for the same transaction share one node call
+func TestLimitedNodeSharedCall(t *testing.T) {
+
+	btcNode := newLimitedTestNode()
+	ln := newLimitedNode(btcNode, 2, 0)
+
+	type txResult struct {
+		rawTx *nodeTx
+		err   error
+	}
+	results := make(chan txResult, 5)
+	for c := 0; c < 5; c++ {
+		go func() {
+			rawTx, err := ln.getTx(context.Background(), testFixturesTxId)
+			results <- txResult{rawTx, err}
+		}()
+	}
+
+	waitForTestFlight(t, &ln.flights, "tx:"+testFixturesTxId, 5)
+	close(btcNode.release)
+
+	var first *nodeTx
+	for c := 0; c < 5; c++ {
+		result := <-results
+		if result.err != nil || result.rawTx == nil || result.rawTx.TxId != testFixturesTxId {
+			t.Fatalf("caller %d received %v, %v", c, result.rawTx, result.err)
+		}
+		if first == nil {
+			first = result.rawTx
+		} else if result.rawTx != first {
+			t.Error("the callers received different transactions")
+		}
+	}
+	if btcNode.getCalls() != 1 {
+		t.Errorf("the node was called %d times, expected 1", btcNode.getCalls())
+	}
+}
+
+// the node call is only cancelled when the last caller waiting for it is cancelled
+func TestLimitedNodeCancel(t *testing.T) {
+
+	btcNode := newLimitedTestNode()
+	ln := newLimitedNode(btcNode, 2, 0)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := ln.getTx(ctx1, testFixturesTxId)
+		errs <- err
+	}()
+	go func() {
+		_, err := ln.getTx(ctx2, testFixturesTxId)
+		errs <- err
+	}()
+	waitForTestFlight(t, &ln.flights, "tx:"+testFixturesTxId, 2)
+
+	cancel1()
+	err := <-errs
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("the first caller received %v, expected it to be cancelled", err)
+	}
+	select {
+	case <-btcNode.cancelled:
+		t.Fatal("the node call was cancelled while a caller was still waiting for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+	err = <-errs
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("the second caller received %v, expected it to be cancelled", err)
+	}
+	select {
+	case <-btcNode.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the node call was not cancelled after every caller left")
+	}
+
+	// a later caller does not join the cancelled call
+	close(btcNode.release)
+	rawTx, err := ln.getTx(context.Background(), testFixturesTxId)
+	if err != nil || rawTx.TxId != testFixturesTxId || btcNode.getCalls() != 2 {
+		t.Errorf("a new caller received %v after %d node calls", err, btcNode.getCalls())
+	}
+}
+
+// the transactions are requested by one worker per slot, so no more calls are made or waiting than there are slots
+func TestLimitedNodeSlots(t *testing.T) {
+
+	btcNode := newLimitedTestNode()
+	ln := newLimitedNode(btcNode, 3, 0)
+	btcNode.flights = &ln.flights
+
+	txIds := make([]string, 20)
+	for i := range txIds {
+		txIds[i] = fmt.Sprintf("%064x", i)
+	}
+
+	go func() {
+		for {
+			select {
+			case btcNode.release <- struct{}{}:
+				time.Sleep(time.Millisecond)
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	rawTxs, err := ln.getTxs(context.Background(), txIds)
+	if err != nil || len(rawTxs) != len(txIds) {
+		t.Fatalf("received %d txs, %v, expected %d", len(rawTxs), err, len(txIds))
+	}
+
+	btcNode.mutex.Lock()
+	defer btcNode.mutex.Unlock()
+	if btcNode.maxActive != 3 || btcNode.maxFlight > 3 {
+		t.Errorf("%d node calls were active and %d requests were waiting at the same time, expected 3 and at most 3", btcNode.maxActive, btcNode.maxFlight)
+	}
+}
+
+// requests beyond the burst wait for the rate limit
+func TestLimitedNodeRate(t *testing.T) {
+
+	ln := newLimitedNode(newLimitedTestNode(), 0, 20)
+
+	start := time.Now()
+	for r := 0; r < 25; r++ {
+		_, err := ln.getBestBlockHash(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the burst of 20 is sent at once and the other 5 are sent 50 ms apart
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("25 requests took %s at 20 requests per second", elapsed)
+	}
+}
@@ -0,0 +1,315 @@
+package node
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// a least recently used cache of blocks and transactions with a memory budget
+// the size of each block and transaction is estimated when it is cached, and the least recently used entries
+// are removed whenever the estimated size of the cache is over the budget
+
+// estimated memory used by the fixed fields of each type, including map and list overhead
+const MEMORY_CACHE_BLOCK_OVERHEAD = 400
+const MEMORY_CACHE_TX_OVERHEAD = 400
+const MEMORY_CACHE_INPUT_OVERHEAD = 500 // includes the previous output, which is added after the transaction is cached
+const MEMORY_CACHE_OUTPUT_OVERHEAD = 150
+
+type memoryCacheEntry struct {
+	block btc.Block
+	tx    btc.Tx
+	size  uint64
+}
+
+// CacheStats is a snapshot of the memory cache counters
+type CacheStats struct {
+	caching     bool
+	maxSize     uint64
+	size        uint64
+	blockCount  int
+	txCount     int
+	blockHits   uint64
+	blockMisses uint64
+	txHits      uint64
+	txMisses    uint64
+	evictions   uint64
+}
+
+func (cs *CacheStats) IsCachingOn() bool {
+	return cs.caching
+}
+
+func (cs *CacheStats) GetMaxSize() uint64 {
+	return cs.maxSize
+}
+
+func (cs *CacheStats) GetSize() uint64 {
+	return cs.size
+}
+
+func (cs *CacheStats) GetBlockCount() int {
+	return cs.blockCount
+}
+
+func (cs *CacheStats) GetTxCount() int {
+	return cs.txCount
+}
+
+func (cs *CacheStats) GetBlockHits() uint64 {
+	return cs.blockHits
+}
+
+func (cs *CacheStats) GetBlockMisses() uint64 {
+	return cs.blockMisses
+}
+
+func (cs *CacheStats) GetTxHits() uint64 {
+	return cs.txHits
+}
+
+func (cs *CacheStats) GetTxMisses() uint64 {
+	return cs.txMisses
+}
+
+func (cs *CacheStats) GetEvictions() uint64 {
+	return cs.evictions
+}
+
+type memoryCache struct {
+	mutex   sync.Mutex
+	maxSize uint64
+	size    uint64
+
+	// the front of the list is the most recently used entry
+	lru     *list.List
+	blocks  map[string]*list.Element // block hash -> entry
+	heights map[uint32]string        // block height -> block hash
+	txs     map[string]*list.Element // tx id -> entry
+
+	stats CacheStats
+}
+
+func newMemoryCache(maxSize uint64) *memoryCache {
+	return &memoryCache{maxSize: maxSize, lru: list.New(), blocks: make(map[string]*list.Element), heights: make(map[uint32]string), txs: make(map[string]*list.Element)}
+}
+
+func estimateBlockSize(block btc.Block) uint64 {
+	return MEMORY_CACHE_BLOCK_OVERHEAD + uint64(len(block.GetTxIds()))*(64+16)
+}
+
+// script bytes are counted twice because parsed scripts keep a copy of each field
+func estimateTxSize(tx btc.Tx) uint64 {
+
+	size := uint64(MEMORY_CACHE_TX_OVERHEAD)
+	for _, input := range tx.GetInputs() {
+		inputScript := input.GetInputScript()
+		size += MEMORY_CACHE_INPUT_OVERHEAD + uint64(len(inputScript.AsBytes()))*2
+
+		segwit := input.GetSegwit()
+		for _, field := range segwit.GetFields() {
+			size += 64 + uint64(len(field.AsBytes()))*2
+		}
+	}
+	for _, output := range tx.GetOutputs() {
+		outputScript := output.GetOutputScript()
+		size += MEMORY_CACHE_OUTPUT_OVERHEAD + uint64(len(outputScript.AsBytes()))*2
+	}
+	return size
+}
+
+// returns a nil block if the block is not cached
+func (mc *memoryCache) getBlock(blockHash string) btc.Block {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.blocks[blockHash]
+	if !found {
+		mc.stats.blockMisses++
+		return btc.Block{}
+	}
+
+	mc.stats.blockHits++
+	mc.lru.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).block
+}
+
+// returns an empty string if no block at this height is cached
+func (mc *memoryCache) getBlockHash(blockHeight uint32) string {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return mc.heights[blockHeight]
+}
+
+// used to check cached blocks against the chain, so it is not counted as a use of the block
+func (mc *memoryCache) getBlockAtHeight(blockHeight uint32) btc.Block {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.blocks[mc.heights[blockHeight]]
+	if !found {
+		return btc.Block{}
+	}
+	return element.Value.(*memoryCacheEntry).block
+}
+
+// returns a nil tx if the tx is not cached
+func (mc *memoryCache) getTx(txId string) btc.Tx {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.txs[txId]
+	if !found {
+		mc.stats.txMisses++
+		return btc.Tx{}
+	}
+
+	mc.stats.txHits++
+	mc.lru.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).tx
+}
+
+func (mc *memoryCache) putBlock(block btc.Block) {
+
+	if block.IsNil() {
+		return
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	// a block that was requested again replaces the cached block, since it might have a new next block or have been orphaned
+	element, found := mc.blocks[block.GetHash()]
+	if found {
+		mc.lru.MoveToFront(element)
+		element.Value.(*memoryCacheEntry).block = block
+		if !block.IsOrphaned() {
+			mc.heights[block.GetHeight()] = block.GetHash()
+		} else if mc.heights[block.GetHeight()] == block.GetHash() {
+			delete(mc.heights, block.GetHeight())
+		}
+		return
+	}
+
+	entry := memoryCacheEntry{block: block, size: estimateBlockSize(block)}
+	mc.blocks[block.GetHash()] = mc.lru.PushFront(&entry)
+	if !block.IsOrphaned() {
+		mc.heights[block.GetHeight()] = block.GetHash()
+	}
+	mc.size += entry.size
+
+	mc.evict()
+}
+
+func (mc *memoryCache) putTx(tx btc.Tx) {
+
+	if tx.IsNil() {
+		return
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.txs[tx.GetTxId()]
+	if found {
+		mc.lru.MoveToFront(element)
+		return
+	}
+
+	entry := memoryCacheEntry{tx: tx, size: estimateTxSize(tx)}
+	mc.txs[tx.GetTxId()] = mc.lru.PushFront(&entry)
+	mc.size += entry.size
+
+	mc.evict()
+}
+
+// removes a block, and its transactions if withTxs is true
+func (mc *memoryCache) removeBlock(blockHash string, withTxs bool) {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.blocks[blockHash]
+	if !found {
+		return
+	}
+
+	if withTxs {
+		mc.removeTxs(element.Value.(*memoryCacheEntry).block)
+	}
+	mc.remove(element)
+}
+
+// keeps the block so that it can still be found by its hash, but removes it from its height and removes its transactions
+func (mc *memoryCache) orphanBlock(blockHash string) {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	element, found := mc.blocks[blockHash]
+	if !found {
+		return
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+	entry.block.SetOrphaned(true)
+	if mc.heights[entry.block.GetHeight()] == blockHash {
+		delete(mc.heights, entry.block.GetHeight())
+	}
+	mc.removeTxs(entry.block)
+}
+
+// the mutex must be locked
+func (mc *memoryCache) removeTxs(block btc.Block) {
+	for _, txId := range block.GetTxIds() {
+		txElement, found := mc.txs[txId]
+		if found {
+			mc.remove(txElement)
+		}
+	}
+}
+
+// the mutex must be locked
+func (mc *memoryCache) remove(element *list.Element) {
+
+	entry := mc.lru.Remove(element).(*memoryCacheEntry)
+	mc.size -= entry.size
+
+	if entry.block.IsNil() {
+		delete(mc.txs, entry.tx.GetTxId())
+		return
+	}
+
+	blockHash := entry.block.GetHash()
+	delete(mc.blocks, blockHash)
+	if mc.heights[entry.block.GetHeight()] == blockHash {
+		delete(mc.heights, entry.block.GetHeight())
+	}
+}
+
+// removes the least recently used entries until the cache is within its budget
+// the mutex must be locked
+func (mc *memoryCache) evict() {
+	for mc.size > mc.maxSize && mc.lru.Len() > 0 {
+		mc.remove(mc.lru.Back())
+		mc.stats.evictions++
+	}
+}
+
+func (mc *memoryCache) getStats() CacheStats {
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	stats := mc.stats
+	stats.caching = true
+	stats.maxSize = mc.maxSize
+	stats.size = mc.size
+	stats.blockCount = len(mc.blocks)
+	stats.txCount = len(mc.txs)
+	return stats
+}
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+func newMemoryCacheTestBlock(height uint32, txIds []string) btc.Block {
+	return btc.NewBlock(getZmqTestBlockHash(height), getZmqTestBlockHash(height-1), "", height, 1, 0, txIds)
+}
+
+// counted as a use of the block or tx
+func isMemoryCacheTestBlockCached(mc *memoryCache, blockHash string) bool {
+	block := mc.getBlock(blockHash)
+	return !block.IsNil()
+}
+
+func isMemoryCacheTestTxCached(mc *memoryCache, txId string) bool {
+	tx := mc.getTx(txId)
+	return !tx.IsNil()
+}
+
+func checkMemoryCacheStats(t *testing.T, mc *memoryCache, expected CacheStats) {
+	stats := mc.getStats()
+	if stats != expected {
+		t.Errorf("the cache stats are %+v, expected %+v", stats, expected)
+	}
+	if stats.GetSize() > stats.GetMaxSize() {
+		t.Errorf("the cache size %d is over the limit of %d", stats.GetSize(), stats.GetMaxSize())
+	}
+}
+
+// the least recently used entries are removed when the estimated size is over the budget
+func TestMemoryCacheEviction(t *testing.T) {
+
+	txId := fmt.Sprintf("%064x", 1)
+	p2pkhScript := btc.NewScript(append(append([]byte{0x76, 0xa9, 0x14}, make([]byte, 20)...), 0x88, 0xac))
+	inputs := []btc.Input{btc.NewInput(false, testFixturesPreviousTxId, 0, btc.NewScript([]byte{0x51}), btc.Segwit{}, 0xffffffff, btc.Output{})}
+	tx := btc.NewTx(txId, 1, inputs, []btc.Output{btc.NewOutput(1000, p2pkhScript, "")}, 0, false, false, getZmqTestBlockHash(1), 0)
+	txSize := uint64(MEMORY_CACHE_TX_OVERHEAD + MEMORY_CACHE_INPUT_OVERHEAD + 2 + MEMORY_CACHE_OUTPUT_OVERHEAD + 2*25)
+	if estimateTxSize(tx) != txSize {
+		t.Fatalf("the size of the tx is estimated as %d, expected %d", estimateTxSize(tx), txSize)
+	}
+
+	// block 1 has the tx, blocks 2 to 4 have none
+	block1 := newMemoryCacheTestBlock(1, []string{txId})
+	block1Size := uint64(MEMORY_CACHE_BLOCK_OVERHEAD + 64 + 16)
+	blockSize := uint64(MEMORY_CACHE_BLOCK_OVERHEAD)
+	maxSize := block1Size + 2*blockSize + txSize - 1
+	mc := newMemoryCache(maxSize)
+
+	mc.putBlock(block1)
+	mc.putBlock(newMemoryCacheTestBlock(2, nil))
+	mc.putBlock(newMemoryCacheTestBlock(3, nil))
+	checkMemoryCacheStats(t, mc, CacheStats{caching: true, maxSize: maxSize, size: block1Size + 2*blockSize, blockCount: 3})
+
+	// block 1 is used, so block 2 is the least recently used when the tx does not fit
+	if !isMemoryCacheTestBlockCached(mc, block1.GetHash()) || isMemoryCacheTestTxCached(mc, txId) {
+		t.Fatal("block 1 is not cached, or its tx is cached before it was added")
+	}
+	mc.putTx(tx)
+	if isMemoryCacheTestBlockCached(mc, getZmqTestBlockHash(2)) || len(mc.getBlockHash(2)) != 0 {
+		t.Error("block 2 was not evicted")
+	}
+	checkMemoryCacheStats(t, mc, CacheStats{caching: true, maxSize: maxSize, size: block1Size + blockSize + txSize, blockCount: 2, txCount: 1,
+		blockHits: 1, blockMisses: 1, txMisses: 1, evictions: 1})
+
+	// the tx is used, so block 3 is evicted next, then block 1
+	if !isMemoryCacheTestTxCached(mc, txId) {
+		t.Fatal("the tx is not cached")
+	}
+	mc.putBlock(newMemoryCacheTestBlock(4, nil))
+	if len(mc.getBlockHash(3)) != 0 || mc.getBlockHash(1) != block1.GetHash() {
+		t.Error("block 3 was not the block evicted")
+	}
+	mc.putBlock(newMemoryCacheTestBlock(5, nil))
+	for _, height := range []uint32{1, 2, 3} {
+		if len(mc.getBlockHash(height)) != 0 {
+			t.Errorf("block %d is still cached", height)
+		}
+	}
+	if mc.getBlockHash(4) != getZmqTestBlockHash(4) || mc.getBlockHash(5) != getZmqTestBlockHash(5) || !isMemoryCacheTestTxCached(mc, txId) {
+		t.Error("blocks 4 and 5 and the tx are not all cached")
+	}
+	checkMemoryCacheStats(t, mc, CacheStats{caching: true, maxSize: maxSize, size: 2*blockSize + txSize, blockCount: 2, txCount: 1,
+		blockHits: 1, blockMisses: 1, txHits: 2, txMisses: 1, evictions: 3})
+}
+
+// a block that is orphaned is still found by its hash, but not by its height, and its transactions are removed
+func TestMemoryCacheOrphanBlock(t *testing.T) {
+
+	txIds := []string{fmt.Sprintf("%064x", 1), fmt.Sprintf("%064x", 2)}
+	mc := newMemoryCache(1 << 20)
+	block := newMemoryCacheTestBlock(1, txIds)
+	mc.putBlock(block)
+	txSize := uint64(0)
+	for _, txId := range txIds {
+		tx := btc.NewTx(txId, 1, nil, nil, 0, false, false, block.GetHash(), 0)
+		mc.putTx(tx)
+		txSize += estimateTxSize(tx)
+	}
+
+	mc.orphanBlock(block.GetHash())
+	orphanedBlock := mc.getBlock(block.GetHash())
+	if orphanedBlock.IsNil() || !orphanedBlock.IsOrphaned() || len(mc.getBlockHash(1)) != 0 {
+		t.Error("the orphaned block was removed, or it is still found by its height")
+	}
+	checkMemoryCacheStats(t, mc, CacheStats{caching: true, maxSize: 1 << 20, size: estimateBlockSize(block), blockCount: 1, blockHits: 1})
+
+	mc.removeBlock(block.GetHash(), true)
+	checkMemoryCacheStats(t, mc, CacheStats{caching: true, maxSize: 1 << 20, blockHits: 1})
+
+	// the stats are all zero without a memory cache
+	c := btcCache{}
+	stats := c.getStats()
+	if stats != (CacheStats{}) {
+		t.Errorf("the stats without a memory cache are %+v", stats)
+	}
+}
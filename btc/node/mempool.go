@@ -0,0 +1,223 @@
+package node
+
+import (
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// mempool data for an unconfirmed transaction
+// ancestor and descendant counts and sizes include the transaction itself
+// values that the backend does not report are zero
+
+type MempoolEntry struct {
+	txId            string
+	fee             uint64
+	vsize           uint32
+	weight          uint32
+	timeSeen        int64
+	height          uint32 // the block height when the transaction entered the mempool
+	ancestorCount   uint32
+	ancestorSize    uint32
+	ancestorFee     uint64
+	descendantCount uint32
+	descendantSize  uint32
+	descendantFee   uint64
+	depends         []string
+	spentBy         []string
+}
+
+func (me *MempoolEntry) IsNil() bool {
+	return len(me.txId) == 0
+}
+
+func (me *MempoolEntry) GetTxId() string {
+	return me.txId
+}
+
+func (me *MempoolEntry) GetFee() uint64 {
+	return me.fee
+}
+
+func (me *MempoolEntry) GetVsize() uint32 {
+	return me.vsize
+}
+
+func (me *MempoolEntry) GetWeight() uint32 {
+	return me.weight
+}
+
+// satoshis per virtual byte
+func (me *MempoolEntry) GetFeeRate() float64 {
+	if me.vsize == 0 {
+		return 0
+	}
+	return float64(me.fee) / float64(me.vsize)
+}
+
+func (me *MempoolEntry) GetTimeSeen() int64 {
+	return me.timeSeen
+}
+
+func (me *MempoolEntry) GetHeight() uint32 {
+	return me.height
+}
+
+func (me *MempoolEntry) GetAncestorCount() uint32 {
+	return me.ancestorCount
+}
+
+func (me *MempoolEntry) GetAncestorSize() uint32 {
+	return me.ancestorSize
+}
+
+func (me *MempoolEntry) GetAncestorFee() uint64 {
+	return me.ancestorFee
+}
+
+func (me *MempoolEntry) GetDescendantCount() uint32 {
+	return me.descendantCount
+}
+
+func (me *MempoolEntry) GetDescendantSize() uint32 {
+	return me.descendantSize
+}
+
+func (me *MempoolEntry) GetDescendantFee() uint64 {
+	return me.descendantFee
+}
+
+// unconfirmed transactions that this transaction spends
+func (me *MempoolEntry) GetDepends() []string {
+	return me.depends
+}
+
+// unconfirmed transactions that spend this transaction
+func (me *MempoolEntry) GetSpentBy() []string {
+	return me.spentBy
+}
+
+// the raw entry has the structure of the response from Bitcoin Core's getmempoolentry
+func makeMempoolEntry(txId string, rawEntry *nodeMempoolEntry) MempoolEntry {
+
+	entry := MempoolEntry{txId: txId,
+		vsize:           rawEntry.Vsize,
+		weight:          rawEntry.Weight,
+		timeSeen:        rawEntry.Time,
+		height:          rawEntry.Height,
+		ancestorCount:   rawEntry.AncestorCount,
+		ancestorSize:    rawEntry.AncestorSize,
+		descendantCount: rawEntry.DescendantCount,
+		descendantSize:  rawEntry.DescendantSize,
+		depends:         rawEntry.Depends,
+		spentBy:         rawEntry.SpentBy}
+
+	if rawEntry.Fees != nil {
+		entry.fee = uint64(rawEntry.Fees.Base)
+		entry.ancestorFee = uint64(rawEntry.Fees.Ancestor)
+		entry.descendantFee = uint64(rawEntry.Fees.Descendant)
+	} else {
+		// older versions of Bitcoin Core report the fees at the top level
+		entry.fee = uint64(rawEntry.Fee)
+		entry.ancestorFee = uint64(rawEntry.AncestorFees)
+		entry.descendantFee = uint64(rawEntry.DescendantFees)
+	}
+
+	if entry.depends == nil {
+		entry.depends = []string{}
+	}
+	if entry.spentBy == nil {
+		entry.spentBy = []string{}
+	}
+
+	return entry
+}
+
+// a summary of the transactions in the mempool
+// the distributions are calculated from a sample of the transactions, since every transaction requires its previous outputs
+
+type MempoolSummary struct {
+	txCount        uint32
+	sampledTxCount uint32
+	inputCount     uint32
+	outputCount    uint32
+	totalFee       uint64
+	spendTypes     map[string]uint32
+	outputTypes    map[string]uint32
+}
+
+// the number of transactions in the mempool
+func (ms *MempoolSummary) GetTxCount() uint32 {
+	return ms.txCount
+}
+
+// the number of transactions the distributions were calculated from
+func (ms *MempoolSummary) GetSampledTxCount() uint32 {
+	return ms.sampledTxCount
+}
+
+func (ms *MempoolSummary) GetInputCount() uint32 {
+	return ms.inputCount
+}
+
+func (ms *MempoolSummary) GetOutputCount() uint32 {
+	return ms.outputCount
+}
+
+// the total fee paid by the sampled transactions
+func (ms *MempoolSummary) GetTotalFee() uint64 {
+	return ms.totalFee
+}
+
+func (ms *MempoolSummary) GetSpendTypes() map[string]uint32 {
+	return ms.spendTypes
+}
+
+func (ms *MempoolSummary) GetOutputTypes() map[string]uint32 {
+	return ms.outputTypes
+}
+
+func (ms *MempoolSummary) addTx(tx btc.Tx) {
+
+	ms.sampledTxCount++
+
+	valueIn := uint64(0)
+	for _, input := range tx.GetInputs() {
+		ms.inputCount++
+		ms.spendTypes[input.GetSpendType()]++
+
+		previousOutput := input.GetPreviousOutput()
+		valueIn += previousOutput.GetValue()
+	}
+
+	valueOut := uint64(0)
+	for _, output := range tx.GetOutputs() {
+		ms.outputCount++
+		ms.outputTypes[output.GetOutputType()]++
+		valueOut += output.GetValue()
+	}
+
+	if valueIn > valueOut {
+		ms.totalFee += valueIn - valueOut
+	}
+}
+
+// transactions that left the mempool while the summary was being created are not included
+func makeMempoolSummary(txIds []string, maxTxCount int, getTxs func(txIds []string) ([]btc.Tx, error)) (MempoolSummary, error) {
+
+	summary := MempoolSummary{txCount: uint32(len(txIds)), spendTypes: make(map[string]uint32), outputTypes: make(map[string]uint32)}
+
+	sampleTxIds := txIds
+	if maxTxCount >= 0 && len(sampleTxIds) > maxTxCount {
+		sampleTxIds = sampleTxIds[:maxTxCount]
+	}
+
+	txs, err := getTxs(sampleTxIds)
+	if err != nil {
+		return MempoolSummary{}, err
+	}
+
+	for _, tx := range txs {
+		summary.addTx(tx)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// a mempool transaction with one P2PKH input of 10000 sats, with a P2PKH output and an OP_RETURN output worth 10000 - fee
+func newMempoolTestTx(txId string, fee uint64) btc.Tx {
+
+	p2pkhScript := btc.NewScript(append(append([]byte{0x76, 0xa9, 0x14}, make([]byte, 20)...), 0x88, 0xac))
+	inputs := []btc.Input{btc.NewInput(false, testFixturesPreviousTxId, 0, btc.NewScript(nil), btc.Segwit{}, 0xffffffff, btc.Output{})}
+	outputs := []btc.Output{btc.NewOutput(10000-fee, p2pkhScript, ""), btc.NewOutput(0, btc.NewScript([]byte{0x6a}), "")}
+
+	tx := btc.NewTx(txId, 2, inputs, outputs, 0, false, false, "", 0)
+	tx.SetPreviousOutput(0, btc.NewOutput(10000, p2pkhScript, ""))
+	return tx
+}
+
+func TestMempoolSummary(t *testing.T) {
+
+	txIds := []string{"01", "02", "03", "04", "05"}
+	fees := map[string]uint64{"01": 100, "02": 200, "03": 300, "04": 400, "05": 500}
+
+	// the stub returns every transaction except 02, which has left the mempool
+	var requestedTxIds []string
+	getTxs := func(txIds []string) ([]btc.Tx, error) {
+		requestedTxIds = txIds
+		txs := make([]btc.Tx, 0, len(txIds))
+		for _, txId := range txIds {
+			if txId != "02" {
+				txs = append(txs, newMempoolTestTx(txId, fees[txId]))
+			}
+		}
+		return txs, nil
+	}
+
+	summaryTests := []struct {
+		maxTxCount     int
+		requestedTxIds []string
+		sampledTxCount uint32
+		totalFee       uint64
+	}{
+		{-1, txIds, 4, 1300},
+		{10, txIds, 4, 1300},
+		{3, txIds[:3], 2, 400},
+		{0, txIds[:0], 0, 0},
+	}
+
+	for _, summaryTest := range summaryTests {
+		summary, err := makeMempoolSummary(txIds, summaryTest.maxTxCount, getTxs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(requestedTxIds, summaryTest.requestedTxIds) {
+			t.Errorf("max %d: requested txs %v, expected %v", summaryTest.maxTxCount, requestedTxIds, summaryTest.requestedTxIds)
+		}
+
+		// the transaction count is the size of the mempool, the distributions are counted from the sample
+		sampledTxCount := summaryTest.sampledTxCount
+		if summary.GetTxCount() != 5 || summary.GetSampledTxCount() != sampledTxCount || summary.GetTotalFee() != summaryTest.totalFee {
+			t.Errorf("max %d: counted %d txs, sampled %d with fee %d, expected 5, %d and %d", summaryTest.maxTxCount, summary.GetTxCount(), summary.GetSampledTxCount(), summary.GetTotalFee(), sampledTxCount, summaryTest.totalFee)
+		}
+		if summary.GetInputCount() != sampledTxCount || summary.GetOutputCount() != 2*sampledTxCount {
+			t.Errorf("max %d: counted %d inputs and %d outputs", summaryTest.maxTxCount, summary.GetInputCount(), summary.GetOutputCount())
+		}
+
+		expectedSpendTypes := map[string]uint32{}
+		expectedOutputTypes := map[string]uint32{}
+		if sampledTxCount > 0 {
+			expectedSpendTypes[btc.OUTPUT_TYPE_P2PKH] = sampledTxCount
+			expectedOutputTypes[btc.OUTPUT_TYPE_P2PKH] = sampledTxCount
+			expectedOutputTypes[btc.OUTPUT_TYPE_OP_RETURN] = sampledTxCount
+		}
+		if !reflect.DeepEqual(summary.GetSpendTypes(), expectedSpendTypes) || !reflect.DeepEqual(summary.GetOutputTypes(), expectedOutputTypes) {
+			t.Errorf("max %d: counted spend types %v and output types %v", summaryTest.maxTxCount, summary.GetSpendTypes(), summary.GetOutputTypes())
+		}
+	}
+
+	failedGetTxs := func(txIds []string) ([]btc.Tx, error) {
+		return nil, errors.New("the node is not available")
+	}
+	_, err := makeMempoolSummary(txIds, -1, failedGetTxs)
+	if err == nil {
+		t.Error("the summary did not return the error from the node")
+	}
+}
@@ -0,0 +1,542 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// sends requests to several nodes, which can be of different types
+// every node is checked periodically, and a node is only used while it is available and its tip is close to the highest tip
+// a request that fails on one node is tried on the next one, including requests for blocks and transactions that are not found,
+// since a node that is behind or a node type without a transaction index might not have them
+// requests for the chain tip always go to the first usable node in the list, so the tip does not move back and forth between nodes
+// nodes that can not be connected to at startup are connected to by the health checks once they are available
+// each node has its own node-max-concurrent-requests and node-max-requests-per-second limits
+
+// the weight of each new latency measurement in the moving average
+const NODE_LATENCY_WEIGHT = 0.2
+
+// NodeStatus is the state of one of the nodes from the nodes setting, as of its last health check or request
+type NodeStatus struct {
+	nodeType    string
+	location    string
+	version     string
+	connected   bool
+	healthy     bool
+	inSync      bool
+	tipHash     string
+	tipHeight   int64 // -1 if unknown
+	latency     time.Duration
+	lastError   string
+	lastChecked time.Time
+}
+
+func (ns *NodeStatus) GetNodeType() string {
+	return ns.nodeType
+}
+
+// the location does not include credentials
+func (ns *NodeStatus) GetLocation() string {
+	return ns.location
+}
+
+func (ns *NodeStatus) GetVersion() string {
+	return ns.version
+}
+
+func (ns *NodeStatus) IsConnected() bool {
+	return ns.connected
+}
+
+func (ns *NodeStatus) IsHealthy() bool {
+	return ns.healthy
+}
+
+// a node is in sync if its tip is within node-max-tip-lag blocks of the highest tip and it agrees with the other nodes at its height
+func (ns *NodeStatus) IsInSync() bool {
+	return ns.inSync
+}
+
+func (ns *NodeStatus) GetTipHash() string {
+	return ns.tipHash
+}
+
+func (ns *NodeStatus) GetTipHeight() int64 {
+	return ns.tipHeight
+}
+
+// the moving average of the time taken by requests
+func (ns *NodeStatus) GetLatency() time.Duration {
+	return ns.latency
+}
+
+func (ns *NodeStatus) GetLastError() string {
+	return ns.lastError
+}
+
+func (ns *NodeStatus) GetLastChecked() time.Time {
+	return ns.lastChecked
+}
+
+type nodeBackend struct {
+	endpoint app.NodeEndpoint
+
+	mutex  sync.Mutex
+	client *limitedNode // nil until the node has been connected to
+	status NodeStatus
+}
+
+func (nb *nodeBackend) getClient() *limitedNode {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+	return nb.client
+}
+
+func (nb *nodeBackend) getStatus() NodeStatus {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+	return nb.status
+}
+
+func (nb *nodeBackend) recordLatency(latency time.Duration) {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+	if nb.status.latency == 0 {
+		nb.status.latency = latency
+	} else {
+		nb.status.latency = time.Duration(NODE_LATENCY_WEIGHT*float64(latency) + (1-NODE_LATENCY_WEIGHT)*float64(nb.status.latency))
+	}
+}
+
+// the node is not used again until a health check succeeds
+func (nb *nodeBackend) markUnhealthy(err error) {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+	if nb.status.healthy {
+		fmt.Println(fmt.Sprintf("Node %s is not available: %s", nb.status.location, err.Error()))
+	}
+	nb.status.healthy = false
+	nb.status.lastError = err.Error()
+}
+
+type multiNode struct {
+	backends  []*nodeBackend
+	routing   string
+	maxTipLag uint32
+
+	nextBackend  atomic.Uint32 // for round-robin routing
+	disagreement string        // the last disagreement about the chain tip that was reported
+}
+
+func newMultiNode(endpoints []app.NodeEndpoint) *multiNode {
+
+	mn := multiNode{routing: app.Settings.GetNodeRouting(), maxTipLag: app.Settings.GetNodeMaxTipLag()}
+	for _, endpoint := range endpoints {
+		backend := nodeBackend{endpoint: endpoint}
+		backend.status = NodeStatus{nodeType: endpoint.GetNodeType(), location: endpoint.GetDisplayLocation(), tipHeight: -1}
+		mn.backends = append(mn.backends, &backend)
+	}
+
+	mn.checkHealth()
+	go mn.runHealthChecks(time.Duration(app.Settings.GetNodeHealthCheckIntervalSeconds()) * time.Second)
+
+	return &mn
+}
+
+// credentials in a Bitcoin Core url are used instead of the credentials from the settings
+func connectNode(endpoint app.NodeEndpoint) (nodeClient, error) {
+
+	location := endpoint.GetLocation()
+
+	switch endpoint.GetNodeType() {
+	case app.NODE_TYPE_BitcoinCore:
+		nodeUrl, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		nodeCredentials := getNodeCredentials()
+		if nodeUrl.User != nil {
+			password, _ := nodeUrl.User.Password()
+			nodeCredentials = newNodeCredentials(nodeUrl.User.Username(), password, "")
+			nodeUrl.User = nil
+		}
+		return NewBitcoinCore(nodeUrl.String(), nodeCredentials)
+	case app.NODE_TYPE_BitcoinCoreRest:
+		return NewBitcoinCoreRest(location)
+	case app.NODE_TYPE_Esplora:
+		return NewEsplora(location)
+	case app.NODE_TYPE_BlockFiles:
+		return NewBlockFiles(location)
+	}
+
+	return nil, errors.New("Unsupported node type " + endpoint.GetNodeType())
+}
+
+func (mn *multiNode) runHealthChecks(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		mn.checkHealth()
+	}
+}
+
+// checks the tip of every node at the same time and then compares the tips
+func (mn *multiNode) checkHealth() {
+
+	var wg sync.WaitGroup
+	for _, backend := range mn.backends {
+		wg.Add(1)
+		go func(backend *nodeBackend) {
+			defer wg.Done()
+			mn.checkBackend(backend)
+		}(backend)
+	}
+	wg.Wait()
+
+	mn.checkConsistency()
+}
+
+func (mn *multiNode) checkBackend(backend *nodeBackend) {
+
+	// nodes that were not available at startup are connected to when they become available
+	client := backend.getClient()
+	if client == nil {
+		newClient, err := connectNode(backend.endpoint)
+		if err != nil {
+			backend.mutex.Lock()
+			backend.status.lastError = err.Error()
+			backend.status.lastChecked = time.Now()
+			backend.mutex.Unlock()
+			return
+		}
+
+		client = newSettingsLimitedNode(newClient)
+		backend.mutex.Lock()
+		backend.client = client
+		backend.status.connected = true
+		backend.status.version = newClient.GetVersionString()
+		backend.mutex.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(app.Settings.GetNodeTimeoutSeconds())*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	tipHash, err := client.getBestBlockHash(ctx)
+	var tipBlock *nodeBlock
+	if err == nil {
+		tipBlock, err = client.getBlock(ctx, tipHash, false)
+	}
+
+	backend.mutex.Lock()
+	backend.status.lastChecked = time.Now()
+	backend.mutex.Unlock()
+
+	if err != nil {
+		backend.markUnhealthy(err)
+		return
+	}
+
+	// the health check sends two requests
+	backend.recordLatency(time.Since(start) / 2)
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	if !backend.status.healthy {
+		fmt.Println(fmt.Sprintf("Node %s is available.", backend.status.location))
+	}
+	backend.status.healthy = true
+	backend.status.lastError = ""
+	backend.status.tipHash = tipHash
+	backend.status.tipHeight = int64(tipBlock.Height)
+}
+
+// nodes that are more than maxTipLag blocks behind the highest tip are not in sync
+// when nodes at the same height have different tips, the tip reported by the most nodes is used, or else the tip of the first of them in the list
+func (mn *multiNode) checkConsistency() {
+
+	statuses := make([]NodeStatus, len(mn.backends))
+	highestTip := int64(-1)
+	for b, backend := range mn.backends {
+		statuses[b] = backend.getStatus()
+		if statuses[b].healthy && statuses[b].tipHeight > highestTip {
+			highestTip = statuses[b].tipHeight
+		}
+	}
+
+	// count the nodes that report each tip at each height
+	tipCounts := make(map[int64]map[string]int)
+	for _, status := range statuses {
+		if !status.healthy {
+			continue
+		}
+		if tipCounts[status.tipHeight] == nil {
+			tipCounts[status.tipHeight] = make(map[string]int)
+		}
+		tipCounts[status.tipHeight][status.tipHash]++
+	}
+
+	agreedTips := make(map[int64]string)
+	disagreements := make([]string, 0)
+	for _, status := range statuses {
+		if !status.healthy {
+			continue
+		}
+		agreedTip, found := agreedTips[status.tipHeight]
+		if !found || tipCounts[status.tipHeight][status.tipHash] > tipCounts[status.tipHeight][agreedTip] {
+			agreedTips[status.tipHeight] = status.tipHash
+		}
+		if found && agreedTip != status.tipHash {
+			disagreements = append(disagreements, fmt.Sprintf("%s has %s at height %d", status.location, status.tipHash, status.tipHeight))
+		}
+	}
+
+	for b, backend := range mn.backends {
+		status := statuses[b]
+		inSync := status.healthy && status.tipHeight+int64(mn.maxTipLag) >= highestTip && agreedTips[status.tipHeight] == status.tipHash
+
+		backend.mutex.Lock()
+		backend.status.inSync = inSync
+		backend.mutex.Unlock()
+	}
+
+	// a disagreement is only reported when it changes
+	disagreement := strings.Join(disagreements, ", ")
+	if disagreement != mn.disagreement && len(disagreement) > 0 {
+		fmt.Println("The nodes do not agree on the chain tip: " + disagreement + ". Requests are not sent to those nodes.")
+	}
+	mn.disagreement = disagreement
+}
+
+// returns the nodes to send a request to, in the order they are tried
+// nodes that are healthy and in sync are ordered by the routing, and they are followed by the other healthy nodes and then by every other connected node
+// requests for the tip always start with the first usable node in the list
+func (mn *multiNode) getRoute(forTip bool) []*nodeBackend {
+
+	inSync := make([]*nodeBackend, 0, len(mn.backends))
+	healthy := make([]*nodeBackend, 0, len(mn.backends))
+	others := make([]*nodeBackend, 0, len(mn.backends))
+	latencies := make(map[*nodeBackend]time.Duration)
+	for _, backend := range mn.backends {
+		status := backend.getStatus()
+		switch {
+		case !status.connected:
+		case status.healthy && status.inSync:
+			inSync = append(inSync, backend)
+			latencies[backend] = status.latency
+		case status.healthy:
+			healthy = append(healthy, backend)
+		default:
+			others = append(others, backend)
+		}
+	}
+
+	if !forTip && len(inSync) > 1 {
+		switch mn.routing {
+		case app.NODE_ROUTING_RoundRobin:
+			first := int(mn.nextBackend.Add(1) % uint32(len(inSync)))
+			inSync = append(inSync[first:], inSync[:first]...)
+		case app.NODE_ROUTING_LeastLatency:
+			sort.SliceStable(inSync, func(a, b int) bool { return latencies[inSync[a]] < latencies[inSync[b]] })
+		}
+	}
+
+	return append(append(inSync, healthy...), others...)
+}
+
+// calls the function with each node in the route until it succeeds
+// if every node fails, the first error that is not a not found error is returned, since the request might succeed later
+func (mn *multiNode) do(ctx context.Context, forTip bool, call func(client *limitedNode) error) error {
+
+	var notFoundErr error
+	var firstErr error
+	for _, backend := range mn.getRoute(forTip) {
+		start := time.Now()
+		err := call(backend.getClient())
+		if err == nil {
+			backend.recordLatency(time.Since(start))
+			return nil
+		}
+
+		// the caller is no longer waiting
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if GetErrorType(err) == ERROR_TYPE_Unavailable {
+			backend.markUnhealthy(err)
+		}
+		if IsNotFound(err) {
+			if notFoundErr == nil {
+				notFoundErr = err
+			}
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if notFoundErr != nil {
+		return notFoundErr
+	}
+	return newUnavailableError("None of the nodes are available.", nil)
+}
+
+// the versions of every connected node
+func (mn *multiNode) GetVersionString() string {
+	versions := make([]string, 0, len(mn.backends))
+	for _, backend := range mn.backends {
+		status := backend.getStatus()
+		if status.connected {
+			versions = append(versions, status.version)
+		}
+	}
+	return strings.Join(versions, ", ")
+}
+
+func (mn *multiNode) getNodeType() string {
+	return app.NODE_TYPE_Multiple
+}
+
+func (mn *multiNode) getVersionStr() string {
+	return mn.GetVersionString()
+}
+
+func (mn *multiNode) getStatuses() []NodeStatus {
+	statuses := make([]NodeStatus, len(mn.backends))
+	for b, backend := range mn.backends {
+		statuses[b] = backend.getStatus()
+	}
+	return statuses
+}
+
+// API functions
+
+func (mn *multiNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	var rawBlock *nodeBlock
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		rawBlock, err = client.getBlock(ctx, blockHash, withTxData)
+		return err
+	})
+	return rawBlock, err
+}
+
+func (mn *multiNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	var rawTx *nodeTx
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		rawTx, err = client.getTx(ctx, txId)
+		return err
+	})
+	return rawTx, err
+}
+
+func (mn *multiNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	var rawTx *nodeTx
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		rawTx, err = client.getTxInBlock(ctx, txId, blockHash)
+		return err
+	})
+	return rawTx, err
+}
+
+// transactions that are not found on one node are requested from the next, as are the transactions of a request that failed
+// nodes that can not request several transactions at once are sent concurrent requests within their own limits
+func (mn *multiNode) getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error) {
+
+	rawTxs := make(map[string]*nodeTx, len(txIds))
+	remaining := txIds
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		found, err := client.getTxs(ctx, remaining)
+		if err != nil {
+			return err
+		}
+
+		notFound := make([]string, 0)
+		for _, txId := range remaining {
+			if rawTx, isFound := found[txId]; isFound {
+				rawTxs[txId] = rawTx
+			} else {
+				notFound = append(notFound, txId)
+			}
+		}
+		remaining = notFound
+
+		if len(remaining) > 0 {
+			return newNotFoundError(fmt.Sprintf("%d transactions were not found.", len(remaining)))
+		}
+		return nil
+	})
+
+	// transactions that are not found on any node are not included
+	// but if a node failed, the transactions that were not found might be on that node, so the request fails
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	return rawTxs, nil
+}
+
+func (mn *multiNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	var blockHash string
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		blockHash, err = client.getBlockHash(ctx, blockHeight)
+		return err
+	})
+	return blockHash, err
+}
+
+func (mn *multiNode) getBestBlockHash(ctx context.Context) (string, error) {
+	var blockHash string
+	err := mn.do(ctx, true, func(client *limitedNode) error {
+		var err error
+		blockHash, err = client.getBestBlockHash(ctx)
+		return err
+	})
+	return blockHash, err
+}
+
+func (mn *multiNode) getChainTips(ctx context.Context) ([]nodeChainTip, error) {
+	var chainTips []nodeChainTip
+	err := mn.do(ctx, true, func(client *limitedNode) error {
+		var err error
+		chainTips, err = client.getChainTips(ctx)
+		return err
+	})
+	return chainTips, err
+}
+
+func (mn *multiNode) getMempoolTxIds(ctx context.Context) ([]string, error) {
+	var txIds []string
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		txIds, err = client.getMempoolTxIds(ctx)
+		return err
+	})
+	return txIds, err
+}
+
+func (mn *multiNode) getMempoolEntry(ctx context.Context, txId string) (*nodeMempoolEntry, error) {
+	var entry *nodeMempoolEntry
+	err := mn.do(ctx, false, func(client *limitedNode) error {
+		var err error
+		entry, err = client.getMempoolEntry(ctx, txId)
+		return err
+	})
+	return entry, err
+}
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a node that can only request one transaction at a time, and that records how many requests it served at the same time
+type multiNodeTestNode struct {
+	nodeClient // only the transaction requests are implemented
+
+	txs map[string]*nodeTx
+	err error // returned for every request if set
+
+	mutex     sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (mntn *multiNodeTestNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	mntn.mutex.Lock()
+	mntn.active++
+	if mntn.active > mntn.maxActive {
+		mntn.maxActive = mntn.active
+	}
+	mntn.mutex.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	mntn.mutex.Lock()
+	defer mntn.mutex.Unlock()
+	mntn.active--
+
+	if mntn.err != nil {
+		return nil, mntn.err
+	}
+	rawTx, found := mntn.txs[txId]
+	if !found {
+		return nil, newNotFoundError("Transaction " + txId + " not found.")
+	}
+	return rawTx, nil
+}
+
+// a node that requests several transactions at once
+type multiNodeTestBatchNode struct {
+	*multiNodeTestNode
+}
+
+func (mntbn *multiNodeTestBatchNode) getTxs(ctx context.Context, txIds []string) (map[string]*nodeTx, error) {
+	if mntbn.err != nil {
+		return nil, mntbn.err
+	}
+	rawTxs := make(map[string]*nodeTx)
+	for _, txId := range txIds {
+		if rawTx, found := mntbn.txs[txId]; found {
+			rawTxs[txId] = rawTx
+		}
+	}
+	return rawTxs, nil
+}
+
+// the nodes are tried in order, and each of them can serve two requests at the same time
+func newMultiNodeTest(clients ...nodeClient) *multiNode {
+	mn := multiNode{}
+	for _, client := range clients {
+		backend := nodeBackend{client: newLimitedNode(client, 2, 0)}
+		backend.status = NodeStatus{connected: true, healthy: true, inSync: true, tipHeight: -1}
+		mn.backends = append(mn.backends, &backend)
+	}
+	return &mn
+}
+
+func getMultiNodeTestTxs(txIds ...string) map[string]*nodeTx {
+	rawTxs := make(map[string]*nodeTx)
+	for _, txId := range txIds {
+		rawTxs[txId] = &nodeTx{TxId: txId}
+	}
+	return rawTxs
+}
+
+func TestMultiNodeGetTxs(t *testing.T) {
+
+	// the transactions of a failed request are requested from the next node, within that node's own limits
+	failedNode := &multiNodeTestBatchNode{&multiNodeTestNode{err: newUnavailableError("The node is down.", nil)}}
+	singleNode := &multiNodeTestNode{txs: getMultiNodeTestTxs("a", "b", "c")}
+	mn := newMultiNodeTest(failedNode, singleNode)
+
+	rawTxs, err := mn.getTxs(context.Background(), []string{"a", "b", "c"})
+	if err != nil || len(rawTxs) != 3 {
+		t.Errorf("received %d txs and %v, expected 3 txs", len(rawTxs), err)
+	}
+	if mn.backends[0].getStatus().healthy {
+		t.Error("the node that failed is still healthy")
+	}
+	if singleNode.maxActive != 2 {
+		t.Errorf("the node received %d requests at the same time, expected 2", singleNode.maxActive)
+	}
+}
+
+func TestMultiNodeGetTxsErrors(t *testing.T) {
+
+	// the transactions that were not found on the first node might be on the node that failed
+	batchNode := &multiNodeTestBatchNode{&multiNodeTestNode{txs: getMultiNodeTestTxs("a")}}
+	failedNode := &multiNodeTestNode{err: newUnavailableError("The node is down.", nil)}
+	mn := newMultiNodeTest(batchNode, failedNode)
+
+	rawTxs, err := mn.getTxs(context.Background(), []string{"a", "b"})
+	if GetErrorType(err) != ERROR_TYPE_Unavailable || rawTxs != nil {
+		t.Errorf("received %d txs and %v, expected an unavailable error", len(rawTxs), err)
+	}
+
+	// transactions that no node has are left out
+	failedNode.err = nil
+	mn = newMultiNodeTest(batchNode, failedNode)
+	rawTxs, err = mn.getTxs(context.Background(), []string{"a", "b"})
+	if err != nil || len(rawTxs) != 1 || rawTxs["a"] == nil {
+		t.Errorf("received %d txs and %v, expected tx a", len(rawTxs), err)
+	}
+}
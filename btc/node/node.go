@@ -3,6 +3,7 @@ package node
 import (
 	//	"fmt"
 	//	"errors"
+	"context"
 	"sync"
 
 	"github.com/btc-script-explorer/scantool/btc"
@@ -15,13 +16,18 @@ type BlockRequest struct {
 	BlockKey string
 }
 
+// BlockHash is the block the transaction is in, if it is known, which lets the transaction be found on a node without a transaction index
 type TxRequest struct {
 	TxId               string
+	BlockHash          string
 	IncludeInputDetail bool
 }
 
+// BlockHash is the block the transaction is in, if it is known
+// the block of a transaction that spends the output is also used as a hint, because outputs are often spent in the same block
 type OutputRequest struct {
 	TxId        string
+	BlockHash   string
 	OutputIndex uint16
 }
 
@@ -29,52 +35,191 @@ type OutputRequest struct {
 
 type NodeProxy struct {
 	cache btcCache
+	ctx   context.Context
 }
 
 var proxy *NodeProxy = nil
 var initProxyOnce sync.Once
+var initProxyErr error = nil
 
+// returns an unavailable error if the node could not be connected to
 func GetNodeProxy() (*NodeProxy, error) {
 	initProxyOnce.Do(initNodeProxy)
-	return proxy, nil
+	return proxy, initProxyErr
 }
 
 func initNodeProxy() {
-	proxy = &NodeProxy{cache: GetCache()}
+	nodeCache, err := GetCache()
+	if err != nil {
+		initProxyErr = err
+		return
+	}
+	proxy = &NodeProxy{cache: nodeCache, ctx: context.Background()}
+}
+
+// returns a proxy whose requests to the node are cancelled when the context is cancelled, such as when an http client disconnects
+func (np *NodeProxy) WithContext(ctx context.Context) *NodeProxy {
+	return &NodeProxy{cache: np.cache, ctx: ctx}
 }
 
-// currently returns negative height on error, should return two values
-func (np *NodeProxy) GetCurrentBlockHeight() int32 {
-	responseChannel := np.cache.getCurrentBlockHeight()
-	return <-responseChannel
+func (np *NodeProxy) GetCurrentBlockHeight() (int32, error) {
+	return np.cache.getCurrentBlockHeight(np.ctx)
 }
 
-func (np *NodeProxy) GetBlock(blockRequest BlockRequest) btc.Block {
+func (np *NodeProxy) GetBlock(blockRequest BlockRequest) (btc.Block, error) {
 
 	blockKey := blockRequest.BlockKey
 	if len(blockKey) == 0 {
-		blockKey = np.GetCurrentBlockHash()
+		var err error
+		blockKey, err = np.GetCurrentBlockHash()
+		if err != nil {
+			return btc.Block{}, err
+		}
 	}
 
-	return np.cache.getBlock(blockKey)
+	block, err := np.cache.getBlock(np.ctx, blockKey)
+	if err != nil {
+		return block, err
+	}
+
+	// the previous outputs will most likely be requested next
+	if np.cache.prefetch != nil {
+		np.cache.prefetch.addBlock(block)
+	}
+	return block, nil
 }
 
-func (np *NodeProxy) GetTx(txRequest TxRequest) btc.Tx {
+func (np *NodeProxy) GetTx(txRequest TxRequest) (btc.Tx, error) {
 	if len(txRequest.TxId) != 64 {
-		return btc.Tx{}
+		return btc.Tx{}, newNotFoundError(txRequest.TxId + " is not a transaction id.")
 	}
-	return np.cache.getTx(txRequest.TxId, txRequest.IncludeInputDetail)
+	return np.cache.getTx(np.ctx, txRequest.TxId, txRequest.BlockHash, txRequest.IncludeInputDetail)
 }
 
-func (np *NodeProxy) GetOutput(outputRequest OutputRequest) btc.Output {
+// returns the transactions that were found, in the same order as the transaction ids
+// the transactions and their previous outputs are requested from the node in batches
+func (np *NodeProxy) GetTxs(txIds []string, includeInputDetail bool) ([]btc.Tx, error) {
+	return np.cache.getTxList(np.ctx, txIds, includeInputDetail)
+}
+
+func (np *NodeProxy) GetOutput(outputRequest OutputRequest) (btc.Output, error) {
 	if len(outputRequest.TxId) != 64 {
-		return btc.Output{}
+		return btc.Output{}, newNotFoundError(outputRequest.TxId + " is not a transaction id.")
+	}
+	return np.cache.getOutput(np.ctx, outputRequest.TxId, outputRequest.BlockHash, outputRequest.OutputIndex)
+}
+
+func (np *NodeProxy) GetMempoolTxIds() ([]string, error) {
+	return np.cache.getMempoolTxIds(np.ctx)
+}
+
+// returns a not found error if the transaction is not in the mempool
+func (np *NodeProxy) GetMempoolEntry(txId string) (MempoolEntry, error) {
+	if len(txId) != 64 {
+		return MempoolEntry{}, newNotFoundError(txId + " is not a transaction id.")
 	}
-	return np.cache.getOutput(outputRequest.TxId, outputRequest.OutputIndex)
+	return np.cache.getMempoolEntry(np.ctx, txId)
+}
+
+// the distributions are calculated from at most maxTxCount transactions
+func (np *NodeProxy) GetMempoolSummary(maxTxCount int) (MempoolSummary, error) {
+	txIds, err := np.cache.getMempoolTxIds(np.ctx)
+	if err != nil {
+		return MempoolSummary{}, err
+	}
+
+	getTxs := func(txIds []string) ([]btc.Tx, error) { return np.cache.getTxList(np.ctx, txIds, true) }
+	return makeMempoolSummary(txIds, maxTxCount, getTxs)
+}
+
+func (np *NodeProxy) GetCurrentBlockHash() (string, error) {
+	return np.cache.getCurrentBlockHash(np.ctx)
+}
+
+// returns an unavailable error if the node can not report its chain tips
+func (np *NodeProxy) GetChainTips() ([]ChainTip, error) {
+	return np.cache.getChainTips(np.ctx)
+}
+
+// returns a not found error if the block is not a chain tip
+func (np *NodeProxy) GetChainBranch(tipHash string) (ChainBranch, error) {
+	return np.cache.getChainBranch(np.ctx, tipHash)
+}
+
+func (np *NodeProxy) CompareBlocks(blockHash1 string, blockHash2 string) (BlockComparison, error) {
+	for _, blockHash := range []string{blockHash1, blockHash2} {
+		if !isHash(blockHash) {
+			return BlockComparison{}, newNotFoundError(blockHash + " is not a block hash.")
+		}
+	}
+	return np.cache.compareBlocks(np.ctx, [2]string{blockHash1, blockHash2})
+}
+
+// events are only published when ZMQ notifications are enabled
+// only events of the given types are received, or events of every type if no types are given
+func (np *NodeProxy) SubscribeEvents(bufferSize int, eventTypes ...string) (uint64, <-chan Event) {
+	return GetEventBus().Subscribe(bufferSize, eventTypes...)
+}
+
+func (np *NodeProxy) UnsubscribeEvents(subscriberId uint64) {
+	GetEventBus().Unsubscribe(subscriberId)
+}
+
+func (np *NodeProxy) AreEventsOn() bool {
+	return len(getZmqTopicEndpoints()) > 0
+}
+
+func (np *NodeProxy) IsSpendIndexOn() bool {
+	return np.cache.spends != nil
+}
+
+// returns the spends of the outputs of a transaction by output index
+// outputs that have not been spent in a block that has been indexed are not included
+// returns an unavailable error if there is no spend index
+func (np *NodeProxy) GetOutputSpends(txId string) (map[uint16]OutputSpend, error) {
+	return np.cache.getOutputSpends(txId)
+}
+
+func (np *NodeProxy) IsAddressIndexOn() bool {
+	return np.cache.address != nil
+}
+
+// scripts are identified by an address or by the hex sha256 hash of the output script
+// returns a not found error if the script is neither, and an unavailable error if there is no address index
+func (np *NodeProxy) GetAddressSummary(addressOrScriptHash string) (AddressSummary, error) {
+	index, err := np.cache.getAddressIndex()
+	if err != nil {
+		return AddressSummary{}, err
+	}
+	return index.getSummary(addressOrScriptHash)
+}
+
+// returns at most limit funding outputs and spending inputs of a script starting at offset, in the order of the blocks they are in
+func (np *NodeProxy) GetAddressHistory(addressOrScriptHash string, offset int, limit int) ([]AddressHistoryEntry, error) {
+	index, err := np.cache.getAddressIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.getHistory(addressOrScriptHash, offset, limit)
+}
+
+// returns the outputs to a script that have not been spent in an indexed block
+func (np *NodeProxy) GetAddressUtxos(addressOrScriptHash string) ([]AddressUtxo, error) {
+	index, err := np.cache.getAddressIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.getUtxos(addressOrScriptHash)
+}
+
+// the counters of the memory cache, which are all zero if caching is off
+func (np *NodeProxy) GetCacheStats() CacheStats {
+	return np.cache.getStats()
 }
 
-func (np *NodeProxy) GetCurrentBlockHash() string {
-	return <-np.cache.getCurrentBlockHash()
+// the status of each node from the nodes setting, which is empty if a single node is used
+func (np *NodeProxy) GetNodeStatuses() []NodeStatus {
+	return np.cache.getNodeStatuses()
 }
 
 func (np *NodeProxy) GetNodeVersion() string {
@@ -0,0 +1,142 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// resolves the previous outputs of requested blocks in the background
+// the web interface requests the transactions of a block one at a time and then the details of every input,
+// so the previous outputs are usually cached by the time they are requested
+// the most recently requested block is prefetched first, and its transactions are prefetched in the order they are shown
+// requests go through the same limits as every other request to the node, and only a few blocks are prefetched at once
+// so that the node has capacity left for the requests of users
+
+// the number of blocks that are prefetched at the same time
+const PREFETCH_MAX_ACTIVE_BLOCKS = 2
+
+// requested blocks waiting to be prefetched, the oldest is dropped when another block is requested
+const PREFETCH_MAX_QUEUED_BLOCKS = 8
+
+// the number of transactions whose previous outputs are requested together
+const PREFETCH_TX_BATCH_SIZE = 25
+
+// recently prefetched blocks are not prefetched again
+const PREFETCH_RECENT_BLOCKS = 64
+
+type prefetcher struct {
+	cache *btcCache
+
+	mutex  sync.Mutex
+	queue  []btc.Block // the most recently requested block is last
+	active int
+	recent map[string]bool
+	order  []string // recent block hashes, oldest first
+}
+
+func newPrefetcher(c *btcCache) *prefetcher {
+	return &prefetcher{cache: c, recent: make(map[string]bool)}
+}
+
+// blocks with only a coinbase transaction have no previous outputs
+func (p *prefetcher) addBlock(block btc.Block) {
+
+	if block.IsNil() || len(block.GetTxIds()) < 2 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	blockHash := block.GetHash()
+	if p.recent[blockHash] {
+		return
+	}
+	p.remember(blockHash)
+
+	p.queue = append(p.queue, block)
+	if len(p.queue) > PREFETCH_MAX_QUEUED_BLOCKS {
+		p.forget(p.queue[0].GetHash())
+		p.queue = p.queue[1:]
+	}
+
+	if p.active < PREFETCH_MAX_ACTIVE_BLOCKS {
+		p.active++
+		go p.run()
+	}
+}
+
+// must be called with the mutex locked
+func (p *prefetcher) remember(blockHash string) {
+	p.recent[blockHash] = true
+	p.order = append(p.order, blockHash)
+	if len(p.order) > PREFETCH_RECENT_BLOCKS {
+		delete(p.recent, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+// a block that was dropped from the queue can be queued again the next time it is requested
+// must be called with the mutex locked
+func (p *prefetcher) forget(blockHash string) {
+	delete(p.recent, blockHash)
+	for b, recentHash := range p.order {
+		if recentHash == blockHash {
+			p.order = append(p.order[:b], p.order[b+1:]...)
+			break
+		}
+	}
+}
+
+// prefetches queued blocks until the queue is empty
+func (p *prefetcher) run() {
+	for {
+		p.mutex.Lock()
+		if len(p.queue) == 0 {
+			p.active--
+			p.mutex.Unlock()
+			return
+		}
+		block := p.queue[len(p.queue)-1]
+		p.queue = p.queue[:len(p.queue)-1]
+		p.mutex.Unlock()
+
+		p.prefetchBlock(block)
+	}
+}
+
+// the transactions and their previous transactions end up in the memory cache and the disk cache,
+// so that setting the previous outputs when they are requested does not require the node
+// the cached transactions are shared with the requests being served, so their previous outputs are not set here
+// a block is abandoned at the first error, which is most likely to happen again for the rest of the block
+func (p *prefetcher) prefetchBlock(block btc.Block) {
+
+	ctx := context.Background()
+	txIds := block.GetTxIds()
+	for start := 0; start < len(txIds); start += PREFETCH_TX_BATCH_SIZE {
+		end := start + PREFETCH_TX_BATCH_SIZE
+		if end > len(txIds) {
+			end = len(txIds)
+		}
+
+		blockTxs, err := p.cache.getTxs(ctx, txIds[start:end], nil)
+		if err == nil {
+			txs := make([]btc.Tx, 0, len(blockTxs))
+			for _, tx := range blockTxs {
+				if !includesPreviousOutputs(tx) {
+					txs = append(txs, tx)
+				}
+			}
+
+			previousTxIds, blockHashHints := getPreviousTxIds(txs)
+			_, err = p.cache.getTxs(ctx, previousTxIds, blockHashHints)
+		}
+		if err != nil {
+			fmt.Println("PREFETCH ERROR: Block " + block.GetHash() + ": " + err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replays the recorded responses, but holds every transaction request until the transactions are released
+type prefetchTestNode struct {
+	*replayNode
+
+	release chan struct{}
+
+	mutex     sync.Mutex
+	requested map[string]int
+}
+
+func (ptn *prefetchTestNode) waitForRelease(ctx context.Context, txId string) error {
+	ptn.mutex.Lock()
+	ptn.requested[txId]++
+	ptn.mutex.Unlock()
+
+	select {
+	case <-ptn.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ptn *prefetchTestNode) getRequestCount(txId string) int {
+	ptn.mutex.Lock()
+	defer ptn.mutex.Unlock()
+	return ptn.requested[txId]
+}
+
+func (ptn *prefetchTestNode) getTx(ctx context.Context, txId string) (*nodeTx, error) {
+	err := ptn.waitForRelease(ctx, txId)
+	if err != nil {
+		return nil, err
+	}
+	return ptn.replayNode.getTx(ctx, txId)
+}
+
+func (ptn *prefetchTestNode) getTxInBlock(ctx context.Context, txId string, blockHash string) (*nodeTx, error) {
+	err := ptn.waitForRelease(ctx, txId)
+	if err != nil {
+		return nil, err
+	}
+	return ptn.replayNode.getTxInBlock(ctx, txId, blockHash)
+}
+
+func waitForPrefetchTest(t *testing.T, description string, done func() bool) {
+	for wait := 0; !done(); wait++ {
+		if wait == 100 {
+			t.Fatalf("timed out waiting until %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// the block is returned while its previous outputs are still being requested,
+// and once they have been requested the input details are served from the cache
+func TestPrefetch(t *testing.T) {
+
+	replay, err := newReplayNode(testFixturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcNode := &prefetchTestNode{replayNode: replay, release: make(chan struct{}), requested: make(map[string]int)}
+	c := &btcCache{btcNode: newLimitedNode(btcNode, 0, 0), memory: newMemoryCache(1 << 20), chain: &chainTip{}}
+	c.prefetch = newPrefetcher(c)
+	np := &NodeProxy{cache: *c, ctx: context.Background()}
+
+	blockDone := make(chan error, 1)
+	go func() {
+		block, err := np.GetBlock(BlockRequest{BlockKey: testFixturesBlockHash})
+		if err == nil && block.GetHash() != testFixturesBlockHash {
+			t.Errorf("requested block %s, got %s", testFixturesBlockHash, block.GetHash())
+		}
+		blockDone <- err
+	}()
+
+	select {
+	case err := <-blockDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the block was not returned while its previous outputs were being prefetched")
+	}
+
+	waitForPrefetchTest(t, "the previous transaction is requested", func() bool { return btcNode.getRequestCount(testFixturesPreviousTxId) == 1 })
+	if isMemoryCacheTestTxCached(c.memory, testFixturesPreviousTxId) {
+		t.Fatal("the previous transaction was cached before it was returned by the node")
+	}
+
+	close(btcNode.release)
+	waitForPrefetchTest(t, "the previous transaction is cached", func() bool { return isMemoryCacheTestTxCached(c.memory, testFixturesPreviousTxId) })
+	waitForPrefetchTest(t, "the prefetch finishes", func() bool {
+		c.prefetch.mutex.Lock()
+		defer c.prefetch.mutex.Unlock()
+		return c.prefetch.active == 0
+	})
+
+	// a recently prefetched block is not prefetched again, and the input details do not require the node
+	_, err = np.GetBlock(BlockRequest{BlockKey: testFixturesBlockHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFixturesTx(t, c, testFixturesBlockHash)
+	if btcNode.getRequestCount(testFixturesPreviousTxId) != 1 {
+		t.Errorf("the previous transaction was requested %d times, expected once", btcNode.getRequestCount(testFixturesPreviousTxId))
+	}
+}
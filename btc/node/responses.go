@@ -0,0 +1,406 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// the structures of the responses from the node
+// every node client returns blocks, transactions and mempool entries in the structure of Bitcoin Core's responses
+
+const SATOSHIS_PER_BTC = 100000000
+const MAX_MONEY = 21000000 * SATOSHIS_PER_BTC
+
+// an amount in satoshis
+// amounts are decoded from the decimal BTC value in the JSON text, so they are exact and never pass through a float
+type btcAmount uint64
+
+func (a *btcAmount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	satoshis, err := parseBtcAmount(string(data))
+	if err != nil {
+		return errors.New(string(data) + " is not an amount. " + err.Error())
+	}
+	*a = btcAmount(satoshis)
+	return nil
+}
+
+func (a btcAmount) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d.%08d", a/SATOSHIS_PER_BTC, a%SATOSHIS_PER_BTC)), nil
+}
+
+// amounts from Bitcoin Core have at most 8 decimal places and no exponent
+// amounts with an exponent are parsed as decimals, which is slower but still exact
+func parseBtcAmount(amount string) (uint64, error) {
+
+	if strings.ContainsAny(amount, "eE") {
+		d, err := decimal.NewFromString(amount)
+		if err != nil {
+			return 0, err
+		}
+		satoshis := d.Shift(8)
+		if !satoshis.IsInteger() || satoshis.IsNegative() || satoshis.GreaterThan(decimal.NewFromInt(MAX_MONEY)) {
+			return 0, errors.New("The amount is not a valid number of satoshis.")
+		}
+		return uint64(satoshis.IntPart()), nil
+	}
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if len(whole) == 0 || len(fraction) > 8 {
+		return 0, errors.New("The amount does not have between 1 and 8 decimal places.")
+	}
+	fraction += strings.Repeat("0", 8-len(fraction))
+
+	wholeBtc, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fractionSatoshis, err := strconv.ParseUint(fraction, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if wholeBtc > MAX_MONEY/SATOSHIS_PER_BTC {
+		return 0, errors.New("The amount is larger than the maximum supply.")
+	}
+
+	satoshis := wholeBtc*SATOSHIS_PER_BTC + fractionSatoshis
+	if satoshis > MAX_MONEY {
+		return 0, errors.New("The amount is larger than the maximum supply.")
+	}
+	return satoshis, nil
+}
+
+// blocks
+
+// the transactions are only transaction ids if the block was requested without transaction data
+type nodeBlock struct {
+	Hash              string       `json:"hash"`
+	Height            uint32       `json:"height"`
+	Version           int32        `json:"version"`
+	Time              int64        `json:"time"`
+	PreviousBlockHash string       `json:"previousblockhash,omitempty"`
+	NextBlockHash     string       `json:"nextblockhash,omitempty"`
+	Confirmations     int64        `json:"confirmations,omitempty"` // -1 for blocks that are not in the active chain
+	Tx                nodeBlockTxs `json:"tx"`
+}
+
+// getblock returns transaction ids with verbosity 1 and transactions with verbosity 2
+type nodeBlockTxs struct {
+	txIds []string
+	txs   []nodeTx
+}
+
+func (nbt *nodeBlockTxs) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(bytes.TrimSpace(data), []byte("[")), " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '"' {
+		return json.Unmarshal(data, &nbt.txIds)
+	}
+	return json.Unmarshal(data, &nbt.txs)
+}
+
+func (nbt nodeBlockTxs) MarshalJSON() ([]byte, error) {
+	if nbt.txs != nil {
+		return json.Marshal(nbt.txs)
+	}
+	if nbt.txIds == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(nbt.txIds)
+}
+
+func (nb *nodeBlock) hasTxData() bool {
+	return nb.Tx.txs != nil
+}
+
+func (nb *nodeBlock) getTxIds() []string {
+	if nb.Tx.txs == nil {
+		return nb.Tx.txIds
+	}
+
+	txIds := make([]string, len(nb.Tx.txs))
+	for t := range nb.Tx.txs {
+		txIds[t] = nb.Tx.txs[t].TxId
+	}
+	return txIds
+}
+
+// the transactions are empty if the block was requested without transaction data
+func (nb *nodeBlock) getTxs() []nodeTx {
+	return nb.Tx.txs
+}
+
+// returns a copy of the transaction with the block hash and block time of the block, or nil if it is not in the block
+// the block must include its transaction data
+func (nb *nodeBlock) findTx(txId string) *nodeTx {
+	for t := range nb.Tx.txs {
+		if nb.Tx.txs[t].TxId == txId {
+			rawTx := nb.Tx.txs[t]
+			rawTx.BlockHash = nb.Hash
+			rawTx.BlockTime = nb.Time
+			return &rawTx
+		}
+	}
+	return nil
+}
+
+// returns a copy of the block without transaction data
+func (nb *nodeBlock) withoutTxData() *nodeBlock {
+	block := *nb
+	block.Tx = nodeBlockTxs{txIds: nb.getTxIds()}
+	return &block
+}
+
+func (nb *nodeBlock) validate() error {
+	if !isHash(nb.Hash) {
+		return newDecodeError("Malformed block from node: "+nb.Hash+" is not a block hash.", nil)
+	}
+	if len(nb.PreviousBlockHash) > 0 && !isHash(nb.PreviousBlockHash) {
+		return newDecodeError("Malformed block from node: block "+nb.Hash+" has an invalid previous block hash.", nil)
+	}
+	if len(nb.NextBlockHash) > 0 && !isHash(nb.NextBlockHash) {
+		return newDecodeError("Malformed block from node: block "+nb.Hash+" has an invalid next block hash.", nil)
+	}
+	if len(nb.Tx.txIds) == 0 && len(nb.Tx.txs) == 0 {
+		return newDecodeError("Malformed block from node: block "+nb.Hash+" has no transactions.", nil)
+	}
+	for t := range nb.Tx.txs {
+		err := nb.Tx.txs[t].validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transactions
+
+// unconfirmed transactions and transactions in blocks have no block hash or block time
+type nodeTx struct {
+	TxId      string       `json:"txid"`
+	Version   int64        `json:"version"` // older versions of Bitcoin Core report the version as a signed number
+	LockTime  uint32       `json:"locktime"`
+	Vin       []nodeInput  `json:"vin"`
+	Vout      []nodeOutput `json:"vout"`
+	BlockHash string       `json:"blockhash,omitempty"`
+	BlockTime int64        `json:"blocktime,omitempty"`
+}
+
+type nodeInput struct {
+	Coinbase  string      `json:"coinbase,omitempty"`
+	TxId      string      `json:"txid,omitempty"`
+	Vout      uint32      `json:"vout"`
+	ScriptSig *nodeScript `json:"scriptSig,omitempty"`
+	Witness   []string    `json:"txinwitness,omitempty"`
+	Sequence  uint32      `json:"sequence"`
+}
+
+type nodeOutput struct {
+	Value        btcAmount  `json:"value"`
+	N            uint32     `json:"n"`
+	ScriptPubKey nodeScript `json:"scriptPubKey"`
+}
+
+type nodeScript struct {
+	Hex     string `json:"hex"`
+	Address string `json:"address,omitempty"`
+}
+
+func (ni *nodeInput) isCoinbase() bool {
+	return len(ni.Coinbase) > 0
+}
+
+// a transaction is bip141 if any input has a witness, since a transaction with the marker and flag must have at least one witness
+func (nt *nodeTx) isBip141() bool {
+	for i := range nt.Vin {
+		if len(nt.Vin[i].Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (nt *nodeTx) validate() error {
+	if !isHash(nt.TxId) {
+		return newDecodeError("Malformed transaction from node: "+nt.TxId+" is not a transaction id.", nil)
+	}
+	if len(nt.Vin) == 0 || len(nt.Vout) == 0 {
+		return newDecodeError("Malformed transaction from node: transaction "+nt.TxId+" has no inputs or no outputs.", nil)
+	}
+	if len(nt.BlockHash) > 0 && !isHash(nt.BlockHash) {
+		return newDecodeError("Malformed transaction from node: transaction "+nt.TxId+" has an invalid block hash.", nil)
+	}
+	for i := range nt.Vin {
+		input := &nt.Vin[i]
+		if input.isCoinbase() {
+			if i > 0 {
+				return newDecodeError(fmt.Sprintf("Malformed transaction from node: input %d of transaction %s is a coinbase input.", i, nt.TxId), nil)
+			}
+			continue
+		}
+		if !isHash(input.TxId) || input.ScriptSig == nil {
+			return newDecodeError(fmt.Sprintf("Malformed transaction from node: input %d of transaction %s has no previous output or input script.", i, nt.TxId), nil)
+		}
+	}
+	return nil
+}
+
+// mempool entries
+
+// fees are in BTC
+// older versions of Bitcoin Core report the fees at the top level instead of in the fees object
+type nodeMempoolEntry struct {
+	Vsize           uint32           `json:"vsize"`
+	Weight          uint32           `json:"weight"`
+	Time            int64            `json:"time"`
+	Height          uint32           `json:"height"`
+	AncestorCount   uint32           `json:"ancestorcount"`
+	AncestorSize    uint32           `json:"ancestorsize"`
+	DescendantCount uint32           `json:"descendantcount"`
+	DescendantSize  uint32           `json:"descendantsize"`
+	Depends         []string         `json:"depends"`
+	SpentBy         []string         `json:"spentby"`
+	Fees            *nodeMempoolFees `json:"fees,omitempty"`
+	Fee             btcAmount        `json:"fee"`
+	AncestorFees    btcAmount        `json:"ancestorfees"`
+	DescendantFees  btcAmount        `json:"descendantfees"`
+}
+
+type nodeMempoolFees struct {
+	Base       btcAmount `json:"base"`
+	Ancestor   btcAmount `json:"ancestor"`
+	Descendant btcAmount `json:"descendant"`
+}
+
+// chain tips
+
+// the branch length is the number of blocks between the tip and the active chain, and 0 for the tip of the active chain
+type nodeChainTip struct {
+	Height    uint32 `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen uint32 `json:"branchlen"`
+	Status    string `json:"status"`
+}
+
+// decoding
+
+func isHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range []byte(hash) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func makeBlock(rawBlock *nodeBlock) btc.Block {
+	block := btc.NewBlock(rawBlock.Hash, rawBlock.PreviousBlockHash, rawBlock.NextBlockHash, rawBlock.Height, rawBlock.Version, rawBlock.Time, rawBlock.getTxIds())
+
+	// blocks that are not in the active chain have -1 confirmations
+	if rawBlock.Confirmations < 0 {
+		block.SetOrphaned(true)
+	}
+	return block
+}
+
+// the transaction must have been validated
+func makeTx(rawTx *nodeTx) (btc.Tx, error) {
+
+	isBip141 := rawTx.isBip141()
+
+	// outputs
+	outputs := make([]btc.Output, len(rawTx.Vout))
+	for o := range rawTx.Vout {
+		nodeOutput := &rawTx.Vout[o]
+
+		outputScriptBytes, err := hex.DecodeString(nodeOutput.ScriptPubKey.Hex)
+		if err != nil {
+			return btc.Tx{}, newDecodeError(fmt.Sprintf("Malformed transaction from node: output %d of transaction %s has an invalid output script.", o, rawTx.TxId), err)
+		}
+
+		outputs[o] = btc.NewOutput(uint64(nodeOutput.Value), btc.NewScript(outputScriptBytes), nodeOutput.ScriptPubKey.Address)
+	}
+
+	// inputs
+	inputs := make([]btc.Input, len(rawTx.Vin))
+	for i := range rawTx.Vin {
+		nodeInput := &rawTx.Vin[i]
+
+		var inputScriptBytes []byte
+		var err error
+		isCoinbase := nodeInput.isCoinbase()
+		previousOutputTxId := ""
+		previousOutputIndex := uint16(0)
+		if isCoinbase {
+			inputScriptBytes, err = hex.DecodeString(nodeInput.Coinbase)
+		} else {
+			inputScriptBytes, err = hex.DecodeString(nodeInput.ScriptSig.Hex)
+			previousOutputTxId = nodeInput.TxId
+			previousOutputIndex = uint16(nodeInput.Vout)
+		}
+		if err != nil {
+			return btc.Tx{}, newDecodeError(fmt.Sprintf("Malformed transaction from node: input %d of transaction %s has an invalid input script.", i, rawTx.TxId), err)
+		}
+
+		segwit := btc.Segwit{}
+		if isBip141 {
+			segwitFields := make([][]byte, len(nodeInput.Witness))
+			for s, rawSegwitField := range nodeInput.Witness {
+				segwitFields[s], err = hex.DecodeString(rawSegwitField)
+				if err != nil {
+					return btc.Tx{}, newDecodeError(fmt.Sprintf("Malformed transaction from node: input %d of transaction %s has an invalid witness.", i, rawTx.TxId), err)
+				}
+			}
+
+			segwit = btc.NewSegwit(segwitFields)
+		}
+
+		inputs[i] = btc.NewInput(isCoinbase,
+			previousOutputTxId,
+			previousOutputIndex,
+			btc.NewScript(inputScriptBytes),
+			segwit,
+			nodeInput.Sequence,
+			btc.Output{})
+	}
+
+	return btc.NewTx(rawTx.TxId,
+		uint32(rawTx.Version),
+		inputs,
+		outputs,
+		rawTx.LockTime,
+		inputs[0].IsCoinbase(),
+		isBip141,
+		rawTx.BlockHash,
+		rawTx.BlockTime), nil
+}
+
+func decodeBlock(rawBlock *nodeBlock) (btc.Block, error) {
+	err := rawBlock.validate()
+	if err != nil {
+		return btc.Block{}, err
+	}
+	return makeBlock(rawBlock), nil
+}
+
+func decodeTx(rawTx *nodeTx) (btc.Tx, error) {
+	err := rawTx.validate()
+	if err != nil {
+		return btc.Tx{}, err
+	}
+	return makeTx(rawTx)
+}
@@ -0,0 +1,193 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// the decoding that the typed responses replaced, which walked the JSON as maps and converted BTC amounts from float64
+// it is only kept to compare the two in the benchmarks
+
+func makeUntypedBlock(rawBlock map[string]interface{}) btc.Block {
+	previousHash := ""
+	nextHash := ""
+	if rawBlock["previousblockhash"] != nil {
+		previousHash = rawBlock["previousblockhash"].(string)
+	}
+	if rawBlock["nextblockhash"] != nil {
+		nextHash = rawBlock["nextblockhash"].(string)
+	}
+
+	rawTxs := rawBlock["tx"].([]interface{})
+	txIds := make([]string, len(rawTxs))
+	for t := range rawTxs {
+		txIds[t] = rawTxs[t].(map[string]interface{})["txid"].(string)
+	}
+
+	return btc.NewBlock(rawBlock["hash"].(string), previousHash, nextHash, uint32(rawBlock["height"].(float64)), int32(rawBlock["version"].(float64)), int64(rawBlock["time"].(float64)), txIds)
+}
+
+func makeUntypedTx(rawTx map[string]interface{}) btc.Tx {
+
+	isBip141 := false
+	for _, rawInput := range rawTx["vin"].([]interface{}) {
+		witness := rawInput.(map[string]interface{})["txinwitness"]
+		if witness != nil && len(witness.([]interface{})) > 0 {
+			isBip141 = true
+			break
+		}
+	}
+
+	vout := rawTx["vout"].([]interface{})
+	outputs := make([]btc.Output, len(vout))
+	for o := range vout {
+		rawOutput := vout[o].(map[string]interface{})
+
+		dValue := decimal.NewFromFloat(rawOutput["value"].(float64))
+		value := uint64(dValue.Mul(decimal.NewFromInt(100000000)).IntPart())
+
+		outputScript := rawOutput["scriptPubKey"].(map[string]interface{})
+		outputScriptBytes, _ := hex.DecodeString(outputScript["hex"].(string))
+		address := ""
+		if outputScript["address"] != nil {
+			address = outputScript["address"].(string)
+		}
+		outputs[o] = btc.NewOutput(value, btc.NewScript(outputScriptBytes), address)
+	}
+
+	vin := rawTx["vin"].([]interface{})
+	inputs := make([]btc.Input, len(vin))
+	for i := range vin {
+		rawInput := vin[i].(map[string]interface{})
+
+		var inputScriptBytes []byte
+		isCoinbase := i == 0 && rawInput["coinbase"] != nil
+		previousOutputTxId := ""
+		previousOutputIndex := uint16(0)
+		if isCoinbase {
+			inputScriptBytes, _ = hex.DecodeString(rawInput["coinbase"].(string))
+		} else {
+			scriptSig := rawInput["scriptSig"].(map[string]interface{})
+			inputScriptBytes, _ = hex.DecodeString(scriptSig["hex"].(string))
+			previousOutputTxId = rawInput["txid"].(string)
+			previousOutputIndex = uint16(rawInput["vout"].(float64))
+		}
+
+		segwit := btc.Segwit{}
+		if isBip141 {
+			segwitFields := make([][]byte, 0)
+			if rawInput["txinwitness"] != nil {
+				for _, rawSegwitField := range rawInput["txinwitness"].([]interface{}) {
+					segwitField, _ := hex.DecodeString(rawSegwitField.(string))
+					segwitFields = append(segwitFields, segwitField)
+				}
+			}
+			segwit = btc.NewSegwit(segwitFields)
+		}
+
+		inputs[i] = btc.NewInput(isCoinbase, previousOutputTxId, previousOutputIndex, btc.NewScript(inputScriptBytes), segwit, uint32(rawInput["sequence"].(float64)), btc.Output{})
+	}
+
+	return btc.NewTx(rawTx["txid"].(string), uint32(rawTx["version"].(float64)), inputs, outputs, uint32(rawTx["locktime"].(float64)), inputs[0].IsCoinbase(), isBip141, "", 0)
+}
+
+// both ways of decoding find the same transactions with the same amounts
+func TestUntypedDecodeMatches(t *testing.T) {
+
+	var untypedBlock map[string]interface{}
+	err := json.Unmarshal(readTestBlockJson(t), &untypedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawBlock := readTestBlockFromJson(t)
+	rawTxs := rawBlock.getTxs()
+	untypedTxs := untypedBlock["tx"].([]interface{})
+	for i := range rawTxs {
+		tx, err := decodeTx(&rawTxs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		untypedTx := makeUntypedTx(untypedTxs[i].(map[string]interface{}))
+		if tx.GetTxId() != untypedTx.GetTxId() || tx.GetInputCount() != untypedTx.GetInputCount() || tx.GetOutputCount() != untypedTx.GetOutputCount() {
+			t.Fatalf("tx %d is %s, expected %s", i, tx.GetTxId(), untypedTx.GetTxId())
+		}
+		untypedOutputs := untypedTx.GetOutputs()
+		for o, output := range tx.GetOutputs() {
+			if output.GetValue() != untypedOutputs[o].GetValue() {
+				t.Errorf("output %d of tx %s is %d, expected %d", o, tx.GetTxId(), output.GetValue(), untypedOutputs[o].GetValue())
+			}
+		}
+	}
+}
+
+// decodes getblock verbosity 2 as maps, the way node responses were decoded before
+func BenchmarkDecodeBlockUntyped(b *testing.B) {
+
+	blockJson := readTestBlockJson(b)
+	b.SetBytes(int64(len(blockJson)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		var rawBlock map[string]interface{}
+		err := json.Unmarshal(blockJson, &rawBlock)
+		if err != nil {
+			b.Fatal(err)
+		}
+		makeUntypedBlock(rawBlock)
+		for _, rawTx := range rawBlock["tx"].([]interface{}) {
+			makeUntypedTx(rawTx.(map[string]interface{}))
+		}
+	}
+}
+
+// decodes getblock verbosity 2 into the typed responses
+func BenchmarkDecodeBlockTyped(b *testing.B) {
+
+	blockJson := readTestBlockJson(b)
+	b.SetBytes(int64(len(blockJson)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		var rawBlock nodeBlock
+		err := json.Unmarshal(blockJson, &rawBlock)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = decodeBlock(&rawBlock)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = decodeBlockTxs(&rawBlock)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// decodes the serialized block, as the REST and block file clients do
+func BenchmarkDecodeBlockSerialized(b *testing.B) {
+
+	serializedBlock := readTestBlock(b)
+	b.SetBytes(int64(len(serializedBlock)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		rawBlock, err := deserializeBlock(serializedBlock, btc.NETWORK_Mainnet, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = decodeBlockTxs(rawBlock)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// native deserialization of blocks and transactions
+// the results have the same structure as the responses from Bitcoin Core's getblock and getrawtransaction
+
+const BLOCK_HEADER_SIZE = 80
+
+type byteReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *byteReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = errors.New("Unexpected end of serialized data.")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *byteReader) readByte() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *byteReader) readUint32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *byteReader) readUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *byteReader) readCompactSize() uint64 {
+	first := r.readByte()
+	switch first {
+	case 0xfd:
+		b := r.read(2)
+		if b == nil {
+			return 0
+		}
+		return uint64(binary.LittleEndian.Uint16(b))
+	case 0xfe:
+		return uint64(r.readUint32())
+	case 0xff:
+		return r.readUint64()
+	}
+	return uint64(first)
+}
+
+// reads a compact size that is used as a count or a length, which can not be larger than the remaining data
+func (r *byteReader) readLength() int {
+	length := r.readCompactSize()
+	if r.err == nil && length > uint64(len(r.data)-r.pos) {
+		r.err = errors.New("Serialized length exceeds remaining data.")
+		return 0
+	}
+	return int(length)
+}
+
+// returns the double sha256 hash in the byte order used for display
+func getDisplayHash(data []byte) string {
+	firstHash := sha256.Sum256(data)
+	secondHash := sha256.Sum256(firstHash[:])
+	return reverseHex(secondHash[:])
+}
+
+func reverseHex(b []byte) string {
+	reversed := make([]byte, len(b))
+	for i, v := range b {
+		reversed[len(b)-1-i] = v
+	}
+	return hex.EncodeToString(reversed)
+}
+
+// returns the block hash and the previous block hash
+func parseBlockHeader(header []byte) (string, string) {
+	return getDisplayHash(header[0:BLOCK_HEADER_SIZE]), reverseHex(header[4:36])
+}
+
+// the block height is not part of a serialized block, so it must be added by the caller
+func deserializeBlock(serializedBlock []byte, network string, withTxData bool) (*nodeBlock, error) {
+
+	if len(serializedBlock) < BLOCK_HEADER_SIZE {
+		return nil, errors.New("Serialized block is too short.")
+	}
+
+	r := byteReader{data: serializedBlock}
+	header := r.read(BLOCK_HEADER_SIZE)
+	blockHash, previousBlockHash := parseBlockHeader(header)
+
+	rawBlock := nodeBlock{Hash: blockHash,
+		Version: int32(binary.LittleEndian.Uint32(header[0:4])),
+		Time:    int64(binary.LittleEndian.Uint32(header[68:72]))}
+	if previousBlockHash != ZERO_HASH {
+		rawBlock.PreviousBlockHash = previousBlockHash
+	}
+
+	txCount := r.readLength()
+	rawTxs := make([]nodeTx, txCount)
+	for t := 0; t < txCount; t++ {
+		var err error
+		rawTxs[t], err = deserializeTx(&r, network)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if withTxData {
+		rawBlock.Tx.txs = rawTxs
+	} else {
+		rawBlock.Tx.txIds = make([]string, txCount)
+		for t := range rawTxs {
+			rawBlock.Tx.txIds[t] = rawTxs[t].TxId
+		}
+	}
+
+	return &rawBlock, r.err
+}
+
+func deserializeTx(r *byteReader, network string) (nodeTx, error) {
+
+	txBegin := r.pos
+	version := r.readUint32()
+
+	// check for the bip141 marker and flag
+	isBip141 := r.pos+2 <= len(r.data) && r.data[r.pos] == 0x00 && r.data[r.pos+1] == 0x01
+	if isBip141 {
+		r.read(2)
+	}
+
+	// the txid is the hash of the transaction without the marker, flag and witness data
+	legacyBegin := r.pos
+
+	// inputs
+	inputCount := r.readLength()
+	vin := make([]nodeInput, inputCount)
+	for i := 0; i < inputCount; i++ {
+		previousTxId := r.read(32)
+		previousOutputIndex := r.readUint32()
+		inputScript := r.read(r.readLength())
+		sequence := r.readUint32()
+		if r.err != nil {
+			return nodeTx{}, r.err
+		}
+
+		if i == 0 && inputCount == 1 && reverseHex(previousTxId) == ZERO_HASH && previousOutputIndex == 0xffffffff {
+			vin[i].Coinbase = hex.EncodeToString(inputScript)
+		} else {
+			vin[i].TxId = reverseHex(previousTxId)
+			vin[i].Vout = previousOutputIndex
+			vin[i].ScriptSig = &nodeScript{Hex: hex.EncodeToString(inputScript)}
+		}
+		vin[i].Sequence = sequence
+	}
+
+	// outputs
+	outputCount := r.readLength()
+	vout := make([]nodeOutput, outputCount)
+	for o := 0; o < outputCount; o++ {
+		value := r.readUint64()
+		outputScript := r.read(r.readLength())
+		if r.err != nil {
+			return nodeTx{}, r.err
+		}
+
+		vout[o].Value = btcAmount(value)
+		vout[o].N = uint32(o)
+		vout[o].ScriptPubKey = nodeScript{Hex: hex.EncodeToString(outputScript), Address: btc.GetAddress(outputScript, network)}
+	}
+	legacyEnd := r.pos
+
+	// witness data
+	if isBip141 {
+		for i := 0; i < inputCount; i++ {
+			fieldCount := r.readLength()
+			if fieldCount == 0 {
+				continue
+			}
+			vin[i].Witness = make([]string, fieldCount)
+			for f := 0; f < fieldCount; f++ {
+				vin[i].Witness[f] = hex.EncodeToString(r.read(r.readLength()))
+			}
+		}
+	}
+
+	lockTime := r.readUint32()
+	if r.err != nil {
+		return nodeTx{}, r.err
+	}
+
+	legacySerialization := make([]byte, 0, 8+legacyEnd-legacyBegin)
+	legacySerialization = append(legacySerialization, r.data[txBegin:txBegin+4]...)
+	legacySerialization = append(legacySerialization, r.data[legacyBegin:legacyEnd]...)
+	legacySerialization = append(legacySerialization, r.data[r.pos-4:r.pos]...)
+
+	return nodeTx{TxId: getDisplayHash(legacySerialization),
+		Version:  int64(version),
+		LockTime: lockTime,
+		Vin:      vin,
+		Vout:     vout}, nil
+}
+
+const ZERO_HASH = "0000000000000000000000000000000000000000000000000000000000000000"
@@ -0,0 +1,230 @@
+package node
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// mainnet block 277647, serialized and in the structure of getblock with verbosity 2
+// the JSON form was decoded independently of the scantool
+const testBlockHash = "0000000000000000054a714e580b16c583701712ab91060e92dbde6eb1e052a8"
+const testBlockHeight = 277647
+const testBlockFile = "mainnet-block-277647.bin"
+const testBlockJsonFile = "mainnet-block-277647.json.gz"
+
+// the signed P2SH-P2WPKH transaction from the examples in BIP 143
+const testSegwitTxHex = "01000000000101db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a5477010000001716001479091972186c449eb1ded22b78e40d009bdf0089feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac02473044022047ac8e878352d3ebbde1c94ce3a10d057c24175747116f8288e5d794d12d482f0220217f36a485cae903c713331d877c1f64677e3622ad4010726870540656fe9dcb012103ad1d8e89212f0b92c74d23bb710c00662ad1470198ac48c43f7d6f93a2a2687392040000"
+const testSegwitTxId = "ef48d9d0f595052e0f8cdcf825f7a5e50b6a388a81f206f3f4846e5ecd7a0c23"
+const testSegwitWtxId = "680f483b2bf6c5dcbf111e69e885ba248a41a5e92070cfb0afec3cfc49a9fabb"
+
+func readTestBlock(t testing.TB) []byte {
+	serializedBlock, err := os.ReadFile(filepath.Join("testdata", testBlockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serializedBlock
+}
+
+func readTestBlockJson(t testing.TB) []byte {
+	file, err := os.Open(filepath.Join("testdata", testBlockJsonFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockJson, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blockJson
+}
+
+func readTestBlockFromJson(t testing.TB) *nodeBlock {
+	var rawBlock nodeBlock
+	err := json.Unmarshal(readTestBlockJson(t), &rawBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rawBlock
+}
+
+// the merkle root of the transaction ids, in the byte order of the block header
+func getTestMerkleRoot(txIds []string) []byte {
+	level := make([][]byte, len(txIds))
+	for t, txId := range txIds {
+		level[t], _ = hex.DecodeString(txId)
+		for i, j := 0, len(level[t])-1; i < j; i, j = i+1, j-1 {
+			level[t][i], level[t][j] = level[t][j], level[t][i]
+		}
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			firstHash := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			secondHash := sha256.Sum256(firstHash[:])
+			next = append(next, secondHash[:])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func TestDeserializeMainnetBlock(t *testing.T) {
+
+	serializedBlock := readTestBlock(t)
+	expected := readTestBlockFromJson(t)
+
+	rawBlock, err := deserializeBlock(serializedBlock, btc.NETWORK_Mainnet, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rawBlock.Hash != testBlockHash || rawBlock.Hash != expected.Hash {
+		t.Errorf("block hash is %s, expected %s", rawBlock.Hash, testBlockHash)
+	}
+	if rawBlock.PreviousBlockHash != expected.PreviousBlockHash || rawBlock.Version != expected.Version || rawBlock.Time != expected.Time {
+		t.Errorf("unexpected header %s %d %d", rawBlock.PreviousBlockHash, rawBlock.Version, rawBlock.Time)
+	}
+	if rawBlock.Height != 0 {
+		t.Errorf("the height is not part of a serialized block, but it was set to %d", rawBlock.Height)
+	}
+
+	txs := rawBlock.getTxs()
+	expectedTxs := expected.getTxs()
+	if len(txs) != len(expectedTxs) {
+		t.Fatalf("block has %d txs, expected %d", len(txs), len(expectedTxs))
+	}
+	for i := range txs {
+		if !reflect.DeepEqual(txs[i], expectedTxs[i]) {
+			t.Errorf("tx %d does not match the JSON form\n%+v\n%+v", i, txs[i], expectedTxs[i])
+		}
+	}
+
+	// the txids are checked against the merkle root in the header as well
+	if !reflect.DeepEqual(getTestMerkleRoot(rawBlock.getTxIds()), serializedBlock[36:68]) {
+		t.Error("the merkle root of the txids does not match the block header")
+	}
+
+	withoutTxData, err := deserializeBlock(serializedBlock, btc.NETWORK_Mainnet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutTxData.hasTxData() || !reflect.DeepEqual(withoutTxData.getTxIds(), rawBlock.getTxIds()) {
+		t.Error("the block without tx data does not have the same txids")
+	}
+}
+
+func TestDeserializeCoinbase(t *testing.T) {
+
+	rawBlock, err := deserializeBlock(readTestBlock(t), btc.NETWORK_Mainnet, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbaseTx := rawBlock.getTxs()[0]
+	if len(coinbaseTx.Vin) != 1 || !coinbaseTx.Vin[0].isCoinbase() {
+		t.Fatalf("the first tx does not have a single coinbase input: %+v", coinbaseTx.Vin)
+	}
+	coinbaseInput := coinbaseTx.Vin[0]
+	if len(coinbaseInput.TxId) != 0 || coinbaseInput.ScriptSig != nil || coinbaseInput.Vout != 0 {
+		t.Errorf("the coinbase input has a previous output: %+v", coinbaseInput)
+	}
+
+	// bip34 puts the height at the start of the coinbase script
+	if !strings.HasPrefix(coinbaseInput.Coinbase, "038f3c04") {
+		t.Errorf("the coinbase script %s does not start with the height %d", coinbaseInput.Coinbase, testBlockHeight)
+	}
+
+	coinbaseTxJson, err := json.Marshal(coinbaseTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded nodeTx
+	err = json.Unmarshal(coinbaseTxJson, &decoded)
+	if err != nil || decoded.validate() != nil || !reflect.DeepEqual(decoded, coinbaseTx) {
+		t.Errorf("the coinbase tx does not survive a round trip through JSON: %v", err)
+	}
+
+	for i := range rawBlock.getTxs()[1:] {
+		if rawBlock.getTxs()[i+1].Vin[0].isCoinbase() {
+			t.Errorf("tx %d has a coinbase input", i+1)
+		}
+	}
+}
+
+func TestDeserializeSegwitTx(t *testing.T) {
+
+	serializedTx, _ := hex.DecodeString(testSegwitTxHex)
+	r := byteReader{data: serializedTx}
+	rawTx, err := deserializeTx(&r, btc.NETWORK_Mainnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.pos != len(serializedTx) {
+		t.Errorf("read %d of %d bytes", r.pos, len(serializedTx))
+	}
+
+	// the txid does not include the marker, the flag or the witness, but the wtxid does
+	if rawTx.TxId != testSegwitTxId {
+		t.Errorf("txid is %s, expected %s", rawTx.TxId, testSegwitTxId)
+	}
+	if getDisplayHash(serializedTx) != testSegwitWtxId {
+		t.Errorf("wtxid is %s, expected %s", getDisplayHash(serializedTx), testSegwitWtxId)
+	}
+
+	expected := nodeTx{TxId: testSegwitTxId,
+		Version:  1,
+		LockTime: 1170,
+		Vin: []nodeInput{{TxId: "77541aeb3c4dac9260b68f74f44c973081a9d4cb2ebe8038b2d70faa201b6bdb",
+			Vout:      1,
+			ScriptSig: &nodeScript{Hex: "16001479091972186c449eb1ded22b78e40d009bdf0089"},
+			Witness: []string{"3044022047ac8e878352d3ebbde1c94ce3a10d057c24175747116f8288e5d794d12d482f0220217f36a485cae903c713331d877c1f64677e3622ad4010726870540656fe9dcb01",
+				"03ad1d8e89212f0b92c74d23bb710c00662ad1470198ac48c43f7d6f93a2a26873"},
+			Sequence: 0xfffffffe}},
+		Vout: []nodeOutput{{Value: 199996600, N: 0, ScriptPubKey: nodeScript{Hex: "76a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac", Address: "1Fyxts6r24DpEieygQiNnWxUdb18ANa5p7"}},
+			{Value: 800000000, N: 1, ScriptPubKey: nodeScript{Hex: "76a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac", Address: "1Q5YjKVj5yQWHBBsyEBamkfph3cA6G9KK8"}}}}
+	if !reflect.DeepEqual(rawTx, expected) {
+		t.Errorf("unexpected tx\n%+v\n%+v", rawTx, expected)
+	}
+	if !rawTx.isBip141() {
+		t.Error("the tx is not recognized as bip141")
+	}
+}
+
+func TestDeserializeTruncated(t *testing.T) {
+
+	serializedTx, _ := hex.DecodeString(testSegwitTxHex)
+	for length := 0; length < len(serializedTx); length++ {
+		r := byteReader{data: serializedTx[:length]}
+		_, err := deserializeTx(&r, btc.NETWORK_Mainnet)
+		if err == nil {
+			t.Errorf("a tx truncated to %d bytes was deserialized", length)
+		}
+	}
+
+	serializedBlock := readTestBlock(t)
+	for _, length := range []int{0, BLOCK_HEADER_SIZE - 1, BLOCK_HEADER_SIZE, BLOCK_HEADER_SIZE + 3, len(serializedBlock) - 1} {
+		_, err := deserializeBlock(serializedBlock[:length], btc.NETWORK_Mainnet, true)
+		if err == nil {
+			t.Errorf("a block truncated to %d bytes was deserialized", length)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the spend index maps each output to the input that spent it, which the node can not look up
+// it is built by the block scanner, which reads every block from the start height to the tip and adds blocks as they arrive
+// blocks replaced by a reorg are removed by reading them again and removing the spends of their inputs
+// spends by unconfirmed transactions are not indexed
+
+// increment whenever the stored data changes, an index built by another version is rebuilt
+const SPEND_INDEX_VERSION = 1
+
+// buckets
+const SPEND_INDEX_BUCKET_Meta = "meta"
+const SPEND_INDEX_BUCKET_Spends = "spends" // outpoint -> spending input
+const SPEND_INDEX_BUCKET_Blocks = "blocks" // height -> hash of every indexed block
+
+// meta keys
+const SPEND_INDEX_KEY_Version = "version" // the version followed by the start height
+
+// OutputSpend is the input that spent an output
+type OutputSpend struct {
+	txId        string
+	inputIndex  uint16
+	blockHeight uint32
+}
+
+func (s *OutputSpend) IsNil() bool {
+	return len(s.txId) == 0
+}
+
+func (s *OutputSpend) GetTxId() string {
+	return s.txId
+}
+
+func (s *OutputSpend) GetInputIndex() uint16 {
+	return s.inputIndex
+}
+
+func (s *OutputSpend) GetBlockHeight() uint32 {
+	return s.blockHeight
+}
+
+type spendIndex struct {
+	db          *bolt.DB
+	btcNode     *limitedNode
+	startHeight uint32
+}
+
+func openSpendIndex(fileName string, btcNode *limitedNode, startHeight uint32) (*spendIndex, error) {
+
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.New("SPEND INDEX ERROR: " + fileName + ": " + err.Error())
+	}
+
+	si := spendIndex{db: db, btcNode: btcNode, startHeight: startHeight}
+	err = si.checkVersion()
+	if err != nil {
+		db.Close()
+		return nil, errors.New("SPEND INDEX ERROR: " + err.Error())
+	}
+
+	return &si, nil
+}
+
+// the index is rebuilt if it was built by a different version or from a different start height
+func (si *spendIndex) checkVersion() error {
+
+	meta := make([]byte, 8)
+	binary.BigEndian.PutUint32(meta[:4], SPEND_INDEX_VERSION)
+	binary.BigEndian.PutUint32(meta[4:], si.startHeight)
+
+	return si.db.Update(func(tx *bolt.Tx) error {
+		metaBucket := tx.Bucket([]byte(SPEND_INDEX_BUCKET_Meta))
+		if metaBucket != nil && bytes.Equal(metaBucket.Get([]byte(SPEND_INDEX_KEY_Version)), meta) {
+			return nil
+		}
+
+		if metaBucket != nil {
+			fmt.Println("The spend index was built by a different version of the scantool or from a different start height and will be rebuilt.")
+		}
+
+		for _, bucket := range []string{SPEND_INDEX_BUCKET_Meta, SPEND_INDEX_BUCKET_Spends, SPEND_INDEX_BUCKET_Blocks} {
+			err := tx.DeleteBucket([]byte(bucket))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(SPEND_INDEX_BUCKET_Meta)).Put([]byte(SPEND_INDEX_KEY_Version), meta)
+	})
+}
+
+// an outpoint is the 32 bytes of the transaction id followed by the output index
+func getOutpointKey(txId string, outputIndex uint32) ([]byte, error) {
+	txIdBytes, err := hex.DecodeString(txId)
+	if err != nil || len(txIdBytes) != 32 {
+		return nil, errors.New(txId + " is not a transaction id.")
+	}
+	return binary.BigEndian.AppendUint32(txIdBytes, outputIndex), nil
+}
+
+// returns the spends of the outputs of a transaction, by output index
+// outputs that have not been spent in an indexed block are not included
+func (si *spendIndex) getOutputSpends(txId string) (map[uint16]OutputSpend, error) {
+
+	prefix, err := hex.DecodeString(txId)
+	if err != nil || len(prefix) != 32 {
+		return nil, newNotFoundError(txId + " is not a transaction id.")
+	}
+
+	spends := make(map[uint16]OutputSpend)
+	err = si.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(SPEND_INDEX_BUCKET_Spends)).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if len(key) != 36 || len(value) != 40 {
+				continue
+			}
+
+			outputIndex := binary.BigEndian.Uint32(key[32:])
+			spends[uint16(outputIndex)] = OutputSpend{txId: hex.EncodeToString(value[:32]), inputIndex: uint16(binary.BigEndian.Uint32(value[32:36])), blockHeight: binary.BigEndian.Uint32(value[36:])}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newUnavailableError("SPEND INDEX ERROR:", err)
+	}
+
+	return spends, nil
+}
+
+func (si *spendIndex) getName() string {
+	return "Spend index"
+}
+
+func (si *spendIndex) getStartHeight() uint32 {
+	return si.startHeight
+}
+
+// the spend index always follows the tip
+func (si *spendIndex) getEndHeight() uint32 {
+	return 0
+}
+
+func (si *spendIndex) getLastBlock() (int64, string) {
+
+	height := int64(-1)
+	blockHash := ""
+	si.db.View(func(tx *bolt.Tx) error {
+		key, value := tx.Bucket([]byte(SPEND_INDEX_BUCKET_Blocks)).Cursor().Last()
+		if len(key) == 4 {
+			height = int64(binary.BigEndian.Uint32(key))
+			blockHash = string(value)
+		}
+		return nil
+	})
+	return height, blockHash
+}
+
+// calls spend for the outpoint spent by every input in the block
+func forEachBlockSpend(rawBlock *nodeBlock, spend func(outpointKey []byte, txId string, inputIndex int) error) error {
+
+	if !rawBlock.hasTxData() {
+		return newDecodeError("Block has no transaction data.", nil)
+	}
+
+	rawTxs := rawBlock.getTxs()
+	for t := range rawTxs {
+		txId := rawTxs[t].TxId
+		for i := range rawTxs[t].Vin {
+			rawInput := &rawTxs[t].Vin[i]
+			if rawInput.isCoinbase() {
+				continue
+			}
+
+			outpointKey, err := getOutpointKey(rawInput.TxId, rawInput.Vout)
+			if err != nil {
+				return newDecodeError("Malformed input in transaction "+txId+".", err)
+			}
+
+			err = spend(outpointKey, txId, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (si *spendIndex) connectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return si.db.Update(func(tx *bolt.Tx) error {
+		spendBucket := tx.Bucket([]byte(SPEND_INDEX_BUCKET_Spends))
+		err := forEachBlockSpend(rawBlock, func(outpointKey []byte, txId string, inputIndex int) error {
+			txIdBytes, err := hex.DecodeString(txId)
+			if err != nil || len(txIdBytes) != 32 {
+				return newDecodeError(txId+" is not a transaction id.", nil)
+			}
+			value := binary.BigEndian.AppendUint32(txIdBytes, uint32(inputIndex))
+			value = binary.BigEndian.AppendUint32(value, blockHeight)
+			return spendBucket.Put(outpointKey, value)
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(SPEND_INDEX_BUCKET_Blocks)).Put(heightKey, []byte(blockHash))
+	})
+}
+
+// the spends of the inputs of a block replaced by a reorg are removed
+func (si *spendIndex) disconnectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return si.db.Update(func(tx *bolt.Tx) error {
+		if rawBlock != nil {
+			spendBucket := tx.Bucket([]byte(SPEND_INDEX_BUCKET_Spends))
+			err := forEachBlockSpend(rawBlock, func(outpointKey []byte, txId string, inputIndex int) error {
+				// the outpoint might have been spent again in a block that is still indexed
+				value := spendBucket.Get(outpointKey)
+				if len(value) == 40 && hex.EncodeToString(value[:32]) == txId {
+					return spendBucket.Delete(outpointKey)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(SPEND_INDEX_BUCKET_Blocks)).Delete(heightKey)
+	})
+}
@@ -0,0 +1,208 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// a small chain for testing the indexes, with a branch that replaces block 2
+//
+// block 0: tx 0 is a coinbase that funds script A twice
+// block 1: tx 1 is a coinbase to script B, tx 2 spends output 0 of tx 0 and funds scripts A and B
+// block 2: tx 3 is a coinbase to script B, tx 4 spends output 1 of tx 0 and output 0 of tx 2 and funds script B
+// stale block 2: tx 5 is a coinbase to script A, tx 6 spends output 1 of tx 0 and funds script A
+// stale block 3: tx 7 is a coinbase to script B
+
+const indexTestScriptA = "76a914aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa88ac"
+const indexTestScriptB = "0014bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+type indexTestNode struct {
+	nodeClient // only the requests used by the block scanner are implemented
+
+	mutex  sync.Mutex
+	blocks map[string]*nodeBlock
+	chain  []string // the active chain, height -> block hash
+}
+
+func getIndexTestTxId(n int) string {
+	return fmt.Sprintf("%064x", 0x3000+n)
+}
+
+type indexTestOutpoint struct {
+	txId string
+	vout uint32
+}
+
+// a coinbase transaction if there are no spends
+func newIndexTestTx(n int, spends []indexTestOutpoint, outputs ...nodeOutput) nodeTx {
+
+	rawTx := nodeTx{TxId: getIndexTestTxId(n), Version: 1, Vout: outputs}
+	if len(spends) == 0 {
+		rawTx.Vin = []nodeInput{{Coinbase: fmt.Sprintf("%04x", n), Sequence: 0xffffffff}}
+	}
+	for _, spend := range spends {
+		rawTx.Vin = append(rawTx.Vin, nodeInput{TxId: spend.txId, Vout: spend.vout, ScriptSig: &nodeScript{}, Sequence: 0xffffffff})
+	}
+	for o := range rawTx.Vout {
+		rawTx.Vout[o].N = uint32(o)
+	}
+	return rawTx
+}
+
+func newIndexTestOutput(value uint64, scriptHex string) nodeOutput {
+	return nodeOutput{Value: btcAmount(value), ScriptPubKey: nodeScript{Hex: scriptHex}}
+}
+
+func newIndexTestNode() *indexTestNode {
+
+	itn := indexTestNode{blocks: make(map[string]*nodeBlock)}
+	itn.addBlock(getZmqTestBlockHash(0), "", 0,
+		newIndexTestTx(0, nil, newIndexTestOutput(5000, indexTestScriptA), newIndexTestOutput(3000, indexTestScriptA)))
+	itn.addBlock(getZmqTestBlockHash(1), getZmqTestBlockHash(0), 1,
+		newIndexTestTx(1, nil, newIndexTestOutput(5000, indexTestScriptB)),
+		newIndexTestTx(2, []indexTestOutpoint{{getIndexTestTxId(0), 0}}, newIndexTestOutput(1000, indexTestScriptA), newIndexTestOutput(3900, indexTestScriptB)))
+	itn.addBlock(getZmqTestBlockHash(2), getZmqTestBlockHash(1), 2,
+		newIndexTestTx(3, nil, newIndexTestOutput(5000, indexTestScriptB)),
+		newIndexTestTx(4, []indexTestOutpoint{{getIndexTestTxId(0), 1}, {getIndexTestTxId(2), 0}}, newIndexTestOutput(3900, indexTestScriptB)))
+	itn.addBlock(getStaleTestBlockHash(2), getZmqTestBlockHash(1), 2,
+		newIndexTestTx(5, nil, newIndexTestOutput(5000, indexTestScriptA)),
+		newIndexTestTx(6, []indexTestOutpoint{{getIndexTestTxId(0), 1}}, newIndexTestOutput(2900, indexTestScriptA)))
+	itn.addBlock(getStaleTestBlockHash(3), getStaleTestBlockHash(2), 3,
+		newIndexTestTx(7, nil, newIndexTestOutput(5000, indexTestScriptB)))
+
+	itn.setChain(getZmqTestBlockHash(0), getZmqTestBlockHash(1), getZmqTestBlockHash(2))
+	return &itn
+}
+
+func (itn *indexTestNode) addBlock(blockHash string, previousHash string, height uint32, txs ...nodeTx) {
+	itn.blocks[blockHash] = &nodeBlock{Hash: blockHash, PreviousBlockHash: previousHash, Height: height, Version: 1, Tx: nodeBlockTxs{txs: txs}}
+}
+
+func (itn *indexTestNode) setChain(blockHashes ...string) {
+	itn.mutex.Lock()
+	defer itn.mutex.Unlock()
+	itn.chain = blockHashes
+}
+
+// replaces block 2 with the stale branch
+func (itn *indexTestNode) reorg() {
+	itn.setChain(getZmqTestBlockHash(0), getZmqTestBlockHash(1), getStaleTestBlockHash(2), getStaleTestBlockHash(3))
+}
+
+func (itn *indexTestNode) getBestBlockHash(ctx context.Context) (string, error) {
+	itn.mutex.Lock()
+	defer itn.mutex.Unlock()
+	return itn.chain[len(itn.chain)-1], nil
+}
+
+func (itn *indexTestNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	itn.mutex.Lock()
+	defer itn.mutex.Unlock()
+	if int(blockHeight) >= len(itn.chain) {
+		return "", newNotFoundError(fmt.Sprintf("Block %d not found.", blockHeight))
+	}
+	return itn.chain[blockHeight], nil
+}
+
+// blocks that are not in the active chain can still be read, as they can from Bitcoin Core
+func (itn *indexTestNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	itn.mutex.Lock()
+	defer itn.mutex.Unlock()
+
+	rawBlock, found := itn.blocks[blockHash]
+	if !found {
+		return nil, newNotFoundError("Block " + blockHash + " not found.")
+	}
+
+	block := *rawBlock
+	height := int(block.Height)
+	if height >= len(itn.chain) || itn.chain[height] != blockHash {
+		block.Confirmations = -1
+	} else if height+1 < len(itn.chain) {
+		block.NextBlockHash = itn.chain[height+1]
+	}
+	if !withTxData {
+		return block.withoutTxData(), nil
+	}
+	return &block, nil
+}
+
+// scans the test chain into the index and checks the last indexed block
+func scanIndexTestChain(t *testing.T, index scannedIndex, btcNode *indexTestNode, expectedHeight int64, expectedHash string) {
+	err := scanBlocks(context.Background(), index, newLimitedNode(btcNode, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, blockHash := index.getLastBlock()
+	if height != expectedHeight || blockHash != expectedHash {
+		t.Fatalf("%s: the last block is %d %s, expected %d %s", index.getName(), height, blockHash, expectedHeight, expectedHash)
+	}
+}
+
+func checkOutputSpends(t *testing.T, si *spendIndex, txId string, expected map[uint16]OutputSpend) {
+	spends, err := si.getOutputSpends(txId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spends, expected) {
+		t.Errorf("the outputs of tx %s were spent by %+v, expected %+v", txId, spends, expected)
+	}
+}
+
+func TestSpendIndex(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "spends.db")
+	si, err := openSpendIndex(fileName, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btcNode := newIndexTestNode()
+	scanIndexTestChain(t, si, btcNode, 2, getZmqTestBlockHash(2))
+	checkOutputSpends(t, si, getIndexTestTxId(0), map[uint16]OutputSpend{
+		0: {txId: getIndexTestTxId(2), inputIndex: 0, blockHeight: 1},
+		1: {txId: getIndexTestTxId(4), inputIndex: 0, blockHeight: 2}})
+	checkOutputSpends(t, si, getIndexTestTxId(2), map[uint16]OutputSpend{0: {txId: getIndexTestTxId(4), inputIndex: 1, blockHeight: 2}})
+
+	// block 2 is replaced, so output 0 of tx 2 is unspent and output 1 of tx 0 is spent by tx 6 instead
+	btcNode.reorg()
+	scanIndexTestChain(t, si, btcNode, 3, getStaleTestBlockHash(3))
+	checkOutputSpends(t, si, getIndexTestTxId(0), map[uint16]OutputSpend{
+		0: {txId: getIndexTestTxId(2), inputIndex: 0, blockHeight: 1},
+		1: {txId: getIndexTestTxId(6), inputIndex: 0, blockHeight: 2}})
+	checkOutputSpends(t, si, getIndexTestTxId(2), map[uint16]OutputSpend{})
+
+	// the index is kept when it is opened again, unless the start height changed
+	si.db.Close()
+	si, err = openSpendIndex(fileName, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, blockHash := si.getLastBlock()
+	if height != 3 || blockHash != getStaleTestBlockHash(3) {
+		t.Errorf("the reopened index ends at block %d %s", height, blockHash)
+	}
+	checkOutputSpends(t, si, getIndexTestTxId(2), map[uint16]OutputSpend{})
+	scanIndexTestChain(t, si, btcNode, 3, getStaleTestBlockHash(3))
+	checkOutputSpends(t, si, getIndexTestTxId(0), map[uint16]OutputSpend{
+		0: {txId: getIndexTestTxId(2), inputIndex: 0, blockHeight: 1},
+		1: {txId: getIndexTestTxId(6), inputIndex: 0, blockHeight: 2}})
+	si.db.Close()
+
+	// spends before the start height are not indexed
+	si, err = openSpendIndex(fileName, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { si.db.Close() })
+	height, _ = si.getLastBlock()
+	if height != -1 {
+		t.Errorf("the index was not rebuilt for a new start height, it ends at block %d", height)
+	}
+	scanIndexTestChain(t, si, btcNode, 3, getStaleTestBlockHash(3))
+	checkOutputSpends(t, si, getIndexTestTxId(0), map[uint16]OutputSpend{1: {txId: getIndexTestTxId(6), inputIndex: 0, blockHeight: 2}})
+}
@@ -0,0 +1,187 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the transaction block index maps each transaction id to the block it is in
+// it lets transactions be found on a node that has no transaction index, such as a pruned node or a node without -txindex,
+// by requesting the transaction from its block
+// it is built by the block scanner in the same way as the spend index
+
+// increment whenever the stored data changes, an index built by another version is rebuilt
+const TX_BLOCK_INDEX_VERSION = 1
+
+// buckets
+const TX_BLOCK_INDEX_BUCKET_Meta = "meta"
+const TX_BLOCK_INDEX_BUCKET_Txs = "txs"       // txid -> block height
+const TX_BLOCK_INDEX_BUCKET_Blocks = "blocks" // height -> hash of every indexed block
+
+// meta keys
+const TX_BLOCK_INDEX_KEY_Version = "version" // the version followed by the start height
+
+type txBlockIndex struct {
+	db          *bolt.DB
+	startHeight uint32
+}
+
+func openTxBlockIndex(fileName string, startHeight uint32) (*txBlockIndex, error) {
+
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.New("TX BLOCK INDEX ERROR: " + fileName + ": " + err.Error())
+	}
+
+	ti := txBlockIndex{db: db, startHeight: startHeight}
+	err = ti.checkVersion()
+	if err != nil {
+		db.Close()
+		return nil, errors.New("TX BLOCK INDEX ERROR: " + err.Error())
+	}
+
+	return &ti, nil
+}
+
+// the index is rebuilt if it was built by a different version or from a different start height
+func (ti *txBlockIndex) checkVersion() error {
+
+	meta := make([]byte, 8)
+	binary.BigEndian.PutUint32(meta[:4], TX_BLOCK_INDEX_VERSION)
+	binary.BigEndian.PutUint32(meta[4:], ti.startHeight)
+
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		metaBucket := tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Meta))
+		if metaBucket != nil && bytes.Equal(metaBucket.Get([]byte(TX_BLOCK_INDEX_KEY_Version)), meta) {
+			return nil
+		}
+
+		if metaBucket != nil {
+			fmt.Println("The transaction block index was built by a different version of the scantool or from a different start height and will be rebuilt.")
+		}
+
+		for _, bucket := range []string{TX_BLOCK_INDEX_BUCKET_Meta, TX_BLOCK_INDEX_BUCKET_Txs, TX_BLOCK_INDEX_BUCKET_Blocks} {
+			err := tx.DeleteBucket([]byte(bucket))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Meta)).Put([]byte(TX_BLOCK_INDEX_KEY_Version), meta)
+	})
+}
+
+// returns the hash of the block the transaction is in, or an empty string if the transaction is not in an indexed block
+func (ti *txBlockIndex) getBlockHash(txId string) string {
+
+	txIdBytes, err := hex.DecodeString(txId)
+	if err != nil || len(txIdBytes) != 32 {
+		return ""
+	}
+
+	blockHash := ""
+	ti.db.View(func(tx *bolt.Tx) error {
+		heightKey := tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Txs)).Get(txIdBytes)
+		if len(heightKey) == 4 {
+			blockHash = string(tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Blocks)).Get(heightKey))
+		}
+		return nil
+	})
+	return blockHash
+}
+
+func (ti *txBlockIndex) getName() string {
+	return "Transaction block index"
+}
+
+func (ti *txBlockIndex) getStartHeight() uint32 {
+	return ti.startHeight
+}
+
+// the transaction block index always follows the tip
+func (ti *txBlockIndex) getEndHeight() uint32 {
+	return 0
+}
+
+func (ti *txBlockIndex) getLastBlock() (int64, string) {
+
+	height := int64(-1)
+	blockHash := ""
+	ti.db.View(func(tx *bolt.Tx) error {
+		key, value := tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Blocks)).Cursor().Last()
+		if len(key) == 4 {
+			height = int64(binary.BigEndian.Uint32(key))
+			blockHash = string(value)
+		}
+		return nil
+	})
+	return height, blockHash
+}
+
+// calls add with the 32 bytes of every transaction id in the block
+func forEachBlockTxId(rawBlock *nodeBlock, add func(txIdBytes []byte) error) error {
+
+	for _, txId := range rawBlock.getTxIds() {
+		txIdBytes, err := hex.DecodeString(txId)
+		if err != nil || len(txIdBytes) != 32 {
+			return newDecodeError(txId+" is not a transaction id.", nil)
+		}
+
+		err = add(txIdBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ti *txBlockIndex) connectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		txBucket := tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Txs))
+		err := forEachBlockTxId(rawBlock, func(txIdBytes []byte) error {
+			return txBucket.Put(txIdBytes, heightKey)
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Blocks)).Put(heightKey, []byte(blockHash))
+	})
+}
+
+// the transactions of a block replaced by a reorg are removed
+// transactions that are in another indexed block are left in the index
+func (ti *txBlockIndex) disconnectBlock(blockHeight uint32, blockHash string, rawBlock *nodeBlock) error {
+
+	heightKey := binary.BigEndian.AppendUint32(nil, blockHeight)
+
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		if rawBlock != nil {
+			txBucket := tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Txs))
+			err := forEachBlockTxId(rawBlock, func(txIdBytes []byte) error {
+				if bytes.Equal(txBucket.Get(txIdBytes), heightKey) {
+					return txBucket.Delete(txIdBytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(TX_BLOCK_INDEX_BUCKET_Blocks)).Delete(heightKey)
+	})
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// the block hash of each test transaction, or an empty string if it is not in an indexed block
+func checkTxBlockHashes(t *testing.T, ti *txBlockIndex, expected map[int]string) {
+	for n, expectedHash := range expected {
+		blockHash := ti.getBlockHash(getIndexTestTxId(n))
+		if blockHash != expectedHash {
+			t.Errorf("tx %d is in block %q, expected %q", n, blockHash, expectedHash)
+		}
+	}
+}
+
+func TestTxBlockIndex(t *testing.T) {
+
+	fileName := filepath.Join(t.TempDir(), "tx-blocks.db")
+	ti, err := openTxBlockIndex(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btcNode := newIndexTestNode()
+	scanIndexTestChain(t, ti, btcNode, 2, getZmqTestBlockHash(2))
+	checkTxBlockHashes(t, ti, map[int]string{
+		0: getZmqTestBlockHash(0),
+		1: getZmqTestBlockHash(1),
+		2: getZmqTestBlockHash(1),
+		3: getZmqTestBlockHash(2),
+		4: getZmqTestBlockHash(2),
+		5: ""})
+	if ti.getBlockHash("not a tx id") != "" {
+		t.Error("found the block of an invalid transaction id")
+	}
+
+	// the transactions of block 2 are replaced by those of the stale branch
+	btcNode.reorg()
+	scanIndexTestChain(t, ti, btcNode, 3, getStaleTestBlockHash(3))
+	expectedBlockHashes := map[int]string{
+		0: getZmqTestBlockHash(0),
+		2: getZmqTestBlockHash(1),
+		3: "",
+		4: "",
+		5: getStaleTestBlockHash(2),
+		6: getStaleTestBlockHash(2),
+		7: getStaleTestBlockHash(3)}
+	checkTxBlockHashes(t, ti, expectedBlockHashes)
+
+	// the index is kept when it is opened again, unless the start height changed
+	ti.db.Close()
+	ti, err = openTxBlockIndex(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, blockHash := ti.getLastBlock()
+	if height != 3 || blockHash != getStaleTestBlockHash(3) {
+		t.Errorf("the reopened index ends at block %d %s", height, blockHash)
+	}
+	checkTxBlockHashes(t, ti, expectedBlockHashes)
+	ti.db.Close()
+
+	// transactions before the start height are not indexed
+	ti, err = openTxBlockIndex(fileName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ti.db.Close() })
+	height, _ = ti.getLastBlock()
+	if height != -1 {
+		t.Errorf("the index was not rebuilt for a new start height, it ends at block %d", height)
+	}
+	scanIndexTestChain(t, ti, btcNode, 3, getStaleTestBlockHash(3))
+	checkTxBlockHashes(t, ti, map[int]string{
+		0: "",
+		2: "",
+		5: getStaleTestBlockHash(2),
+		7: getStaleTestBlockHash(3)})
+}
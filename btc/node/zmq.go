@@ -0,0 +1,402 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btc-script-explorer/scantool/app"
+)
+
+// subscribes to Bitcoin Core's ZMQ notifications and publishes them to the event bus
+// only the parts of ZMTP 3.0 needed for a SUB socket with the NULL security mechanism are implemented
+
+const ZMQ_TOPIC_RawBlock = "rawblock"
+const ZMQ_TOPIC_HashBlock = "hashblock"
+const ZMQ_TOPIC_RawTx = "rawtx"
+const ZMQ_TOPIC_Sequence = "sequence"
+
+const ZMQ_MIN_RECONNECT_DELAY = time.Second
+const ZMQ_MAX_RECONNECT_DELAY = 30 * time.Second
+
+// the number of recently published blocks remembered, so a block notified on multiple topics is only published once
+const ZMQ_RECENT_BLOCK_COUNT = 10
+
+type zmqSubscriber struct {
+	btcNode nodeClient
+	bus     *EventBus
+
+	blockMutex      sync.Mutex
+	lastBlockHeight int32
+	recentBlocks    []string
+}
+
+// starts a subscriber for every endpoint, each of which can publish more than one topic
+func startZmqSubscriber(btcNode nodeClient, topicEndpoints map[string]string) *zmqSubscriber {
+
+	zs := zmqSubscriber{btcNode: btcNode, bus: GetEventBus()}
+	tipHash, err := btcNode.getBestBlockHash(context.Background())
+	LogError(err)
+	zs.lastBlockHeight = zs.getBlockHeight(tipHash)
+	zs.addRecentBlock(tipHash)
+
+	endpointTopics := make(map[string][]string)
+	for topic, endpoint := range topicEndpoints {
+		endpointTopics[endpoint] = append(endpointTopics[endpoint], topic)
+	}
+
+	for endpoint, topics := range endpointTopics {
+		go zs.run(endpoint, topics)
+	}
+
+	return &zs
+}
+
+// connects to the endpoint and reads messages, reconnecting whenever the connection is lost
+func (zs *zmqSubscriber) run(endpoint string, topics []string) {
+
+	reconnectDelay := ZMQ_MIN_RECONNECT_DELAY
+	for connectionCount := 0; ; connectionCount++ {
+
+		conn, err := zmqConnect(endpoint, topics)
+		if err != nil {
+			fmt.Println("ZMQ ERROR: " + err.Error())
+			time.Sleep(reconnectDelay)
+			reconnectDelay *= 2
+			if reconnectDelay > ZMQ_MAX_RECONNECT_DELAY {
+				reconnectDelay = ZMQ_MAX_RECONNECT_DELAY
+			}
+			continue
+		}
+		reconnectDelay = ZMQ_MIN_RECONNECT_DELAY
+
+		// blocks might have been missed while disconnected
+		if connectionCount > 0 {
+			tipHash, err := zs.btcNode.getBestBlockHash(context.Background())
+			LogError(err)
+			zs.handleBlock(tipHash, nil, true)
+		}
+
+		lastSequence := make(map[string]uint32)
+		for {
+			frames, err := zmqReadMessage(conn)
+			if err != nil {
+				fmt.Println("ZMQ ERROR: " + endpoint + ": " + err.Error())
+				break
+			}
+
+			// every notification has three parts, the topic, the body and a sequence number
+			if len(frames) != 3 || len(frames[2]) != 4 {
+				continue
+			}
+			topic := string(frames[0])
+			sequence := binary.LittleEndian.Uint32(frames[2])
+			previousSequence, found := lastSequence[topic]
+			if found && sequence != previousSequence+1 {
+				fmt.Println(fmt.Sprintf("ZMQ: %d %s notifications missed.", sequence-previousSequence-1, topic))
+			}
+			lastSequence[topic] = sequence
+
+			zs.handleMessage(topic, frames[1])
+		}
+		conn.Close()
+	}
+}
+
+func (zs *zmqSubscriber) handleMessage(topic string, body []byte) {
+
+	switch topic {
+
+	case ZMQ_TOPIC_RawBlock:
+		if len(body) < BLOCK_HEADER_SIZE {
+			return
+		}
+		blockHash, _ := parseBlockHeader(body)
+		zs.handleBlock(blockHash, body, false)
+
+	case ZMQ_TOPIC_HashBlock:
+		if len(body) != 32 {
+			return
+		}
+		zs.handleBlock(hex.EncodeToString(body), nil, false)
+
+	case ZMQ_TOPIC_RawTx:
+		r := byteReader{data: body}
+		rawTx, err := deserializeTx(&r, "")
+		if err != nil {
+			fmt.Println("ZMQ ERROR: " + err.Error())
+			return
+		}
+		zs.bus.publish(Event{eventType: EVENT_TYPE_Tx, blockHeight: -1, txId: rawTx.TxId, rawData: body})
+
+	case ZMQ_TOPIC_Sequence:
+		// a hash followed by a label, and a mempool sequence number for mempool events
+		if len(body) < 33 {
+			return
+		}
+		hash := hex.EncodeToString(body[0:32])
+		switch body[32] {
+		case 'C':
+			zs.handleBlock(hash, nil, false)
+		case 'D':
+			zs.bus.publish(Event{eventType: EVENT_TYPE_BlockDisconnected, blockHash: hash, blockHeight: -1})
+		case 'A', 'R':
+			eventType := EVENT_TYPE_MempoolAdd
+			if body[32] == 'R' {
+				eventType = EVENT_TYPE_MempoolRemove
+			}
+			mempoolSequence := uint64(0)
+			if len(body) == 41 {
+				mempoolSequence = binary.LittleEndian.Uint64(body[33:41])
+			}
+			zs.bus.publish(Event{eventType: eventType, blockHeight: -1, txId: hash, mempoolSequence: mempoolSequence})
+		}
+	}
+}
+
+// publishes a connected block, after publishing any blocks that were missed before it
+func (zs *zmqSubscriber) handleBlock(blockHash string, rawBlock []byte, backfilled bool) {
+
+	if len(blockHash) == 0 {
+		return
+	}
+
+	zs.blockMutex.Lock()
+	defer zs.blockMutex.Unlock()
+
+	for _, recentBlock := range zs.recentBlocks {
+		if recentBlock == blockHash {
+			return
+		}
+	}
+
+	blockHeight := zs.getBlockHeight(blockHash)
+	if blockHeight >= 0 && zs.lastBlockHeight >= 0 {
+		for missedHeight := zs.lastBlockHeight + 1; missedHeight < blockHeight; missedHeight++ {
+			missedHash, err := zs.btcNode.getBlockHash(context.Background(), uint32(missedHeight))
+			LogError(err)
+			if err == nil {
+				zs.addRecentBlock(missedHash)
+				zs.bus.publish(Event{eventType: EVENT_TYPE_BlockConnected, blockHash: missedHash, blockHeight: missedHeight, backfilled: true})
+			}
+		}
+	}
+
+	zs.addRecentBlock(blockHash)
+	zs.bus.publish(Event{eventType: EVENT_TYPE_BlockConnected, blockHash: blockHash, blockHeight: blockHeight, rawData: rawBlock, backfilled: backfilled})
+
+	if blockHeight >= 0 {
+		zs.lastBlockHeight = blockHeight
+	}
+}
+
+func (zs *zmqSubscriber) addRecentBlock(blockHash string) {
+	zs.recentBlocks = append(zs.recentBlocks, blockHash)
+	if len(zs.recentBlocks) > ZMQ_RECENT_BLOCK_COUNT {
+		zs.recentBlocks = zs.recentBlocks[1:]
+	}
+}
+
+// returns -1 if the height can not be determined
+func (zs *zmqSubscriber) getBlockHeight(blockHash string) int32 {
+	if len(blockHash) == 0 {
+		return -1
+	}
+
+	rawBlock, err := zs.btcNode.getBlock(context.Background(), blockHash, false)
+	if err != nil {
+		LogError(err)
+		return -1
+	}
+	return int32(rawBlock.Height)
+}
+
+// ZMTP
+
+// connects to a ZMQ publisher and subscribes to the topics
+func zmqConnect(endpoint string, topics []string) (net.Conn, error) {
+
+	if !strings.HasPrefix(endpoint, "tcp://") {
+		return nil, errors.New("Unsupported ZMQ endpoint " + endpoint + ", only tcp:// is supported.")
+	}
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(endpoint, "tcp://"), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	err = zmqHandshake(conn, "SUB")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// in ZMTP 3.0, a subscription is a message that begins with 1
+	for _, topic := range topics {
+		err = zmqWriteFrame(conn, 0x00, append([]byte{0x01}, []byte(topic)...))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// exchanges greetings and READY commands
+func zmqHandshake(conn net.Conn, socketType string) error {
+
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 // version 3.0
+	copy(greeting[12:32], []byte("NULL"))
+	_, err := conn.Write(greeting)
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	peerGreeting := make([]byte, 64)
+	_, err = io.ReadFull(conn, peerGreeting)
+	if err != nil {
+		return err
+	}
+	if peerGreeting[0] != 0xff || peerGreeting[9] != 0x7f || peerGreeting[10] < 3 {
+		return errors.New("Peer is not a ZMTP 3 publisher.")
+	}
+	if !bytes.Equal(bytes.TrimRight(peerGreeting[12:32], "\x00"), []byte("NULL")) {
+		return errors.New("Peer does not use the NULL security mechanism.")
+	}
+
+	err = zmqWriteFrame(conn, 0x04, zmqReadyCommand(socketType))
+	if err != nil {
+		return err
+	}
+
+	// wait for the peer's READY command
+	for {
+		flags, body, err := zmqReadFrame(conn)
+		if err != nil {
+			return err
+		}
+		if flags&0x04 == 0 || len(body) == 0 || len(body) < 1+int(body[0]) {
+			continue
+		}
+		commandName := string(body[1 : 1+int(body[0])])
+		switch commandName {
+		case "READY":
+			return nil
+		case "ERROR":
+			return errors.New("Peer returned error during handshake.")
+		}
+	}
+}
+
+func zmqReadyCommand(socketType string) []byte {
+	command := []byte{5}
+	command = append(command, []byte("READY")...)
+
+	propertyName := "Socket-Type"
+	command = append(command, byte(len(propertyName)))
+	command = append(command, []byte(propertyName)...)
+
+	propertyValueLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(propertyValueLen, uint32(len(socketType)))
+	command = append(command, propertyValueLen...)
+	command = append(command, []byte(socketType)...)
+
+	return command
+}
+
+func zmqWriteFrame(w io.Writer, flags byte, body []byte) error {
+	header := []byte{flags}
+	if len(body) > 255 {
+		header[0] |= 0x02
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(body)))
+		header = append(header, size...)
+	} else {
+		header = append(header, byte(len(body)))
+	}
+
+	_, err := w.Write(append(header, body...))
+	return err
+}
+
+func zmqReadFrame(r io.Reader) (byte, []byte, error) {
+	flags := make([]byte, 1)
+	_, err := io.ReadFull(r, flags)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := uint64(0)
+	if flags[0]&0x02 != 0 {
+		sizeBytes := make([]byte, 8)
+		_, err = io.ReadFull(r, sizeBytes)
+		size = binary.BigEndian.Uint64(sizeBytes)
+	} else {
+		sizeBytes := make([]byte, 1)
+		_, err = io.ReadFull(r, sizeBytes)
+		size = uint64(sizeBytes[0])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// no block or transaction can be anywhere near this large
+	if size > 1<<30 {
+		return 0, nil, errors.New(fmt.Sprintf("ZMQ frame too large (%d bytes).", size))
+	}
+
+	body := make([]byte, size)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return flags[0], body, nil
+}
+
+// returns the frames of the next message, skipping any commands
+func zmqReadMessage(r io.Reader) ([][]byte, error) {
+	frames := make([][]byte, 0, 3)
+	for {
+		flags, body, err := zmqReadFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&0x04 != 0 {
+			continue
+		}
+
+		frames = append(frames, body)
+		if flags&0x01 == 0 {
+			return frames, nil
+		}
+	}
+}
+
+// the ZMQ endpoints come from the settings and are only used when at least one is set
+func getZmqTopicEndpoints() map[string]string {
+	topicEndpoints := make(map[string]string)
+	for topic, endpoint := range map[string]string{ZMQ_TOPIC_RawBlock: app.Settings.GetZmqRawBlockEndpoint(),
+		ZMQ_TOPIC_HashBlock: app.Settings.GetZmqHashBlockEndpoint(),
+		ZMQ_TOPIC_RawTx:     app.Settings.GetZmqRawTxEndpoint(),
+		ZMQ_TOPIC_Sequence:  app.Settings.GetZmqSequenceEndpoint()} {
+		if len(endpoint) > 0 {
+			topicEndpoints[topic] = endpoint
+		}
+	}
+	return topicEndpoints
+}
@@ -0,0 +1,270 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a chain of made up block hashes, with a tip that can be moved while the subscriber is disconnected
+type zmqTestNode struct {
+	nodeClient // only the block requests are implemented
+
+	mutex     sync.Mutex
+	tipHeight uint32
+}
+
+func getZmqTestBlockHash(height uint32) string {
+	return fmt.Sprintf("%064x", 0x1000+height)
+}
+
+func (ztn *zmqTestNode) setTipHeight(height uint32) {
+	ztn.mutex.Lock()
+	defer ztn.mutex.Unlock()
+	ztn.tipHeight = height
+}
+
+func (ztn *zmqTestNode) getBestBlockHash(ctx context.Context) (string, error) {
+	ztn.mutex.Lock()
+	defer ztn.mutex.Unlock()
+	return getZmqTestBlockHash(ztn.tipHeight), nil
+}
+
+func (ztn *zmqTestNode) getBlockHash(ctx context.Context, blockHeight uint32) (string, error) {
+	return getZmqTestBlockHash(blockHeight), nil
+}
+
+func (ztn *zmqTestNode) getBlock(ctx context.Context, blockHash string, withTxData bool) (*nodeBlock, error) {
+	var height uint32
+	_, err := fmt.Sscanf(blockHash, "%x", &height)
+	if err != nil || height < 0x1000 {
+		return nil, newNotFoundError("Block " + blockHash + " not found.")
+	}
+	return &nodeBlock{Hash: blockHash, Height: height - 0x1000}, nil
+}
+
+// a ZMTP 3.0 publisher that accepts one subscriber at a time, the way Bitcoin Core's PUB sockets do
+type zmqTestPublisher struct {
+	t        *testing.T
+	listener net.Listener
+	sequence map[string]uint32
+}
+
+func newZmqTestPublisher(t *testing.T) *zmqTestPublisher {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &zmqTestPublisher{t: t, listener: listener, sequence: make(map[string]uint32)}
+}
+
+func (ztp *zmqTestPublisher) getEndpoint() string {
+	return "tcp://" + ztp.listener.Addr().String()
+}
+
+// completes the handshake and returns the connection and the topics the subscriber subscribed to
+func (ztp *zmqTestPublisher) accept(topicCount int) (net.Conn, []string) {
+
+	conn, err := ztp.listener.Accept()
+	if err != nil {
+		ztp.t.Fatal(err)
+	}
+	ztp.t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	greeting := make([]byte, 64)
+	_, err = io.ReadFull(conn, greeting)
+	if err != nil {
+		ztp.t.Fatal(err)
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] != 3 || !bytes.HasPrefix(greeting[12:32], []byte("NULL\x00")) {
+		ztp.t.Fatalf("unexpected greeting %x", greeting)
+	}
+
+	publisherGreeting := make([]byte, 64)
+	publisherGreeting[0] = 0xff
+	publisherGreeting[9] = 0x7f
+	publisherGreeting[10] = 3
+	publisherGreeting[11] = 0
+	copy(publisherGreeting[12:32], []byte("NULL"))
+	_, err = conn.Write(publisherGreeting)
+	if err != nil {
+		ztp.t.Fatal(err)
+	}
+
+	flags, body, err := zmqReadFrame(conn)
+	if err != nil {
+		ztp.t.Fatal(err)
+	}
+	if flags&0x04 == 0 || !bytes.Equal(body, zmqReadyCommand("SUB")) {
+		ztp.t.Fatalf("unexpected READY command %x %x", flags, body)
+	}
+	err = zmqWriteFrame(conn, 0x04, zmqReadyCommand("PUB"))
+	if err != nil {
+		ztp.t.Fatal(err)
+	}
+
+	topics := make([]string, 0, topicCount)
+	for len(topics) < topicCount {
+		flags, body, err = zmqReadFrame(conn)
+		if err != nil {
+			ztp.t.Fatal(err)
+		}
+		if flags != 0 || len(body) == 0 || body[0] != 0x01 {
+			ztp.t.Fatalf("unexpected subscription %x %x", flags, body)
+		}
+		topics = append(topics, string(body[1:]))
+	}
+	sort.Strings(topics)
+
+	conn.SetDeadline(time.Time{})
+	return conn, topics
+}
+
+func (ztp *zmqTestPublisher) publish(conn net.Conn, topic string, body []byte) {
+	sequence := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sequence, ztp.sequence[topic])
+	ztp.sequence[topic]++
+
+	for f, frame := range [][]byte{[]byte(topic), body, sequence} {
+		flags := byte(0x01)
+		if f == 2 {
+			flags = 0x00
+		}
+		err := zmqWriteFrame(conn, flags, frame)
+		if err != nil {
+			ztp.t.Fatal(err)
+		}
+	}
+}
+
+func receiveTestEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func expectNoTestEvent(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s event", event.GetType())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func expectBlockEvent(t *testing.T, events <-chan Event, eventType string, height int32, backfilled bool) {
+	t.Helper()
+	event := receiveTestEvent(t, events)
+	expectedHash := getZmqTestBlockHash(uint32(height))
+	if event.GetType() != eventType || event.GetBlockHash() != expectedHash || event.IsBackfilled() != backfilled {
+		t.Errorf("received %s %s %d backfilled %t, expected %s %s %d backfilled %t", event.GetType(), event.GetBlockHash(), event.GetBlockHeight(), event.IsBackfilled(),
+			eventType, expectedHash, height, backfilled)
+	}
+	if eventType == EVENT_TYPE_BlockConnected && event.GetBlockHeight() != height {
+		t.Errorf("block %s has height %d, expected %d", event.GetBlockHash(), event.GetBlockHeight(), height)
+	}
+}
+
+func TestZmqSubscriber(t *testing.T) {
+
+	btcNode := &zmqTestNode{tipHeight: 100}
+	publisher := newZmqTestPublisher(t)
+
+	blockSubscriberId, blockEvents := GetEventBus().Subscribe(100, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+	defer GetEventBus().Unsubscribe(blockSubscriberId)
+	allSubscriberId, allEvents := GetEventBus().Subscribe(100)
+	defer GetEventBus().Unsubscribe(allSubscriberId)
+
+	startZmqSubscriber(btcNode, map[string]string{ZMQ_TOPIC_HashBlock: publisher.getEndpoint(),
+		ZMQ_TOPIC_RawTx:    publisher.getEndpoint(),
+		ZMQ_TOPIC_Sequence: publisher.getEndpoint()})
+
+	conn, topics := publisher.accept(3)
+	if fmt.Sprint(topics) != fmt.Sprint([]string{ZMQ_TOPIC_HashBlock, ZMQ_TOPIC_RawTx, ZMQ_TOPIC_Sequence}) {
+		t.Errorf("subscribed to %v", topics)
+	}
+
+	// transactions only go to subscribers that accept every event type
+	serializedTx, _ := hex.DecodeString(testSegwitTxHex)
+	publisher.publish(conn, ZMQ_TOPIC_RawTx, serializedTx)
+	event := receiveTestEvent(t, allEvents)
+	if event.GetType() != EVENT_TYPE_Tx || event.GetTxId() != testSegwitTxId || !bytes.Equal(event.GetRawData(), serializedTx) {
+		t.Errorf("received %s %s, expected a tx event for %s", event.GetType(), event.GetTxId(), testSegwitTxId)
+	}
+	expectNoTestEvent(t, blockEvents)
+
+	// the blocks between the last known tip and a new block are backfilled first
+	blockHash, _ := hex.DecodeString(getZmqTestBlockHash(103))
+	publisher.publish(conn, ZMQ_TOPIC_HashBlock, blockHash)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 101, true)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 102, true)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 103, false)
+
+	// a block notified on another topic is only published once
+	publisher.publish(conn, ZMQ_TOPIC_Sequence, append(append([]byte{}, blockHash...), 'C'))
+	publisher.publish(conn, ZMQ_TOPIC_Sequence, append(append([]byte{}, blockHash...), 'D'))
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockDisconnected, 103, false)
+
+	// the blocks connected while the subscriber was disconnected are backfilled after it reconnects
+	conn.Close()
+	btcNode.setTipHeight(105)
+	conn, topics = publisher.accept(3)
+	if len(topics) != 3 {
+		t.Errorf("resubscribed to %v", topics)
+	}
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 104, true)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 105, true)
+
+	// notifications continue on the new connection
+	blockHash, _ = hex.DecodeString(getZmqTestBlockHash(106))
+	publisher.publish(conn, ZMQ_TOPIC_HashBlock, blockHash)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 106, false)
+	expectNoTestEvent(t, blockEvents)
+}
+
+// a subscriber that only accepts block events does not lose them to a flood of transactions
+func TestEventBusFilter(t *testing.T) {
+
+	bus := &EventBus{subscribers: make(map[uint64]eventSubscriber)}
+	blockSubscriberId, blockEvents := bus.Subscribe(2, EVENT_TYPE_BlockConnected, EVENT_TYPE_BlockDisconnected)
+	allSubscriberId, allEvents := bus.Subscribe(2)
+
+	for i := 0; i < 10; i++ {
+		bus.publish(Event{eventType: EVENT_TYPE_Tx, blockHeight: -1, txId: fmt.Sprintf("%064x", i)})
+	}
+	bus.publish(Event{eventType: EVENT_TYPE_BlockConnected, blockHash: getZmqTestBlockHash(1), blockHeight: 1})
+	bus.publish(Event{eventType: EVENT_TYPE_BlockDisconnected, blockHash: getZmqTestBlockHash(1), blockHeight: -1})
+
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockConnected, 1, false)
+	expectBlockEvent(t, blockEvents, EVENT_TYPE_BlockDisconnected, 1, false)
+
+	// the subscriber that accepts everything was full after the first two transactions
+	for i := 0; i < 2; i++ {
+		event := receiveTestEvent(t, allEvents)
+		if event.GetType() != EVENT_TYPE_Tx {
+			t.Errorf("received %s, expected a tx event", event.GetType())
+		}
+	}
+	expectNoTestEvent(t, allEvents)
+
+	bus.Unsubscribe(blockSubscriberId)
+	bus.Unsubscribe(allSubscriberId)
+	if _, open := <-blockEvents; open {
+		t.Error("the channel of an unsubscribed subscriber is open")
+	}
+}
@@ -0,0 +1,162 @@
+package btc
+
+import (
+	"fmt"
+)
+
+// sub-types of the Non-Standard spend type
+const NON_STANDARD_TYPE_AnyoneCanSpend = "Anyone-Can-Spend"
+const NON_STANDARD_TYPE_HashPuzzle = "Hash Puzzle"
+const NON_STANDARD_TYPE_Unspendable = "Unspendable"
+const NON_STANDARD_TYPE_FutureWitnessVersion = "Future Witness Version"
+const NON_STANDARD_TYPE_InvalidRedeemScript = "Invalid Redeem Script"
+const NON_STANDARD_TYPE_InvalidWitnessScript = "Invalid Witness Script"
+const NON_STANDARD_TYPE_InvalidTapScript = "Invalid Tap Script"
+const NON_STANDARD_TYPE_InvalidControlBlock = "Invalid Control Block"
+const NON_STANDARD_TYPE_InvalidSignature = "Invalid Signature"
+const NON_STANDARD_TYPE_ExtraStackItems = "Extra Stack Items"
+const NON_STANDARD_TYPE_MissingWitness = "Missing Witness"
+const NON_STANDARD_TYPE_NonEmptyInputScript = "Non-Empty Input Script"
+const NON_STANDARD_TYPE_Unknown = "Unknown"
+
+// the order in which the sub-types are displayed
+func GetNonStandardTypes() []string {
+	return []string{NON_STANDARD_TYPE_AnyoneCanSpend,
+		NON_STANDARD_TYPE_HashPuzzle,
+		NON_STANDARD_TYPE_Unspendable,
+		NON_STANDARD_TYPE_FutureWitnessVersion,
+		NON_STANDARD_TYPE_InvalidRedeemScript,
+		NON_STANDARD_TYPE_InvalidWitnessScript,
+		NON_STANDARD_TYPE_InvalidTapScript,
+		NON_STANDARD_TYPE_InvalidControlBlock,
+		NON_STANDARD_TYPE_InvalidSignature,
+		NON_STANDARD_TYPE_ExtraStackItems,
+		NON_STANDARD_TYPE_MissingWitness,
+		NON_STANDARD_TYPE_NonEmptyInputScript,
+		NON_STANDARD_TYPE_Unknown}
+}
+
+// returns the label used for a non-standard sub-type in charts and lists
+func GetNonStandardSpendTypeLabel(nonStandardType string) string {
+	if len(nonStandardType) == 0 {
+		return SPEND_TYPE_NonStandard
+	}
+	return SPEND_TYPE_NonStandard + " (" + nonStandardType + ")"
+}
+
+// determines why an input could not be identified as one of the standard spend types
+// returns the sub-type and a human readable reason
+func (i *Input) classifyNonStandard() (string, string) {
+
+	previousOutputScript := i.previousOutput.GetOutputScript()
+
+	switch i.previousOutput.GetOutputType() {
+
+	case OUTPUT_TYPE_P2SH:
+
+		// a plain p2sh spend is always identified, so only the p2sh-wrapped segwit spend types get here
+		if i.redeemScript.IsNil() {
+			return NON_STANDARD_TYPE_InvalidRedeemScript, "redeem script failed to parse"
+		}
+		return NON_STANDARD_TYPE_InvalidRedeemScript, "input has segwit fields but the redeem script is not a witness program"
+
+	case OUTPUT_TYPE_P2WSH:
+
+		if i.segwit.IsEmpty() {
+			return NON_STANDARD_TYPE_MissingWitness, "segwit is empty"
+		}
+		return NON_STANDARD_TYPE_InvalidWitnessScript, "witness script failed to parse"
+
+	case OUTPUT_TYPE_TAPROOT:
+		return i.classifyNonStandardTaproot()
+
+	case OUTPUT_TYPE_WitnessUnknown:
+		return NON_STANDARD_TYPE_FutureWitnessVersion, fmt.Sprintf("witness version %d is not defined", getWitnessVersion(previousOutputScript))
+
+	case OUTPUT_TYPE_P2A:
+
+		if !i.inputScript.IsEmpty() {
+			return NON_STANDARD_TYPE_NonEmptyInputScript, "witness program outputs must be spent with an empty input script"
+		}
+		return NON_STANDARD_TYPE_ExtraStackItems, "pay-to-anchor outputs must be spent with an empty witness"
+
+	case OUTPUT_TYPE_OP_RETURN:
+		return NON_STANDARD_TYPE_Unspendable, "previous output is an OP_RETURN output"
+
+	case OUTPUT_TYPE_NonStandard:
+
+		if previousOutputScript.HasParseError() {
+			return NON_STANDARD_TYPE_Unknown, "previous output script failed to parse"
+		}
+		if previousOutputScript.IsEmpty() {
+			return NON_STANDARD_TYPE_AnyoneCanSpend, "previous output script is empty"
+		}
+		if previousOutputScript.IsAnyoneCanSpend() {
+			return NON_STANDARD_TYPE_AnyoneCanSpend, "previous output script only pushes a true value"
+		}
+		if previousOutputScript.IsHashPuzzle() {
+			return NON_STANDARD_TYPE_HashPuzzle, "previous output script requires a hash preimage"
+		}
+		return NON_STANDARD_TYPE_Unknown, "previous output script is non-standard"
+	}
+
+	if IsWitnessProgramOutputType(i.previousOutput.GetOutputType()) {
+		return NON_STANDARD_TYPE_NonEmptyInputScript, "witness program outputs must be spent with an empty input script"
+	}
+
+	// the previous output has no type if it was not found
+	if len(i.previousOutput.GetOutputType()) == 0 {
+		return NON_STANDARD_TYPE_Unknown, "previous output was not found"
+	}
+	return NON_STANDARD_TYPE_Unknown, fmt.Sprintf("spends of %s outputs are not classified", i.previousOutput.GetOutputType())
+}
+
+func (i *Input) classifyNonStandardTaproot() (string, string) {
+
+	if i.segwit.IsEmpty() {
+		return NON_STANDARD_TYPE_MissingWitness, "segwit is empty"
+	}
+
+	// count only non-empty fields, excluding the annex
+	fieldCount := len(i.segwit.fields)
+	if i.segwit.HasAnnex() {
+		fieldCount--
+	}
+	nonEmptyFieldCount := 0
+	for f := 0; f < fieldCount; f++ {
+		if len(i.segwit.fields[f].AsBytes()) > 0 {
+			nonEmptyFieldCount++
+		}
+	}
+
+	if nonEmptyFieldCount <= 1 {
+		// it looks like a key path spend
+		for f := 0; f < fieldCount; f++ {
+			fieldBytes := i.segwit.fields[f].AsBytes()
+			if len(fieldBytes) > 0 && !IsValidSchnorrSignature(fieldBytes) {
+				return NON_STANDARD_TYPE_InvalidSignature, fmt.Sprintf("key path field is not a valid Schnorr signature (%d bytes)", len(fieldBytes))
+			}
+		}
+		return NON_STANDARD_TYPE_MissingWitness, "segwit contains no signature"
+	}
+
+	// it looks like a script path spend
+	if i.segwit.GetControlBlockIndex() == INVALID_CB_INDEX {
+		controlBlockLength := len(i.segwit.fields[fieldCount-1].AsBytes())
+		if IsValidSchnorrSignature(i.segwit.fields[0].AsBytes()) && controlBlockLength < 33 {
+			return NON_STANDARD_TYPE_ExtraStackItems, fmt.Sprintf("key path signature followed by %d extra stack items", nonEmptyFieldCount-1)
+		}
+		return NON_STANDARD_TYPE_InvalidControlBlock, fmt.Sprintf("control block has an invalid length (%d bytes)", controlBlockLength)
+	}
+
+	return NON_STANDARD_TYPE_InvalidTapScript, "tap script failed to parse"
+}
+
+// returns the witness version of a witness program output script
+func getWitnessVersion(script Script) int {
+	rawBytes := script.AsBytes()
+	if len(rawBytes) == 0 || rawBytes[0] == 0x00 {
+		return 0
+	}
+	return int(rawBytes[0]) - 0x50
+}
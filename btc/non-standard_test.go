@@ -0,0 +1,42 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// every input that is not one of the standard spend types is given a sub-type and a reason
+func TestClassifyNonStandard(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		outputScript    []byte
+		segwit          Segwit
+		nonStandardType string
+	}{
+		{"OP_TRUE", []byte{0x51}, Segwit{}, NON_STANDARD_TYPE_AnyoneCanSpend},
+		{"empty output script", []byte{}, Segwit{}, NON_STANDARD_TYPE_AnyoneCanSpend},
+		{"SHA256 hash puzzle", append(append([]byte{0xa8, 0x20}, bytes.Repeat([]byte{0x11}, 32)...), 0x87), Segwit{}, NON_STANDARD_TYPE_HashPuzzle},
+		{"OP_RETURN", []byte{0x6a, 0x01, 0x00}, Segwit{}, NON_STANDARD_TYPE_Unspendable},
+		{"P2WSH without a witness", append([]byte{0x00, 0x20}, bytes.Repeat([]byte{0x22}, 32)...), Segwit{}, NON_STANDARD_TYPE_MissingWitness},
+		{"witness version 2", append([]byte{0x52, 0x20}, bytes.Repeat([]byte{0x33}, 32)...), Segwit{}, NON_STANDARD_TYPE_FutureWitnessVersion},
+		{"non-standard output script", []byte{0x75, 0x75, 0x51}, Segwit{}, NON_STANDARD_TYPE_Unknown},
+		{"previous output not found", nil, Segwit{}, NON_STANDARD_TYPE_Unknown},
+	}
+
+	for _, test := range tests {
+		previousOutput := Output{}
+		if test.outputScript != nil {
+			previousOutput = NewOutput(1000, NewScript(test.outputScript), "")
+		}
+		input := NewInput(false, "", 0, NewScript(nil), test.segwit, 0xffffffff, Output{})
+		input.SetPreviousOutput(previousOutput)
+
+		if input.GetSpendType() != SPEND_TYPE_NonStandard || input.GetNonStandardType() != test.nonStandardType {
+			t.Errorf("%s: spend type %s (%s), expected %s (%s)", test.name, input.GetSpendType(), input.GetNonStandardType(), SPEND_TYPE_NonStandard, test.nonStandardType)
+		}
+		if len(input.GetNonStandardReason()) == 0 {
+			t.Errorf("%s: no reason was given", test.name)
+		}
+	}
+}
@@ -11,6 +11,7 @@ const OUTPUT_TYPE_P2SH = "P2SH"
 const OUTPUT_TYPE_P2WPKH = "P2WPKH"
 const OUTPUT_TYPE_P2WSH = "P2WSH"
 const OUTPUT_TYPE_TAPROOT = "Taproot"
+const OUTPUT_TYPE_P2A = "P2A"
 const OUTPUT_TYPE_OP_RETURN = "OP_RETURN"
 const OUTPUT_TYPE_WitnessUnknown = "Witness Unknown"
 const OUTPUT_TYPE_NonStandard = "Non-Standard"
@@ -42,6 +43,8 @@ func NewOutput(value uint64, script Script, address string) Output {
 		outputType = OUTPUT_TYPE_P2PK
 	} else if script.IsNullDataOutput() {
 		outputType = OUTPUT_TYPE_OP_RETURN
+	} else if wpt, found := script.getWitnessProgramType(); found {
+		outputType = wpt.outputType
 	} else if script.IsWitnessUnknownOutput() {
 		outputType = OUTPUT_TYPE_WitnessUnknown
 	} else {
@@ -75,7 +78,11 @@ func (o *Output) setFieldTypes() {
 		o.outputScript.SetFieldType(2, "Public Key Hash")
 		//		o.outputScript.SetFieldType (3, "OP_EQUALVERIFY")
 		//		o.outputScript.SetFieldType (4, "OP_CHECKSIG")
-	} else if o.outputType != OUTPUT_TYPE_P2PK && o.outputType != OUTPUT_TYPE_MultiSig && o.outputType != OUTPUT_TYPE_OP_RETURN && o.outputType != OUTPUT_TYPE_WitnessUnknown && o.outputType != OUTPUT_TYPE_NonStandard {
+	} else if wpt, found := o.outputScript.getWitnessProgramType(); found && wpt.outputType == o.outputType {
+		o.outputScript.SetFieldType(1, wpt.programFieldType)
+	} else if o.outputType == OUTPUT_TYPE_MultiSig {
+		o.outputScript.setFakePublicKeyContentTypes()
+	} else if o.outputType != OUTPUT_TYPE_P2PK && o.outputType != OUTPUT_TYPE_OP_RETURN && o.outputType != OUTPUT_TYPE_WitnessUnknown && o.outputType != OUTPUT_TYPE_NonStandard {
 		fmt.Println("Unknown output type ", o.outputType)
 	}
 }
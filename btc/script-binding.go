@@ -0,0 +1,616 @@
+package btc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The script binder executes a serialized script symbolically against the stack items supplied by the witness.
+// Signatures are not verified. Every non-empty signature is assumed to be valid, which is enough to follow the
+// same path through the script that the node followed when the transaction was confirmed.
+// Each witness item is bound to the first opcode that consumes it, except that a public key or signature binding
+// replaces a hash binding, since a public key is often copied and hashed before the original is consumed by a signature check.
+
+type symbolicItem struct {
+	witnessIndex int // -1 if the item was not taken directly from the witness
+	value        []byte
+	known        bool
+}
+
+type scriptBinder struct {
+	stack     []symbolicItem
+	altStack  []symbolicItem
+	bindings  map[int]string
+	keyNumber int
+	tapscript bool
+	hashBound map[int]bool // witness items whose only binding is a hash preimage
+}
+
+// returns a map of witness item index to a description of how the item is used by the script
+// execution stops at the first opcode that can not be evaluated, returning whatever bindings have been found
+func bindWitnessStack(script Script, witnessItems [][]byte, tapscript bool) map[int]string {
+
+	b := scriptBinder{bindings: make(map[int]string), tapscript: tapscript, hashBound: make(map[int]bool)}
+	for w, item := range witnessItems {
+		b.stack = append(b.stack, symbolicItem{witnessIndex: w, value: item, known: true})
+	}
+
+	b.execute(script)
+	return b.bindings
+}
+
+func (b *scriptBinder) push(value []byte, known bool) {
+	b.stack = append(b.stack, symbolicItem{witnessIndex: -1, value: value, known: known})
+}
+
+func (b *scriptBinder) pushItem(item symbolicItem) {
+	b.stack = append(b.stack, item)
+}
+
+func (b *scriptBinder) pop() (symbolicItem, bool) {
+	stackSize := len(b.stack)
+	if stackSize == 0 {
+		return symbolicItem{}, false
+	}
+	item := b.stack[stackSize-1]
+	b.stack = b.stack[:stackSize-1]
+	return item, true
+}
+
+// returns the item n positions from the top of the stack
+func (b *scriptBinder) peek(n int) (symbolicItem, bool) {
+	stackSize := len(b.stack)
+	if n < 0 || n >= stackSize {
+		return symbolicItem{}, false
+	}
+	return b.stack[stackSize-1-n], true
+}
+
+func (b *scriptBinder) popNumber() (int64, symbolicItem, bool) {
+	item, ok := b.pop()
+	if !ok || !item.known {
+		return 0, item, false
+	}
+	n, valid := decodeScriptNumber(item.value)
+	return n, item, valid
+}
+
+func (b *scriptBinder) bind(item symbolicItem, usage string) {
+	if item.witnessIndex < 0 {
+		return
+	}
+	if _, alreadyBound := b.bindings[item.witnessIndex]; !alreadyBound {
+		b.bindings[item.witnessIndex] = usage
+	}
+}
+
+// binds an item consumed by a hash opcode, which a signature check can replace
+func (b *scriptBinder) bindHash(item symbolicItem, usage string) {
+	if item.witnessIndex < 0 {
+		return
+	}
+	if _, alreadyBound := b.bindings[item.witnessIndex]; !alreadyBound {
+		b.bindings[item.witnessIndex] = usage
+		b.hashBound[item.witnessIndex] = true
+	}
+}
+
+// binds an item consumed by a signature check, replacing a hash binding of the same witness item
+func (b *scriptBinder) bindKey(item symbolicItem, usage string) {
+	if item.witnessIndex < 0 {
+		return
+	}
+	if b.hashBound[item.witnessIndex] {
+		delete(b.bindings, item.witnessIndex)
+		delete(b.hashBound, item.witnessIndex)
+	}
+	b.bind(item, usage)
+}
+
+func (b *scriptBinder) execute(script Script) {
+
+	execStack := make([]bool, 0)
+	for _, field := range script.fields {
+
+		executing := true
+		for _, e := range execStack {
+			executing = executing && e
+		}
+
+		if !field.IsOpcode() {
+			if executing {
+				b.push(field.AsBytes(), true)
+			}
+			continue
+		}
+
+		opcode := field.AsBytes()[0]
+		opcodeName := getOpcodeName(opcode)
+
+		// flow control is evaluated even in branches that are not executed
+		switch opcode {
+		case 0x63, 0x64: // OP_IF, OP_NOTIF
+			if !executing {
+				execStack = append(execStack, false)
+				continue
+			}
+			selector, ok := b.pop()
+			if !ok || !selector.known {
+				return
+			}
+			condition := castToBool(selector.value)
+			b.bind(selector, fmt.Sprintf("%s selector = %t branch", opcodeName[3:], condition))
+			if opcode == 0x64 {
+				condition = !condition
+			}
+			execStack = append(execStack, condition)
+			continue
+		case 0x67: // OP_ELSE
+			if len(execStack) == 0 {
+				return
+			}
+			execStack[len(execStack)-1] = !execStack[len(execStack)-1]
+			continue
+		case 0x68: // OP_ENDIF
+			if len(execStack) == 0 {
+				return
+			}
+			execStack = execStack[:len(execStack)-1]
+			continue
+		}
+
+		if !executing {
+			continue
+		}
+
+		if !b.executeOpcode(opcode, opcodeName) {
+			return
+		}
+	}
+}
+
+// returns false if execution can not continue
+func (b *scriptBinder) executeOpcode(opcode byte, opcodeName string) bool {
+
+	switch {
+
+	// constants
+	case opcode == 0x00:
+		b.push([]byte{}, true)
+	case opcode == 0x4f:
+		b.push([]byte{0x81}, true)
+	case opcode >= 0x51 && opcode <= 0x60:
+		b.push([]byte{opcode - 0x50}, true)
+
+	// no-ops
+	case opcode == 0x61 || opcode == 0xab || opcode == 0xb0 || (opcode >= 0xb3 && opcode <= 0xb9):
+
+	case opcode == 0x69: // OP_VERIFY
+		item, ok := b.pop()
+		if !ok {
+			return false
+		}
+		b.bind(item, "checked by OP_VERIFY")
+		return !item.known || castToBool(item.value)
+
+	case opcode == 0x6a: // OP_RETURN
+		return false
+
+	// stack operations
+	case opcode == 0x6b: // OP_TOALTSTACK
+		item, ok := b.pop()
+		if !ok {
+			return false
+		}
+		b.altStack = append(b.altStack, item)
+	case opcode == 0x6c: // OP_FROMALTSTACK
+		altStackSize := len(b.altStack)
+		if altStackSize == 0 {
+			return false
+		}
+		b.pushItem(b.altStack[altStackSize-1])
+		b.altStack = b.altStack[:altStackSize-1]
+	case opcode == 0x6d || opcode == 0x75: // OP_2DROP, OP_DROP
+		count := 1
+		if opcode == 0x6d {
+			count = 2
+		}
+		for i := 0; i < count; i++ {
+			item, ok := b.pop()
+			if !ok {
+				return false
+			}
+			b.bind(item, "dropped by "+opcodeName)
+		}
+	case opcode == 0x6e || opcode == 0x6f || opcode == 0x76: // OP_2DUP, OP_3DUP, OP_DUP
+		count := 1
+		if opcode == 0x6e {
+			count = 2
+		} else if opcode == 0x6f {
+			count = 3
+		}
+		for i := 0; i < count; i++ {
+			item, ok := b.peek(count - 1)
+			if !ok {
+				return false
+			}
+			b.pushItem(item)
+		}
+	case opcode == 0x70: // OP_2OVER
+		for i := 0; i < 2; i++ {
+			item, ok := b.peek(3)
+			if !ok {
+				return false
+			}
+			b.pushItem(item)
+		}
+	case opcode == 0x78: // OP_OVER
+		item, ok := b.peek(1)
+		if !ok {
+			return false
+		}
+		b.pushItem(item)
+	case opcode == 0x71 || opcode == 0x72 || opcode == 0x7b || opcode == 0x7c: // OP_2ROT, OP_2SWAP, OP_ROT, OP_SWAP
+		moveFrom, moveCount := 1, 1
+		switch opcode {
+		case 0x71:
+			moveFrom, moveCount = 5, 2
+		case 0x72:
+			moveFrom, moveCount = 3, 2
+		case 0x7b:
+			moveFrom = 2
+		}
+		if !b.moveToTop(moveFrom, moveCount) {
+			return false
+		}
+	case opcode == 0x73: // OP_IFDUP
+		item, ok := b.peek(0)
+		if !ok || !item.known {
+			return false
+		}
+		if castToBool(item.value) {
+			b.pushItem(item)
+		}
+	case opcode == 0x74: // OP_DEPTH
+		b.push(encodeScriptNumber(int64(len(b.stack))), true)
+	case opcode == 0x77: // OP_NIP
+		item, ok := b.peek(1)
+		if !ok {
+			return false
+		}
+		b.bind(item, "dropped by OP_NIP")
+		b.stack = append(b.stack[:len(b.stack)-2], b.stack[len(b.stack)-1])
+	case opcode == 0x79 || opcode == 0x7a: // OP_PICK, OP_ROLL
+		n, nItem, ok := b.popNumber()
+		b.bind(nItem, "index for "+opcodeName)
+		if !ok || n < 0 {
+			return false
+		}
+		item, ok := b.peek(int(n))
+		if !ok {
+			return false
+		}
+		if opcode == 0x7a {
+			b.moveToTop(int(n), 1)
+		} else {
+			b.pushItem(item)
+		}
+	case opcode == 0x7d: // OP_TUCK
+		top, ok := b.peek(0)
+		if !ok || len(b.stack) < 2 {
+			return false
+		}
+		b.stack = append(b.stack[:len(b.stack)-2], top, b.stack[len(b.stack)-2], top)
+
+	case opcode == 0x82: // OP_SIZE
+		item, ok := b.peek(0)
+		if !ok {
+			return false
+		}
+		b.push(encodeScriptNumber(int64(len(item.value))), item.known)
+
+	case opcode == 0x87 || opcode == 0x88: // OP_EQUAL, OP_EQUALVERIFY
+		item2, ok2 := b.pop()
+		item1, ok1 := b.pop()
+		if !ok1 || !ok2 {
+			return false
+		}
+		b.bind(item1, "compared by "+opcodeName)
+		b.bind(item2, "compared by "+opcodeName)
+		known := item1.known && item2.known
+		equal := bytes.Equal(item1.value, item2.value)
+		if opcode == 0x88 {
+			return !known || equal
+		}
+		b.push(boolToScriptValue(equal), known)
+
+	// numeric, except OP_2MUL and OP_2DIV which are disabled
+	case opcode >= 0x8b && opcode <= 0x92 && opcode != 0x8d && opcode != 0x8e:
+		n, item, ok := b.popNumber()
+		b.bind(item, "operand for "+opcodeName)
+		if !ok {
+			b.push(nil, false)
+			break
+		}
+		b.push(evaluateUnaryNumeric(opcode, n), true)
+	case opcode >= 0x93 && opcode <= 0xa4:
+		if len(b.stack) < 2 {
+			return false
+		}
+		n2, item2, ok2 := b.popNumber()
+		n1, item1, ok1 := b.popNumber()
+		result, isBinaryNumeric := evaluateBinaryNumeric(opcode, n1, n2)
+		if !isBinaryNumeric {
+			return false
+		}
+		b.bind(item1, "operand for "+opcodeName)
+		b.bind(item2, "operand for "+opcodeName)
+		if len(item1.value) > 0 && !item1.known && len(item2.value) > 0 && !item2.known {
+			return false
+		}
+		if opcode == 0x9d { // OP_NUMEQUALVERIFY
+			return !(ok1 && ok2) || castToBool(result)
+		}
+		b.push(result, ok1 && ok2)
+	case opcode == 0xa5: // OP_WITHIN
+		if len(b.stack) < 3 {
+			return false
+		}
+		for i := 0; i < 3; i++ {
+			_, item, _ := b.popNumber()
+			b.bind(item, "operand for OP_WITHIN")
+		}
+		b.push(nil, false)
+
+	// crypto
+	case opcode >= 0xa6 && opcode <= 0xaa:
+		item, ok := b.pop()
+		if !ok {
+			return false
+		}
+		b.bindHash(item, "preimage for "+opcodeName)
+		hashType, _ := getHashOpcodeType(opcodeName)
+		b.push(ComputeHash(hashType, item.value), item.known)
+	case opcode == 0xac || opcode == 0xad: // OP_CHECKSIG, OP_CHECKSIGVERIFY
+		publicKey, ok1 := b.pop()
+		signature, ok2 := b.pop()
+		if !ok1 || !ok2 {
+			return false
+		}
+		b.keyNumber++
+		b.bindKey(signature, fmt.Sprintf("signature for pubkey #%d in %s", b.keyNumber, opcodeName[3:]))
+		b.bindKey(publicKey, fmt.Sprintf("pubkey #%d for %s", b.keyNumber, opcodeName[3:]))
+		if opcode == 0xac {
+			b.push(boolToScriptValue(len(signature.value) > 0), signature.known)
+		}
+	case opcode == 0xba: // OP_CHECKSIGADD
+		publicKey, ok1 := b.pop()
+		n, nItem, ok2 := b.popNumber()
+		signature, ok3 := b.pop()
+		if !ok1 || !ok3 {
+			return false
+		}
+		b.keyNumber++
+		b.bindKey(signature, fmt.Sprintf("signature for pubkey #%d in CHECKSIGADD", b.keyNumber))
+		b.bindKey(publicKey, fmt.Sprintf("pubkey #%d for CHECKSIGADD", b.keyNumber))
+		b.bind(nItem, "counter for CHECKSIGADD")
+		if len(signature.value) > 0 {
+			n++
+		}
+		b.push(encodeScriptNumber(n), ok2 && signature.known)
+	case opcode == 0xae || opcode == 0xaf: // OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY
+		return b.executeCheckMultiSig(opcode, opcodeName)
+	case opcode == 0xb1 || opcode == 0xb2: // OP_CHECKLOCKTIMEVERIFY, OP_CHECKSEQUENCEVERIFY
+		item, ok := b.peek(0)
+		if !ok {
+			return false
+		}
+		usage := "locktime for "
+		if opcode == 0xb2 {
+			usage = "sequence for "
+		}
+		b.bind(item, usage+opcodeName)
+
+	default:
+		// disabled opcodes, reserved opcodes and OP_SUCCESS opcodes all end execution
+		return false
+	}
+
+	return true
+}
+
+func (b *scriptBinder) executeCheckMultiSig(opcode byte, opcodeName string) bool {
+
+	// OP_CHECKMULTISIG and OP_CHECKMULTISIGVERIFY are disabled in tapscript
+	if b.tapscript {
+		return false
+	}
+
+	keyCount, keyCountItem, ok := b.popNumber()
+	if !ok || keyCount < 0 || keyCount > 20 {
+		return false
+	}
+	b.bind(keyCountItem, "pubkey count for "+opcodeName[3:])
+
+	for k := keyCount; k > 0; k-- {
+		publicKey, ok := b.pop()
+		if !ok {
+			return false
+		}
+		b.bindKey(publicKey, fmt.Sprintf("pubkey #%d for %s", k, opcodeName[3:]))
+	}
+
+	sigCount, sigCountItem, ok := b.popNumber()
+	if !ok || sigCount < 0 || sigCount > keyCount {
+		return false
+	}
+	b.bind(sigCountItem, "signature count for "+opcodeName[3:])
+
+	allSigned := true
+	for s := sigCount; s > 0; s-- {
+		signature, ok := b.pop()
+		if !ok {
+			return false
+		}
+		allSigned = allSigned && len(signature.value) > 0
+		if sigCount == keyCount {
+			b.bindKey(signature, fmt.Sprintf("signature for pubkey #%d in %s", s, opcodeName[3:]))
+		} else {
+			b.bindKey(signature, fmt.Sprintf("signature #%d of %d in %s (%d-of-%d)", s, sigCount, opcodeName[3:], sigCount, keyCount))
+		}
+	}
+
+	dummy, ok := b.pop()
+	if !ok {
+		return false
+	}
+	b.bind(dummy, "dummy element for "+opcodeName[3:])
+
+	if opcode == 0xaf {
+		return allSigned
+	}
+	b.push(boolToScriptValue(allSigned), true)
+	return true
+}
+
+// moves count items starting at position from (counted from the top) to the top of the stack
+func (b *scriptBinder) moveToTop(from int, count int) bool {
+	stackSize := len(b.stack)
+	begin := stackSize - 1 - from
+	if begin < 0 || from-count+1 < 0 {
+		return false
+	}
+
+	moved := make([]symbolicItem, count)
+	copy(moved, b.stack[begin:begin+count])
+	b.stack = append(b.stack[:begin], b.stack[begin+count:]...)
+	b.stack = append(b.stack, moved...)
+	return true
+}
+
+func castToBool(value []byte) bool {
+	for i, v := range value {
+		if v != 0 {
+			// negative zero is false
+			return !(i == len(value)-1 && v == 0x80)
+		}
+	}
+	return false
+}
+
+func boolToScriptValue(b bool) []byte {
+	if b {
+		return []byte{0x01}
+	}
+	return []byte{}
+}
+
+// returns false if the value is too large to be a script number
+func decodeScriptNumber(value []byte) (int64, bool) {
+	valueLen := len(value)
+	if valueLen == 0 {
+		return 0, true
+	}
+	if valueLen > 8 {
+		return 0, false
+	}
+
+	result := int64(0)
+	for i, v := range value {
+		result |= int64(v) << (8 * i)
+	}
+
+	// the sign bit is the high bit of the last byte
+	if value[valueLen-1]&0x80 != 0 {
+		return -(result & ^(int64(0x80) << (8 * (valueLen - 1)))), true
+	}
+	return result, true
+}
+
+func encodeScriptNumber(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	result := make([]byte, 0)
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+func evaluateUnaryNumeric(opcode byte, n int64) []byte {
+	switch opcode {
+	case 0x8b: // OP_1ADD
+		n++
+	case 0x8c: // OP_1SUB
+		n--
+	case 0x8f: // OP_NEGATE
+		n = -n
+	case 0x90: // OP_ABS
+		if n < 0 {
+			n = -n
+		}
+	case 0x91: // OP_NOT
+		return boolToScriptValue(n == 0)
+	case 0x92: // OP_0NOTEQUAL
+		return boolToScriptValue(n != 0)
+	}
+	return encodeScriptNumber(n)
+}
+
+// returns false if the opcode is not a binary numeric opcode that is enabled
+func evaluateBinaryNumeric(opcode byte, n1 int64, n2 int64) ([]byte, bool) {
+	switch opcode {
+	case 0x93: // OP_ADD
+		return encodeScriptNumber(n1 + n2), true
+	case 0x94: // OP_SUB
+		return encodeScriptNumber(n1 - n2), true
+	case 0x9a: // OP_BOOLAND
+		return boolToScriptValue(n1 != 0 && n2 != 0), true
+	case 0x9b: // OP_BOOLOR
+		return boolToScriptValue(n1 != 0 || n2 != 0), true
+	case 0x9c, 0x9d: // OP_NUMEQUAL, OP_NUMEQUALVERIFY
+		return boolToScriptValue(n1 == n2), true
+	case 0x9e: // OP_NUMNOTEQUAL
+		return boolToScriptValue(n1 != n2), true
+	case 0x9f: // OP_LESSTHAN
+		return boolToScriptValue(n1 < n2), true
+	case 0xa0: // OP_GREATERTHAN
+		return boolToScriptValue(n1 > n2), true
+	case 0xa1: // OP_LESSTHANOREQUAL
+		return boolToScriptValue(n1 <= n2), true
+	case 0xa2: // OP_GREATERTHANOREQUAL
+		return boolToScriptValue(n1 >= n2), true
+	case 0xa3: // OP_MIN
+		if n1 < n2 {
+			return encodeScriptNumber(n1), true
+		}
+		return encodeScriptNumber(n2), true
+	case 0xa4: // OP_MAX
+		if n1 > n2 {
+			return encodeScriptNumber(n1), true
+		}
+		return encodeScriptNumber(n2), true
+	}
+
+	// OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT and OP_RSHIFT are disabled
+	return nil, false
+}
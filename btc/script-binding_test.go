@@ -0,0 +1,68 @@
+package btc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindWitnessStack(t *testing.T) {
+
+	signature := append(make([]byte, 71), 0x01)
+	publicKey := append([]byte{0x02}, make([]byte, 32)...)
+	pushKey := append([]byte{0x21}, publicKey...)
+	pushKeyHash := append([]byte{0x14}, ComputeHash(HASH_TYPE_HASH160, publicKey)...)
+	pushOtherKeyHash := append([]byte{0x14}, make([]byte, 20)...)
+
+	multiSigScript := append(append(append([]byte{0x52}, pushKey...), pushKey...), 0x52, 0xae)
+	ifScript := append(append([]byte{0x63}, pushKey...), 0xac, 0x67, 0x6a, 0x68)
+	notIfScript := append(append([]byte{0x64}, pushKey...), 0xac, 0x67, 0x6a, 0x68)
+
+	bindingTests := []struct {
+		name      string
+		script    []byte
+		witness   [][]byte
+		tapscript bool
+		expected  map[int]string
+	}{
+		{"2-of-2 CHECKMULTISIG", multiSigScript, [][]byte{{}, signature, signature}, false,
+			map[int]string{0: "dummy element for CHECKMULTISIG", 1: "signature for pubkey #1 in CHECKMULTISIG", 2: "signature for pubkey #2 in CHECKMULTISIG"}},
+		{"CHECKMULTISIG in tapscript", multiSigScript, [][]byte{{}, signature, signature}, true,
+			map[int]string{}},
+
+		{"IF true branch", ifScript, [][]byte{signature, {0x01}}, false,
+			map[int]string{0: "signature for pubkey #1 in CHECKSIG", 1: "IF selector = true branch"}},
+		{"IF false branch", ifScript, [][]byte{signature, {}}, false,
+			map[int]string{1: "IF selector = false branch"}},
+		{"NOTIF false branch", notIfScript, [][]byte{signature, {}}, false,
+			map[int]string{0: "signature for pubkey #1 in CHECKSIG", 1: "NOTIF selector = false branch"}},
+		{"NOTIF true branch", notIfScript, [][]byte{signature, {0x01}}, false,
+			map[int]string{1: "NOTIF selector = true branch"}},
+
+		// the public key is hashed before it is consumed by the signature check
+		{"hashed pubkey", append(append([]byte{0x76, 0xa9}, pushKeyHash...), 0x88, 0xac), [][]byte{signature, publicKey}, false,
+			map[int]string{0: "signature for pubkey #1 in CHECKSIG", 1: "pubkey #1 for CHECKSIG"}},
+		{"hashed pubkey that does not match", append(append([]byte{0x76, 0xa9}, pushOtherKeyHash...), 0x88, 0xac), [][]byte{signature, publicKey}, false,
+			map[int]string{1: "preimage for OP_HASH160"}},
+
+		{"OP_1ADD", []byte{0x8b, 0x75}, [][]byte{{0x02}}, false,
+			map[int]string{0: "operand for OP_1ADD"}},
+		{"disabled OP_2MUL", []byte{0x8d, 0x75}, [][]byte{{0x02}}, false, map[int]string{}},
+		{"disabled OP_2DIV", []byte{0x8e, 0x75}, [][]byte{{0x02}}, false, map[int]string{}},
+		{"disabled OP_MUL", []byte{0x95, 0x75}, [][]byte{{0x02}, {0x02}}, false, map[int]string{}},
+		{"disabled OP_CAT", []byte{0x7e, 0x75}, [][]byte{{0x02}, {0x02}}, false, map[int]string{}},
+		{"OP_2MUL in tapscript", []byte{0x8d, 0x75}, [][]byte{{0x02}}, true, map[int]string{}},
+
+		// execution stops when there are not enough items on the stack
+		{"OP_ADD stack underflow", []byte{0x93, 0x75}, [][]byte{{0x02}}, false, map[int]string{}},
+		{"OP_WITHIN stack underflow", []byte{0xa5, 0x75}, [][]byte{{0x02}, {0x03}}, false, map[int]string{}},
+		{"OP_WITHIN", []byte{0xa5, 0x75}, [][]byte{{0x02}, {0x01}, {0x03}}, false,
+			map[int]string{0: "operand for OP_WITHIN", 1: "operand for OP_WITHIN", 2: "operand for OP_WITHIN"}},
+	}
+
+	for _, bindingTest := range bindingTests {
+		bindings := bindWitnessStack(NewScript(bindingTest.script), bindingTest.witness, bindingTest.tapscript)
+		if !reflect.DeepEqual(bindings, bindingTest.expected) {
+			t.Errorf("%s: bound %v, expected %v", bindingTest.name, bindings, bindingTest.expected)
+		}
+	}
+}
@@ -181,7 +181,11 @@ func NewScript(rawBytes []byte) Script {
 		}
 	}
 
-	return Script{rawBytes: rawBytes, fields: fields, parseError: parseError, appearsValid: appearsValid}
+	script := Script{rawBytes: rawBytes, fields: fields, parseError: parseError, appearsValid: appearsValid}
+	script.setEmbeddedContentTypes()
+	script.setHashLockFieldTypes()
+
+	return script
 }
 
 // used only for testing
@@ -196,6 +200,15 @@ func (s *Script) PrintToScreen () {
 }
 */
 
+// the raw bytes are shared, but the fields are copied so that their types can be changed
+func (s *Script) copy() Script {
+	scriptCopy := *s
+	if s.fields != nil {
+		scriptCopy.fields = append(make([]ScriptField, 0, len(s.fields)), s.fields...)
+	}
+	return scriptCopy
+}
+
 func (s *Script) AsBytes() []byte {
 	return s.rawBytes
 }
@@ -400,7 +413,7 @@ func (s *Script) IsP2shP2wshRedeemScript() bool  { return s.IsP2wshOutput() }
 func (s *Script) IsNullDataOutput() bool { return len(s.rawBytes) >= 1 && s.rawBytes[0] == 0x6a }
 
 func (s *Script) IsNonstandardOutput() bool {
-	return !s.IsTaprootOutput() && !s.IsP2wpkhOutput() && !s.IsP2wshOutput() && !s.IsP2shOutput() && !s.IsP2pkhOutput() && !s.IsMultiSigOutput() && !s.IsP2pkOutput() && !s.IsNullDataOutput() && !s.IsWitnessUnknownOutput()
+	return !s.IsTaprootOutput() && !s.IsP2wpkhOutput() && !s.IsP2wshOutput() && !s.IsP2shOutput() && !s.IsP2pkhOutput() && !s.IsMultiSigOutput() && !s.IsP2pkOutput() && !s.IsNullDataOutput() && !s.IsRegisteredWitnessProgramOutput() && !s.IsWitnessUnknownOutput()
 }
 
 func (s *Script) IsWitnessUnknownOutput() bool {
@@ -424,9 +437,28 @@ func (s *Script) IsWitnessUnknownOutput() bool {
 		return false
 	}
 
+	if s.IsRegisteredWitnessProgramOutput() {
+		return false
+	}
+
 	return true
 }
 
+// a script that leaves a true value on the stack without requiring anything from the input
+func (s *Script) IsAnyoneCanSpend() bool {
+	if len(s.fields) != 1 || !s.fields[0].IsOpcode() {
+		return false
+	}
+
+	opcode := s.fields[0].AsBytes()[0]
+	return opcode >= 0x51 && opcode <= 0x60
+}
+
+// <hash opcode> <hash> OP_EQUAL
+func (s *Script) IsHashPuzzle() bool {
+	return len(s.fields) == 3 && s.fields[2].AsHex() == "OP_EQUAL" && len(s.GetHashLocks()) == 1
+}
+
 func (s *Script) IsOrdinal() bool {
 
 	fieldCount := len(s.fields)
@@ -9,6 +9,7 @@ import (
 type SegwitField struct {
 	rawBytes []byte
 	dataType string
+	binding  string
 }
 
 func (swf *SegwitField) AsBytes() []byte {
@@ -28,6 +29,15 @@ func (swf *SegwitField) AsType() string {
 	return swf.dataType
 }
 
+// the binding describes how the field is used by the witness script or tap script
+func (swf *SegwitField) SetBinding(binding string) {
+	swf.binding = binding
+}
+
+func (swf *SegwitField) GetBinding() string {
+	return swf.binding
+}
+
 type Segwit struct {
 	fields         []SegwitField
 	witnessScript  Script
@@ -54,6 +64,16 @@ func NewSegwit(rawFields [][]byte) Segwit {
 	return Segwit{fields: fields, tapScriptIndex: INVALID_CB_INDEX}
 }
 
+func (s *Segwit) copy() Segwit {
+	segwitCopy := *s
+	if s.fields != nil {
+		segwitCopy.fields = append(make([]SegwitField, 0, len(s.fields)), s.fields...)
+	}
+	segwitCopy.witnessScript = s.witnessScript.copy()
+	segwitCopy.tapScript = s.tapScript.copy()
+	return segwitCopy
+}
+
 func (s *Segwit) GetWitnessScript() Script {
 	return s.witnessScript
 }
@@ -174,6 +194,8 @@ func (s *Segwit) SetWitnessScript(ws Script) {
 			s.witnessScript.SetFieldType(f, GetStackItemType(field.AsBytes(), false))
 		}
 	}
+	s.witnessScript.setEmbeddedContentTypes()
+	s.witnessScript.setHashLockFieldTypes()
 }
 
 func (s *Segwit) IsValidTaprootScriptPath() bool {
@@ -252,6 +274,8 @@ func (s *Segwit) SetTapScript(ts Script, i uint32) {
 			s.tapScript.SetFieldType(f, itemType)
 		}
 	}
+	s.tapScript.setEmbeddedContentTypes()
+	s.tapScript.setHashLockFieldTypes()
 }
 
 func (s *Segwit) HasAnnex() bool {
@@ -305,3 +329,30 @@ func (s *Segwit) GetControlBlockIndex() uint32 {
 
 	return uint32(controlBlockIndex)
 }
+
+// binds the stack items that precede the witness script or tap script to the opcodes that consume them
+func (s *Segwit) setStackBindings() {
+
+	var script Script
+	stackItemCount := 0
+	tapscript := false
+	if !s.tapScript.IsNil() {
+		script = s.tapScript
+		stackItemCount = int(s.tapScriptIndex)
+		tapscript = true
+	} else if !s.witnessScript.IsNil() {
+		script = s.witnessScript
+		stackItemCount = len(s.fields) - 1
+	} else {
+		return
+	}
+
+	stackItems := make([][]byte, stackItemCount)
+	for f := 0; f < stackItemCount; f++ {
+		stackItems[f] = s.fields[f].AsBytes()
+	}
+
+	for f, binding := range bindWitnessStack(script, stackItems, tapscript) {
+		s.fields[f].SetBinding(binding)
+	}
+}
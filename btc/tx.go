@@ -30,6 +30,11 @@ func (tx *Tx) GetTxId() string {
 	return tx.id
 }
 
+// unconfirmed transactions have no block hash or block time
+func (tx *Tx) IsConfirmed() bool {
+	return len(tx.blockHash) > 0
+}
+
 func (tx *Tx) GetBlockHash() string {
 	return tx.blockHash
 }
@@ -58,12 +63,31 @@ func (tx *Tx) GetInputs() []Input {
 	return tx.inputs
 }
 
+// returns a copy whose inputs can be re-evaluated without changing the inputs of the original
+func (tx *Tx) Copy() Tx {
+	txCopy := *tx
+	txCopy.inputs = make([]Input, len(tx.inputs))
+	for i := range tx.inputs {
+		txCopy.inputs[i] = tx.inputs[i].Copy()
+	}
+	return txCopy
+}
+
 func (tx *Tx) SetPreviousOutput(inputIndex uint16, previousOutput Output) {
 	if inputIndex < tx.GetInputCount() {
 		tx.inputs[inputIndex].SetPreviousOutput(previousOutput)
 	}
 }
 
+// only inputs that include their previous outputs are counted
+func (tx *Tx) GetRevealedPreimageCount() uint16 {
+	count := uint16(0)
+	for _, input := range tx.inputs {
+		count += input.GetRevealedPreimageCount()
+	}
+	return count
+}
+
 func (tx *Tx) GetOutputCount() uint16 {
 	return uint16(len(tx.outputs))
 }
@@ -143,6 +143,12 @@ func GetStackItemType(field []byte, schnorr bool) string {
 		}
 	}
 
+	// check for embedded content
+	mimeType, confidence := DetectContentType(field)
+	if len(mimeType) > 0 {
+		return getContentTypeLabel("Data", len(field), 1, 1, mimeType, confidence)
+	}
+
 	fieldLen := len(field)
 	s := ""
 	if fieldLen != 1 {
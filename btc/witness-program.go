@@ -0,0 +1,112 @@
+package btc
+
+import (
+	"bytes"
+	"sync"
+)
+
+// witness program types that are identified by version and program rather than by a dedicated Script method
+// new witness versions and program formats can be registered without changing the output type logic
+type witnessProgramType struct {
+	version          byte
+	programLen       int
+	program          []byte // nil matches any program of programLen bytes
+	outputType       string
+	programFieldType string
+}
+
+var witnessProgramTypes = []witnessProgramType{
+	{version: 1, programLen: 2, program: []byte{0x4e, 0x73}, outputType: OUTPUT_TYPE_P2A, programFieldType: "Witness Program (Anchor)"},
+}
+var witnessProgramTypesMutex sync.RWMutex
+
+// registers a witness program type
+// if program is nil, every program of programLen bytes with the given version is identified as outputType
+// output types that are registered more than once are identified by the first registration that matches
+func RegisterWitnessProgramType(version byte, programLen int, program []byte, outputType string, programFieldType string) {
+	witnessProgramTypesMutex.Lock()
+	witnessProgramTypes = append(witnessProgramTypes, witnessProgramType{version: version, programLen: programLen, program: program, outputType: outputType, programFieldType: programFieldType})
+	witnessProgramTypesMutex.Unlock()
+}
+
+// returns the output types of all registered witness program types, in the order they were registered
+func GetWitnessProgramOutputTypes() []string {
+	witnessProgramTypesMutex.RLock()
+	defer witnessProgramTypesMutex.RUnlock()
+
+	outputTypes := make([]string, 0, len(witnessProgramTypes))
+	for _, wpt := range witnessProgramTypes {
+		found := false
+		for _, outputType := range outputTypes {
+			if outputType == wpt.outputType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			outputTypes = append(outputTypes, wpt.outputType)
+		}
+	}
+	return outputTypes
+}
+
+func IsWitnessProgramOutputType(outputType string) bool {
+	for _, wpt := range GetWitnessProgramOutputTypes() {
+		if wpt == outputType {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the version and program of a witness program output script
+// returns false if the script is not a witness program
+func (s *Script) getWitnessProgram() (byte, []byte, bool) {
+	scriptLen := len(s.rawBytes)
+	if scriptLen < 4 || scriptLen > 42 || len(s.fields) != 2 || s.fields[1].IsOpcode() {
+		return 0, nil, false
+	}
+
+	version := s.rawBytes[0]
+	if version != 0x00 {
+		if version < 0x51 || version > 0x60 {
+			return 0, nil, false
+		}
+		version -= 0x50
+	}
+
+	program := s.fields[1].AsBytes()
+	if int(s.rawBytes[1]) != len(program) || len(program) < 2 || len(program) > 40 {
+		return 0, nil, false
+	}
+
+	return version, program, true
+}
+
+// returns the registered witness program type of the script, if there is one
+func (s *Script) getWitnessProgramType() (witnessProgramType, bool) {
+	version, program, isWitnessProgram := s.getWitnessProgram()
+	if !isWitnessProgram {
+		return witnessProgramType{}, false
+	}
+
+	witnessProgramTypesMutex.RLock()
+	defer witnessProgramTypesMutex.RUnlock()
+
+	for _, wpt := range witnessProgramTypes {
+		if wpt.version != version || wpt.programLen != len(program) {
+			continue
+		}
+		if wpt.program != nil && !bytes.Equal(wpt.program, program) {
+			continue
+		}
+		return wpt, true
+	}
+
+	return witnessProgramType{}, false
+}
+
+func (s *Script) IsRegisteredWitnessProgramOutput() bool {
+	_, found := s.getWitnessProgramType()
+	return found
+}
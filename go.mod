@@ -5,4 +5,9 @@ go 1.20
 require (
 	github.com/go-echarts/go-echarts/v2 v2.3.3
 	github.com/shopspring/decimal v1.3.1
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/crypto v0.9.0
+	golang.org/x/time v0.5.0
 )
+
+require golang.org/x/sys v0.9.0 // indirect
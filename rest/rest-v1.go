@@ -1,15 +1,23 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
 
 	"github.com/btc-script-explorer/scantool/btc"
 	"github.com/btc-script-explorer/scantool/btc/node"
 )
 
+// the number of mempool transactions the spend type and output type distributions are calculated from, unless specified
+const MEMPOOL_DEFAULT_MAX_TX_COUNT = 500
+
+// the number of address history entries returned, unless specified
+const ADDRESS_HISTORY_DEFAULT_LIMIT = 100
+
 type RestApiV1 struct {
 }
 
@@ -64,6 +72,9 @@ func segwitToJson(segwit btc.Segwit) map[string]interface{} {
 		if len(field.AsType()) > 0 {
 			fields[f]["type"] = field.AsType()
 		}
+		if len(field.GetBinding()) > 0 {
+			fields[f]["binding"] = field.GetBinding()
+		}
 
 		if cbIndex != btc.INVALID_CB_INDEX && uint32(f) == cbIndex {
 			fields[f]["type"] = "Control Block"
@@ -105,6 +116,22 @@ func outputToJson(output btc.Output) map[string]interface{} {
 	return json
 }
 
+// spent_by is null for outputs that have not been spent in an indexed block
+func outputSpendToJson(spend node.OutputSpend) map[string]interface{} {
+
+	if spend.IsNil() {
+		return nil
+	}
+
+	json := make(map[string]interface{})
+
+	json["tx_id"] = spend.GetTxId()
+	json["input_index"] = spend.GetInputIndex()
+	json["block_height"] = spend.GetBlockHeight()
+
+	return json
+}
+
 func inputToJson(input btc.Input) map[string]interface{} {
 
 	json := make(map[string]interface{})
@@ -141,6 +168,13 @@ func inputToJson(input btc.Input) map[string]interface{} {
 
 		// other data
 		json["spend_type"] = input.GetSpendType()
+		if input.GetSpendType() == btc.SPEND_TYPE_NonStandard {
+			json["non_standard_type"] = input.GetNonStandardType()
+			json["non_standard_reason"] = input.GetNonStandardReason()
+		}
+		if input.GetRevealedPreimageCount() > 0 {
+			json["revealed_preimage_count"] = input.GetRevealedPreimageCount()
+		}
 	}
 
 	return json
@@ -167,8 +201,246 @@ func txToJson(tx btc.Tx) map[string]interface{} {
 	json["locktime"] = tx.GetLockTime()
 	json["coinbase"] = tx.IsCoinbase()
 	json["bip141"] = tx.SupportsBip141()
-	json["blockhash"] = tx.GetBlockHash()
-	json["blocktime"] = tx.GetBlockTime()
+	json["confirmed"] = tx.IsConfirmed()
+
+	// unconfirmed transactions have no block
+	json["blockhash"] = nil
+	json["blocktime"] = nil
+	if tx.IsConfirmed() {
+		json["blockhash"] = tx.GetBlockHash()
+		json["blocktime"] = tx.GetBlockTime()
+	}
+
+	return json
+}
+
+func mempoolEntryToJson(entry node.MempoolEntry) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["fee"] = entry.GetFee()
+	json["vsize"] = entry.GetVsize()
+	json["weight"] = entry.GetWeight()
+	json["fee_rate"] = entry.GetFeeRate()
+	json["time_seen"] = entry.GetTimeSeen()
+	json["height"] = entry.GetHeight()
+	json["ancestor_count"] = entry.GetAncestorCount()
+	json["ancestor_size"] = entry.GetAncestorSize()
+	json["ancestor_fee"] = entry.GetAncestorFee()
+	json["descendant_count"] = entry.GetDescendantCount()
+	json["descendant_size"] = entry.GetDescendantSize()
+	json["descendant_fee"] = entry.GetDescendantFee()
+	json["depends"] = entry.GetDepends()
+	json["spent_by"] = entry.GetSpentBy()
+
+	return json
+}
+
+func mempoolSummaryToJson(summary node.MempoolSummary) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["tx_count"] = summary.GetTxCount()
+	json["sampled_tx_count"] = summary.GetSampledTxCount()
+	json["input_count"] = summary.GetInputCount()
+	json["output_count"] = summary.GetOutputCount()
+	json["total_fee"] = summary.GetTotalFee()
+	json["spend_types"] = summary.GetSpendTypes()
+	json["output_types"] = summary.GetOutputTypes()
+
+	return json
+}
+
+func cacheStatsToJson(stats node.CacheStats) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["caching"] = stats.IsCachingOn()
+	json["max_size"] = stats.GetMaxSize()
+	json["size"] = stats.GetSize()
+	json["block_count"] = stats.GetBlockCount()
+	json["tx_count"] = stats.GetTxCount()
+	json["block_hits"] = stats.GetBlockHits()
+	json["block_misses"] = stats.GetBlockMisses()
+	json["tx_hits"] = stats.GetTxHits()
+	json["tx_misses"] = stats.GetTxMisses()
+	json["evictions"] = stats.GetEvictions()
+
+	return json
+}
+
+func addressHistoryEntryToJson(entry node.AddressHistoryEntry) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["tx_id"] = entry.GetTxId()
+	json["block_height"] = entry.GetBlockHeight()
+	json["value"] = entry.GetValue()
+	if entry.IsSpend() {
+		json["type"] = "spend"
+		json["input_index"] = entry.GetIndex()
+		json["previous_output_tx_id"] = entry.GetPreviousOutputTxId()
+		json["previous_output_index"] = entry.GetPreviousOutputIndex()
+		json["spend_type"] = entry.GetSpendType()
+	} else {
+		json["type"] = "output"
+		json["output_index"] = entry.GetIndex()
+	}
+
+	return json
+}
+
+func addressUtxoToJson(utxo node.AddressUtxo) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["tx_id"] = utxo.GetTxId()
+	json["output_index"] = utxo.GetOutputIndex()
+	json["value"] = utxo.GetValue()
+	json["block_height"] = utxo.GetBlockHeight()
+
+	return json
+}
+
+func addressSummaryToJson(summary node.AddressSummary) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["script_hash"] = summary.GetScriptHash()
+	outputScript := summary.GetOutputScript()
+	if !outputScript.IsNil() {
+		json["output_script"] = scriptToJson(outputScript)
+		json["output_type"] = summary.GetOutputType()
+	}
+	if len(summary.GetAddress()) > 0 {
+		json["address"] = summary.GetAddress()
+	}
+	json["funded_count"] = summary.GetFundedCount()
+	json["funded_value"] = summary.GetFundedValue()
+	json["spent_count"] = summary.GetSpentCount()
+	json["spent_value"] = summary.GetSpentValue()
+	json["balance"] = summary.GetBalance()
+	json["spend_types"] = summary.GetSpendTypes()
+	json["start_height"] = summary.GetStartHeight()
+	json["indexed_height"] = summary.GetIndexedHeight()
+
+	return json
+}
+
+func nodeStatusToJson(status node.NodeStatus) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["node_type"] = status.GetNodeType()
+	json["location"] = status.GetLocation()
+	json["version"] = status.GetVersion()
+	json["connected"] = status.IsConnected()
+	json["healthy"] = status.IsHealthy()
+	json["in_sync"] = status.IsInSync()
+	json["tip_hash"] = status.GetTipHash()
+	json["tip_height"] = status.GetTipHeight()
+	json["latency_ms"] = status.GetLatency().Milliseconds()
+	json["last_error"] = status.GetLastError()
+	json["last_checked"] = int64(0)
+	if !status.GetLastChecked().IsZero() {
+		json["last_checked"] = status.GetLastChecked().Unix()
+	}
+
+	return json
+}
+
+func chainTipToJson(chainTip node.ChainTip) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	json["height"] = chainTip.GetHeight()
+	json["hash"] = chainTip.GetHash()
+	json["branch_length"] = chainTip.GetBranchLength()
+	json["fork_height"] = chainTip.GetForkHeight()
+	json["status"] = chainTip.GetStatus()
+
+	return json
+}
+
+// active_hash is the block of the active chain at the same height, null if the branch is longer than the active chain
+func branchBlockToJson(branchBlock node.BranchBlock) map[string]interface{} {
+
+	json := make(map[string]interface{})
+
+	block := branchBlock.GetBlock()
+	json["hash"] = block.GetHash()
+	json["height"] = block.GetHeight()
+	json["timestamp"] = block.GetTimestamp()
+	json["tx_count"] = len(block.GetTxIds())
+	json["active_hash"] = nil
+	if len(branchBlock.GetActiveBlockHash()) > 0 {
+		json["active_hash"] = branchBlock.GetActiveBlockHash()
+	}
+
+	return json
+}
+
+func chainBranchToJson(branch node.ChainBranch) map[string]interface{} {
+
+	blocks := make([]map[string]interface{}, len(branch.GetBlocks()))
+	for b, branchBlock := range branch.GetBlocks() {
+		blocks[b] = branchBlockToJson(branchBlock)
+	}
+
+	json := make(map[string]interface{})
+
+	json["tip"] = chainTipToJson(branch.GetTip())
+	json["complete"] = branch.IsComplete()
+	json["blocks"] = blocks
+
+	return json
+}
+
+// the unique transactions are only included with include_txs, otherwise only their ids are
+func comparedBlockToJson(comparedBlock node.ComparedBlock, includeTxs bool) map[string]interface{} {
+
+	uniqueTxs := comparedBlock.GetUniqueTxs()
+	uniqueTxIds := make([]string, len(uniqueTxs))
+	for t, tx := range uniqueTxs {
+		uniqueTxIds[t] = tx.GetTxId()
+	}
+
+	json := make(map[string]interface{})
+
+	block := comparedBlock.GetBlock()
+	json["hash"] = block.GetHash()
+	json["height"] = block.GetHeight()
+	json["timestamp"] = block.GetTimestamp()
+	json["orphaned"] = block.IsOrphaned()
+	json["tx_count"] = len(block.GetTxIds())
+	json["unique_tx_count"] = comparedBlock.GetUniqueTxCount()
+	json["compared_tx_count"] = len(uniqueTxs)
+	json["unique_tx_ids"] = uniqueTxIds
+	json["spend_types"] = comparedBlock.GetSpendTypes()
+	json["output_types"] = comparedBlock.GetOutputTypes()
+
+	if includeTxs {
+		txs := make([]map[string]interface{}, len(uniqueTxs))
+		for t, tx := range uniqueTxs {
+			txs[t] = txToJson(tx)
+		}
+		json["unique_txs"] = txs
+	}
+
+	return json
+}
+
+func blockComparisonToJson(comparison node.BlockComparison, includeTxs bool) map[string]interface{} {
+
+	blocks := make([]map[string]interface{}, len(comparison.GetBlocks()))
+	for b, comparedBlock := range comparison.GetBlocks() {
+		blocks[b] = comparedBlockToJson(comparedBlock, includeTxs)
+	}
+
+	json := make(map[string]interface{})
+
+	json["shared_tx_count"] = comparison.GetSharedTxCount()
+	json["blocks"] = blocks
 
 	return json
 }
@@ -177,13 +449,40 @@ func (api *RestApiV1) GetVersion() uint16 {
 	return 1
 }
 
-func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getParams []string, requestBody io.ReadCloser) string {
+// returns the JSON error response and http status for an error returned by the node proxy
+func (api *RestApiV1) getNodeErrorResponse(err error) (string, int) {
+
+	node.LogError(err)
+
+	statusCode := http.StatusInternalServerError
+	switch node.GetErrorType(err) {
+	case node.ERROR_TYPE_NotFound:
+		statusCode = http.StatusNotFound
+	case node.ERROR_TYPE_Unavailable:
+		statusCode = http.StatusServiceUnavailable
+	case node.ERROR_TYPE_Unauthorized, node.ERROR_TYPE_Decode:
+		statusCode = http.StatusBadGateway
+	}
+
+	errBytes, _ := json.Marshal(RestError{Error: err.Error(), Type: node.GetErrorType(err)})
+	return string(errBytes), statusCode
+}
+
+// returns the JSON error response for a malformed request
+func (api *RestApiV1) getBadRequestResponse(message string) (string, int) {
+	errBytes, _ := json.Marshal(RestError{Error: message})
+	return string(errBytes), http.StatusBadRequest
+}
+
+// node requests are cancelled when the context is cancelled
+// returns the JSON response and the http status
+func (api *RestApiV1) HandleRequest(ctx context.Context, httpMethod string, functionName string, getParams []string, requestBody io.ReadCloser) (string, int) {
 
 	nodeProxy, err := node.GetNodeProxy()
 	if err != nil {
-		fmt.Println(err.Error())
-		return ""
+		return api.getNodeErrorResponse(err)
 	}
+	nodeProxy = nodeProxy.WithContext(ctx)
 
 	errorMessage := ""
 	responseJson := ""
@@ -218,11 +517,11 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		if requestParams["hash"] != nil {
 			switch requestParams["hash"].(type) {
 			case float64:
-				return "malformed request: parameter hash is formatted as a number"
+				return api.getBadRequestResponse("malformed request: parameter hash is formatted as a number")
 			case string:
 				blockRequest.BlockKey = requestParams["hash"].(string)
 				if len(blockRequest.BlockKey) != 64 {
-					return "malformed request: parameter hash is not a valid block hash"
+					return api.getBadRequestResponse("malformed request: parameter hash is not a valid block hash")
 				}
 			}
 		} else if requestParams["height"] != nil {
@@ -230,35 +529,54 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 			case float64:
 				blockRequest.BlockKey = strconv.Itoa(int(requestParams["height"].(float64)))
 			case string:
-				return "malformed request: parameter height is formatted as a string"
+				return api.getBadRequestResponse("malformed request: parameter height is formatted as a string")
 			}
 		}
 
 		// request the block from the node proxy
 
-		block := nodeProxy.GetBlock(blockRequest)
-		if block.IsNil() {
-			return "block not found"
+		block, err := nodeProxy.GetBlock(blockRequest)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+
+		// count the preimages revealed by the inputs of the block, this requires every previous output
+		var revealedPreimageCount *uint32
+		if blockRequestOptions["include_preimage_count"] != nil && blockRequestOptions["include_preimage_count"].(bool) {
+			txs, err := nodeProxy.GetTxs(block.GetTxIds(), true)
+			if err != nil {
+				return api.getNodeErrorResponse(err)
+			}
+
+			count := uint32(0)
+			for _, tx := range txs {
+				count += uint32(tx.GetRevealedPreimageCount())
+			}
+			revealedPreimageCount = &count
 		}
 
 		// create the JSON response
 
 		blockJson := struct {
-			Hash         string   `json:"hash"`
-			PreviousHash string   `json:"previous_hash"`
-			NextHash     string   `json:"next_hash"`
-			Height       uint32   `json:"height"`
-			Version      int32    `json:"version"`
-			Timestamp    int64    `json:"timestamp"`
-			TxIds        []string `json:"tx_ids"`
+			Hash                  string   `json:"hash"`
+			PreviousHash          string   `json:"previous_hash"`
+			NextHash              string   `json:"next_hash"`
+			Height                uint32   `json:"height"`
+			Version               int32    `json:"version"`
+			Timestamp             int64    `json:"timestamp"`
+			Orphaned              bool     `json:"orphaned"`
+			TxIds                 []string `json:"tx_ids"`
+			RevealedPreimageCount *uint32  `json:"revealed_preimage_count,omitempty"`
 		}{
-			Hash:         block.GetHash(),
-			PreviousHash: block.GetPreviousHash(),
-			NextHash:     block.GetNextHash(),
-			Height:       block.GetHeight(),
-			Version:      block.GetVersion(),
-			Timestamp:    block.GetTimestamp(),
-			TxIds:        block.GetTxIds()}
+			Hash:                  block.GetHash(),
+			PreviousHash:          block.GetPreviousHash(),
+			NextHash:              block.GetNextHash(),
+			Height:                block.GetHeight(),
+			Version:               block.GetVersion(),
+			Timestamp:             block.GetTimestamp(),
+			Orphaned:              block.IsOrphaned(),
+			TxIds:                 block.GetTxIds(),
+			RevealedPreimageCount: revealedPreimageCount}
 
 		var blockBytes []byte
 		if blockRequestOptions["human_readable"] != nil && blockRequestOptions["human_readable"].(bool) {
@@ -288,7 +606,7 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		}
 
 		if requestParams["id"] == nil {
-			return "id parameter is required"
+			return api.getBadRequestResponse("id parameter is required")
 		}
 
 		// get the request options
@@ -298,10 +616,19 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		case string:
 			txRequest.TxId = requestParams["id"].(string)
 			if len(txRequest.TxId) != 64 {
-				return "malformed request: parameter id is not a valid transaction id"
+				return api.getBadRequestResponse("malformed request: parameter id is not a valid transaction id")
 			}
 		default:
-			return "malformed request: id must be a hex string"
+			return api.getBadRequestResponse("malformed request: id must be a hex string")
+		}
+
+		// the block is optional, it lets the transaction be found on a node without a transaction index
+		if requestParams["block_hash"] != nil {
+			blockHash, isString := requestParams["block_hash"].(string)
+			if !isString || len(blockHash) != 64 {
+				return api.getBadRequestResponse("malformed request: parameter block_hash is not a valid block hash")
+			}
+			txRequest.BlockHash = blockHash
 		}
 
 		txRequestOptions := map[string]interface{}{}
@@ -312,13 +639,34 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		txRequest.IncludeInputDetail = txRequestOptions["include_input_detail"] != nil && txRequestOptions["include_input_detail"].(bool)
 
 		// get the tx from the node proxy
-		tx := nodeProxy.GetTx(txRequest)
-		if tx.IsNil() {
-			return "transaction not found"
+		tx, err := nodeProxy.GetTx(txRequest)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
 		}
 
+		// the transaction is returned without the mempool entry if the entry is not available
 		txJsonObj := txToJson(tx)
 
+		// the outputs include the inputs that spent them if there is a spend index
+		if nodeProxy.IsSpendIndexOn() {
+			spends, err := nodeProxy.GetOutputSpends(tx.GetTxId())
+			if err == nil {
+				for o, outputJsonObj := range txJsonObj["outputs"].([]map[string]interface{}) {
+					outputJsonObj["spent_by"] = outputSpendToJson(spends[uint16(o)])
+				}
+			} else {
+				node.LogError(err)
+			}
+		}
+		if !tx.IsConfirmed() {
+			mempoolEntry, err := nodeProxy.GetMempoolEntry(tx.GetTxId())
+			if err == nil {
+				txJsonObj["mempool_entry"] = mempoolEntryToJson(mempoolEntry)
+			} else {
+				node.LogError(err)
+			}
+		}
+
 		var txBytes []byte
 		if txRequestOptions["human_readable"] != nil && txRequestOptions["human_readable"].(bool) {
 			txBytes, err = json.MarshalIndent(txJsonObj, "", "\t")
@@ -347,11 +695,11 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		}
 
 		if requestParams["tx_id"] == nil {
-			return "tx_id parameter is required"
+			return api.getBadRequestResponse("tx_id parameter is required")
 		}
 
 		if requestParams["output_index"] == nil {
-			return "output_index parameter is required"
+			return api.getBadRequestResponse("output_index parameter is required")
 		}
 
 		// get the request options
@@ -361,17 +709,25 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		case string:
 			outputRequest.TxId = requestParams["tx_id"].(string)
 			if len(outputRequest.TxId) != 64 {
-				return "malformed request: parameter tx_id is not a valid transaction id"
+				return api.getBadRequestResponse("malformed request: parameter tx_id is not a valid transaction id")
 			}
 		default:
-			return "malformed request: tx_id must be a hex string"
+			return api.getBadRequestResponse("malformed request: tx_id must be a hex string")
+		}
+
+		if requestParams["block_hash"] != nil {
+			blockHash, isString := requestParams["block_hash"].(string)
+			if !isString || len(blockHash) != 64 {
+				return api.getBadRequestResponse("malformed request: parameter block_hash is not a valid block hash")
+			}
+			outputRequest.BlockHash = blockHash
 		}
 
 		switch requestParams["output_index"].(type) {
 		case float64:
 			outputRequest.OutputIndex = uint16(requestParams["output_index"].(float64))
 		default:
-			return "malformed request: output_index must be a numeric index"
+			return api.getBadRequestResponse("malformed request: output_index must be a numeric index")
 		}
 
 		outputRequestOptions := map[string]interface{}{}
@@ -380,12 +736,20 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		}
 
 		// get the output from the node proxy
-		output := nodeProxy.GetOutput(outputRequest)
-		if len(output.GetOutputType()) == 0 {
-			return "output not found"
+		output, err := nodeProxy.GetOutput(outputRequest)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
 		}
 
 		outputJsonObj := outputToJson(output)
+		if nodeProxy.IsSpendIndexOn() {
+			spends, err := nodeProxy.GetOutputSpends(outputRequest.TxId)
+			if err == nil {
+				outputJsonObj["spent_by"] = outputSpendToJson(spends[outputRequest.OutputIndex])
+			} else {
+				node.LogError(err)
+			}
+		}
 
 		var outputBytes []byte
 		if outputRequestOptions["human_readable"] != nil && outputRequestOptions["human_readable"].(bool) {
@@ -415,11 +779,11 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		}
 
 		if requestParams["tx_id"] == nil {
-			return "tx_id parameter is required"
+			return api.getBadRequestResponse("tx_id parameter is required")
 		}
 
 		if requestParams["input_index"] == nil {
-			return "input_index parameter is required"
+			return api.getBadRequestResponse("input_index parameter is required")
 		}
 
 		// get the request options
@@ -429,10 +793,18 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		case string:
 			txRequest.TxId = requestParams["tx_id"].(string)
 			if len(txRequest.TxId) != 64 {
-				return "malformed request: parameter tx_id is not a valid transaction id"
+				return api.getBadRequestResponse("malformed request: parameter tx_id is not a valid transaction id")
 			}
 		default:
-			return "malformed request: tx_id must be a hex string"
+			return api.getBadRequestResponse("malformed request: tx_id must be a hex string")
+		}
+
+		if requestParams["block_hash"] != nil {
+			blockHash, isString := requestParams["block_hash"].(string)
+			if !isString || len(blockHash) != 64 {
+				return api.getBadRequestResponse("malformed request: parameter block_hash is not a valid block hash")
+			}
+			txRequest.BlockHash = blockHash
 		}
 
 		input_index := uint16(0xffff)
@@ -440,7 +812,7 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		case float64:
 			input_index = uint16(requestParams["input_index"].(float64))
 		default:
-			return "malformed request: input_index must be a numeric index"
+			return api.getBadRequestResponse("malformed request: input_index must be a numeric index")
 		}
 
 		inputRequestOptions := map[string]interface{}{}
@@ -449,19 +821,24 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 		}
 
 		// get the input from the node proxy
-		tx := nodeProxy.GetTx(txRequest)
-		if tx.IsNil() || input_index >= tx.GetInputCount() {
-			return "input not found"
+		tx, err := nodeProxy.GetTx(txRequest)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+		if input_index >= tx.GetInputCount() {
+			errBytes, _ := json.Marshal(RestError{Error: fmt.Sprintf("Transaction %s does not have an input %d.", txRequest.TxId, input_index), Type: node.ERROR_TYPE_NotFound})
+			return string(errBytes), http.StatusNotFound
 		}
 
+		// the transaction might be shared with other requests, so the input is re-evaluated in a copy
 		input := tx.GetInput(input_index)
+		input = input.Copy()
 		if !input.IsCoinbase() {
-			previousOutput := nodeProxy.GetOutput(node.OutputRequest{TxId: input.GetPreviousOutputTxId(), OutputIndex: input.GetPreviousOutputIndex()})
-			input.SetPreviousOutput(previousOutput)
-
-			if len(input.GetSpendType()) == 0 {
-				return "input not found"
+			previousOutput, err := nodeProxy.GetOutput(node.OutputRequest{TxId: input.GetPreviousOutputTxId(), BlockHash: tx.GetBlockHash(), OutputIndex: input.GetPreviousOutputIndex()})
+			if err != nil {
+				return api.getNodeErrorResponse(err)
 			}
+			input.SetPreviousOutput(previousOutput)
 		}
 
 		inputJsonObj := inputToJson(input)
@@ -478,6 +855,161 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 
 		responseJson = string(inputBytes)
 
+	case "mempool":
+
+		if httpMethod != "POST" {
+			errorMessage = fmt.Sprintf("%s must be sent as a POST request.", functionName)
+			break
+		}
+
+		// unpack the json, the request body is optional
+		var requestParams map[string]interface{}
+		err := json.NewDecoder(requestBody).Decode(&requestParams)
+		if err != nil && err != io.EOF {
+			errorMessage = err.Error()
+			break
+		}
+
+		mempoolRequestOptions := map[string]interface{}{}
+		if requestParams["options"] != nil {
+			mempoolRequestOptions = requestParams["options"].(map[string]interface{})
+		}
+
+		maxTxCount := MEMPOOL_DEFAULT_MAX_TX_COUNT
+		if mempoolRequestOptions["max_tx_count"] != nil {
+			switch mempoolRequestOptions["max_tx_count"].(type) {
+			case float64:
+				maxTxCount = int(mempoolRequestOptions["max_tx_count"].(float64))
+				if maxTxCount < 0 {
+					return api.getBadRequestResponse("malformed request: max_tx_count can not be negative")
+				}
+			default:
+				return api.getBadRequestResponse("malformed request: max_tx_count must be a number")
+			}
+		}
+
+		// get the summary from the node proxy
+		summary, err := nodeProxy.GetMempoolSummary(maxTxCount)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+		summaryJsonObj := mempoolSummaryToJson(summary)
+
+		var summaryBytes []byte
+		if mempoolRequestOptions["human_readable"] != nil && mempoolRequestOptions["human_readable"].(bool) {
+			summaryBytes, err = json.MarshalIndent(summaryJsonObj, "", "\t")
+		} else {
+			summaryBytes, err = json.Marshal(summaryJsonObj)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+
+		responseJson = string(summaryBytes)
+
+	case "address_history", "address_balance", "address_utxos":
+
+		if httpMethod != "POST" {
+			errorMessage = fmt.Sprintf("%s must be sent as a POST request.", functionName)
+			break
+		}
+
+		// unpack the json
+		var requestParams map[string]interface{}
+		err := json.NewDecoder(requestBody).Decode(&requestParams)
+		if err != nil {
+			errorMessage = err.Error()
+			break
+		}
+
+		if requestParams["address"] == nil {
+			return api.getBadRequestResponse("address parameter is required")
+		}
+
+		// the address parameter can also be the hash of an output script
+		address := ""
+		switch requestParams["address"].(type) {
+		case string:
+			address = requestParams["address"].(string)
+		default:
+			return api.getBadRequestResponse("malformed request: address must be a string")
+		}
+
+		addressRequestOptions := map[string]interface{}{}
+		if requestParams["options"] != nil {
+			addressRequestOptions = requestParams["options"].(map[string]interface{})
+		}
+
+		var addressJsonObj interface{}
+		switch functionName {
+		case "address_history":
+			offset := 0
+			if addressRequestOptions["offset"] != nil {
+				switch addressRequestOptions["offset"].(type) {
+				case float64:
+					offset = int(addressRequestOptions["offset"].(float64))
+					if offset < 0 {
+						return api.getBadRequestResponse("malformed request: offset can not be negative")
+					}
+				default:
+					return api.getBadRequestResponse("malformed request: offset must be a number")
+				}
+			}
+
+			limit := ADDRESS_HISTORY_DEFAULT_LIMIT
+			if addressRequestOptions["limit"] != nil {
+				switch addressRequestOptions["limit"].(type) {
+				case float64:
+					limit = int(addressRequestOptions["limit"].(float64))
+					if limit < 0 {
+						return api.getBadRequestResponse("malformed request: limit can not be negative")
+					}
+				default:
+					return api.getBadRequestResponse("malformed request: limit must be a number")
+				}
+			}
+
+			history, err := nodeProxy.GetAddressHistory(address, offset, limit)
+			if err != nil {
+				return api.getNodeErrorResponse(err)
+			}
+			historyJson := make([]map[string]interface{}, len(history))
+			for h, entry := range history {
+				historyJson[h] = addressHistoryEntryToJson(entry)
+			}
+			addressJsonObj = historyJson
+
+		case "address_balance":
+			summary, err := nodeProxy.GetAddressSummary(address)
+			if err != nil {
+				return api.getNodeErrorResponse(err)
+			}
+			addressJsonObj = addressSummaryToJson(summary)
+
+		case "address_utxos":
+			utxos, err := nodeProxy.GetAddressUtxos(address)
+			if err != nil {
+				return api.getNodeErrorResponse(err)
+			}
+			utxosJson := make([]map[string]interface{}, len(utxos))
+			for u, utxo := range utxos {
+				utxosJson[u] = addressUtxoToJson(utxo)
+			}
+			addressJsonObj = utxosJson
+		}
+
+		var addressBytes []byte
+		if addressRequestOptions["human_readable"] != nil && addressRequestOptions["human_readable"].(bool) {
+			addressBytes, err = json.MarshalIndent(addressJsonObj, "", "\t")
+		} else {
+			addressBytes, err = json.Marshal(addressJsonObj)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+
+		responseJson = string(addressBytes)
+
 	case "current_block_height":
 
 		if httpMethod != "GET" {
@@ -485,7 +1017,10 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 			break
 		}
 
-		height := nodeProxy.GetCurrentBlockHeight()
+		height, err := nodeProxy.GetCurrentBlockHeight()
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
 
 		blockJsonData := struct {
 			H int32 `json:"current_block_height"`
@@ -497,15 +1032,182 @@ func (api *RestApiV1) HandleRequest(httpMethod string, functionName string, getP
 
 		responseJson = string(jsonBytes)
 
+	case "cache_stats":
+
+		if httpMethod != "GET" {
+			errorMessage = fmt.Sprintf("%s must be sent as a GET request.", functionName)
+			break
+		}
+
+		jsonBytes, err := json.Marshal(cacheStatsToJson(nodeProxy.GetCacheStats()))
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		responseJson = string(jsonBytes)
+
+	case "node_status":
+
+		if httpMethod != "GET" {
+			errorMessage = fmt.Sprintf("%s must be sent as a GET request.", functionName)
+			break
+		}
+
+		statuses := nodeProxy.GetNodeStatuses()
+		statusesJson := make([]map[string]interface{}, len(statuses))
+		for n, status := range statuses {
+			statusesJson[n] = nodeStatusToJson(status)
+		}
+
+		jsonBytes, err := json.Marshal(statusesJson)
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		responseJson = string(jsonBytes)
+
+	case "chain_tips":
+
+		if httpMethod != "GET" {
+			errorMessage = fmt.Sprintf("%s must be sent as a GET request.", functionName)
+			break
+		}
+
+		chainTips, err := nodeProxy.GetChainTips()
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+		chainTipsJson := make([]map[string]interface{}, len(chainTips))
+		for t, chainTip := range chainTips {
+			chainTipsJson[t] = chainTipToJson(chainTip)
+		}
+
+		jsonBytes, err := json.Marshal(chainTipsJson)
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		responseJson = string(jsonBytes)
+
+	case "chain_branch":
+
+		if httpMethod != "POST" {
+			errorMessage = fmt.Sprintf("%s must be sent as a POST request.", functionName)
+			break
+		}
+
+		// unpack the json
+		var requestParams map[string]interface{}
+		err := json.NewDecoder(requestBody).Decode(&requestParams)
+		if err != nil {
+			errorMessage = err.Error()
+			break
+		}
+
+		if requestParams["hash"] == nil {
+			return api.getBadRequestResponse("hash parameter is required")
+		}
+
+		tipHash := ""
+		switch requestParams["hash"].(type) {
+		case string:
+			tipHash = requestParams["hash"].(string)
+			if len(tipHash) != 64 {
+				return api.getBadRequestResponse("malformed request: parameter hash is not a valid block hash")
+			}
+		default:
+			return api.getBadRequestResponse("malformed request: hash must be a string")
+		}
+
+		branchRequestOptions := map[string]interface{}{}
+		if requestParams["options"] != nil {
+			branchRequestOptions = requestParams["options"].(map[string]interface{})
+		}
+
+		branch, err := nodeProxy.GetChainBranch(tipHash)
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+		branchJsonObj := chainBranchToJson(branch)
+
+		var branchBytes []byte
+		if branchRequestOptions["human_readable"] != nil && branchRequestOptions["human_readable"].(bool) {
+			branchBytes, err = json.MarshalIndent(branchJsonObj, "", "\t")
+		} else {
+			branchBytes, err = json.Marshal(branchJsonObj)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+
+		responseJson = string(branchBytes)
+
+	case "block_comparison":
+
+		if httpMethod != "POST" {
+			errorMessage = fmt.Sprintf("%s must be sent as a POST request.", functionName)
+			break
+		}
+
+		// unpack the json
+		var requestParams map[string]interface{}
+		err := json.NewDecoder(requestBody).Decode(&requestParams)
+		if err != nil {
+			errorMessage = err.Error()
+			break
+		}
+
+		if requestParams["hashes"] == nil {
+			return api.getBadRequestResponse("hashes parameter is required")
+		}
+
+		blockHashes := make([]string, 0, 2)
+		switch requestParams["hashes"].(type) {
+		case []interface{}:
+			for _, hash := range requestParams["hashes"].([]interface{}) {
+				blockHash, isString := hash.(string)
+				if !isString || len(blockHash) != 64 {
+					return api.getBadRequestResponse("malformed request: hashes must be block hashes")
+				}
+				blockHashes = append(blockHashes, blockHash)
+			}
+		}
+		if len(blockHashes) != 2 {
+			return api.getBadRequestResponse("malformed request: hashes must be an array of two block hashes")
+		}
+
+		comparisonRequestOptions := map[string]interface{}{}
+		if requestParams["options"] != nil {
+			comparisonRequestOptions = requestParams["options"].(map[string]interface{})
+		}
+		includeTxs := comparisonRequestOptions["include_txs"] != nil && comparisonRequestOptions["include_txs"].(bool)
+
+		comparison, err := nodeProxy.CompareBlocks(blockHashes[0], blockHashes[1])
+		if err != nil {
+			return api.getNodeErrorResponse(err)
+		}
+		comparisonJsonObj := blockComparisonToJson(comparison, includeTxs)
+
+		var comparisonBytes []byte
+		if comparisonRequestOptions["human_readable"] != nil && comparisonRequestOptions["human_readable"].(bool) {
+			comparisonBytes, err = json.MarshalIndent(comparisonJsonObj, "", "\t")
+		} else {
+			comparisonBytes, err = json.Marshal(comparisonJsonObj)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+
+		responseJson = string(comparisonBytes)
+
 	default:
 		errorMessage = fmt.Sprintf("Unknown REST v%d function: %s", api.GetVersion(), functionName)
 	}
 
 	if len(errorMessage) > 0 {
 		fmt.Println(errorMessage)
-		errBytes, _ := json.Marshal(RestError{Error: errorMessage})
-		responseJson = string(errBytes)
+		return api.getBadRequestResponse(errorMessage)
 	}
 
-	return responseJson
+	return responseJson, http.StatusOK
 }
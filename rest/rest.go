@@ -7,8 +7,10 @@ import (
 	"strings"
 )
 
+// Type is the type of error returned by the node, if the error came from the node
 type RestError struct {
 	Error string
+	Type  string `json:",omitempty"`
 }
 
 func RestHandler(response http.ResponseWriter, request *http.Request) {
@@ -28,9 +30,10 @@ func RestHandler(response http.ResponseWriter, request *http.Request) {
 	// at a minimum, there must be at least 3 url parameters
 
 	responseJson := ""
+	statusCode := http.StatusOK
 	if !formatError {
 		requestParts := strings.Split(modifiedPath, "/")
-		formatError = requestParts[0] != "rest"
+		formatError = len(requestParts) < 3 || requestParts[0] != "rest"
 		if !formatError {
 			restAPIVersion := requestParts[1]
 			restAPIEndpoint := requestParts[2]
@@ -38,7 +41,9 @@ func RestHandler(response http.ResponseWriter, request *http.Request) {
 			switch restAPIVersion {
 			case "v1":
 				restApiV1 := RestApiV1{}
-				responseJson = restApiV1.HandleRequest(request.Method, restAPIEndpoint, restAPIParamString, request.Body)
+				responseJson, statusCode = restApiV1.HandleRequest(request.Context(), request.Method, restAPIEndpoint, restAPIParamString, request.Body)
+			default:
+				formatError = true
 			}
 		}
 	}
@@ -48,7 +53,9 @@ func RestHandler(response http.ResponseWriter, request *http.Request) {
 		fmt.Println(errorMessage)
 		errBytes, _ := json.Marshal(RestError{Error: errorMessage})
 		responseJson = string(errBytes)
+		statusCode = http.StatusBadRequest
 	}
 
+	response.WriteHeader(statusCode)
 	fmt.Fprint(response, responseJson)
 }
@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/app"
+	"github.com/btc-script-explorer/scantool/btc/node"
+)
+
+// the node responses for mainnet block 170 and its transactions were recorded from Bitcoin Core and are replayed here
+// block 170 has the first transaction from one person to another, which spends the coinbase output of block 9
+const testFixturesDir = "../btc/node/testdata/mainnet-fixtures"
+const testBlockHash = "00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee"
+const testBlockHeight = 170
+const testCoinbaseTxId = "b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082"
+const testTxId = "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16"
+const testPreviousTxId = "0437cd7f8525ceed2324359c2d0ba26006d92d856a9c20fa0241106ee5a597c9"
+
+// the settings are read from the command line, so they are parsed before the test flags are
+func TestMain(m *testing.M) {
+	args := os.Args
+	os.Args = []string{args[0], "--node-fixtures-mode=replay", "--node-fixtures-dir=" + testFixturesDir}
+	app.ParseSettings("test")
+	os.Args = args
+
+	os.Exit(m.Run())
+}
+
+func sendRestTestRequest(t *testing.T, method string, path string, body string) (int, map[string]interface{}) {
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	RestHandler(recorder, request)
+
+	var responseJson map[string]interface{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &responseJson)
+	if err != nil {
+		t.Fatalf("%s %s returned %q, which is not a JSON object", path, body, recorder.Body.String())
+	}
+	return recorder.Code, responseJson
+}
+
+func TestRestBlock(t *testing.T) {
+
+	for _, body := range []string{`{"hash":"` + testBlockHash + `"}`, `{"height":170}`} {
+		statusCode, block := sendRestTestRequest(t, "POST", "/rest/v1/block", body)
+		if statusCode != http.StatusOK {
+			t.Fatalf("block %s returned status %d: %v", body, statusCode, block)
+		}
+
+		if block["hash"] != testBlockHash || block["height"] != float64(testBlockHeight) || block["orphaned"] != false {
+			t.Errorf("block %s returned %v %v, expected %s %d", body, block["hash"], block["height"], testBlockHash, testBlockHeight)
+		}
+		if block["previous_hash"] != "000000002a22cfee1f2c846adbd12b3e183d4f97683f85dad08a79780a84bd55" || block["next_hash"] != "00000000c9ec538cab7f38ef9c67a95742f56ab07b0a37c5be6b02808dbfb4e0" {
+			t.Errorf("block %s is linked to %v and %v", body, block["previous_hash"], block["next_hash"])
+		}
+
+		txIds, _ := block["tx_ids"].([]interface{})
+		if len(txIds) != 2 || txIds[0] != testCoinbaseTxId || txIds[1] != testTxId {
+			t.Errorf("block %s has txs %v, expected %s %s", body, txIds, testCoinbaseTxId, testTxId)
+		}
+	}
+}
+
+func TestRestTx(t *testing.T) {
+
+	for _, body := range []string{`{"id":"` + testTxId + `"}`, `{"id":"` + testTxId + `","block_hash":"` + testBlockHash + `"}`} {
+		statusCode, tx := sendRestTestRequest(t, "POST", "/rest/v1/tx", body)
+		if statusCode != http.StatusOK {
+			t.Fatalf("tx %s returned status %d: %v", body, statusCode, tx)
+		}
+
+		if tx["id"] != testTxId || tx["blockhash"] != testBlockHash || tx["confirmed"] != true || tx["coinbase"] != false {
+			t.Errorf("tx %s returned %v in block %v", body, tx["id"], tx["blockhash"])
+		}
+
+		outputs, _ := tx["outputs"].([]interface{})
+		expectedValues := []float64{1000000000, 4000000000}
+		if len(outputs) != len(expectedValues) {
+			t.Fatalf("tx %s has %d outputs, expected %d", body, len(outputs), len(expectedValues))
+		}
+		for o, output := range outputs {
+			value := output.(map[string]interface{})["value"]
+			if value != expectedValues[o] {
+				t.Errorf("output %d of tx %s has value %v, expected %.0f", o, body, value, expectedValues[o])
+			}
+		}
+	}
+
+	// a transaction the node did not find
+	statusCode, restError := sendRestTestRequest(t, "POST", "/rest/v1/tx", `{"id":"0000000000000000000000000000000000000000000000000000000000000001"}`)
+	if statusCode != http.StatusNotFound || restError["Type"] != node.ERROR_TYPE_NotFound {
+		t.Errorf("a missing tx returned status %d: %v", statusCode, restError)
+	}
+}
+
+// the previous output of the input is found from the block of the spending transaction or without it
+func TestRestInput(t *testing.T) {
+
+	for _, body := range []string{`{"tx_id":"` + testTxId + `","input_index":0}`, `{"tx_id":"` + testTxId + `","input_index":0,"block_hash":"` + testBlockHash + `"}`} {
+		statusCode, input := sendRestTestRequest(t, "POST", "/rest/v1/input", body)
+		if statusCode != http.StatusOK {
+			t.Fatalf("input %s returned status %d: %v", body, statusCode, input)
+		}
+
+		if input["previous_output_tx_id"] != testPreviousTxId || input["previous_output_index"] != float64(0) || input["spend_type"] != "P2PK" {
+			t.Errorf("input %s spends %v:%v as %v", body, input["previous_output_tx_id"], input["previous_output_index"], input["spend_type"])
+		}
+
+		previousOutput, _ := input["previous_output"].(map[string]interface{})
+		if previousOutput["value"] != float64(5000000000) || previousOutput["output_type"] != "P2PK" {
+			t.Errorf("input %s has previous output %v", body, previousOutput)
+		}
+	}
+
+	statusCode, restError := sendRestTestRequest(t, "POST", "/rest/v1/input", `{"tx_id":"`+testTxId+`","input_index":1}`)
+	if statusCode != http.StatusNotFound || restError["Type"] != node.ERROR_TYPE_NotFound {
+		t.Errorf("a missing input returned status %d: %v", statusCode, restError)
+	}
+}
+
+// every request that can not be answered returns an error object
+func TestRestBadRequests(t *testing.T) {
+
+	badRequests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/rest/v1/block", `{"hash":170}`},
+		{"POST", "/rest/v1/block", `{"height":"170"}`},
+		{"GET", "/rest/v1/block", ``},
+		{"POST", "/rest/v1/tx", `{"id":5}`},
+		{"POST", "/rest/v1/input", `{"tx_id":"` + testTxId + `","input_index":"0"}`},
+		{"POST", "/rest/v1/unknown", `{}`},
+		{"POST", "/rest/v2/block", `{}`},
+		{"POST", "/rest/v1", `{}`},
+	}
+
+	for _, badRequest := range badRequests {
+		statusCode, restError := sendRestTestRequest(t, badRequest.method, badRequest.path, badRequest.body)
+		message, _ := restError["Error"].(string)
+		if statusCode != http.StatusBadRequest || len(message) == 0 {
+			t.Errorf("%s %s %s returned status %d: %v", badRequest.method, badRequest.path, badRequest.body, statusCode, restError)
+		}
+	}
+}
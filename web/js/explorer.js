@@ -11,8 +11,13 @@ console.log (block_tx_ids);
 	{
 		const headers = new Headers ();
 		headers.append ("Content-Type", "application/json");
-		const response = await fetch (base_url_web + '/block-tx/' + block_tx_ids [t] + '/' + t);
+		const response = await fetch (base_url_web + '/block-tx/' + block_tx_ids [t] + '/' + t + '/' + block_hash);
 		const data = await response.json ();
+		if (!response.ok)
+		{
+			console.log (data.Error);
+			break;
+		}
 		$ ('#tx-count').html (++txs_loaded);
 		if (data.bip141)
 			++bip141_count;
@@ -42,9 +47,14 @@ async function get_tx_inputs ()
 	{
 		const headers = new Headers ();
 		headers.append ("Content-Type", "application/json");
-		var request_data = { method: 'POST', headers: headers, body: JSON.stringify ({ tx_id: tx_inputs [i].tx_id, input_index: tx_inputs [i].input_index }) };
+		var request_data = { method: 'POST', headers: headers, body: JSON.stringify ({ tx_id: tx_inputs [i].tx_id, input_index: tx_inputs [i].input_index, block_hash: tx_inputs [i].block_hash }) };
 		const response = await fetch (base_url_web + '/input', request_data);
 		const data = await response.json ();
+		if (!response.ok)
+		{
+			console.log (data.Error);
+			break;
+		}
 
 		$ ('#input-minimized-' + i + '-spend-type').html (data.spend_type)
 		$ ('#input-minimized-' + i + '-value').html (get_value_html (data.value_in))
@@ -72,10 +82,11 @@ async function get_tx_inputs ()
 	$ ('#tx-fee').html (get_value_html ($ ('#tx-fee').text ()));
 }
 
+// block ids and transaction ids are hex, addresses are base58 or bech32
 function check_query_id_format (query_id)
 {
 	query_id = query_id.toLowerCase ();
-	var allowed_chars = '0123456789abcdef';
+	var allowed_chars = '0123456789abcdefghijklmnopqrstuvwxyz';
 	for (var i = 0; i < query_id.length; i++)
 	{
 		if (allowed_chars.indexOf (query_id.charAt (i)) == -1)
@@ -115,6 +126,31 @@ function get_pending_block_spend_types ()
 	return pending_spend_types;
 }
 
+// the types are counted by the server from the transactions of the block, including the non-standard sub-types
+async function get_block_charts ()
+{
+	$ ('#toggle-charts-link').css ('display', 'none');
+
+	const headers = new Headers ();
+	headers.append ("Content-Type", "application/json");
+	var request_data = { method: 'POST', headers: headers, body: JSON.stringify ({ block_hash: block_hash }) };
+	const response = await fetch (base_url_web + '/block_charts', request_data);
+	const data = await response.json ();
+	if (!response.ok)
+	{
+		console.log (data.Error);
+		$ ('#toggle-charts-link').css ('display', 'block');
+		return;
+	}
+
+	if (typeof data.SpendTypeChart != 'undefined')
+		$ ('#spend-types').html (data.SpendTypeChart);
+	else
+		$ ('#spend-type-chart').css ('display', 'none');
+	$ ('#output-types').html (data.OutputTypeChart);
+	$ ('#type-charts').css ('display', 'block');
+}
+
 function get_value_html (value)
 {
 	var val_str = Number (value).toString ();
@@ -200,10 +236,46 @@ async function check_for_new_block ()
 	var request_data = { method: 'GET', headers: headers };
 	const response = await fetch (base_url_rest + '/current_block_height', request_data);
 	const data = await response.json ();
+	if (!response.ok)
+		return;
 
 	$ ('#current-block').html (data.current_block_height);
 }
 
+// the number of mempool transactions the spend type and output type distributions are calculated from
+const MEMPOOL_SAMPLE_SIZE = 100;
+
+function get_type_rows_html (type_counts)
+{
+	var types = Object.keys (type_counts).sort (function (a, b) { return type_counts [b] - type_counts [a]; });
+	var rows_html = '';
+	for (var t in types)
+		rows_html += '<tr><td style="text-align:left;">' + $ ('<span>').text (types [t]).html () + '</td><td style="text-align:right;">' + type_counts [types [t]] + '</td></tr>';
+
+	return rows_html;
+}
+
+var mempool_interval = null;
+async function get_mempool_summary ()
+{
+	const headers = new Headers ();
+	headers.append ("Content-Type", "application/json");
+	var request_data = { method: 'POST', headers: headers, body: JSON.stringify ({ options: { max_tx_count: MEMPOOL_SAMPLE_SIZE } }) };
+	const response = await fetch (base_url_rest + '/mempool', request_data);
+	const data = await response.json ();
+	if (typeof data.tx_count === 'undefined')
+		return;
+
+	$ ('#mempool-tx-count').html (data.tx_count);
+	$ ('#mempool-sampled-tx-count').html (data.sampled_tx_count);
+	$ ('#mempool-input-count').html (data.input_count);
+	$ ('#mempool-output-count').html (data.output_count);
+	$ ('#mempool-total-fee').html (get_value_html (data.total_fee));
+	$ ('#mempool-spend-types').html (get_type_rows_html (data.spend_types));
+	$ ('#mempool-output-types').html (get_type_rows_html (data.output_types));
+	$ ('#mempool-summary').css ('display', 'block');
+}
+
 $ (document).ready (
 function ()
 {
@@ -224,5 +296,33 @@ function ()
 
 	check_for_new_block ();
 	current_block_interval = setInterval (check_for_new_block, 60000);
+
+	if ($ ('#mempool-summary').length > 0)
+	{
+		get_mempool_summary ();
+		mempool_interval = setInterval (get_mempool_summary, 30000);
+	}
+
+	// live block notifications replace polling when they are available
+	if (typeof EventSource !== 'undefined')
+	{
+		const block_events = new EventSource (base_url_web + '/events');
+		block_events.onopen = function () { clearInterval (current_block_interval); current_block_interval = null; };
+		block_events.onmessage = function (e)
+		{
+			const block_event = JSON.parse (e.data);
+			if (block_event.type == 'block_connected' && block_event.block_height >= 0)
+				$ ('#current-block').html (block_event.block_height);
+
+			// a new block removes its transactions from the mempool
+			if (mempool_interval != null)
+				get_mempool_summary ();
+		};
+		block_events.onerror = function ()
+		{
+			if (block_events.readyState == EventSource.CLOSED && current_block_interval == null)
+				current_block_interval = setInterval (check_for_new_block, 60000);
+		};
+	}
 });
 
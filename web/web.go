@@ -24,6 +24,9 @@ import (
 	"github.com/btc-script-explorer/scantool/rest"
 )
 
+// the number of history entries shown on the address page
+const ADDRESS_PAGE_HISTORY_LIMIT = 100
+
 // html template structs
 
 type ElementTypeHTML struct {
@@ -59,6 +62,8 @@ type InputHtmlData struct {
 	DisplayTypeClassPrefix string
 	IsCoinbase             bool
 	SpendType              string
+	NonStandardType        string
+	NonStandardReason      string
 	ValueIn                template.HTML
 	BaseUrl                string
 	PreviousOutputTxId     string
@@ -82,6 +87,11 @@ type OutputHtmlData struct {
 	Value                  template.HTML
 	Address                string
 	OutputScript           ScriptHtmlData
+	BaseUrl                string
+	SpendIndexOn           bool
+	SpentByTxId            string
+	SpentByInputIndex      uint16
+	SpentByBlockHeight     uint32
 }
 
 type SegwitHtmlData struct {
@@ -129,10 +139,19 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// node requests are cancelled if the browser disconnects
+	nodeProxy = nodeProxy.WithContext(request.Context())
+
 	html := ""
 	customJavascript := fmt.Sprintf("var base_url_web = '%s/web';\n", app.Settings.GetFullUrl())
 	customJavascript += fmt.Sprintf("var base_url_rest = '%s/rest/v%d';\n", app.Settings.GetFullUrl(), restApi.GetVersion())
 
+	// live block notifications
+	if paramCount >= 1 && params[0] == "events" {
+		serveEvents(response, request, nodeProxy)
+		return
+	}
+
 	// about page
 	if paramCount >= 1 && params[0] == "about" {
 		fmt.Fprint(response, getAboutPageHtml(customJavascript))
@@ -143,6 +162,9 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 
 	possibleQueryTypes := make([]string, 0)
 
+	// if every query type fails, the page shows the most serious error
+	var nodeErr error = nil
+
 	if paramCount < 1 {
 		possibleQueryTypes = append(possibleQueryTypes, "block")
 	} else {
@@ -167,14 +189,19 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 
 				possibleQueryTypes = append(possibleQueryTypes, "tx")
 				possibleQueryTypes = append(possibleQueryTypes, "block")
+
+				// or the hash of an output script
+				if nodeProxy.IsAddressIndexOn() {
+					possibleQueryTypes = append(possibleQueryTypes, "address")
+				}
 			} else {
-				// it could be a block height
+				// it could be a block height or an address
 				_, err := strconv.ParseUint(params[1], 10, 32)
-				if err != nil {
-					fmt.Println(params[1] + " is not a valid block height.")
+				if err == nil || !nodeProxy.IsAddressIndexOn() {
+					possibleQueryTypes = append(possibleQueryTypes, "block")
+				} else {
+					possibleQueryTypes = append(possibleQueryTypes, "address")
 				}
-
-				possibleQueryTypes = append(possibleQueryTypes, "block")
 			}
 		}
 	}
@@ -200,8 +227,9 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 			//				options := make (map [string] interface {})
 			//				blockRequestData ["options"] = options
 
-			block := nodeProxy.GetBlock(blockRequest)
-			if block.IsNil() {
+			block, err := nodeProxy.GetBlock(blockRequest)
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
 				break
 			}
 
@@ -214,6 +242,7 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 			}
 
 			customJavascript += fmt.Sprintf("var block_tx_ids = JSON.parse ('%s');\n", string(txIdsBytes))
+			customJavascript += fmt.Sprintf("var block_hash = '%s';\n", block.GetHash())
 			html = getBlockHtml(block, customJavascript)
 
 		// block-tx is for the web interface to get HTML segments in real time
@@ -241,10 +270,15 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 				break
 			}
 
+			// the block hash lets the transaction be found on a node without a transaction index
 			txRequest := node.TxRequest{TxId: params[1]}
-			tx := nodeProxy.GetTx(txRequest)
-			if tx.IsNil() {
-				break
+			if paramCount >= 4 && len(params[3]) == 64 {
+				txRequest.BlockHash = params[3]
+			}
+			tx, err := nodeProxy.GetTx(txRequest)
+			if err != nil {
+				writeNodeErrorJson(response, err)
+				return
 			}
 
 			blockTxResponse := getBlockTxResponse(tx, uint16(blockIndex))
@@ -275,10 +309,15 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 				break
 			}
 
+			// links from blocks include the block hash, so the transaction can be found on a node without a transaction index
 			txRequest := node.TxRequest{TxId: params[1]}
+			if paramCount >= 3 && len(params[2]) == 64 {
+				txRequest.BlockHash = params[2]
+			}
 
-			tx := nodeProxy.GetTx(txRequest)
-			if tx.IsNil() {
+			tx, err := nodeProxy.GetTx(txRequest)
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
 				break
 			}
 
@@ -287,13 +326,116 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 				if len(javascriptInputs) > 0 {
 					javascriptInputs += ","
 				}
-				javascriptInputs += fmt.Sprintf("{tx_id:\"%s\",input_index:%d}", params[1], i)
+				javascriptInputs += fmt.Sprintf("{tx_id:\"%s\",input_index:%d,block_hash:\"%s\"}", params[1], i, tx.GetBlockHash())
+			}
+
+			// the transaction is shown without the mempool entry if the entry is not available
+			mempoolEntry := node.MempoolEntry{}
+			if !tx.IsConfirmed() {
+				mempoolEntry, err = nodeProxy.GetMempoolEntry(tx.GetTxId())
+				node.LogError(err)
+			}
+
+			// the spends of the outputs are only known if there is a spend index
+			var spends map[uint16]node.OutputSpend
+			if nodeProxy.IsSpendIndexOn() {
+				spends, err = nodeProxy.GetOutputSpends(tx.GetTxId())
+				node.LogError(err)
 			}
 
 			customJavascript += fmt.Sprintf("var tx_inputs = [%s];", javascriptInputs)
-			html = getTxHtml(tx, customJavascript)
+			html = getTxHtml(tx, mempoolEntry, spends, customJavascript)
+
+		// returns html
+		case "address":
+
+			if request.Method != "GET" {
+				fmt.Println(fmt.Sprintf("%s must be sent as a GET request.", queryType))
+				break
+			}
+
+			if paramCount < 2 {
+				fmt.Println("No address provided. Request ignored.")
+				break
+			}
+
+			summary, err := nodeProxy.GetAddressSummary(params[1])
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
+				break
+			}
 
-			//			case "address": // would probably require an electrum server for implementation
+			// the most recent history is shown
+			historyCount := int(summary.GetFundedCount() + summary.GetSpentCount())
+			offset := 0
+			if historyCount > ADDRESS_PAGE_HISTORY_LIMIT {
+				offset = historyCount - ADDRESS_PAGE_HISTORY_LIMIT
+			}
+			history, err := nodeProxy.GetAddressHistory(params[1], offset, ADDRESS_PAGE_HISTORY_LIMIT)
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
+				break
+			}
+
+			html = getAddressHtml(params[1], summary, history, historyCount, customJavascript)
+
+		// returns html
+		case "chain-tips":
+
+			if request.Method != "GET" {
+				fmt.Println(fmt.Sprintf("%s must be sent as a GET request.", queryType))
+				break
+			}
+
+			chainTips, err := nodeProxy.GetChainTips()
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
+				break
+			}
+
+			html = getChainTipsHtml(chainTips, customJavascript)
+
+		// returns html
+		case "chain-branch":
+
+			if request.Method != "GET" {
+				fmt.Println(fmt.Sprintf("%s must be sent as a GET request.", queryType))
+				break
+			}
+
+			if paramCount < 2 {
+				fmt.Println("No chain tip provided. Request ignored.")
+				break
+			}
+
+			branch, err := nodeProxy.GetChainBranch(params[1])
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
+				break
+			}
+
+			html = getChainBranchHtml(branch, customJavascript)
+
+		// returns html
+		case "compare":
+
+			if request.Method != "GET" {
+				fmt.Println(fmt.Sprintf("%s must be sent as a GET request.", queryType))
+				break
+			}
+
+			if paramCount < 3 {
+				fmt.Println("Two block hashes are required for a comparison. Request ignored.")
+				break
+			}
+
+			comparison, err := nodeProxy.CompareBlocks(params[1], params[2])
+			if err != nil {
+				nodeErr = getWorseNodeError(nodeErr, err)
+				break
+			}
+
+			html = getBlockComparisonHtml(comparison, customJavascript)
 
 		// returns json
 		case "input":
@@ -343,12 +485,14 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 
 			// get the tx
 			txRequest := node.TxRequest{TxId: txId}
-			tx := nodeProxy.GetTx(txRequest)
+			if blockHash, isString := params["block_hash"].(string); isString && len(blockHash) == 64 {
+				txRequest.BlockHash = blockHash
+			}
+			tx, err := nodeProxy.GetTx(txRequest)
 
 			// check for errors
-			if tx.IsNil() {
-				fmt.Println(fmt.Sprintf("Tx %s could not be found.", txId))
-				fmt.Fprint(response, "")
+			if err != nil {
+				writeNodeErrorJson(response, err)
 				return
 			}
 
@@ -359,7 +503,9 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 			}
 
 			// get the input
+			// the transaction might be shared with other requests, so the input is re-evaluated in a copy
 			input := tx.GetInput(inputIndex)
+			input = input.Copy()
 			var valueIn uint64
 			var address string
 			if input.IsCoinbase() {
@@ -369,8 +515,12 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 					valueIn += output.GetValue()
 				}
 			} else {
-				outputRequest := node.OutputRequest{TxId: input.GetPreviousOutputTxId(), OutputIndex: input.GetPreviousOutputIndex()}
-				previousOutput := nodeProxy.GetOutput(outputRequest)
+				outputRequest := node.OutputRequest{TxId: input.GetPreviousOutputTxId(), BlockHash: tx.GetBlockHash(), OutputIndex: input.GetPreviousOutputIndex()}
+				previousOutput, err := nodeProxy.GetOutput(outputRequest)
+				if err != nil {
+					writeNodeErrorJson(response, err)
+					return
+				}
 				input.SetPreviousOutput(previousOutput)
 				address = previousOutput.GetAddress()
 				if len(address) == 0 {
@@ -387,6 +537,10 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 
 			jsonInput := make(map[string]interface{})
 			jsonInput["spend_type"] = input.GetSpendType()
+			if input.GetSpendType() == btc.SPEND_TYPE_NonStandard {
+				jsonInput["non_standard_type"] = input.GetNonStandardType()
+				jsonInput["non_standard_reason"] = input.GetNonStandardReason()
+			}
 			jsonInput["address"] = address
 			jsonInput["value_in"] = valueIn
 			jsonInput["input_html"] = inputHtml
@@ -413,7 +567,22 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 				fmt.Println(err.Error())
 			}
 
-			blockCharts := getBlockCharts(blockChartData.NonCoinbaseInputCount, blockChartData.OutputCount, blockChartData.SpendTypes, blockChartData.OutputTypes)
+			// the types are counted here if only the block is given, which is the only way to get the non-standard sub-types
+			if len(blockChartData.BlockHash) == 64 {
+				block, err := nodeProxy.GetBlock(node.BlockRequest{BlockKey: blockChartData.BlockHash})
+				if err != nil {
+					writeNodeErrorJson(response, err)
+					return
+				}
+				txs, err := nodeProxy.GetTxs(block.GetTxIds(), true)
+				if err != nil {
+					writeNodeErrorJson(response, err)
+					return
+				}
+				blockChartData = getBlockChartData(txs)
+			}
+
+			blockCharts := getBlockCharts(blockChartData.NonCoinbaseInputCount, blockChartData.OutputCount, blockChartData.SpendTypes, blockChartData.NonStandardTypes, blockChartData.OutputTypes)
 
 			chartsBytes, err := json.Marshal(blockCharts)
 			if err != nil {
@@ -431,12 +600,93 @@ func WebHandler(response http.ResponseWriter, request *http.Request) {
 	}
 
 	if len(html) == 0 {
-		html = getExplorerPageHtml()
+		errorMessage := ""
+		if nodeErr != nil {
+			node.LogError(nodeErr)
+
+			var statusCode int
+			statusCode, errorMessage = getNodeErrorStatus(nodeErr)
+			response.WriteHeader(statusCode)
+		}
+		html = getExplorerPageHtml(errorMessage)
 	}
 
 	fmt.Fprint(response, html)
 }
 
+// returns the http status and the message shown to the user for an error returned by the node proxy
+func getNodeErrorStatus(err error) (int, string) {
+	switch node.GetErrorType(err) {
+	case node.ERROR_TYPE_NotFound:
+		return http.StatusNotFound, "No block, transaction or address was found."
+	case node.ERROR_TYPE_Unavailable:
+		return http.StatusServiceUnavailable, "The node is not available. Try again later."
+	case node.ERROR_TYPE_Unauthorized:
+		return http.StatusBadGateway, "The node rejected the credentials it was sent."
+	case node.ERROR_TYPE_Decode:
+		return http.StatusBadGateway, "The response from the node could not be read."
+	}
+	return http.StatusInternalServerError, "The node returned an error."
+}
+
+// not found errors are the least serious, because a search is tried as both a transaction and a block
+func getWorseNodeError(previousErr error, err error) error {
+	if previousErr == nil || node.IsNotFound(previousErr) {
+		return err
+	}
+	return previousErr
+}
+
+// for requests from the browser that expect JSON
+func writeNodeErrorJson(response http.ResponseWriter, err error) {
+	node.LogError(err)
+
+	statusCode, errorMessage := getNodeErrorStatus(err)
+	errBytes, _ := json.Marshal(rest.RestError{Error: errorMessage, Type: node.GetErrorType(err)})
+
+	response.WriteHeader(statusCode)
+	fmt.Fprint(response, string(errBytes))
+}
+
+// streams block events to the browser as server-sent events until the browser disconnects
+func serveEvents(response http.ResponseWriter, request *http.Request, nodeProxy *node.NodeProxy) {
+
+	flusher, canFlush := response.(http.Flusher)
+	if !canFlush || !nodeProxy.AreEventsOn() {
+		http.Error(response, "Live notifications are not enabled.", http.StatusNotFound)
+		return
+	}
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	subscriberId, events := nodeProxy.SubscribeEvents(20, node.EVENT_TYPE_BlockConnected, node.EVENT_TYPE_BlockDisconnected)
+	defer nodeProxy.UnsubscribeEvents(subscriberId)
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case event, open := <-events:
+			if !open {
+				return
+			}
+
+			eventJson := map[string]interface{}{"type": event.GetType(), "block_hash": event.GetBlockHash(), "block_height": event.GetBlockHeight()}
+			jsonBytes, err := json.Marshal(eventJson)
+			if err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+
+			fmt.Fprintf(response, "data: %s\n\n", jsonBytes)
+			flusher.Flush()
+		}
+	}
+}
+
 func ServeFile(response http.ResponseWriter, request *http.Request) {
 
 	if request.URL.Path == "/favicon.ico" {
@@ -492,10 +742,13 @@ func getLayoutHtmlData(customJavascript string, explorerPageData map[string]inte
 	return layoutData
 }
 
-func getExplorerPageHtml() string {
+// the error message is shown above the mempool summary if it is not empty
+func getExplorerPageHtml(errorMessage string) string {
 
 	// get the data
 	explorerPageData := getExplorerPageHtmlData("", nil)
+	explorerPageData["ShowMempool"] = true
+	explorerPageData["ErrorMessage"] = errorMessage
 	layoutData := getLayoutHtmlData("", explorerPageData)
 
 	// parse the files
@@ -537,9 +790,15 @@ func getBlockHtml(block btc.Block, customJavascript string) string {
 	if len(nextHash) > 0 {
 		blockHtmlData["NextHash"] = nextHash
 	}
+	if block.IsOrphaned() {
+		blockHtmlData["Orphaned"] = true
+	}
 
 	// create the html page
 	explorerPageHtmlData := getExplorerPageHtmlData(blockHash, blockHtmlData)
+
+	// the mempool is shown with the most recent block
+	explorerPageHtmlData["ShowMempool"] = len(nextHash) == 0 && !block.IsOrphaned()
 	layoutHtmlData := getLayoutHtmlData(customJavascript, explorerPageHtmlData)
 
 	// parse the files
@@ -566,6 +825,7 @@ type BlockTxHtmlData struct {
 	InputCount  uint16
 	OutputCount uint16
 	BlockIndex  uint16
+	BlockHash   string
 	BaseUrl     string
 }
 
@@ -577,14 +837,44 @@ type BlockTxResponse struct {
 }
 
 // blockCharts := getBlockCharts(blockChartData.NonCoinbaseInputCount, blockChartData.OutputCount, blockChartData.SpendTypes, blockChartData.OutputTypes)
-// func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes map[string]uint16, outputTypes map[string]uint16) map[string]string {
+// func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes map[string]uint16, nonStandardTypes map[string]uint16, outputTypes map[string]uint16) map[string]string {
+// if BlockHash is set, everything else is counted from the transactions of the block
 type BlockChartData struct {
+	BlockHash             string            `json:"block_hash"`
 	NonCoinbaseInputCount uint16            `json:"non_coinbase_input_count"`
 	OutputCount           uint16            `json:"output_count"`
 	SpendTypes            map[string]uint16 `json:"spend_types"`
+	NonStandardTypes      map[string]uint16 `json:"non_standard_types"`
 	OutputTypes           map[string]uint16 `json:"output_types"`
 }
 
+// counts the spend types, the non-standard sub-types and the output types of the transactions of a block
+// the transactions must include their previous outputs
+func getBlockChartData(txs []btc.Tx) BlockChartData {
+
+	chartData := BlockChartData{SpendTypes: make(map[string]uint16), NonStandardTypes: make(map[string]uint16), OutputTypes: make(map[string]uint16)}
+	for _, tx := range txs {
+		for _, input := range tx.GetInputs() {
+			if input.IsCoinbase() {
+				continue
+			}
+
+			chartData.NonCoinbaseInputCount++
+			chartData.SpendTypes[input.GetSpendType()]++
+			if input.GetSpendType() == btc.SPEND_TYPE_NonStandard {
+				chartData.NonStandardTypes[input.GetNonStandardType()]++
+			}
+		}
+
+		for _, output := range tx.GetOutputs() {
+			chartData.OutputCount++
+			chartData.OutputTypes[output.GetOutputType()]++
+		}
+	}
+
+	return chartData
+}
+
 func getBlockTxResponse(tx btc.Tx, blockIndex uint16) BlockTxResponse {
 
 	blockTxData := BlockTxHtmlData{Id: tx.GetTxId(),
@@ -592,6 +882,7 @@ func getBlockTxResponse(tx btc.Tx, blockIndex uint16) BlockTxResponse {
 		InputCount:  tx.GetInputCount(),
 		OutputCount: tx.GetOutputCount(),
 		BlockIndex:  blockIndex,
+		BlockHash:   tx.GetBlockHash(),
 		BaseUrl:     app.Settings.GetFullUrl() + "/web"}
 
 	// parse the file
@@ -611,15 +902,53 @@ func getBlockTxResponse(tx btc.Tx, blockIndex uint16) BlockTxResponse {
 	return blockTxResponse
 }
 
-func getTxHtml(tx btc.Tx, customJavascript string) string {
+type MempoolEntryHtmlData struct {
+	Fee             uint64
+	Vsize           uint32
+	FeeRate         string
+	TimeSeen        time.Time
+	HasPackageData  bool
+	AncestorCount   uint32 // not including the transaction itself
+	DescendantCount uint32 // not including the transaction itself
+}
+
+// returns nil if the node does not report mempool entries
+func getMempoolEntryHtmlData(entry node.MempoolEntry) *MempoolEntryHtmlData {
+
+	if entry.IsNil() {
+		return nil
+	}
+
+	entryHtmlData := MempoolEntryHtmlData{Fee: entry.GetFee(),
+		Vsize:   entry.GetVsize(),
+		FeeRate: fmt.Sprintf("%.2f", entry.GetFeeRate())}
+	if entry.GetTimeSeen() > 0 {
+		entryHtmlData.TimeSeen = time.Unix(entry.GetTimeSeen(), 0).UTC()
+	}
+	if entry.GetAncestorCount() > 0 && entry.GetDescendantCount() > 0 {
+		entryHtmlData.HasPackageData = true
+		entryHtmlData.AncestorCount = entry.GetAncestorCount() - 1
+		entryHtmlData.DescendantCount = entry.GetDescendantCount() - 1
+	}
+
+	return &entryHtmlData
+}
+
+// spends is nil if there is no spend index
+func getTxHtml(tx btc.Tx, mempoolEntry node.MempoolEntry, spends map[uint16]node.OutputSpend, customJavascript string) string {
 
 	txPageHtmlData := make(map[string]interface{})
 
 	// transaction data
 	txPageHtmlData["BaseUrl"] = app.Settings.GetFullUrl() + "/web"
-	txPageHtmlData["BlockTime"] = time.Unix(tx.GetBlockTime(), 0).UTC()
+	txPageHtmlData["IsConfirmed"] = tx.IsConfirmed()
+	if tx.IsConfirmed() {
+		txPageHtmlData["BlockTime"] = time.Unix(tx.GetBlockTime(), 0).UTC()
+		txPageHtmlData["BlockHash"] = tx.GetBlockHash()
+	} else {
+		txPageHtmlData["MempoolEntry"] = getMempoolEntryHtmlData(mempoolEntry)
+	}
 
-	txPageHtmlData["BlockHash"] = tx.GetBlockHash()
 	txPageHtmlData["Version"] = tx.GetVersion()
 	txPageHtmlData["IsCoinbase"] = tx.IsCoinbase()
 	txPageHtmlData["SupportsBip141"] = tx.SupportsBip141()
@@ -641,6 +970,16 @@ func getTxHtml(tx btc.Tx, customJavascript string) string {
 		totalOut += output.GetValue()
 		scriptHtmlId := fmt.Sprintf("output-script-%d", o)
 		outputHtmlData[o] = getOutputHtmlData(outputs[o], scriptHtmlId, "", uint16(o))
+
+		// links to the inputs that spent the outputs
+		outputHtmlData[o].BaseUrl = app.Settings.GetFullUrl() + "/web"
+		outputHtmlData[o].SpendIndexOn = spends != nil
+		spend := spends[uint16(o)]
+		if !spend.IsNil() {
+			outputHtmlData[o].SpentByTxId = spend.GetTxId()
+			outputHtmlData[o].SpentByInputIndex = spend.GetInputIndex()
+			outputHtmlData[o].SpentByBlockHeight = spend.GetBlockHeight()
+		}
 	}
 	txPageHtmlData["OutputData"] = outputHtmlData
 
@@ -696,6 +1035,271 @@ func getTxHtml(tx btc.Tx, customJavascript string) string {
 	return buff.String()
 }
 
+type AddressHistoryHtmlData struct {
+	TxId                string
+	BlockHeight         uint32
+	IsSpend             bool
+	Index               uint32
+	Value               template.HTML
+	PreviousOutputTxId  string
+	PreviousOutputIndex uint32
+	SpendType           string
+}
+
+// history is the most recent part of the history, and historyCount is the number of entries in the whole history
+func getAddressHtml(query string, summary node.AddressSummary, history []node.AddressHistoryEntry, historyCount int, customJavascript string) string {
+
+	addressPageHtmlData := make(map[string]interface{})
+
+	addressPageHtmlData["BaseUrl"] = app.Settings.GetFullUrl() + "/web"
+	addressPageHtmlData["Address"] = summary.GetAddress()
+	addressPageHtmlData["ScriptHash"] = summary.GetScriptHash()
+	addressPageHtmlData["FundedCount"] = summary.GetFundedCount()
+	addressPageHtmlData["FundedValue"] = template.HTML(getValueHtml(summary.GetFundedValue()))
+	addressPageHtmlData["SpentCount"] = summary.GetSpentCount()
+	addressPageHtmlData["SpentValue"] = template.HTML(getValueHtml(summary.GetSpentValue()))
+	addressPageHtmlData["Balance"] = template.HTML(getValueHtml(summary.GetBalance()))
+	addressPageHtmlData["StartHeight"] = summary.GetStartHeight()
+	addressPageHtmlData["HasIndexedBlocks"] = summary.GetIndexedHeight() >= 0
+	addressPageHtmlData["IndexedHeight"] = summary.GetIndexedHeight()
+
+	// every output to the script has the same output type, and the spends are counted by spend type
+	outputScript := summary.GetOutputScript()
+	if !outputScript.IsNil() {
+		addressPageHtmlData["OutputScript"] = getScriptHtmlData(outputScript, "address-output-script", "")
+		addressPageHtmlData["OutputTypes"] = []ElementTypeHTML{{Label: summary.GetOutputType(), Count: uint16(summary.GetFundedCount())}}
+	}
+
+	spendTypes := make([]ElementTypeHTML, 0, len(summary.GetSpendTypes()))
+	for spendType, count := range summary.GetSpendTypes() {
+		spendTypes = append(spendTypes, ElementTypeHTML{Label: spendType, Count: uint16(count)})
+	}
+	sort.Slice(spendTypes, func(a, b int) bool { return spendTypes[a].Label < spendTypes[b].Label })
+	addressPageHtmlData["SpendTypes"] = spendTypes
+
+	// most recent first
+	historyHtmlData := make([]AddressHistoryHtmlData, len(history))
+	for h, entry := range history {
+		historyHtmlData[len(history)-1-h] = AddressHistoryHtmlData{TxId: entry.GetTxId(),
+			BlockHeight:         entry.GetBlockHeight(),
+			IsSpend:             entry.IsSpend(),
+			Index:               entry.GetIndex(),
+			Value:               template.HTML(getValueHtml(entry.GetValue())),
+			PreviousOutputTxId:  entry.GetPreviousOutputTxId(),
+			PreviousOutputIndex: entry.GetPreviousOutputIndex(),
+			SpendType:           entry.GetSpendType()}
+	}
+	addressPageHtmlData["History"] = historyHtmlData
+	addressPageHtmlData["HistoryCount"] = historyCount
+
+	// create the html page
+	explorerPageHtmlData := getExplorerPageHtmlData(query, addressPageHtmlData)
+	layoutHtmlData := getLayoutHtmlData(customJavascript, explorerPageHtmlData)
+
+	// parse the files
+	layoutHtmlFiles := []string{
+		GetPath() + "html/layout.html",
+		GetPath() + "html/page-explorer.html",
+		GetPath() + "html/address.html",
+		GetPath() + "html/field-set.html"}
+	templ := template.Must(template.ParseFiles(layoutHtmlFiles...))
+
+	// execute the templates
+	var buff bytes.Buffer
+	if err := templ.ExecuteTemplate(&buff, "Layout", layoutHtmlData); err != nil {
+		panic(err)
+	}
+
+	// return the html
+	return buff.String()
+}
+
+type ChainTipHtmlData struct {
+	Height       uint32
+	Hash         string
+	BranchLength uint32
+	ForkHeight   uint32
+	Status       string
+}
+
+func getChainTipHtmlData(chainTip node.ChainTip) ChainTipHtmlData {
+	return ChainTipHtmlData{Height: chainTip.GetHeight(),
+		Hash:         chainTip.GetHash(),
+		BranchLength: chainTip.GetBranchLength(),
+		ForkHeight:   chainTip.GetForkHeight(),
+		Status:       chainTip.GetStatus()}
+}
+
+func getChainTipsHtml(chainTips []node.ChainTip, customJavascript string) string {
+
+	chainTipsHtmlData := make([]ChainTipHtmlData, len(chainTips))
+	for t, chainTip := range chainTips {
+		chainTipsHtmlData[t] = getChainTipHtmlData(chainTip)
+	}
+
+	// every status is counted, so that forks can be found in a long list
+	statusCounts := make(map[string]uint32)
+	for _, chainTip := range chainTips {
+		statusCounts[chainTip.GetStatus()]++
+	}
+	statuses := make([]ElementTypeHTML, 0, len(statusCounts))
+	for status, count := range statusCounts {
+		statuses = append(statuses, ElementTypeHTML{Label: status, Count: uint16(count)})
+	}
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].Label < statuses[b].Label })
+
+	chainTipsPageHtmlData := make(map[string]interface{})
+	chainTipsPageHtmlData["BaseUrl"] = app.Settings.GetFullUrl() + "/web"
+	chainTipsPageHtmlData["ChainTips"] = chainTipsHtmlData
+	chainTipsPageHtmlData["Statuses"] = statuses
+
+	return getQueryResultsHtml(chainTipsPageHtmlData, "chain-tips.html", customJavascript)
+}
+
+type BranchBlockHtmlData struct {
+	Hash            string
+	Height          uint32
+	Time            time.Time
+	TxCount         int
+	ActiveBlockHash string
+}
+
+func getChainBranchHtml(branch node.ChainBranch, customJavascript string) string {
+
+	blocksHtmlData := make([]BranchBlockHtmlData, len(branch.GetBlocks()))
+	for b, branchBlock := range branch.GetBlocks() {
+		block := branchBlock.GetBlock()
+		blocksHtmlData[b] = BranchBlockHtmlData{Hash: block.GetHash(),
+			Height:          block.GetHeight(),
+			Time:            time.Unix(block.GetTimestamp(), 0).UTC(),
+			TxCount:         len(block.GetTxIds()),
+			ActiveBlockHash: branchBlock.GetActiveBlockHash()}
+	}
+
+	branchPageHtmlData := make(map[string]interface{})
+	branchPageHtmlData["BaseUrl"] = app.Settings.GetFullUrl() + "/web"
+	branchPageHtmlData["Tip"] = getChainTipHtmlData(branch.GetTip())
+	branchPageHtmlData["Blocks"] = blocksHtmlData
+	branchPageHtmlData["Complete"] = branch.IsComplete()
+
+	return getQueryResultsHtml(branchPageHtmlData, "chain-branch.html", customJavascript)
+}
+
+type ComparedTxHtmlData struct {
+	TxId        string
+	SpendTypes  string
+	OutputTypes string
+}
+
+type ComparedBlockHtmlData struct {
+	Hash          string
+	Height        uint32
+	Time          time.Time
+	Orphaned      bool
+	TxCount       int
+	UniqueTxCount uint32
+	SpendTypes    []ElementTypeHTML
+	OutputTypes   []ElementTypeHTML
+	UniqueTxs     []ComparedTxHtmlData
+}
+
+// returns the types sorted by label, with the percent of the total of the counts
+func getTypeCountsHtmlData(typeCounts map[string]uint32) []ElementTypeHTML {
+
+	total := uint32(0)
+	for _, count := range typeCounts {
+		total += count
+	}
+
+	types := make([]ElementTypeHTML, 0, len(typeCounts))
+	for label, count := range typeCounts {
+		types = append(types, ElementTypeHTML{Label: label, Count: uint16(count), Percent: fmt.Sprintf("%9.2f%%", float32(count*100)/float32(total))})
+	}
+	sort.Slice(types, func(a, b int) bool { return types[a].Label < types[b].Label })
+	return types
+}
+
+// lists each type once, followed by the number of times it appears if it appears more than once
+func getTypeListText(typeList []string) string {
+
+	typeCounts := make(map[string]int)
+	types := make([]string, 0)
+	for _, label := range typeList {
+		if typeCounts[label] == 0 {
+			types = append(types, label)
+		}
+		typeCounts[label]++
+	}
+
+	for t, label := range types {
+		if typeCounts[label] > 1 {
+			types[t] = fmt.Sprintf("%s (%d)", label, typeCounts[label])
+		}
+	}
+	return strings.Join(types, ", ")
+}
+
+func getBlockComparisonHtml(comparison node.BlockComparison, customJavascript string) string {
+
+	blocksHtmlData := make([]ComparedBlockHtmlData, len(comparison.GetBlocks()))
+	for b, comparedBlock := range comparison.GetBlocks() {
+		block := comparedBlock.GetBlock()
+
+		uniqueTxs := make([]ComparedTxHtmlData, len(comparedBlock.GetUniqueTxs()))
+		for t, tx := range comparedBlock.GetUniqueTxs() {
+			spendTypes := make([]string, 0, tx.GetInputCount())
+			for _, input := range tx.GetInputs() {
+				spendTypes = append(spendTypes, input.GetSpendType())
+			}
+			outputTypes := make([]string, 0, tx.GetOutputCount())
+			for _, output := range tx.GetOutputs() {
+				outputTypes = append(outputTypes, output.GetOutputType())
+			}
+			uniqueTxs[t] = ComparedTxHtmlData{TxId: tx.GetTxId(), SpendTypes: getTypeListText(spendTypes), OutputTypes: getTypeListText(outputTypes)}
+		}
+
+		blocksHtmlData[b] = ComparedBlockHtmlData{Hash: block.GetHash(),
+			Height:        block.GetHeight(),
+			Time:          time.Unix(block.GetTimestamp(), 0).UTC(),
+			Orphaned:      block.IsOrphaned(),
+			TxCount:       len(block.GetTxIds()),
+			UniqueTxCount: comparedBlock.GetUniqueTxCount(),
+			SpendTypes:    getTypeCountsHtmlData(comparedBlock.GetSpendTypes()),
+			OutputTypes:   getTypeCountsHtmlData(comparedBlock.GetOutputTypes()),
+			UniqueTxs:     uniqueTxs}
+	}
+
+	comparisonPageHtmlData := make(map[string]interface{})
+	comparisonPageHtmlData["BaseUrl"] = app.Settings.GetFullUrl() + "/web"
+	comparisonPageHtmlData["Blocks"] = blocksHtmlData
+	comparisonPageHtmlData["SharedTxCount"] = comparison.GetSharedTxCount()
+
+	return getQueryResultsHtml(comparisonPageHtmlData, "block-comparison.html", customJavascript)
+}
+
+// creates an explorer page whose query results are shown by the template in the html file
+func getQueryResultsHtml(queryResults map[string]interface{}, htmlFile string, customJavascript string) string {
+
+	explorerPageHtmlData := getExplorerPageHtmlData("", queryResults)
+	layoutHtmlData := getLayoutHtmlData(customJavascript, explorerPageHtmlData)
+
+	// parse the files
+	layoutHtmlFiles := []string{
+		GetPath() + "html/layout.html",
+		GetPath() + "html/page-explorer.html",
+		GetPath() + "html/" + htmlFile}
+	templ := template.Must(template.ParseFiles(layoutHtmlFiles...))
+
+	// execute the templates
+	var buff bytes.Buffer
+	if err := templ.ExecuteTemplate(&buff, "Layout", layoutHtmlData); err != nil {
+		panic(err)
+	}
+
+	// return the html
+	return buff.String()
+}
+
 func getInputHtml(htmlData InputHtmlData) string {
 
 	htmlFiles := []string{
@@ -735,6 +1339,10 @@ func getInputHtmlData(input btc.Input, txIndex uint16, satoshis uint64, bip141 b
 
 	displayTypeClassPrefix := fmt.Sprintf("input-%d", txIndex)
 	htmlData := InputHtmlData{InputIndex: txIndex, DisplayTypeClassPrefix: displayTypeClassPrefix, SpendType: input.GetSpendType(), Sequence: input.GetSequence(), Bip141: bip141}
+	if input.GetSpendType() == btc.SPEND_TYPE_NonStandard {
+		htmlData.NonStandardType = input.GetNonStandardType()
+		htmlData.NonStandardReason = input.GetNonStandardReason()
+	}
 
 	htmlId := fmt.Sprintf("input-script-%d", txIndex)
 
@@ -859,8 +1467,12 @@ func getSegwitHtmlData(segwit btc.Segwit, inputIndex uint16, displayTypeClassPre
 				textFieldsHtml[f].CopyText = entireTextField
 			}
 
-			// field types
-			typeFieldsHtml[f] = FieldHtmlData{DisplayText: template.HTML(field.AsType()), ShowCopyButton: false}
+			// field types, followed by how the field is used by the script
+			typeText := field.AsType()
+			if len(field.GetBinding()) > 0 {
+				typeText += " &rarr; " + template.HTMLEscapeString(field.GetBinding())
+			}
+			typeFieldsHtml[f] = FieldHtmlData{DisplayText: template.HTML(typeText), ShowCopyButton: false}
 		}
 	}
 
@@ -989,7 +1601,7 @@ func extractBodyFromHTML(html string) string {
 	return body[bodyBegin:]
 }
 
-func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes map[string]uint16, outputTypes map[string]uint16) map[string]string {
+func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes map[string]uint16, nonStandardTypes map[string]uint16, outputTypes map[string]uint16) map[string]string {
 
 	const pieRadius = 90
 	const verticalPadding = 10
@@ -997,7 +1609,32 @@ func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes
 
 	// gather data for the spend type and output type charts
 
-	spendTypeNames := []string{btc.OUTPUT_TYPE_P2PK, btc.OUTPUT_TYPE_MultiSig, btc.OUTPUT_TYPE_P2PKH, btc.OUTPUT_TYPE_P2SH, btc.SPEND_TYPE_P2SH_P2WPKH, btc.SPEND_TYPE_P2SH_P2WSH, btc.OUTPUT_TYPE_P2WPKH, btc.OUTPUT_TYPE_P2WSH, btc.SPEND_TYPE_P2TR_Key, btc.SPEND_TYPE_P2TR_Script, btc.SPEND_TYPE_NonStandard}
+	spendTypeNames := []string{btc.OUTPUT_TYPE_P2PK, btc.OUTPUT_TYPE_MultiSig, btc.OUTPUT_TYPE_P2PKH, btc.OUTPUT_TYPE_P2SH, btc.SPEND_TYPE_P2SH_P2WPKH, btc.SPEND_TYPE_P2SH_P2WSH, btc.OUTPUT_TYPE_P2WPKH, btc.OUTPUT_TYPE_P2WSH, btc.SPEND_TYPE_P2TR_Key, btc.SPEND_TYPE_P2TR_Script}
+	spendTypeNames = append(spendTypeNames, btc.GetWitnessProgramOutputTypes()...)
+	spendTypeNames = append(spendTypeNames, btc.SPEND_TYPE_NonStandard)
+
+	// if the non-standard sub-types were provided, the non-standard spend type is broken down by sub-type
+	// anything not accounted for by the sub-types remains in the non-standard spend type
+	if len(nonStandardTypes) > 0 {
+		breakdown := make(map[string]uint16)
+		for k, v := range spendTypes {
+			breakdown[k] = v
+		}
+		for _, nonStandardType := range btc.GetNonStandardTypes() {
+			count := nonStandardTypes[nonStandardType]
+			if count == 0 {
+				continue
+			}
+			if count > breakdown[btc.SPEND_TYPE_NonStandard] {
+				count = breakdown[btc.SPEND_TYPE_NonStandard]
+			}
+			label := btc.GetNonStandardSpendTypeLabel(nonStandardType)
+			breakdown[label] = count
+			breakdown[btc.SPEND_TYPE_NonStandard] -= count
+			spendTypeNames = append(spendTypeNames, label)
+		}
+		spendTypes = breakdown
+	}
 
 	var spendTypesHTML []ElementTypeHTML
 	for _, typeName := range spendTypeNames {
@@ -1005,11 +1642,13 @@ func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes
 			if len(typeName) > longestLabel {
 				longestLabel = len(typeName)
 			}
-			spendTypesHTML = append(spendTypesHTML, ElementTypeHTML{Label: typeName, Count: spendTypes[typeName], Percent: fmt.Sprintf("%9.2f%%", float32(spendTypes[typeName]*100)/float32(nonCoinbaseInputCount))})
+			spendTypesHTML = append(spendTypesHTML, ElementTypeHTML{Label: typeName, Count: spendTypes[typeName], Percent: fmt.Sprintf("%9.2f%%", float32(spendTypes[typeName])*100/float32(nonCoinbaseInputCount))})
 		}
 	}
 
-	outputTypeNames := []string{btc.OUTPUT_TYPE_P2PK, btc.OUTPUT_TYPE_MultiSig, btc.OUTPUT_TYPE_P2PKH, btc.OUTPUT_TYPE_P2SH, btc.OUTPUT_TYPE_P2WPKH, btc.OUTPUT_TYPE_P2WSH, btc.OUTPUT_TYPE_TAPROOT, btc.OUTPUT_TYPE_OP_RETURN, btc.OUTPUT_TYPE_WitnessUnknown, btc.OUTPUT_TYPE_NonStandard}
+	outputTypeNames := []string{btc.OUTPUT_TYPE_P2PK, btc.OUTPUT_TYPE_MultiSig, btc.OUTPUT_TYPE_P2PKH, btc.OUTPUT_TYPE_P2SH, btc.OUTPUT_TYPE_P2WPKH, btc.OUTPUT_TYPE_P2WSH, btc.OUTPUT_TYPE_TAPROOT}
+	outputTypeNames = append(outputTypeNames, btc.GetWitnessProgramOutputTypes()...)
+	outputTypeNames = append(outputTypeNames, btc.OUTPUT_TYPE_OP_RETURN, btc.OUTPUT_TYPE_WitnessUnknown, btc.OUTPUT_TYPE_NonStandard)
 
 	var outputTypesHTML []ElementTypeHTML
 	for _, typeName := range outputTypeNames {
@@ -1017,7 +1656,7 @@ func getBlockCharts(nonCoinbaseInputCount uint16, outputCount uint16, spendTypes
 			if len(typeName) > longestLabel {
 				longestLabel = len(typeName)
 			}
-			outputTypesHTML = append(outputTypesHTML, ElementTypeHTML{Label: typeName, Count: outputTypes[typeName], Percent: fmt.Sprintf("%9.2f%%", float32(outputTypes[typeName]*100)/float32(outputCount))})
+			outputTypesHTML = append(outputTypesHTML, ElementTypeHTML{Label: typeName, Count: outputTypes[typeName], Percent: fmt.Sprintf("%9.2f%%", float32(outputTypes[typeName])*100/float32(outputCount))})
 		}
 	}
 
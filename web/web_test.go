@@ -0,0 +1,125 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/btc-script-explorer/scantool/app"
+	"github.com/btc-script-explorer/scantool/btc"
+)
+
+// the node responses for mainnet block 170 and its transactions were recorded from Bitcoin Core and are replayed here
+// block 170 has the first transaction from one person to another, which spends the coinbase output of block 9
+const testFixturesDir = "../btc/node/testdata/mainnet-fixtures"
+const testBlockHash = "00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee"
+const testCoinbaseTxId = "b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082"
+const testTxId = "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16"
+
+// the settings are read from the command line, so they are parsed before the test flags are
+// the templates are read from the html directory of this package
+func TestMain(m *testing.M) {
+	args := os.Args
+	os.Args = []string{args[0], "--node-fixtures-mode=replay", "--node-fixtures-dir=" + testFixturesDir}
+	app.ParseSettings("test")
+	os.Args = args
+
+	SetWebPath(".")
+
+	os.Exit(m.Run())
+}
+
+func sendWebTestRequest(method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	WebHandler(recorder, request)
+	return recorder
+}
+
+func TestWebBlock(t *testing.T) {
+
+	for _, blockKey := range []string{testBlockHash, "170"} {
+		recorder := sendWebTestRequest("GET", "/web/block/"+blockKey, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("block %s returned status %d", blockKey, recorder.Code)
+		}
+
+		html := recorder.Body.String()
+		for _, expected := range []string{testBlockHash, testCoinbaseTxId, testTxId} {
+			if !strings.Contains(html, expected) {
+				t.Errorf("the page of block %s does not contain %s", blockKey, expected)
+			}
+		}
+	}
+}
+
+// the previous output of the input is found from the block of the spending transaction or without it
+func TestWebInput(t *testing.T) {
+
+	for _, blockHash := range []string{"", testBlockHash} {
+		recorder := sendWebTestRequest("POST", "/web/input", `{"tx_id":"`+testTxId+`","input_index":0,"block_hash":"`+blockHash+`"}`)
+
+		var input map[string]interface{}
+		err := json.Unmarshal(recorder.Body.Bytes(), &input)
+		if err != nil {
+			t.Fatalf("input 0 in block %q returned %q, which is not a JSON object", blockHash, recorder.Body.String())
+		}
+
+		if input["spend_type"] != "P2PK" || input["value_in"] != float64(5000000000) || input["address"] != "No Address Format" {
+			t.Errorf("input 0 in block %q spends %v %v from %v", blockHash, input["value_in"], input["spend_type"], input["address"])
+		}
+		inputHtml, _ := input["input_html"].(string)
+		if !strings.Contains(inputHtml, "P2PK") {
+			t.Errorf("the html of input 0 in block %q does not show its spend type", blockHash)
+		}
+	}
+}
+
+// the non-standard spends of a block are broken down by sub-type in the spend type chart
+func TestBlockChartsNonStandard(t *testing.T) {
+
+	anyoneCanSpendOutput := btc.NewOutput(1000, btc.NewScript([]byte{0x51}), "")
+	p2pkhOutput := btc.NewOutput(2000, btc.NewScript(append(append([]byte{0x76, 0xa9, 0x14}, make([]byte, 20)...), 0x88, 0xac)), "")
+	opReturnOutput := btc.NewOutput(0, btc.NewScript([]byte{0x6a}), "")
+
+	inputs := []btc.Input{
+		btc.NewInput(false, testTxId, 0, btc.NewScript(nil), btc.Segwit{}, 0xffffffff, btc.Output{}),
+		btc.NewInput(false, testTxId, 1, btc.NewScript(nil), btc.Segwit{}, 0xffffffff, btc.Output{})}
+	tx := btc.NewTx(testTxId, 1, inputs, []btc.Output{opReturnOutput}, 0, false, false, testBlockHash, 0)
+	tx.SetPreviousOutput(0, anyoneCanSpendOutput)
+	tx.SetPreviousOutput(1, p2pkhOutput)
+
+	chartData := getBlockChartData([]btc.Tx{tx})
+	if chartData.NonCoinbaseInputCount != 2 || chartData.SpendTypes[btc.SPEND_TYPE_NonStandard] != 1 || chartData.SpendTypes[btc.OUTPUT_TYPE_P2PKH] != 1 {
+		t.Errorf("counted %d inputs with spend types %v", chartData.NonCoinbaseInputCount, chartData.SpendTypes)
+	}
+	if len(chartData.NonStandardTypes) != 1 || chartData.NonStandardTypes[btc.NON_STANDARD_TYPE_AnyoneCanSpend] != 1 {
+		t.Errorf("counted non-standard types %v, expected one %s", chartData.NonStandardTypes, btc.NON_STANDARD_TYPE_AnyoneCanSpend)
+	}
+	if chartData.OutputCount != 1 || chartData.OutputTypes[btc.OUTPUT_TYPE_OP_RETURN] != 1 {
+		t.Errorf("counted %d outputs with output types %v", chartData.OutputCount, chartData.OutputTypes)
+	}
+
+	blockCharts := getBlockCharts(chartData.NonCoinbaseInputCount, chartData.OutputCount, chartData.SpendTypes, chartData.NonStandardTypes, chartData.OutputTypes)
+	if !strings.Contains(blockCharts["SpendTypeChart"], btc.GetNonStandardSpendTypeLabel(btc.NON_STANDARD_TYPE_AnyoneCanSpend)) {
+		t.Error("the spend type chart does not break down the non-standard spends")
+	}
+}
+
+// the block charts are counted from the block when only its hash is sent
+func TestWebBlockCharts(t *testing.T) {
+
+	recorder := sendWebTestRequest("POST", "/web/block_charts", `{"block_hash":"`+testBlockHash+`"}`)
+
+	var blockCharts map[string]string
+	err := json.Unmarshal(recorder.Body.Bytes(), &blockCharts)
+	if err != nil {
+		t.Fatalf("the block charts returned %q, which is not a JSON object", recorder.Body.String())
+	}
+	if !strings.Contains(blockCharts["SpendTypeChart"], btc.OUTPUT_TYPE_P2PK) || !strings.Contains(blockCharts["OutputTypeChart"], btc.OUTPUT_TYPE_P2PK) {
+		t.Errorf("the charts of block %s do not show its P2PK spend and outputs", testBlockHash)
+	}
+}